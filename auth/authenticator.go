@@ -13,15 +13,61 @@ import (
 
 // constants
 const JWT_TIME_TO_LIVE_MINUTES int = 60
+const REFRESH_TOKEN_TIME_TO_LIVE_MINUTES int = 7 * 24 * 60
+
+// TOKEN_ID_BYTES is the number of random bytes of the JWT ID of a token.
+const TOKEN_ID_BYTES = 16
+
+// The types of the tokens: access tokens authenticate the requests, refresh
+// tokens are exchanged for new tokens.
+const ACCESS_TOKEN = "access"
+const REFRESH_TOKEN = "refresh"
 
 type Authenticator interface {
 	GenerateToken(string) (string, error)
 	VerifyToken(token string) (string, error)
 }
 
+// TokenOptions are the lifetimes of the tokens, and the issuer and audience
+// they are issued for, which are not checked when empty.
+type TokenOptions struct {
+	AccessTimeToLive  time.Duration
+	RefreshTimeToLive time.Duration
+	Issuer            string
+	Audience          string
+}
+
+func DefaultTokenOptions() TokenOptions {
+	return TokenOptions{
+		AccessTimeToLive:  time.Duration(JWT_TIME_TO_LIVE_MINUTES) * time.Minute,
+		RefreshTimeToLive: time.Duration(REFRESH_TOKEN_TIME_TO_LIVE_MINUTES) * time.Minute,
+	}
+}
+
+// withDefaults returns the options with the default lifetimes in place of
+// the unset ones.
+func (options TokenOptions) withDefaults() TokenOptions {
+	defaults := DefaultTokenOptions()
+	if options.AccessTimeToLive <= 0 {
+		options.AccessTimeToLive = defaults.AccessTimeToLive
+	}
+	if options.RefreshTimeToLive <= 0 {
+		options.RefreshTimeToLive = defaults.RefreshTimeToLive
+	}
+	return options
+}
+
+// TokenPair is the access token and the refresh token issued on login or
+// refresh.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 type JWTAutenticator struct {
 	usersStore *UserStore
 	jwtKey     []byte
+	options    TokenOptions
 }
 
 func NewJWTAutenticator() *JWTAutenticator {
@@ -38,46 +84,181 @@ func NewJWTAutenticatorWithUsers(usersStore *UserStore) *JWTAutenticator {
 	}
 }
 
+func NewJWTAutenticatorWithOptions(usersStore *UserStore, options TokenOptions) *JWTAutenticator {
+	return &JWTAutenticator{
+		jwtKey:     getJWTKey(),
+		usersStore: usersStore,
+		options:    options,
+	}
+}
+
+// Claims are the claims of the tokens. Family identifies the refresh tokens
+// issued from the same login.
 type Claims struct {
 	Username string `json:"username"`
+	Type     string `json:"token_type"`
+	Family   string `json:"family,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// GenerateToken issues a token to the user and registers its session, so
+// that a user may hold several valid tokens at once.
 func (jwtAuthenticator *JWTAutenticator) GenerateToken(username string) (string, error) {
-	expirationTime := time.Now().Add(time.Duration(JWT_TIME_TO_LIVE_MINUTES) * time.Minute)
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	expirationTime := time.Now().Add(jwtAuthenticator.options.withDefaults().AccessTimeToLive)
+	token, err := jwtAuthenticator.sign(username, ACCESS_TOKEN, id, "", expirationTime)
+	if err != nil {
+		return "", err
+	}
+	jwtAuthenticator.usersStore.SaveSession(username, id, expirationTime)
+	return token, nil
+}
+
+// GenerateTokens issues an access token and a refresh token to the user,
+// starting a new family of refresh tokens.
+func (jwtAuthenticator *JWTAutenticator) GenerateTokens(username string) (TokenPair, error) {
+	family, err := newTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return jwtAuthenticator.issue(username, family, func(accessID, refreshID string, accessExpiresAt, refreshExpiresAt time.Time) error {
+		jwtAuthenticator.usersStore.SaveSession(username, accessID, accessExpiresAt)
+		jwtAuthenticator.usersStore.SaveRefreshFamily(username, family, refreshID, accessID, refreshExpiresAt)
+		return nil
+	})
+}
+
+// Refresh exchanges a refresh token for new tokens. Each refresh token is
+// used once: the refresh token is rotated and the previous access token of
+// its family revoked. Presenting a refresh token already used revokes the
+// whole family, as it was likely stolen.
+func (jwtAuthenticator *JWTAutenticator) Refresh(refreshToken string) (TokenPair, error) {
+	claims, err := jwtAuthenticator.parse(refreshToken, REFRESH_TOKEN)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return jwtAuthenticator.issue(claims.Username, claims.Family, func(accessID, refreshID string, accessExpiresAt, refreshExpiresAt time.Time) error {
+		return jwtAuthenticator.usersStore.RotateRefreshToken(claims.Username, claims.Family, claims.ID, refreshID, accessID, accessExpiresAt, refreshExpiresAt)
+	})
+}
+
+// VerifyToken returns the user of the access token if it is valid and its
+// session has not been revoked.
+func (jwtAuthenticator *JWTAutenticator) VerifyToken(tokenString string) (string, error) {
+	claims, err := jwtAuthenticator.parse(tokenString, ACCESS_TOKEN)
+	if err != nil {
+		return "", err
+	}
+
+	if !jwtAuthenticator.usersStore.ValidateSession(claims.Username, claims.ID) {
+		return "", fmt.Errorf("invalid token")
+	}
+
+	return claims.Username, nil
+}
+
+// RevokeToken ends the session of a valid access token, along with the
+// refresh tokens issued with it.
+func (jwtAuthenticator *JWTAutenticator) RevokeToken(tokenString string) error {
+	claims, err := jwtAuthenticator.parse(tokenString, ACCESS_TOKEN)
+	if err != nil {
+		return err
+	}
+
+	if err := jwtAuthenticator.usersStore.DeleteSession(claims.Username, claims.ID); err != nil {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+// issue signs a new pair of tokens of the family, registered by save before
+// they are returned.
+func (jwtAuthenticator *JWTAutenticator) issue(username, family string, save func(accessID, refreshID string, accessExpiresAt, refreshExpiresAt time.Time) error) (TokenPair, error) {
+	options := jwtAuthenticator.options.withDefaults()
+	accessID, err := newTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshID, err := newTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now()
+	accessExpiresAt, refreshExpiresAt := now.Add(options.AccessTimeToLive), now.Add(options.RefreshTimeToLive)
+
+	accessToken, err := jwtAuthenticator.sign(username, ACCESS_TOKEN, accessID, family, accessExpiresAt)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := jwtAuthenticator.sign(username, REFRESH_TOKEN, refreshID, family, refreshExpiresAt)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := save(accessID, refreshID, accessExpiresAt, refreshExpiresAt); err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (jwtAuthenticator *JWTAutenticator) sign(username, tokenType, id, family string, expirationTime time.Time) (string, error) {
 	claims := &Claims{
 		Username: username,
+		Type:     tokenType,
+		Family:   family,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Issuer:    jwtAuthenticator.options.Issuer,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
+	if jwtAuthenticator.options.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{jwtAuthenticator.options.Audience}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtAuthenticator.jwtKey)
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtAuthenticator.jwtKey)
 }
 
-func (jwtAuthenticator *JWTAutenticator) VerifyToken(tokenString string) (string, error) {
+// parse returns the claims of a valid token of the given type, issued for
+// the configured issuer and audience.
+func (jwtAuthenticator *JWTAutenticator) parse(tokenString string, tokenType string) (*Claims, error) {
+	var parserOptions []jwt.ParserOption
+	if jwtAuthenticator.options.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(jwtAuthenticator.options.Issuer))
+	}
+	if jwtAuthenticator.options.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(jwtAuthenticator.options.Audience))
+	}
+
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return jwtAuthenticator.jwtKey, nil
-	})
+	}, parserOptions...)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+	if !token.Valid || claims.Type != tokenType {
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	userStore := jwtAuthenticator.usersStore
+	return claims, nil
+}
 
-	if userStore.tokens[claims.Username] != tokenString {
-		return "", fmt.Errorf("invalid token")
+func newTokenID() (string, error) {
+	id := make([]byte, TOKEN_ID_BYTES)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
 	}
-
-	return claims.Username, nil
+	return hex.EncodeToString(id), nil
 }
 
 var (
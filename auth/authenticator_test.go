@@ -6,6 +6,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGenerateToken(t *testing.T) {
@@ -46,8 +47,6 @@ func TestJWTAuthenticator_VerifyToken(t *testing.T) {
 	assert.NoError(t, err, "Expected no error when generating token")
 	assert.NotEmpty(t, tokenString, "Expected a token, got an empty string")
 
-	userStore.SaveToken(username, tokenString)
-
 	// Test case: valid token
 	returnedUsername, err := authenticator.VerifyToken(tokenString)
 	assert.NoError(t, err, "Expected no error for a valid token")
@@ -61,7 +60,6 @@ func TestJWTAuthenticator_VerifyToken(t *testing.T) {
 
 	// Test case: expired token
 	expiredTokenString, _ := generateExpiredToken(username)
-	userStore.SaveToken(username, expiredTokenString)
 	_, err = authenticator.VerifyToken(expiredTokenString)
 	assert.Error(t, err, "Expected error for an expired token")
 
@@ -87,3 +85,111 @@ func generateExpiredToken(username string) (string, error) {
 	jwtKey := getJWTKey()
 	return token.SignedString(jwtKey)
 }
+
+func TestJWTAuthenticator_Sessions(t *testing.T) {
+	userStore := NewUserStore()
+	authenticator := NewJWTAutenticatorWithUsers(userStore)
+
+	// Logging in twice keeps both tokens valid
+	first, err := authenticator.GenerateToken("testuser")
+	require.NoError(t, err)
+	second, err := authenticator.GenerateToken("testuser")
+	require.NoError(t, err)
+	assert.Len(t, userStore.ListSessions("testuser"), 2)
+	for _, token := range []string{first, second} {
+		username, err := authenticator.VerifyToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, "testuser", username)
+	}
+
+	// Revoking a token ends its session only
+	require.NoError(t, authenticator.RevokeToken(first))
+	_, err = authenticator.VerifyToken(first)
+	assert.Error(t, err)
+	_, err = authenticator.VerifyToken(second)
+	assert.NoError(t, err)
+	assert.Error(t, authenticator.RevokeToken(first))
+
+	userStore.DeleteSessions("testuser")
+	_, err = authenticator.VerifyToken(second)
+	assert.Error(t, err)
+}
+
+func TestJWTAuthenticator_Refresh(t *testing.T) {
+	userStore := NewUserStore()
+	authenticator := NewJWTAutenticatorWithOptions(userStore, TokenOptions{AccessTimeToLive: 5 * time.Minute})
+
+	tokens, err := authenticator.GenerateTokens("testuser")
+	require.NoError(t, err)
+	claims, err := authenticator.parse(tokens.AccessToken, ACCESS_TOKEN)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), claims.ExpiresAt.Time, time.Minute)
+	_, err = authenticator.VerifyToken(tokens.AccessToken)
+	require.NoError(t, err)
+
+	// A refresh token does not authenticate requests, nor an access token refreshes
+	_, err = authenticator.VerifyToken(tokens.RefreshToken)
+	assert.Error(t, err)
+	_, err = authenticator.Refresh(tokens.AccessToken)
+	assert.Error(t, err)
+
+	// Refreshing rotates the refresh token and replaces the access token
+	refreshed, err := authenticator.Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	_, err = authenticator.VerifyToken(tokens.AccessToken)
+	assert.Error(t, err)
+	username, err := authenticator.VerifyToken(refreshed.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", username)
+	assert.Len(t, userStore.ListSessions("testuser"), 1)
+
+	// Reusing a refresh token revokes its family
+	_, err = authenticator.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	_, err = authenticator.VerifyToken(refreshed.AccessToken)
+	assert.Error(t, err)
+	_, err = authenticator.Refresh(refreshed.RefreshToken)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Logging out revokes the refresh token issued with the access token
+	tokens, err = authenticator.GenerateTokens("testuser")
+	require.NoError(t, err)
+	require.NoError(t, authenticator.RevokeToken(tokens.AccessToken))
+	_, err = authenticator.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	tokens, err = authenticator.GenerateTokens("testuser")
+	require.NoError(t, err)
+	userStore.DeleteSessions("testuser")
+	_, err = authenticator.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Changing the password revokes the refresh tokens
+	require.NoError(t, userStore.AddUser("testuser", "password"))
+	tokens, err = authenticator.GenerateTokens("testuser")
+	require.NoError(t, err)
+	require.NoError(t, userStore.UpdatePassword("testuser", "newpassword"))
+	_, err = authenticator.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	_, err = authenticator.VerifyToken(tokens.AccessToken)
+	assert.Error(t, err)
+}
+
+func TestJWTAuthenticator_IssuerAndAudience(t *testing.T) {
+	userStore := NewUserStore()
+	authenticator := NewJWTAutenticatorWithOptions(userStore, TokenOptions{Issuer: "dare-db", Audience: "clients"})
+	other := NewJWTAutenticatorWithOptions(userStore, TokenOptions{Issuer: "dare-db", Audience: "others"})
+
+	token, err := authenticator.GenerateToken("testuser")
+	require.NoError(t, err)
+	_, err = authenticator.VerifyToken(token)
+	assert.NoError(t, err)
+	_, err = other.VerifyToken(token)
+	assert.Error(t, err)
+
+	// Tokens without issuer and audience are rejected once they are configured
+	token, err = NewJWTAutenticatorWithUsers(userStore).GenerateToken("testuser")
+	require.NoError(t, err)
+	_, err = authenticator.VerifyToken(token)
+	assert.Error(t, err)
+}
@@ -1,11 +1,18 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
 
 	"github.com/casbin/casbin"
+	fileadapter "github.com/casbin/casbin/persist/file-adapter"
 	"github.com/dmarro89/dare-db/logger"
 )
 
@@ -17,6 +24,22 @@ const DEFAULT_ROLE = "admin"
 const RBAC_CONFIG_FILE = "auth/rbac_model.conf"
 const RBAC_POLICY_FILE = "auth/rbac_policy.csv"
 
+// POLICY_FILE is the file of the settings directory holding the policies
+// changed at runtime.
+const POLICY_FILE = "rbac_policy.csv"
+
+// The actions of the requests: reading or writing data, and administering a
+// collection or the server.
+const ACTION_READ = "read"
+const ACTION_WRITE = "write"
+const ACTION_ADMIN = "admin"
+
+// SERVER_ASSET is the asset of the requests on the server rather than on a
+// collection.
+const SERVER_ASSET = "dare-db"
+
+var ErrInvalidPolicy = errors.New("policy must have a subject, an asset and an action matching a valid regular expression")
+
 type Authorizer interface {
 	HasPermission(userID, action, asset string) bool
 }
@@ -27,45 +50,261 @@ type User struct {
 
 type Users map[string]*User
 
+// Policy allows a subject, a role or a user, to run an action on an asset.
+// The asset is a pattern of the assets built by CollectionAsset and KeyAsset,
+// or SERVER_ASSET, "*" for every asset, and the action a regular expression
+// matching the whole action, such as "read|write".
+type Policy struct {
+	Subject string `json:"subject"`
+	Asset   string `json:"asset"`
+	Action  string `json:"action"`
+}
+
+// CasbinAuth authorizes the users from their roles, either given on creation
+// or assigned at runtime, and the policies of a Casbin enforcer. The roles
+// and policies changed at runtime are saved through the adapter, and every
+// change replaces the enforcer with a new one, so that the requests being
+// authorized meanwhile keep using the previous one.
 type CasbinAuth struct {
-	users    Users
-	enforcer *casbin.Enforcer
-	logger   logger.Logger
+	users     Users
+	modelPath string
+	adapter   *fileadapter.Adapter
+	enforcer  *casbin.Enforcer
+	logger    logger.Logger
+
+	// mu guards the enforcer pointer, updateMu serializes the changes
+	mu       sync.RWMutex
+	updateMu sync.Mutex
 }
 
 func NewCasbinAuth(modelPath, policyPath string, users Users) *CasbinAuth {
 	if users == nil {
 		users = Users{GUEST_USER: {Roles: []string{GUEST_ROLE}}}
 	}
-	enforcer, err := casbin.NewEnforcerSafe(modelPath, policyPath)
+	a := &CasbinAuth{
+		users:     users,
+		modelPath: modelPath,
+		adapter:   fileadapter.NewAdapter(policyPath),
+		logger:    logger.NewDareLogger(),
+	}
+	enforcer, err := a.newEnforcer()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create Casbin enforcer: %v", err))
 	}
-	return &CasbinAuth{
-		users:    users,
-		enforcer: enforcer,
-		logger:   logger.NewDareLogger(),
-	}
+	a.enforcer = enforcer
+	return a
 }
 
 func (a *CasbinAuth) HasPermission(userID, action, asset string) bool {
+	enforcer := a.currentEnforcer()
 	user, ok := a.users[userID]
-	if !ok {
+	roles, _ := enforcer.GetRolesForUser(userID)
+	if !ok && len(roles) == 0 && len(enforcer.GetPermissionsForUser(userID)) == 0 {
 		a.logger.Error("Unknown user:", userID)
 		return false
 	}
 
-	for _, role := range user.Roles {
-		if a.enforcer.Enforce(role, asset, action) {
-			a.logger.Info(fmt.Sprintf("User '%s' is allowed to '%s' resource '%s'", userID, action, asset))
-			return true
+	allowed := enforcer.Enforce(userID, asset, action)
+	if ok {
+		for _, role := range user.Roles {
+			allowed = allowed || enforcer.Enforce(role, asset, action)
 		}
 	}
+	if allowed {
+		a.logger.Info(fmt.Sprintf("User '%s' is allowed to '%s' resource '%s'", userID, action, asset))
+		return true
+	}
 
 	a.logger.Info(fmt.Sprintf("User '%s' is not allowed to '%s' resource '%s'", userID, action, asset))
 	return false
 }
 
+// GetRolesForUser returns the roles of the user, given on creation or
+// assigned at runtime, in lexicographic order.
+func (a *CasbinAuth) GetRolesForUser(userID string) []string {
+	roles := make(map[string]bool)
+	if user, ok := a.users[userID]; ok {
+		for _, role := range user.Roles {
+			roles[role] = true
+		}
+	}
+	for _, role := range a.currentEnforcer().GetImplicitRolesForUser(userID) {
+		roles[role] = true
+	}
+	return sortedKeys(roles)
+}
+
+// AddRoleForUser assigns the role to the user. It returns false if the user
+// already had the role.
+func (a *CasbinAuth) AddRoleForUser(userID, role string) (bool, error) {
+	if userID == "" || role == "" {
+		return false, errors.New("user and role must not be empty")
+	}
+	return a.update(func(enforcer *casbin.Enforcer) bool {
+		return enforcer.AddRoleForUser(userID, role)
+	})
+}
+
+// DeleteRoleForUser removes a role assigned at runtime to the user. It
+// returns false if the user did not have the role.
+func (a *CasbinAuth) DeleteRoleForUser(userID, role string) (bool, error) {
+	return a.update(func(enforcer *casbin.Enforcer) bool {
+		return enforcer.DeleteRoleForUser(userID, role)
+	})
+}
+
+// DeleteRolesForUser removes every role assigned at runtime to the user.
+func (a *CasbinAuth) DeleteRolesForUser(userID string) (bool, error) {
+	return a.update(func(enforcer *casbin.Enforcer) bool {
+		return enforcer.DeleteRolesForUser(userID)
+	})
+}
+
+// GetPolicies returns every policy, in the order of the policy file.
+func (a *CasbinAuth) GetPolicies() []Policy {
+	return toPolicies(a.currentEnforcer().GetPolicy())
+}
+
+// AddPolicy adds the policy. It returns false if it already exists.
+func (a *CasbinAuth) AddPolicy(policy Policy) (bool, error) {
+	if policy.Subject == "" || policy.Asset == "" || policy.Action == "" {
+		return false, ErrInvalidPolicy
+	}
+	// The action is matched as a regular expression, an invalid one would fail every check
+	if _, err := regexp.Compile(policy.Action); err != nil {
+		return false, ErrInvalidPolicy
+	}
+	return a.update(func(enforcer *casbin.Enforcer) bool {
+		return enforcer.AddPolicy(policy.Subject, policy.Asset, policy.Action)
+	})
+}
+
+// RemovePolicy removes the policy. It returns false if it did not exist.
+func (a *CasbinAuth) RemovePolicy(policy Policy) (bool, error) {
+	return a.update(func(enforcer *casbin.Enforcer) bool {
+		return enforcer.RemovePolicy(policy.Subject, policy.Asset, policy.Action)
+	})
+}
+
+// GetPermissionsForUser returns the policies granted to the user, directly
+// or through its roles and the roles they inherit.
+func (a *CasbinAuth) GetPermissionsForUser(userID string) []Policy {
+	enforcer := a.currentEnforcer()
+	subjects := append([]string{userID}, a.GetRolesForUser(userID)...)
+	seen := make(map[Policy]bool)
+	permissions := []Policy{}
+	for _, subject := range subjects {
+		for _, policy := range toPolicies(enforcer.GetPermissionsForUser(subject)) {
+			if !seen[policy] {
+				seen[policy] = true
+				permissions = append(permissions, policy)
+			}
+		}
+	}
+	return permissions
+}
+
+// ReloadPolicy loads the policies again through the adapter, picking up
+// the changes made to the policy file.
+func (a *CasbinAuth) ReloadPolicy() error {
+	a.updateMu.Lock()
+	defer a.updateMu.Unlock()
+
+	enforcer, err := a.newEnforcer()
+	if err != nil {
+		return err
+	}
+	a.setEnforcer(enforcer)
+	return nil
+}
+
+// update applies change to a copy of the enforcer loaded through the
+// adapter, then saves it and makes it current if change reports a change.
+func (a *CasbinAuth) update(change func(enforcer *casbin.Enforcer) bool) (bool, error) {
+	a.updateMu.Lock()
+	defer a.updateMu.Unlock()
+
+	enforcer, err := a.newEnforcer()
+	if err != nil {
+		return false, err
+	}
+	if !change(enforcer) {
+		return false, nil
+	}
+	if err := enforcer.SavePolicy(); err != nil {
+		return false, err
+	}
+	a.setEnforcer(enforcer)
+	return true, nil
+}
+
+func (a *CasbinAuth) newEnforcer() (*casbin.Enforcer, error) {
+	enforcer, err := casbin.NewEnforcerSafe(a.modelPath, a.adapter)
+	if err != nil {
+		return nil, err
+	}
+	// Changes are saved as a whole, the file adapter cannot save a single rule
+	enforcer.EnableAutoSave(false)
+	return enforcer, nil
+}
+
+func (a *CasbinAuth) currentEnforcer() *casbin.Enforcer {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.enforcer
+}
+
+func (a *CasbinAuth) setEnforcer(enforcer *casbin.Enforcer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enforcer = enforcer
+}
+
+// CollectionAsset returns the asset of a collection. A policy on it also
+// applies to every key of the collection.
+func CollectionAsset(collection string) string {
+	return "collections/" + url.PathEscape(collection)
+}
+
+// KeyAsset returns the asset of a key of a collection, or of the collection
+// itself if key is empty.
+func KeyAsset(collection, key string) string {
+	if key == "" {
+		return CollectionAsset(collection)
+	}
+	return CollectionAsset(collection) + "/" + key
+}
+
+// MethodAction returns the action of a request on data with the given HTTP
+// method: reading for GET and HEAD, writing otherwise.
+func MethodAction(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return ACTION_READ
+	}
+	return ACTION_WRITE
+}
+
+func toPolicies(rules [][]string) []Policy {
+	policies := make([]Policy, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule) == 3 {
+			policies = append(policies, Policy{Subject: rule[0], Asset: rule[1], Action: rule[2]})
+		}
+	}
+	return policies
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetDefaultAuth returns the authorizer of the default model and policies.
+// Its users get their roles from the policies only.
 func GetDefaultAuth() *CasbinAuth {
 	dir, err := os.Getwd()
 	if err != nil {
@@ -75,7 +314,38 @@ func GetDefaultAuth() *CasbinAuth {
 	modelPath := filepath.Join(dir, RBAC_CONFIG_FILE)
 	policyPath := filepath.Join(dir, RBAC_POLICY_FILE)
 
-	return NewCasbinAuth(modelPath, policyPath, Users{
-		DEFAULT_USER: {Roles: []string{DEFAULT_ROLE}},
-	})
+	return NewCasbinAuth(modelPath, policyPath, Users{})
+}
+
+// GetDefaultAuthWithPolicyFile returns the default authorizer with its
+// policies kept in the file at policyPath, so that the changes made at
+// runtime outlive restarts. The file is created from the default policies
+// if it does not exist. The admin user, if not empty, is given DEFAULT_ROLE
+// unless it already has it.
+func GetDefaultAuthWithPolicyFile(policyPath string, adminUser string) *CasbinAuth {
+	dir, err := os.Getwd()
+	if err != nil {
+		panic("Failed to get current working directory: " + err.Error())
+	}
+
+	if _, err := os.Stat(policyPath); errors.Is(err, os.ErrNotExist) {
+		policies, err := os.ReadFile(filepath.Join(dir, RBAC_POLICY_FILE))
+		if err != nil {
+			panic("Failed to read the default policies: " + err.Error())
+		}
+		if err := os.MkdirAll(filepath.Dir(policyPath), 0755); err != nil {
+			panic("Failed to create the policy directory: " + err.Error())
+		}
+		if err := os.WriteFile(policyPath, policies, 0644); err != nil {
+			panic("Failed to write the policy file: " + err.Error())
+		}
+	}
+
+	a := NewCasbinAuth(filepath.Join(dir, RBAC_CONFIG_FILE), policyPath, Users{})
+	if adminUser != "" {
+		if _, err := a.AddRoleForUser(adminUser, DEFAULT_ROLE); err != nil {
+			panic("Failed to assign the admin role: " + err.Error())
+		}
+	}
+	return a
 }
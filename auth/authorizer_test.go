@@ -1,9 +1,13 @@
 package auth
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,11 +24,11 @@ g = _, _
 e = some(where (p.eft == allow))
 
 [matchers]
-m = g(r.sub, p.sub) && (p.obj == "*" || keyMatch(r.obj, p.obj)) && regexMatch(r.act, p.act)
+m = g(r.sub, p.sub) && (p.obj == "*" || keyMatch(r.obj, p.obj) || keyMatch(r.obj, p.obj + "/*")) && regexMatch(r.act, "^(" + p.act + ")$")
 `
 
-const RBAC_POLICY = `p, role1, *, GET
-p, role2, *, POST
+const RBAC_POLICY = `p, role1, *, read
+p, role2, *, write
 
 g, user1, role1
 g, user2, role2`
@@ -98,20 +102,20 @@ func TestHasPermission(t *testing.T) {
 		"user2": {Roles: []string{"role2"}},
 	})
 
-	// Test with user1 and GET on dare-db
-	ok := casbinAuth.HasPermission("user1", "GET", "dare-db")
+	// Test with user1 and read on dare-db
+	ok := casbinAuth.HasPermission("user1", ACTION_READ, "dare-db")
 	require.True(t, ok)
 
-	// Test with user2 and POST on dare-db
-	ok = casbinAuth.HasPermission("user2", "POST", "dare-db")
+	// Test with user2 and write on dare-db
+	ok = casbinAuth.HasPermission("user2", ACTION_WRITE, "dare-db")
 	require.True(t, ok)
 
-	// Test with user1 and POST on dare-db (should not have permission)
-	ok = casbinAuth.HasPermission("user1", "POST", "dare-db")
+	// Test with user1 and write on dare-db (should not have permission)
+	ok = casbinAuth.HasPermission("user1", ACTION_WRITE, "dare-db")
 	require.False(t, ok)
 
-	// Test with user2 and GET on dare-db (should not have permission)
-	ok = casbinAuth.HasPermission("user2", "GET", "dare-db")
+	// Test with user2 and read on dare-db (should not have permission)
+	ok = casbinAuth.HasPermission("user2", ACTION_READ, "dare-db")
 	require.False(t, ok)
 }
 
@@ -148,6 +152,114 @@ func TestUnknownUser(t *testing.T) {
 	})
 
 	// Test with unknown user
-	ok := casbinAuth.HasPermission("unknown", "GET", "dare-db")
+	ok := casbinAuth.HasPermission("unknown", ACTION_READ, "dare-db")
 	require.False(t, ok)
 }
+
+// newTestCasbinAuth creates an authorizer from the test model and policies,
+// kept in a temporary directory.
+func newTestCasbinAuth(t *testing.T) (*CasbinAuth, string) {
+	dir := t.TempDir()
+	modelPath := filepath.Join(dir, "rbac_model.conf")
+	policyPath := filepath.Join(dir, "rbac_policy.csv")
+	require.NoError(t, os.WriteFile(modelPath, []byte(RBAC_MODEL_CONTENT), 0644))
+	require.NoError(t, os.WriteFile(policyPath, []byte(RBAC_POLICY), 0644))
+	return NewCasbinAuth(modelPath, policyPath, Users{"admin": {Roles: []string{"role1", "role2"}}}), policyPath
+}
+
+func TestCasbinAuth_Roles(t *testing.T) {
+	casbinAuth, policyPath := newTestCasbinAuth(t)
+	require.False(t, casbinAuth.HasPermission("user3", ACTION_READ, "dare-db"))
+
+	added, err := casbinAuth.AddRoleForUser("user3", "role1")
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = casbinAuth.AddRoleForUser("user3", "role1")
+	require.NoError(t, err)
+	assert.False(t, added)
+	assert.True(t, casbinAuth.HasPermission("user3", ACTION_READ, "dare-db"))
+	assert.False(t, casbinAuth.HasPermission("user3", ACTION_WRITE, "dare-db"))
+	assert.Equal(t, []string{"role1"}, casbinAuth.GetRolesForUser("user3"))
+	assert.Equal(t, []string{"role1", "role2"}, casbinAuth.GetRolesForUser("admin"))
+
+	// The assignment is saved and loaded by a new authorizer
+	reloaded := NewCasbinAuth(casbinAuth.modelPath, policyPath, nil)
+	assert.True(t, reloaded.HasPermission("user3", ACTION_READ, "dare-db"))
+
+	removed, err := casbinAuth.DeleteRoleForUser("user3", "role1")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.False(t, casbinAuth.HasPermission("user3", ACTION_READ, "dare-db"))
+}
+
+func TestCasbinAuth_Policies(t *testing.T) {
+	casbinAuth, policyPath := newTestCasbinAuth(t)
+
+	_, err := casbinAuth.AddPolicy(Policy{Subject: "role1", Asset: "*", Action: "("})
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+	added, err := casbinAuth.AddPolicy(Policy{Subject: "role1", Asset: "collections/reports", Action: ACTION_WRITE})
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Contains(t, casbinAuth.GetPolicies(), Policy{Subject: "role1", Asset: "collections/reports", Action: ACTION_WRITE})
+	assert.True(t, casbinAuth.HasPermission("user1", ACTION_WRITE, "collections/reports/2024"))
+	assert.False(t, casbinAuth.HasPermission("user1", ACTION_WRITE, "dare-db"))
+	assert.ElementsMatch(t, []Policy{
+		{Subject: "role1", Asset: "*", Action: ACTION_READ},
+		{Subject: "role1", Asset: "collections/reports", Action: ACTION_WRITE},
+	}, casbinAuth.GetPermissionsForUser("user1"))
+
+	removed, err := casbinAuth.RemovePolicy(Policy{Subject: "role1", Asset: "collections/reports", Action: ACTION_WRITE})
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.False(t, casbinAuth.HasPermission("user1", ACTION_WRITE, "collections/reports/2024"))
+
+	// Changes made to the file are picked up by a reload
+	require.NoError(t, os.WriteFile(policyPath, []byte(RBAC_POLICY+"\np, role1, *, write"), 0644))
+	assert.False(t, casbinAuth.HasPermission("user1", ACTION_WRITE, "dare-db"))
+	require.NoError(t, casbinAuth.ReloadPolicy())
+	assert.True(t, casbinAuth.HasPermission("user1", ACTION_WRITE, "dare-db"))
+}
+
+func TestCasbinAuth_ConcurrentChanges(t *testing.T) {
+	casbinAuth, _ := newTestCasbinAuth(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.True(t, casbinAuth.HasPermission("user1", ACTION_READ, "dare-db"))
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		_, err := casbinAuth.AddRoleForUser(fmt.Sprintf("user%d", i+10), "role2")
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	assert.True(t, casbinAuth.HasPermission("user19", ACTION_WRITE, "dare-db"))
+}
+
+func TestGetDefaultAuthWithPolicyFile(t *testing.T) {
+	// The default model and policies are found from the root of the repository
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(".."))
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	policyPath := filepath.Join(t.TempDir(), POLICY_FILE)
+	casbinAuth := GetDefaultAuthWithPolicyFile(policyPath, "alice")
+	assert.True(t, casbinAuth.HasPermission("alice", ACTION_ADMIN, SERVER_ASSET))
+	assert.False(t, casbinAuth.HasPermission("bob", ACTION_READ, SERVER_ASSET))
+	assert.Equal(t, []string{DEFAULT_ROLE}, casbinAuth.GetRolesForUser("alice"))
+
+	// Restarting keeps the assignment without adding it again
+	policies, err := os.ReadFile(policyPath)
+	require.NoError(t, err)
+	casbinAuth = GetDefaultAuthWithPolicyFile(policyPath, "alice")
+	assert.True(t, casbinAuth.HasPermission("alice", ACTION_ADMIN, SERVER_ASSET))
+	reloaded, err := os.ReadFile(policyPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(policies), string(reloaded))
+}
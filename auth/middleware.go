@@ -1,15 +1,37 @@
 package auth
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
-	"strings"
 
+	"github.com/dmarro89/dare-db/database"
 	"github.com/dmarro89/dare-db/logger"
 )
 
+// The path values of the routes naming the collection and the key of a
+// request, from which the middleware derives the asset.
+const COLLECTION_PATH_VALUE = "collectionName"
+const KEY_PATH_VALUE = "key"
+
 type Middleware interface {
+	// HandleFunc authorizes reading or writing, by the HTTP method, the key
+	// and collection of the path
 	HandleFunc(next http.HandlerFunc) http.HandlerFunc
+	// HandleAdminFunc authorizes administering the collection of the path
+	HandleAdminFunc(next http.HandlerFunc) http.HandlerFunc
+	// HandleServerFunc authorizes running the action on the server
+	HandleServerFunc(action string, next http.HandlerFunc) http.HandlerFunc
+	// HandleAccessFunc authorizes every access returned by accesses, such as
+	// the keys named in the body of the request
+	HandleAccessFunc(accesses func(r *http.Request) ([]Access, error), next http.HandlerFunc) http.HandlerFunc
+}
+
+// Access is an action of a request on an asset.
+type Access struct {
+	Action string
+	Asset  string
 }
 type DareMiddleware struct {
 	authorizer    Authorizer
@@ -26,6 +48,41 @@ func NewCasbinMiddleware(casbinAuth Authorizer, authenticator Authenticator) Mid
 }
 
 func (middleware *DareMiddleware) HandleFunc(next http.HandlerFunc) http.HandlerFunc {
+	return middleware.handle(next, func(r *http.Request) ([]Access, error) {
+		return []Access{{Action: MethodAction(r.Method), Asset: extractAssetFromPath(r)}}, nil
+	})
+}
+
+func (middleware *DareMiddleware) HandleAdminFunc(next http.HandlerFunc) http.HandlerFunc {
+	return middleware.handle(next, func(r *http.Request) ([]Access, error) {
+		return []Access{{Action: ACTION_ADMIN, Asset: CollectionAsset(collectionFromPath(r))}}, nil
+	})
+}
+
+func (middleware *DareMiddleware) HandleServerFunc(action string, next http.HandlerFunc) http.HandlerFunc {
+	return middleware.handle(next, func(r *http.Request) ([]Access, error) {
+		return []Access{{Action: action, Asset: SERVER_ASSET}}, nil
+	})
+}
+
+// HandleAccessFunc lets accesses read the body of the request, which is
+// then given again to next. A request whose accesses cannot be read, such as
+// an invalid body, is passed to next, which rejects it.
+func (middleware *DareMiddleware) HandleAccessFunc(accesses func(r *http.Request) ([]Access, error), next http.HandlerFunc) http.HandlerFunc {
+	return middleware.handle(next, func(r *http.Request) ([]Access, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		defer func() { r.Body = io.NopCloser(bytes.NewReader(body)) }()
+		return accesses(r)
+	})
+}
+
+// handle authenticates the user of the request, then checks its permission
+// for every access returned by authorization.
+func (middleware *DareMiddleware) handle(next http.HandlerFunc, authorization func(r *http.Request) ([]Access, error)) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := r.Header.Get("Authorization")
 		if tokenStr == "" {
@@ -41,28 +98,37 @@ func (middleware *DareMiddleware) HandleFunc(next http.HandlerFunc) http.Handler
 			return
 		}
 
-		asset := middleware.extractAssetFromPath(r.URL.Path)
-
-		middleware.logger.Info(fmt.Sprintf("User '%s' is requesting '%s' resource '%s'", username, r.Method, asset))
-		if !middleware.authorizer.HasPermission(username, r.Method, asset) {
-			middleware.logger.Info(fmt.Sprintf("User '%s' is not allowed to '%s' resource '%s'", username, r.Method, asset))
-			http.Error(w, "Forbidden: you do not have permission to access this resource", http.StatusForbidden)
+		accesses, err := authorization(r)
+		if err != nil {
+			// The handler answers the invalid requests
+			next(w, r)
 			return
 		}
 
+		for _, access := range accesses {
+			middleware.logger.Info(fmt.Sprintf("User '%s' is requesting '%s' resource '%s'", username, access.Action, access.Asset))
+			if !middleware.authorizer.HasPermission(username, access.Action, access.Asset) {
+				middleware.logger.Info(fmt.Sprintf("User '%s' is not allowed to '%s' resource '%s'", username, access.Action, access.Asset))
+				http.Error(w, "Forbidden: you do not have permission to access this resource", http.StatusForbidden)
+				return
+			}
+		}
+
 		next(w, r)
 	})
 }
 
-func (middleware *DareMiddleware) extractAssetFromPath(path string) string {
-	if strings.HasPrefix(path, "/get/") {
-		return strings.TrimPrefix(path, "/get/")
-	}
-	if strings.HasPrefix(path, "/delete/") {
-		return strings.TrimPrefix(path, "/delete/")
-	}
-	if path == "/set" {
-		return "set"
+// extractAssetFromPath returns the asset of the key of the matched route, or
+// of its collection if the route has no key.
+func extractAssetFromPath(r *http.Request) string {
+	return KeyAsset(collectionFromPath(r), r.PathValue(KEY_PATH_VALUE))
+}
+
+// collectionFromPath returns the collection of the matched route, the
+// default collection if the route has none.
+func collectionFromPath(r *http.Request) string {
+	if collection := r.PathValue(COLLECTION_PATH_VALUE); collection != "" {
+		return collection
 	}
-	return "dare-db"
+	return database.DEFAULT_COLLECTION
 }
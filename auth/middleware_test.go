@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/dmarro89/dare-db/logger"
@@ -65,7 +67,6 @@ func TestMiddleware(t *testing.T) {
 	token, err := middleware.authenticator.GenerateToken("user1")
 	require.NoError(t, err)
 	assert.NotNil(t, token)
-	userStore.SaveToken("user1", token)
 	req.Header.Set("Authorization", token)
 
 	rr := httptest.NewRecorder()
@@ -88,7 +89,6 @@ func TestMiddleware(t *testing.T) {
 	require.NoError(t, err)
 	token, err = middleware.authenticator.GenerateToken("user2")
 	assert.Nil(t, err)
-	userStore.SaveToken("user2", token)
 	req.Header.Set("Authorization", token)
 
 	rr = httptest.NewRecorder()
@@ -115,3 +115,87 @@ func TestMiddleware(t *testing.T) {
 
 	require.Equal(t, http.StatusUnauthorized, rr.Code)
 }
+
+func TestMiddleware_Assets(t *testing.T) {
+	casbinAuth, policyPath := newTestCasbinAuth(t)
+	require.NoError(t, os.WriteFile(policyPath, []byte(`p, team, collections/team, read
+p, bob, collections/default/user:*, read|write
+p, ops, collections/team, admin
+p, ops, dare-db, admin
+
+g, alice, team
+g, carol, ops`), 0644))
+	require.NoError(t, casbinAuth.ReloadPolicy())
+
+	userStore := NewUserStore()
+	middleware := NewCasbinMiddleware(casbinAuth, NewJWTAutenticatorWithUsers(userStore))
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /get/{key}", middleware.HandleFunc(ok))
+	// The keys of the body are authorized, in the default collection
+	bodyKeys := func(r *http.Request) ([]Access, error) {
+		var data map[string]string
+		err := json.NewDecoder(r.Body).Decode(&data)
+		var accesses []Access
+		for key := range data {
+			accesses = append(accesses, Access{Action: ACTION_WRITE, Asset: KeyAsset("default", key)})
+		}
+		return accesses, err
+	}
+	mux.HandleFunc("POST /set", middleware.HandleAccessFunc(bodyKeys, func(w http.ResponseWriter, r *http.Request) {
+		// The body is given again to the handler
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		}
+	}))
+	mux.HandleFunc("GET /collections/{collectionName}/get/{key}", middleware.HandleFunc(ok))
+	mux.HandleFunc("POST /collections/{collectionName}/set", middleware.HandleFunc(ok))
+	mux.HandleFunc("DELETE /collections/{collectionName}", middleware.HandleAdminFunc(ok))
+	mux.HandleFunc("GET /admin/users", middleware.HandleServerFunc(ACTION_ADMIN, ok))
+
+	tokens := make(map[string]string)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		token, err := NewJWTAutenticatorWithUsers(userStore).GenerateToken(username)
+		require.NoError(t, err)
+		tokens[username] = token
+	}
+
+	for _, test := range []struct {
+		user, method, path, body string
+		code                     int
+	}{
+		{"alice", "GET", "/collections/team/get/key", "", http.StatusOK},
+		{"alice", "GET", "/collections/teams/get/key", "", http.StatusForbidden},
+		{"alice", "GET", "/get/key", "", http.StatusForbidden},
+		{"alice", "POST", "/collections/team/set", "", http.StatusForbidden},
+		{"alice", "DELETE", "/collections/team", "", http.StatusForbidden},
+		{"bob", "GET", "/get/user:1", "", http.StatusOK},
+		{"bob", "GET", "/collections/default/get/user:1", "", http.StatusOK},
+		{"bob", "GET", "/get/order:1", "", http.StatusForbidden},
+		{"bob", "POST", "/set", `{"user:1": "value", "user:2": "value"}`, http.StatusOK},
+		{"bob", "POST", "/set", `{"user:1": "value", "order:1": "value"}`, http.StatusForbidden},
+		{"bob", "POST", "/set", `{"order:1": "value"}`, http.StatusForbidden},
+		{"bob", "POST", "/set", `invalid`, http.StatusBadRequest},
+		{"carol", "DELETE", "/collections/team", "", http.StatusOK},
+		{"carol", "DELETE", "/collections/other", "", http.StatusForbidden},
+		{"carol", "GET", "/collections/team/get/key", "", http.StatusForbidden},
+		{"carol", "GET", "/admin/users", "", http.StatusOK},
+		{"alice", "GET", "/admin/users", "", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Header.Set("Authorization", tokens[test.user])
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		assert.Equal(t, test.code, rr.Code, "%s %s %s %s", test.user, test.method, test.path, test.body)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	assert.Equal(t, "collections/team", CollectionAsset("team"))
+	assert.Equal(t, "collections/a%2Fb", CollectionAsset("a/b"))
+	assert.Equal(t, "collections/team/user:1", KeyAsset("team", "user:1"))
+	assert.Equal(t, "collections/team", KeyAsset("team", ""))
+	assert.Equal(t, ACTION_READ, MethodAction(http.MethodGet))
+	assert.Equal(t, ACTION_WRITE, MethodAction(http.MethodDelete))
+}
@@ -1,36 +1,118 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// USERS_FILE is the file of the settings directory holding the users.
+const USERS_FILE = "users.json"
+
+// MAX_PASSWORD_LENGTH is the longest password accepted, in bytes, as bcrypt
+// ignores the bytes after it.
+const MAX_PASSWORD_LENGTH = 72
+
+// RESET_PASSWORD_BYTES is the number of random bytes of the passwords
+// generated by ResetPassword.
+const RESET_PASSWORD_BYTES = 16
+
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user does not exist")
+	ErrInvalidUsername    = errors.New("username must not be empty")
+	ErrInvalidPassword    = errors.New("password must be between 1 and 72 bytes")
+	ErrSessionNotFound    = errors.New("session does not exist")
+	ErrRefreshTokenReused = errors.New("refresh token already used, its session is revoked")
 )
 
+// userRecord is a user as stored in the users file.
+type userRecord struct {
+	PasswordHash string `json:"password_hash"`
+}
+
+// Session is a token issued to a user, identified by its JWT ID.
+type Session struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// refreshFamily is the refresh token currently valid among the ones issued
+// from the same login, and the session of the access token issued with it.
+type refreshFamily struct {
+	refreshID string
+	accessID  string
+	expiresAt time.Time
+}
+
+// UserStore keeps the users with the bcrypt hash of their password, and
+// the sessions of their tokens, any number per user. A store created with
+// NewUserStoreWithFile saves the users into its file on every change; the
+// sessions and refresh tokens are kept in memory only.
 type UserStore struct {
-	usersMu sync.RWMutex
-	tokenMu sync.RWMutex
-	users   map[string]string
-	tokens  map[string]string
+	usersMu  sync.RWMutex
+	tokenMu  sync.RWMutex
+	users    map[string]userRecord
+	sessions map[string]map[string]time.Time
+	families map[string]map[string]refreshFamily
+	path     string
 }
 
 func NewUserStore() *UserStore {
 	return &UserStore{
-		users:   make(map[string]string),
-		tokens:  make(map[string]string),
-		usersMu: sync.RWMutex{},
-		tokenMu: sync.RWMutex{},
+		users:    make(map[string]userRecord),
+		sessions: make(map[string]map[string]time.Time),
+		families: make(map[string]map[string]refreshFamily),
+		usersMu:  sync.RWMutex{},
+		tokenMu:  sync.RWMutex{},
 	}
 }
 
+// NewUserStoreWithFile creates a store persisted into the file at path,
+// loading the users already saved in it, if any.
+func NewUserStoreWithFile(path string) (*UserStore, error) {
+	store := NewUserStore()
+	store.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.users); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
 func (store *UserStore) AddUser(username, password string) error {
+	if username == "" {
+		return ErrInvalidUsername
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
 	store.usersMu.Lock()
 	defer store.usersMu.Unlock()
 
 	if _, exists := store.users[username]; exists {
-		return errors.New("user already exists")
+		return ErrUserExists
 	}
 
-	store.users[username] = password
-	return nil
+	store.users[username] = userRecord{PasswordHash: hash}
+	return store.saveLocked()
 }
 
 func (store *UserStore) DeleteUser(username string) error {
@@ -38,56 +120,273 @@ func (store *UserStore) DeleteUser(username string) error {
 	defer store.usersMu.Unlock()
 
 	if _, exists := store.users[username]; !exists {
-		return errors.New("user does not exist")
+		return ErrUserNotFound
 	}
 
 	delete(store.users, username)
-	store.DeleteToken(username)
-	return nil
+	store.DeleteSessions(username)
+	return store.saveLocked()
 }
 
+// UpdatePassword replaces the password of the user and revokes its
+// sessions, along with their refresh tokens.
 func (store *UserStore) UpdatePassword(username, newPassword string) error {
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
 	store.usersMu.Lock()
 	defer store.usersMu.Unlock()
 
 	if _, exists := store.users[username]; !exists {
-		return errors.New("user does not exist")
+		return ErrUserNotFound
 	}
 
-	store.users[username] = newPassword
-	return nil
+	store.users[username] = userRecord{PasswordHash: hash}
+	store.DeleteSessions(username)
+	return store.saveLocked()
 }
 
-func (store *UserStore) ValidateCredentials(username, password string) bool {
+// ResetPassword replaces the password of the user with a random one, which
+// is returned, and revokes the sessions of the user.
+func (store *UserStore) ResetPassword(username string) (string, error) {
+	random := make([]byte, RESET_PASSWORD_BYTES)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	password := hex.EncodeToString(random)
+
+	if err := store.UpdatePassword(username, password); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// ListUsers returns the usernames in lexicographic order.
+func (store *UserStore) ListUsers() []string {
+	store.usersMu.RLock()
+	defer store.usersMu.RUnlock()
+
+	usernames := make([]string, 0, len(store.users))
+	for username := range store.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// HasUser reports whether the user exists.
+func (store *UserStore) HasUser(username string) bool {
 	store.usersMu.RLock()
 	defer store.usersMu.RUnlock()
+	_, exists := store.users[username]
+	return exists
+}
+
+// ValidateCredentials reports whether password is the password of the user,
+// checked against its bcrypt hash.
+func (store *UserStore) ValidateCredentials(username, password string) bool {
+	store.usersMu.RLock()
+	record, exists := store.users[username]
+	store.usersMu.RUnlock()
+	if !exists {
+		return false
+	}
 
-	storedPassword, exists := store.users[username]
-	return exists && storedPassword == password
+	return bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)) == nil
 }
 
-func (store *UserStore) SaveToken(username, token string) {
+// SaveSession registers the session id of the user, valid until expiresAt.
+// The expired sessions and refresh tokens of every user are dropped
+// meanwhile.
+func (store *UserStore) SaveSession(username, id string, expiresAt time.Time) {
 	store.tokenMu.Lock()
 	defer store.tokenMu.Unlock()
-	store.tokens[username] = token
+
+	now := time.Now()
+	for user, sessions := range store.sessions {
+		for sessionID, sessionExpiresAt := range sessions {
+			if !sessionExpiresAt.After(now) {
+				delete(sessions, sessionID)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(store.sessions, user)
+		}
+	}
+	for user, families := range store.families {
+		for familyID, family := range families {
+			if !family.expiresAt.After(now) {
+				delete(families, familyID)
+			}
+		}
+		if len(families) == 0 {
+			delete(store.families, user)
+		}
+	}
+
+	store.saveSessionLocked(username, id, expiresAt)
 }
 
-func (store *UserStore) DeleteToken(username string) error {
+func (store *UserStore) saveSessionLocked(username, id string, expiresAt time.Time) {
+	if store.sessions[username] == nil {
+		store.sessions[username] = make(map[string]time.Time)
+	}
+	store.sessions[username][id] = expiresAt
+}
+
+// SaveRefreshFamily registers the refresh token refreshID of the user, valid
+// until expiresAt, as the first of the family familyID, issued along with
+// the session accessID.
+func (store *UserStore) SaveRefreshFamily(username, familyID, refreshID, accessID string, expiresAt time.Time) {
 	store.tokenMu.Lock()
 	defer store.tokenMu.Unlock()
 
-	if _, exists := store.tokens[username]; !exists {
-		return errors.New("user does not exist")
+	if store.families[username] == nil {
+		store.families[username] = make(map[string]refreshFamily)
 	}
+	store.families[username][familyID] = refreshFamily{refreshID: refreshID, accessID: accessID, expiresAt: expiresAt}
+}
+
+// RotateRefreshToken replaces the refresh token refreshID of the family with
+// newRefreshID, and the session of the previous access token of the family
+// with newAccessID. If refreshID is not the current refresh token of the
+// family, it was already used: the family and its session are revoked and
+// ErrRefreshTokenReused is returned.
+func (store *UserStore) RotateRefreshToken(username, familyID, refreshID, newRefreshID, newAccessID string, accessExpiresAt, refreshExpiresAt time.Time) error {
+	store.tokenMu.Lock()
+	defer store.tokenMu.Unlock()
 
-	delete(store.tokens, username)
+	family, exists := store.families[username][familyID]
+	if !exists || !family.expiresAt.After(time.Now()) {
+		return ErrSessionNotFound
+	}
+	store.deleteSessionLocked(username, family.accessID)
+	if family.refreshID != refreshID {
+		store.deleteFamilyLocked(username, familyID)
+		return ErrRefreshTokenReused
+	}
 
+	store.saveSessionLocked(username, newAccessID, accessExpiresAt)
+	store.families[username][familyID] = refreshFamily{refreshID: newRefreshID, accessID: newAccessID, expiresAt: refreshExpiresAt}
 	return nil
 }
 
-func (store *UserStore) ValidateToken(username, token string) bool {
-	store.usersMu.RLock()
-	defer store.usersMu.RUnlock()
-	storedToken, exists := store.tokens[username]
-	return exists && storedToken == token
+// ValidateSession reports whether the session id of the user exists and has
+// not expired.
+func (store *UserStore) ValidateSession(username, id string) bool {
+	store.tokenMu.RLock()
+	defer store.tokenMu.RUnlock()
+	expiresAt, exists := store.sessions[username][id]
+	return exists && expiresAt.After(time.Now())
+}
+
+// DeleteSession revokes the session id of the user, and the refresh token
+// issued with it, if any.
+func (store *UserStore) DeleteSession(username, id string) error {
+	store.tokenMu.Lock()
+	defer store.tokenMu.Unlock()
+
+	if _, exists := store.sessions[username][id]; !exists {
+		return ErrSessionNotFound
+	}
+	store.deleteSessionLocked(username, id)
+	for familyID, family := range store.families[username] {
+		if family.accessID == id {
+			store.deleteFamilyLocked(username, familyID)
+		}
+	}
+	return nil
+}
+
+// DeleteSessions revokes every session and refresh token of the user,
+// returning the number of sessions.
+func (store *UserStore) DeleteSessions(username string) int {
+	store.tokenMu.Lock()
+	defer store.tokenMu.Unlock()
+
+	count := len(store.sessions[username])
+	delete(store.sessions, username)
+	delete(store.families, username)
+	return count
+}
+
+func (store *UserStore) deleteSessionLocked(username, id string) {
+	delete(store.sessions[username], id)
+	if len(store.sessions[username]) == 0 {
+		delete(store.sessions, username)
+	}
+}
+
+func (store *UserStore) deleteFamilyLocked(username, familyID string) {
+	delete(store.families[username], familyID)
+	if len(store.families[username]) == 0 {
+		delete(store.families, username)
+	}
+}
+
+// ListSessions returns the sessions of the user which have not expired, by
+// expiration time.
+func (store *UserStore) ListSessions(username string) []Session {
+	store.tokenMu.RLock()
+	defer store.tokenMu.RUnlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0, len(store.sessions[username]))
+	for id, expiresAt := range store.sessions[username] {
+		if expiresAt.After(now) {
+			sessions = append(sessions, Session{ID: id, ExpiresAt: expiresAt})
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].ExpiresAt.Equal(sessions[j].ExpiresAt) {
+			return sessions[i].ExpiresAt.Before(sessions[j].ExpiresAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// saveLocked writes the users atomically into the file of the store, if
+// any: the data is written to a temporary file, synced and then renamed into
+// place. The caller must hold usersMu.
+func (store *UserStore) saveLocked() error {
+	if store.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(store.users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(store.path), USERS_FILE+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), store.path)
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) == 0 || len(password) > MAX_PASSWORD_LENGTH {
+		return "", ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
 }
@@ -1,9 +1,14 @@
 package auth
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUserStore_AddUser(t *testing.T) {
@@ -73,31 +78,126 @@ func TestUserStore_ValidateCredentials(t *testing.T) {
 	assert.False(t, valid, "Expected credentials to be invalid for non-existing user")
 }
 
-func TestUserStore_SaveToken(t *testing.T) {
+func TestUserStore_SaveSession(t *testing.T) {
 	store := NewUserStore()
 
-	// Save a token for a user
-	store.SaveToken("user1", "token123")
+	// Save two sessions for a user
+	store.SaveSession("user1", "session1", time.Now().Add(time.Hour))
+	store.SaveSession("user1", "session2", time.Now().Add(2*time.Hour))
 
-	// Test that the token was saved correctly
-	assert.True(t, store.ValidateToken("user1", "token123"), "Expected the token to be valid")
+	// Test that both sessions are valid
+	assert.True(t, store.ValidateSession("user1", "session1"), "Expected the first session to be valid")
+	assert.True(t, store.ValidateSession("user1", "session2"), "Expected the second session to be valid")
+	assert.Equal(t, []string{"session1", "session2"}, sessionIDs(store.ListSessions("user1")))
 }
 
-func TestUserStore_ValidateToken(t *testing.T) {
+func TestUserStore_ValidateSession(t *testing.T) {
 	store := NewUserStore()
 
-	// Save a token for a user
-	store.SaveToken("user1", "token123")
+	// Save a session for a user
+	store.SaveSession("user1", "session1", time.Now().Add(time.Hour))
 
-	// Test validating correct token
-	valid := store.ValidateToken("user1", "token123")
-	assert.True(t, valid, "Expected the token to be valid")
+	// Test validating correct session
+	valid := store.ValidateSession("user1", "session1")
+	assert.True(t, valid, "Expected the session to be valid")
 
-	// Test validating incorrect token
-	valid = store.ValidateToken("user1", "wrongtoken")
-	assert.False(t, valid, "Expected the token to be invalid")
+	// Test validating incorrect session
+	valid = store.ValidateSession("user1", "wrongsession")
+	assert.False(t, valid, "Expected the session to be invalid")
 
-	// Test validating token for non-existing user
-	valid = store.ValidateToken("user2", "token123")
-	assert.False(t, valid, "Expected the token to be invalid for non-existing user")
+	// Test validating session for non-existing user
+	valid = store.ValidateSession("user2", "session1")
+	assert.False(t, valid, "Expected the session to be invalid for non-existing user")
+
+	// Test validating expired session
+	store.SaveSession("user1", "expired", time.Now().Add(-time.Minute))
+	assert.False(t, store.ValidateSession("user1", "expired"), "Expected the session to be expired")
+}
+
+func TestUserStore_DeleteSessions(t *testing.T) {
+	store := NewUserStore()
+	store.SaveSession("user1", "session1", time.Now().Add(time.Hour))
+	store.SaveSession("user1", "session2", time.Now().Add(time.Hour))
+	store.SaveSession("user2", "session3", time.Now().Add(time.Hour))
+
+	require.NoError(t, store.DeleteSession("user1", "session1"))
+	assert.ErrorIs(t, store.DeleteSession("user1", "session1"), ErrSessionNotFound)
+	assert.ErrorIs(t, store.DeleteSession("user2", "session2"), ErrSessionNotFound)
+	assert.False(t, store.ValidateSession("user1", "session1"))
+	assert.True(t, store.ValidateSession("user1", "session2"))
+
+	assert.Equal(t, 1, store.DeleteSessions("user1"))
+	assert.Empty(t, store.ListSessions("user1"))
+	assert.True(t, store.ValidateSession("user2", "session3"))
+}
+
+func TestUserStore_DropsExpiredSessions(t *testing.T) {
+	store := NewUserStore()
+	store.SaveSession("user1", "expired", time.Now().Add(-time.Minute))
+	store.SaveSession("user2", "expired", time.Now().Add(-time.Minute))
+	store.SaveSession("user2", "valid", time.Now().Add(time.Hour))
+	assert.Empty(t, store.ListSessions("user1"))
+
+	// Saving a session drops the expired ones of every user
+	store.SaveSession("user3", "valid", time.Now().Add(time.Hour))
+	assert.NotContains(t, store.sessions, "user1")
+	assert.Equal(t, map[string]time.Time{"valid": store.sessions["user2"]["valid"]}, store.sessions["user2"])
+}
+
+func sessionIDs(sessions []Session) []string {
+	ids := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+func TestUserStore_HashesPasswords(t *testing.T) {
+	store := NewUserStore()
+	require.NoError(t, store.AddUser("user1", "password1"))
+	assert.NotEqual(t, "password1", store.users["user1"].PasswordHash)
+
+	assert.ErrorIs(t, store.AddUser("user2", ""), ErrInvalidPassword)
+	assert.ErrorIs(t, store.AddUser("user2", strings.Repeat("a", MAX_PASSWORD_LENGTH+1)), ErrInvalidPassword)
+	assert.ErrorIs(t, store.AddUser("", "password"), ErrInvalidUsername)
+
+	assert.True(t, store.ValidateCredentials("user1", "password1"))
+	require.NoError(t, store.UpdatePassword("user1", "password2"))
+	assert.False(t, store.ValidateCredentials("user1", "password1"))
+	assert.True(t, store.ValidateCredentials("user1", "password2"))
+}
+
+func TestUserStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), USERS_FILE)
+	store, err := NewUserStoreWithFile(path)
+	require.NoError(t, err)
+	require.NoError(t, store.AddUser("user1", "password1"))
+	require.NoError(t, store.AddUser("user2", "password2"))
+	require.NoError(t, store.UpdatePassword("user2", "newpassword"))
+	require.NoError(t, store.DeleteUser("user1"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "newpassword")
+
+	loaded, err := NewUserStoreWithFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2"}, loaded.ListUsers())
+	assert.True(t, loaded.ValidateCredentials("user2", "newpassword"))
+}
+
+func TestUserStore_ResetPassword(t *testing.T) {
+	store := NewUserStore()
+	require.NoError(t, store.AddUser("user1", "password1"))
+	store.SaveSession("user1", "session1", time.Now().Add(time.Hour))
+
+	password, err := store.ResetPassword("user1")
+	require.NoError(t, err)
+	assert.Len(t, password, 2*RESET_PASSWORD_BYTES)
+	assert.True(t, store.ValidateCredentials("user1", password))
+	assert.False(t, store.ValidateCredentials("user1", "password1"))
+	assert.False(t, store.ValidateSession("user1", "session1"), "Expected the session to be revoked")
+
+	_, err = store.ResetPassword("user2")
+	assert.ErrorIs(t, err, ErrUserNotFound)
 }
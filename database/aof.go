@@ -0,0 +1,409 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmarro89/dare-db/logger"
+)
+
+const AOF_FILE_NAME = "appendonly.aof"
+const DEFAULT_AOF_REWRITE_MIN_SIZE int64 = 64 * 1024 * 1024
+
+type FsyncPolicy string
+
+const (
+	FSYNC_ALWAYS   FsyncPolicy = "always"
+	FSYNC_EVERYSEC FsyncPolicy = "everysec"
+	FSYNC_NO       FsyncPolicy = "no"
+)
+
+var ErrRewriteInProgress = errors.New("append log rewrite already in progress")
+
+// ParseFsyncPolicy returns the policy matching name, defaulting to FSYNC_EVERYSEC.
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch FsyncPolicy(name) {
+	case FSYNC_ALWAYS, FSYNC_EVERYSEC, FSYNC_NO:
+		return FsyncPolicy(name), nil
+	case "":
+		return FSYNC_EVERYSEC, nil
+	}
+	return "", fmt.Errorf("unknown fsync policy %q", name)
+}
+
+// AppendLog is a Journal writing every operation as a JSON line to a file.
+// Replaying the file on top of the latest snapshot restores the operations
+// issued after the snapshot was taken.
+type AppendLog struct {
+	path              string
+	fsync             FsyncPolicy
+	rewriteMinSize    int64
+	collectionManager *CollectionManager
+	logger            logger.Logger
+
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	size     int64
+	baseSize int64
+	dirty    bool
+
+	rewriting atomic.Bool
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewAppendLog(path string, fsync FsyncPolicy, rewriteMinSize int64) *AppendLog {
+	if rewriteMinSize <= 0 {
+		rewriteMinSize = DEFAULT_AOF_REWRITE_MIN_SIZE
+	}
+	return &AppendLog{
+		path:           path,
+		fsync:          fsync,
+		rewriteMinSize: rewriteMinSize,
+		logger:         logger.NewDareLogger(),
+	}
+}
+
+// Replay applies the operations of the log with a sequence number greater
+// than afterSeq. A truncated final record, left by a crash in the middle of a
+// write, is discarded and cut from the file. It returns the number of
+// operations applied.
+func (aof *AppendLog) Replay(collectionManager *CollectionManager, afterSeq uint64) (int, error) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.seq = afterSeq
+
+	file, err := os.Open(aof.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open append log: %w", err)
+	}
+	defer file.Close()
+
+	applied := 0
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) == 0 && errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return applied, fmt.Errorf("failed to read append log: %w", readErr)
+		}
+
+		var op Operation
+		if err := json.Unmarshal(line, &op); err != nil || readErr != nil {
+			// Only the last record may be incomplete
+			if _, peekErr := reader.Peek(1); readErr == nil && peekErr == nil {
+				return applied, fmt.Errorf("corrupted append log record at offset %d: %w", offset, err)
+			}
+			aof.logger.Warn("Discarding truncated append log record at offset ", offset)
+			if err := os.Truncate(aof.path, offset); err != nil {
+				return applied, fmt.Errorf("failed to truncate append log: %w", err)
+			}
+			break
+		}
+		offset += int64(len(line))
+
+		if op.Seq > aof.seq {
+			aof.seq = op.Seq
+		}
+		if op.Seq <= afterSeq {
+			continue
+		}
+		if err := collectionManager.Apply(op); err != nil {
+			return applied, fmt.Errorf("failed to replay operation %d: %w", op.Seq, err)
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+// Open opens the log for appending, attaches it as journal of the collection
+// manager and starts the background fsync and rewrite loop.
+func (aof *AppendLog) Open(collectionManager *CollectionManager) error {
+	if err := os.MkdirAll(filepath.Dir(aof.path), 0755); err != nil {
+		return fmt.Errorf("failed to create append log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(aof.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open append log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat append log: %w", err)
+	}
+
+	aof.mu.Lock()
+	aof.file = file
+	aof.size = info.Size()
+	aof.baseSize = info.Size()
+	aof.collectionManager = collectionManager
+	aof.mu.Unlock()
+
+	collectionManager.SetJournal(aof)
+
+	aof.stop = make(chan struct{})
+	aof.done = make(chan struct{})
+	go aof.background()
+	return nil
+}
+
+func (aof *AppendLog) Append(op Operation) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.file == nil {
+		return errors.New("append log is closed")
+	}
+
+	op.Seq = aof.seq + 1
+	line, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n, err := aof.file.Write(line)
+	aof.size += int64(n)
+	if err != nil {
+		aof.logger.Error("Error writing append log: ", err)
+		return fmt.Errorf("failed to write append log: %w", err)
+	}
+	aof.seq = op.Seq
+
+	if aof.fsync == FSYNC_ALWAYS {
+		if err := aof.file.Sync(); err != nil {
+			aof.logger.Error("Error syncing append log: ", err)
+			return fmt.Errorf("failed to sync append log: %w", err)
+		}
+	} else {
+		aof.dirty = true
+	}
+	return nil
+}
+
+func (aof *AppendLog) LastSeq() uint64 {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.seq
+}
+
+// Size returns the current size of the log in bytes.
+func (aof *AppendLog) Size() int64 {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.size
+}
+
+// Rewrite replaces the log with the minimal set of operations rebuilding the
+// current state. Operations appended while the rewrite runs are carried over.
+func (aof *AppendLog) Rewrite() error {
+	if !aof.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	defer aof.rewriting.Store(false)
+
+	if aof.collectionManager == nil {
+		return errors.New("append log is not open")
+	}
+	snapshot := aof.collectionManager.Snapshot()
+
+	tmp, err := os.CreateTemp(filepath.Dir(aof.path), filepath.Base(aof.path)+".rewrite-*")
+	if err != nil {
+		return fmt.Errorf("failed to create rewritten append log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	// Every base operation carries the snapshot sequence number: replay
+	// either skips all of them or applies all of them.
+	if err := encoder.Encode(Operation{Seq: snapshot.Seq, Type: OP_RESET}); err != nil {
+		return err
+	}
+	for name, entries := range snapshot.Collections {
+		if err := encoder.Encode(Operation{Seq: snapshot.Seq, Type: OP_ADD_COLLECTION, Collection: name}); err != nil {
+			return err
+		}
+		if slices.Contains(snapshot.Indexes, name) {
+			if err := encoder.Encode(Operation{Seq: snapshot.Seq, Type: OP_CREATE_INDEX, Collection: name}); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if err := encoder.Encode(Operation{Seq: snapshot.Seq, Type: OP_SET, Collection: name, Key: entry.Key, Value: entry.Value, ValueType: entry.Type, ExpiresAt: entry.ExpiresAt, Version: entry.Version}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	// Copy the operations appended since the snapshot without blocking
+	// writers, then copy the remainder while holding the lock.
+	offset, err := aof.copyTail(tmp, snapshot.Seq, -1)
+	if err != nil {
+		return err
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.file == nil {
+		return errors.New("append log is closed")
+	}
+	if _, err := aof.copyTail(tmp, snapshot.Seq, offset); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), aof.path); err != nil {
+		return fmt.Errorf("failed to replace append log: %w", err)
+	}
+
+	file, err := os.OpenFile(aof.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen append log: %w", err)
+	}
+	aof.file.Close()
+	aof.file = file
+	aof.size = info.Size()
+	aof.baseSize = info.Size()
+	aof.dirty = false
+
+	aof.logger.Info("Rewrote append log, new size: ", info.Size())
+	return nil
+}
+
+// RewriteInBackground starts a rewrite unless one is already running.
+func (aof *AppendLog) RewriteInBackground() bool {
+	if aof.rewriting.Load() {
+		return false
+	}
+
+	go func() {
+		if err := aof.Rewrite(); err != nil && !errors.Is(err, ErrRewriteInProgress) {
+			aof.logger.Error("Error rewriting append log: ", err)
+		}
+	}()
+	return true
+}
+
+// copyTail appends to dst the records of the log with a sequence number
+// greater than afterSeq. If from is not negative the scan starts at that
+// offset and every record is copied. It returns the offset reached.
+func (aof *AppendLog) copyTail(dst io.Writer, afterSeq uint64, from int64) (int64, error) {
+	src, err := os.Open(aof.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open append log: %w", err)
+	}
+	defer src.Close()
+
+	offset := int64(0)
+	if from >= 0 {
+		offset = from
+		if _, err := src.Seek(from, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	copying := from >= 0
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// An incomplete record is still being written, it is picked up
+			// by the next call
+			return offset, nil
+		}
+		offset += int64(len(line))
+
+		if !copying {
+			var op Operation
+			if err := json.Unmarshal(bytes.TrimSpace(line), &op); err != nil {
+				return 0, fmt.Errorf("corrupted append log record: %w", err)
+			}
+			copying = op.Seq > afterSeq
+		}
+		if copying {
+			if _, err := dst.Write(line); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+func (aof *AppendLog) background() {
+	defer close(aof.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			aof.mu.Lock()
+			if aof.fsync == FSYNC_EVERYSEC && aof.dirty && aof.file != nil {
+				if err := aof.file.Sync(); err != nil {
+					aof.logger.Error("Error syncing append log: ", err)
+				}
+				aof.dirty = false
+			}
+			grown := aof.size >= aof.rewriteMinSize && aof.size >= 2*aof.baseSize
+			aof.mu.Unlock()
+
+			if grown {
+				aof.RewriteInBackground()
+			}
+		case <-aof.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background loop, syncs and closes the log.
+func (aof *AppendLog) Close() error {
+	if aof.stop != nil {
+		close(aof.stop)
+		<-aof.done
+		aof.stop = nil
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.file == nil {
+		return nil
+	}
+	err := aof.file.Sync()
+	if closeErr := aof.file.Close(); err == nil {
+		err = closeErr
+	}
+	aof.file = nil
+	return err
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestAppendLog(t *testing.T, path string) (*CollectionManager, *AppendLog) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+
+	aof := NewAppendLog(path, FSYNC_ALWAYS, 0)
+	_, err := aof.Replay(cm, 0)
+	require.NoError(t, err)
+	require.NoError(t, aof.Open(cm))
+	return cm, aof
+}
+
+func TestAppendLog_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	cm.GetDefaultCollection().Set("key1", "value1")
+	cm.GetDefaultCollection().Set("key2", "value2")
+	cm.GetDefaultCollection().Delete("key1")
+	cm.AddCollection("collection1")
+	collection, _ := cm.GetCollection("collection1")
+	collection.Set("key3", "value3")
+	cm.AddCollection("collection2")
+	cm.RemoveCollection("collection2")
+	require.NoError(t, aof.Close())
+	assert.Equal(t, uint64(7), aof.LastSeq())
+
+	replayed, replayedLog := openTestAppendLog(t, path)
+	defer replayedLog.Close()
+
+	assert.Equal(t, uint64(7), replayedLog.LastSeq())
+	assert.ElementsMatch(t, []string{DEFAULT_COLLECTION, "collection1"}, replayed.GetCollectionNames())
+	assert.Equal(t, "", replayed.GetDefaultCollection().Get("key1"))
+	assert.Equal(t, "value2", replayed.GetDefaultCollection().Get("key2"))
+	collection, _ = replayed.GetCollection("collection1")
+	assert.Equal(t, "value3", collection.Get("key3"))
+}
+
+func TestAppendLog_ReplayAfterSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	cm.GetDefaultCollection().Set("key1", "value1")
+	snapshot := cm.Snapshot()
+	cm.GetDefaultCollection().Set("key2", "value2")
+	require.NoError(t, aof.Close())
+
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(snapshot))
+	applied, err := NewAppendLog(path, FSYNC_NO, 0).Replay(restored, snapshot.Seq)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, "value1", restored.GetDefaultCollection().Get("key1"))
+	assert.Equal(t, "value2", restored.GetDefaultCollection().Get("key2"))
+}
+
+func TestAppendLog_ReplayTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	cm.GetDefaultCollection().Set("key1", "value1")
+	require.NoError(t, aof.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":2,"op":"set","collection":"default","key":"ke`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	replayed, replayedLog := openTestAppendLog(t, path)
+	defer replayedLog.Close()
+	assert.Equal(t, "value1", replayed.GetDefaultCollection().Get("key1"))
+
+	truncated, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), truncated.Size(), "Expected the truncated record to be removed")
+}
+
+func TestAppendLog_ReplayCorruptedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+	content := "not-json\n" + `{"seq":1,"op":"set","collection":"default","key":"key1","value":"value1"}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	_, err := NewAppendLog(path, FSYNC_NO, 0).Replay(cm, 0)
+	assert.Error(t, err)
+}
+
+func TestAppendLog_Rewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	for i := 0; i < 100; i++ {
+		cm.GetDefaultCollection().Set("key", "value")
+	}
+	cm.AddCollection("removed")
+	cm.RemoveCollection("removed")
+	sizeBefore := aof.Size()
+
+	require.NoError(t, aof.Rewrite())
+	assert.Less(t, aof.Size(), sizeBefore)
+
+	cm.GetDefaultCollection().Set("key2", "value2")
+	require.NoError(t, aof.Close())
+
+	// A stale snapshot restoring a removed collection is reset by the rewritten log
+	replayed := NewCollectionManager()
+	replayed.AddCollection("removed")
+	_, err := NewAppendLog(path, FSYNC_NO, 0).Replay(replayed, 0)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{DEFAULT_COLLECTION}, replayed.GetCollectionNames())
+	assert.Equal(t, "value", replayed.GetDefaultCollection().Get("key"))
+	assert.Equal(t, "value2", replayed.GetDefaultCollection().Get("key2"))
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	policy, err := ParseFsyncPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, FSYNC_EVERYSEC, policy)
+
+	policy, err = ParseFsyncPolicy("always")
+	assert.NoError(t, err)
+	assert.Equal(t, FSYNC_ALWAYS, policy)
+
+	_, err = ParseFsyncPolicy("sometimes")
+	assert.Error(t, err)
+}
@@ -2,25 +2,52 @@ package database
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 const DEFAULT_COLLECTION = "default"
 
 type CollectionManager struct {
 	collections map[string]*Database
+	journal     Journal
+	maxMemory   int64
+	policy      EvictionPolicy
+	events      *eventLog
+	stripes     int
 	mu          sync.RWMutex
+
+	// localExpirations is set when expired keys are deleted without being journaled
+	localExpirations atomic.Bool
 }
 
 func NewCollectionManager() *CollectionManager {
 	return &CollectionManager{
 		collections: make(map[string]*Database),
+		policy:      NO_EVICTION,
+		events:      newEventLog(),
+		stripes:     DEFAULT_LOCK_STRIPES,
+	}
+}
+
+// SetJournal attaches a journal recording every mutation of the collections.
+// Passing nil detaches it.
+func (cm *CollectionManager) SetJournal(journal Journal) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.journal = journal
+	for _, db := range cm.collections {
+		db.lockAll()
+		db.journal = journal
+		db.unlockAll()
 	}
 }
 
 func (cm *CollectionManager) AddCollection(name string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	cm.collections[name] = NewDatabase()
+	cm.collections[name] = cm.newCollection(name)
+	cm.record(Operation{Type: OP_ADD_COLLECTION, Collection: name})
 }
 
 func (cm *CollectionManager) GetCollection(name string) (*Database, bool) {
@@ -52,4 +79,26 @@ func (cm *CollectionManager) RemoveCollection(name string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 	delete(cm.collections, name)
+	cm.record(Operation{Type: OP_REMOVE_COLLECTION, Collection: name})
+}
+
+// newCollection creates a database bound to the collection name. The caller must hold cm.mu.
+func (cm *CollectionManager) newCollection(name string) *Database {
+	db := NewDatabaseWithStripes(cm.stripes)
+	db.name = name
+	db.journal = cm.journal
+	db.manager = cm
+	return db
+}
+
+// record appends a collection level operation to the journal and reports it
+// to the watchers. The caller must hold cm.mu.
+func (cm *CollectionManager) record(op Operation) {
+	cm.events.publish(op, EVENT_DELETE)
+	if cm.journal == nil {
+		return
+	}
+	// Journal failures are reported by the journal itself, collection
+	// operations have no error to return.
+	cm.journal.Append(op)
 }
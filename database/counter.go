@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var ErrNotInteger = errors.New("value is not an integer or out of range")
+var ErrOverflow = errors.New("increment would overflow")
+
+// IncrBy adds delta to the integer stored under key, atomically, keeping the
+// expiration of the key. A missing key counts as zero. It returns the new
+// value, ErrNotInteger if the stored value is not a 64-bit integer or
+// ErrOverflow if the result would not fit.
+func (db *Database) IncrBy(key string, delta int64) (int64, error) {
+	var result int64
+	_, _, err := db.update(key, func(value string, exists bool) (string, error) {
+		var current int64
+		if exists {
+			var err error
+			if current, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return "", ErrNotInteger
+			}
+		}
+		result = current + delta
+		if (delta >= 0) != (result >= current) {
+			return "", ErrOverflow
+		}
+		return strconv.FormatInt(result, 10), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// DecrBy subtracts delta from the integer stored under key, as IncrBy.
+func (db *Database) DecrBy(key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, ErrOverflow
+	}
+	return db.IncrBy(key, -delta)
+}
+
+// IncrByFloat adds delta to the number stored under key, atomically, keeping
+// the expiration of the key. A missing key counts as zero. It returns the new
+// value, ErrNotNumber if the stored value is not a number or ErrOverflow if
+// the result would be infinite.
+func (db *Database) IncrByFloat(key string, delta float64) (float64, error) {
+	if math.IsInf(delta, 0) || math.IsNaN(delta) {
+		return 0, ErrNotNumber
+	}
+
+	var result float64
+	_, _, err := db.update(key, func(value string, exists bool) (string, error) {
+		var current float64
+		if exists {
+			var err error
+			current, err = strconv.ParseFloat(value, 64)
+			if err != nil || math.IsInf(current, 0) || math.IsNaN(current) {
+				return "", ErrNotNumber
+			}
+		}
+		result = current + delta
+		if math.IsInf(result, 0) {
+			return "", ErrOverflow
+		}
+		return strconv.FormatFloat(result, 'f', -1, 64), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
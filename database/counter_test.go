@@ -0,0 +1,75 @@
+package database
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_IncrBy(t *testing.T) {
+	db := NewDatabase()
+	value, err := db.IncrBy("counter", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = db.DecrBy("counter", 7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-2), value)
+	assert.Equal(t, "-2", db.Get("counter"))
+
+	require.NoError(t, db.Set("max", "9223372036854775807"))
+	_, err = db.IncrBy("max", 1)
+	assert.ErrorIs(t, err, ErrOverflow)
+	_, err = db.DecrBy("counter", math.MinInt64)
+	assert.ErrorIs(t, err, ErrOverflow)
+
+	require.NoError(t, db.Set("text", "abc"))
+	_, err = db.IncrBy("text", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+	require.NoError(t, db.Set("float", "1.5"))
+	_, err = db.IncrBy("float", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+	assert.Equal(t, "1.5", db.Get("float"))
+}
+
+func TestDatabase_IncrByFloat(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.SetWithTTL("price", "10", time.Hour))
+
+	value, err := db.IncrByFloat("price", 0.5)
+	require.NoError(t, err)
+	assert.Equal(t, 10.5, value)
+	assert.Equal(t, "10.5", db.Get("price"))
+
+	ttl, err := db.TTL("price")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Minute)
+
+	_, err = db.IncrByFloat("price", math.Inf(1))
+	assert.ErrorIs(t, err, ErrNotNumber)
+	require.NoError(t, db.Set("text", "abc"))
+	_, err = db.IncrByFloat("text", 1)
+	assert.ErrorIs(t, err, ErrNotNumber)
+	require.NoError(t, db.Set("huge", "1.7e308"))
+	_, err = db.IncrByFloat("huge", 1.7e308)
+	assert.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestDatabase_IncrByConcurrently(t *testing.T) {
+	db := NewDatabase()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.IncrBy("counter", 1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, "100", db.Get("counter"))
+}
@@ -3,46 +3,272 @@
 package database
 
 import (
+	"errors"
+	"hash/maphash"
 	"sync"
-
-	"github.com/dmarro89/go-redis-hashtable/structure"
+	"sync/atomic"
+	"time"
 )
 
+// NO_EXPIRATION is returned by TTL for keys without an expiration.
+const NO_EXPIRATION time.Duration = -1
+
+var ErrKeyNotFound = errors.New("key not found")
+
 type Database struct {
-	dict structure.IDict
-	mu   sync.RWMutex
+	// stripes hold the keys, spread by the hash of the key
+	stripes []*stripe
+	seed    maphash.Seed
+	// index keeps the keys in order, nil unless an ordered index was created.
+	// It is only replaced while holding every stripe, and changed while
+	// holding indexMu and the stripe of the key.
+	index   *skiplist
+	indexMu sync.Mutex
+	memory  atomic.Int64
+	// version is the last version assigned to a key
+	version atomic.Uint64
+	name    string
+	journal Journal
+	manager *CollectionManager
 }
 
 func NewDatabase() *Database {
-	return &Database{
-		dict: structure.NewSipHashDict(),
+	return NewDatabaseWithStripes(DEFAULT_LOCK_STRIPES)
+}
+
+// NewDatabaseWithStripes creates a database spreading its keys over count
+// stripes, each with its own lock. A count lower or equal to zero uses
+// DEFAULT_LOCK_STRIPES.
+func NewDatabaseWithStripes(count int) *Database {
+	if count <= 0 {
+		count = DEFAULT_LOCK_STRIPES
+	}
+	db := &Database{
+		stripes: make([]*stripe, count),
+		seed:    maphash.MakeSeed(),
 	}
+	for i := range db.stripes {
+		db.stripes[i] = newStripe()
+	}
+	return db
+}
+
+// Name returns the name of the collection, empty for a database created
+// outside of a CollectionManager.
+func (db *Database) Name() string {
+	return db.name
 }
 
 func (db *Database) Get(key string) string {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	value, _ := db.GetWithVersion(key)
+	return value
+}
+
+// GetWithVersion returns the value of the key and its version, an empty
+// value and zero if the key does not exist.
+func (db *Database) GetWithVersion(key string) (string, uint64) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	value := s.dict.Get(key)
+	now := nowMillis()
+	expired := db.isExpired(key, now)
+	var version uint64
+	if meta, ok := s.meta[key]; ok && !expired {
+		meta.touch(now)
+		version = meta.version
+	}
+	s.mu.RUnlock()
 
-	return db.dict.Get(key)
+	if expired {
+		db.deleteIfExpired(key)
+		return "", 0
+	}
+	return value, version
 }
 
+// GetAllItems returns every item of the database, as stored at a single
+// point in time: every stripe is read locked during the copy.
 func (db *Database) GetAllItems() map[string]string {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	db.rlockAll()
+	defer db.runlockAll()
 
-	return db.dict.GetAllItems()
+	items := make(map[string]string)
+	now := nowMillis()
+	for _, s := range db.stripes {
+		for key, value := range s.dict.GetAllItems() {
+			if !db.isExpired(key, now) {
+				items[key] = value
+			}
+		}
+		for key := range s.values {
+			if !db.isExpired(key, now) {
+				items[key] = db.renderValue(key)
+			}
+		}
+	}
+	return items
 }
 
+// Set stores the value and clears any expiration previously set on the key.
 func (db *Database) Set(key string, value string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.SetWithTTL(key, value, 0)
+}
 
-	return db.dict.Set(key, value)
+// SetWithTTL stores the value with an expiration after ttl. A ttl lower or
+// equal to zero stores the value without expiration.
+func (db *Database) SetWithTTL(key string, value string, ttl time.Duration) error {
+	_, err := db.SetIf(key, value, ttl, nil)
+	return err
 }
 
 func (db *Database) Delete(key string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.DeleteIf(key, nil)
+}
+
+// UsedMemory returns the approximate memory used by the keys of the database, in bytes.
+func (db *Database) UsedMemory() int64 {
+	return db.memory.Load()
+}
+
+// set stores the value with the given expiration and version. A zero version
+// assigns the next version of the database. It returns the version of the
+// key. The caller must hold the write lock of the stripe of key.
+func (db *Database) set(key string, value string, expiresAt int64, version uint64) (uint64, error) {
+	s := db.stripeOf(key)
+	if err := s.dict.Set(key, value); err != nil {
+		return 0, err
+	}
+	delete(s.values, key)
+	return db.store(key, entrySize(key, value), expiresAt, version), nil
+}
+
+// store updates the expiration and the metadata of a key just written, of
+// the given size. A zero version assigns the next version of the database.
+// It returns the version of the key. The caller must hold the write lock of
+// the stripe of key.
+func (db *Database) store(key string, size int64, expiresAt int64, version uint64) uint64 {
+	s := db.stripeOf(key)
+	if expiresAt > 0 {
+		s.expires[key] = expiresAt
+	} else {
+		delete(s.expires, key)
+	}
+
+	now := nowMillis()
+	meta, ok := s.meta[key]
+	if ok {
+		meta.touch(now)
+	} else {
+		meta = newKeyMeta(now)
+		s.meta[key] = meta
+		s.keys.add(key)
+		if db.index != nil {
+			db.indexMu.Lock()
+			db.index.insert(key)
+			db.indexMu.Unlock()
+		}
+	}
+	db.memory.Add(size - meta.size)
+	meta.size = size
+
+	if version == 0 {
+		version = db.version.Add(1)
+	}
+	for current := db.version.Load(); version > current && !db.version.CompareAndSwap(current, version); {
+		current = db.version.Load()
+	}
+	meta.version = version
+	return version
+}
+
+// remove deletes the key and its metadata, returning false if the key did
+// not exist. The caller must hold the write lock of the stripe of key.
+func (db *Database) remove(key string) bool {
+	s := db.stripeOf(key)
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+	} else if err := s.dict.Delete(key); err != nil {
+		return false
+	}
+	delete(s.expires, key)
+	if meta, ok := s.meta[key]; ok {
+		db.memory.Add(-meta.size)
+		delete(s.meta, key)
+		s.keys.remove(key)
+		if db.index != nil {
+			db.indexMu.Lock()
+			db.index.delete(key)
+			db.indexMu.Unlock()
+		}
+	}
+	return true
+}
 
-	return db.dict.Delete(key)
+// record appends an operation on this collection to the journal. The caller
+// must hold the write lock of the stripe of the key of the operation.
+func (db *Database) record(op Operation) error {
+	return db.recordAs(op, EVENT_DELETE)
+}
+
+// recordAs records op like record, reporting the deletion of a key to the
+// watchers as removal: EVENT_DELETE, EVENT_EXPIRE or EVENT_EVICT.
+func (db *Database) recordAs(op Operation, removal EventType) error {
+	op.Collection = db.name
+	if db.manager != nil {
+		db.manager.events.publish(op, removal)
+	}
+	if db.journal == nil {
+		return nil
+	}
+	return db.journal.Append(op)
+}
+
+// update replaces the value of the key with the result of modify, called
+// with the current value and whether the key exists, keeping the expiration
+// of the key. modify runs without holding the lock of the key: the result is only stored
+// if the key was not written meanwhile, otherwise modify is called again with
+// the new value. It returns the stored value and its version.
+func (db *Database) update(key string, modify func(value string, exists bool) (string, error)) (string, uint64, error) {
+	s := db.stripeOf(key)
+	for {
+		s.mu.RLock()
+		now := nowMillis()
+		version := db.versionOf(key, now)
+		var value string
+		if version != 0 {
+			value = s.dict.Get(key)
+		}
+		_, typed := s.values[key]
+		s.mu.RUnlock()
+
+		if version != 0 && typed {
+			return "", 0, ErrWrongType
+		}
+
+		updated, err := modify(value, version != 0)
+		if err != nil {
+			return "", 0, err
+		}
+		if err := db.reserveMemory(key, updated); err != nil {
+			return "", 0, err
+		}
+
+		s.mu.Lock()
+		now = nowMillis()
+		if db.versionOf(key, now) != version {
+			s.mu.Unlock()
+			continue
+		}
+
+		var expiresAt int64
+		if version != 0 {
+			expiresAt = s.expires[key]
+		}
+		version, err = db.set(key, updated, expiresAt, 0)
+		if err == nil {
+			err = db.record(Operation{Type: OP_SET, Key: key, Value: updated, ExpiresAt: expiresAt, Version: version})
+		}
+		s.mu.Unlock()
+		return updated, version, err
+	}
 }
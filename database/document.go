@@ -0,0 +1,389 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrNotDocument = errors.New("value is not a JSON document")
+var ErrInvalidPath = errors.New("invalid JSON path")
+var ErrPathNotFound = errors.New("path not found in the document")
+var ErrNotNumber = errors.New("value is not a number")
+
+// Values may hold JSON documents, queried and modified in place with paths
+// such as "$.user.tags[0]" or "user.tags[-1]": members are separated by
+// dots, or written as ["member"] when they contain dots or brackets, and
+// array elements are selected with [index], negative indexes counting from
+// the end. The empty path and "$" select the whole document.
+
+type pathSegment struct {
+	member  string
+	index   int
+	isIndex bool
+}
+
+func parseJSONPath(path string) ([]pathSegment, error) {
+	path = strings.TrimPrefix(path, "$")
+	segments := []pathSegment{}
+	for i := 0; i < len(path); {
+		if path[i] == '[' {
+			if strings.HasPrefix(path[i+1:], `"`) {
+				end := strings.Index(path[i+1:], `"]`)
+				if end <= 0 {
+					return nil, ErrInvalidPath
+				}
+				member, err := strconv.Unquote(path[i+1 : i+2+end])
+				if err != nil {
+					return nil, ErrInvalidPath
+				}
+				segments = append(segments, pathSegment{member: member})
+				i += end + 3
+				continue
+			}
+
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, ErrInvalidPath
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, ErrInvalidPath
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			i += end + 1
+			continue
+		}
+
+		if path[i] == '.' {
+			i++
+		} else if len(segments) > 0 {
+			return nil, ErrInvalidPath
+		}
+		end := i
+		for end < len(path) && path[end] != '.' && path[end] != '[' {
+			end++
+		}
+		if end == i {
+			return nil, ErrInvalidPath
+		}
+		segments = append(segments, pathSegment{member: path[i:end]})
+		i = end
+	}
+	return segments, nil
+}
+
+func decodeDocument(value string) (interface{}, error) {
+	if !json.Valid([]byte(value)) {
+		return nil, ErrNotDocument
+	}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, ErrNotDocument
+	}
+	return document, nil
+}
+
+func encodeDocument(document interface{}) (string, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// arrayIndex resolves a possibly negative index into array.
+func arrayIndex(array []interface{}, index int) (int, bool) {
+	if index < 0 {
+		index += len(array)
+	}
+	return index, index >= 0 && index < len(array)
+}
+
+func lookupPath(node interface{}, segments []pathSegment) (interface{}, bool) {
+	for _, segment := range segments {
+		if segment.isIndex {
+			array, ok := node.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			index, ok := arrayIndex(array, segment.index)
+			if !ok {
+				return nil, false
+			}
+			node = array[index]
+			continue
+		}
+
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = object[segment.member]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setPath returns node with value stored at the path, creating the missing
+// objects along it. Array elements must exist.
+func setPath(node interface{}, segments []pathSegment, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+
+	segment := segments[0]
+	if segment.isIndex {
+		array, ok := node.([]interface{})
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		index, ok := arrayIndex(array, segment.index)
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		child, err := setPath(array[index], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		array[index] = child
+		return array, nil
+	}
+
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+	child, exists := object[segment.member]
+	if !exists && len(segments) > 1 && !segments[1].isIndex {
+		child = make(map[string]interface{})
+	}
+	child, err := setPath(child, segments[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	object[segment.member] = child
+	return object, nil
+}
+
+// deletePath removes the member or element at the path, which must not be empty.
+func deletePath(node interface{}, segments []pathSegment) (interface{}, error) {
+	parent, ok := lookupPath(node, segments[:len(segments)-1])
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+
+	last := segments[len(segments)-1]
+	if !last.isIndex {
+		object, ok := parent.(map[string]interface{})
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		if _, ok := object[last.member]; !ok {
+			return nil, ErrPathNotFound
+		}
+		delete(object, last.member)
+		return node, nil
+	}
+
+	array, ok := parent.([]interface{})
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+	index, ok := arrayIndex(array, last.index)
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+	return setPath(node, segments[:len(segments)-1], append(array[:index], array[index+1:]...))
+}
+
+// addNumbers adds two JSON numbers, as integers when both are integers and
+// the sum does not overflow, as floating point numbers otherwise.
+func addNumbers(a json.Number, b json.Number) (json.Number, error) {
+	if x, err := a.Int64(); err == nil {
+		if y, err := b.Int64(); err == nil {
+			sum := x + y
+			if (y >= 0) == (sum >= x) {
+				return json.Number(strconv.FormatInt(sum, 10)), nil
+			}
+		}
+	}
+
+	x, err := a.Float64()
+	if err != nil {
+		return "", ErrNotNumber
+	}
+	y, err := b.Float64()
+	if err != nil {
+		return "", ErrNotNumber
+	}
+	sum := x + y
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", ErrNotNumber
+	}
+	return json.Number(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}
+
+// GetPath returns the JSON value at the path of the document stored under key.
+func (db *Database) GetPath(key string, path string) (json.RawMessage, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := db.getDocument(key)
+	if err != nil {
+		return nil, err
+	}
+	node, ok := lookupPath(document, segments)
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+	value, err := encodeDocument(node)
+	return json.RawMessage(value), err
+}
+
+// Project returns the JSON values at the paths of the document stored under
+// key, by path. Paths missing from the document are left out.
+func (db *Database) Project(key string, paths []string) (map[string]json.RawMessage, error) {
+	segments := make([][]pathSegment, len(paths))
+	for i, path := range paths {
+		var err error
+		if segments[i], err = parseJSONPath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	document, err := db.getDocument(key)
+	if err != nil {
+		return nil, err
+	}
+	projection := make(map[string]json.RawMessage)
+	for i, path := range paths {
+		node, ok := lookupPath(document, segments[i])
+		if !ok {
+			continue
+		}
+		value, err := encodeDocument(node)
+		if err != nil {
+			return nil, err
+		}
+		projection[path] = json.RawMessage(value)
+	}
+	return projection, nil
+}
+
+// SetPath stores the JSON value at the path of the document stored under
+// key, atomically. A missing key is created, as an object unless the path is
+// the root. It returns the new version of the key.
+func (db *Database) SetPath(key string, path string, value json.RawMessage) (uint64, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := decodeDocument(string(value)); err != nil {
+		return 0, err
+	}
+
+	_, version, err := db.update(key, func(current string, exists bool) (string, error) {
+		var document interface{} = make(map[string]interface{})
+		var err error
+		if exists {
+			if document, err = decodeDocument(current); err != nil {
+				return "", err
+			}
+		}
+		node, _ := decodeDocument(string(value))
+		if document, err = setPath(document, segments, node); err != nil {
+			return "", err
+		}
+		return encodeDocument(document)
+	})
+	return version, err
+}
+
+// DeletePath removes the member or array element at the path of the document
+// stored under key, atomically. It returns the new version of the key.
+func (db *Database) DeletePath(key string, path string) (uint64, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(segments) == 0 {
+		return 0, ErrInvalidPath
+	}
+
+	_, version, err := db.update(key, func(current string, exists bool) (string, error) {
+		if !exists {
+			return "", ErrKeyNotFound
+		}
+		document, err := decodeDocument(current)
+		if err != nil {
+			return "", err
+		}
+		if document, err = deletePath(document, segments); err != nil {
+			return "", err
+		}
+		return encodeDocument(document)
+	})
+	return version, err
+}
+
+// IncrPath adds delta to the number at the path of the document stored under
+// key, atomically. It returns the new number and the new version of the key.
+func (db *Database) IncrPath(key string, path string, delta json.Number) (json.Number, uint64, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := delta.Float64(); err != nil {
+		return "", 0, ErrNotNumber
+	}
+
+	var result json.Number
+	_, version, err := db.update(key, func(current string, exists bool) (string, error) {
+		if !exists {
+			return "", ErrKeyNotFound
+		}
+		document, err := decodeDocument(current)
+		if err != nil {
+			return "", err
+		}
+		node, ok := lookupPath(document, segments)
+		if !ok {
+			return "", ErrPathNotFound
+		}
+		number, ok := node.(json.Number)
+		if !ok {
+			return "", ErrNotNumber
+		}
+		if result, err = addNumbers(number, delta); err != nil {
+			return "", err
+		}
+		if document, err = setPath(document, segments, result); err != nil {
+			return "", err
+		}
+		return encodeDocument(document)
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return result, version, nil
+}
+
+// getDocument decodes the document stored under key.
+func (db *Database) getDocument(key string) (interface{}, error) {
+	value := db.Get(key)
+	if value == "" {
+		return nil, ErrKeyNotFound
+	}
+	return decodeDocument(value)
+}
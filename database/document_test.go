@@ -0,0 +1,105 @@
+package database
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	segments, err := parseJSONPath(`$.user.tags[-1]["a.b"]`)
+	require.NoError(t, err)
+	assert.Equal(t, []pathSegment{{member: "user"}, {member: "tags"}, {index: -1, isIndex: true}, {member: "a.b"}}, segments)
+
+	segments, err = parseJSONPath("")
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+
+	for _, path := range []string{"a..b", "a[x]", "a[0", "a[0]b", ".", `a["b]`} {
+		_, err := parseJSONPath(path)
+		assert.ErrorIs(t, err, ErrInvalidPath, path)
+	}
+}
+
+func TestDatabase_DocumentPaths(t *testing.T) {
+	db := NewDatabase()
+	_, err := db.GetPath("user", "name")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = db.SetPath("user", "$", json.RawMessage(`{"name":"Ada","tags":["a","b"],"visits":1}`))
+	require.NoError(t, err)
+	_, err = db.SetPath("user", "address.city", json.RawMessage(`"London"`))
+	require.NoError(t, err)
+	_, err = db.SetPath("user", "tags[-1]", json.RawMessage(`"c"`))
+	require.NoError(t, err)
+	_, err = db.SetPath("user", "tags[5]", json.RawMessage(`"d"`))
+	assert.ErrorIs(t, err, ErrPathNotFound)
+
+	value, err := db.GetPath("user", "$.address")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"city":"London"}`, string(value))
+
+	number, _, err := db.IncrPath("user", "visits", "2")
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("3"), number)
+	number, _, err = db.IncrPath("user", "visits", "0.5")
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("3.5"), number)
+	_, _, err = db.IncrPath("user", "name", "1")
+	assert.ErrorIs(t, err, ErrNotNumber)
+
+	_, err = db.DeletePath("user", "tags[0]")
+	require.NoError(t, err)
+	_, err = db.DeletePath("user", "missing")
+	assert.ErrorIs(t, err, ErrPathNotFound)
+	_, err = db.DeletePath("user", "")
+	assert.ErrorIs(t, err, ErrInvalidPath)
+
+	assert.JSONEq(t, `{"name":"Ada","tags":["c"],"visits":3.5,"address":{"city":"London"}}`, db.Get("user"))
+
+	projection, err := db.Project("user", []string{"name", "address.city", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{"name": json.RawMessage(`"Ada"`), "address.city": json.RawMessage(`"London"`)}, projection)
+
+	require.NoError(t, db.Set("plain", "text"))
+	_, err = db.GetPath("plain", "a")
+	assert.ErrorIs(t, err, ErrNotDocument)
+	_, err = db.SetPath("new", "", json.RawMessage(`{"broken"`))
+	assert.ErrorIs(t, err, ErrNotDocument)
+}
+
+func TestDatabase_IncrPathConcurrently(t *testing.T) {
+	db := NewDatabase()
+	_, err := db.SetPath("counter", "", json.RawMessage(`{"hits":0}`))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := db.IncrPath("counter", "hits", "1")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	value, err := db.GetPath("counter", "hits")
+	require.NoError(t, err)
+	assert.Equal(t, "50", string(value))
+}
+
+func TestDatabase_UpdateKeepsExpiration(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.SetWithTTL("doc", `{"a":1}`, time.Hour))
+	_, err := db.SetPath("doc", "b", json.RawMessage(`2`))
+	require.NoError(t, err)
+
+	ttl, err := db.TTL("doc")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Minute)
+}
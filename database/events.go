@@ -0,0 +1,240 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// EVENT_HISTORY_SIZE is the number of past events kept to let watchers resume.
+const EVENT_HISTORY_SIZE = 4096
+
+// WATCH_BUFFER_SIZE is the number of pending events buffered per watcher.
+const WATCH_BUFFER_SIZE = 256
+
+var ErrEventsUnavailable = errors.New("the events following the requested sequence number are no longer available")
+var ErrSlowWatcher = errors.New("watcher too slow, its buffer of pending events is full")
+var ErrWatchClosed = errors.New("watchers closed")
+
+// EventType is the kind of change reported by an Event.
+type EventType string
+
+const (
+	EVENT_SET               EventType = "set"
+	EVENT_DELETE            EventType = "delete"
+	EVENT_EXPIRE            EventType = "expire"
+	EVENT_EVICT             EventType = "evict"
+	EVENT_ADD_COLLECTION    EventType = "add_collection"
+	EVENT_REMOVE_COLLECTION EventType = "remove_collection"
+)
+
+// Event is a change of the keyspace. Seq orders the events of a collection
+// manager and lets watchers resume after the last event they received.
+type Event struct {
+	Seq        uint64    `json:"seq"`
+	Type       EventType `json:"type"`
+	Collection string    `json:"collection"`
+	Key        string    `json:"key,omitempty"`
+	// Version is the version of the key written by an EVENT_SET
+	Version uint64 `json:"version,omitempty"`
+	// Timestamp is the time of the change in unix milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// eventLog numbers the events, keeps the recent ones and delivers them to
+// the watchers.
+type eventLog struct {
+	mu       sync.Mutex
+	seq      uint64
+	history  []Event
+	next     int
+	watchers map[*Watcher]struct{}
+	closed   bool
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		history:  make([]Event, 0, EVENT_HISTORY_SIZE),
+		watchers: make(map[*Watcher]struct{}),
+	}
+}
+
+// Watcher receives the events of a collection, or of every collection, on
+// the keys starting with a prefix.
+type Watcher struct {
+	log        *eventLog
+	collection string
+	prefix     string
+	events     chan Event
+	done       chan struct{}
+
+	// The fields below are guarded by log.mu
+	closed bool
+	err    error
+}
+
+// Watch starts watching the changes of collection, every collection if
+// empty, on the keys starting with prefix. Collection events are reported
+// when collection is empty or names the collection, whatever the prefix.
+//
+// A positive since resumes after the event of that sequence number: the
+// retained events following it are returned, and the watcher receives the
+// next ones. ErrEventsUnavailable is returned if some of them were already
+// dropped from the history, or if since is ahead of the current sequence
+// number, such as after a restart. Watch also returns the current sequence
+// number, which is where a watcher that has not received any event resumes.
+func (cm *CollectionManager) Watch(collection string, prefix string, since uint64) (*Watcher, []Event, uint64, error) {
+	log := cm.events
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	var backlog []Event
+	if since > 0 {
+		if since > log.seq || since+uint64(len(log.history)) < log.seq {
+			return nil, nil, log.seq, ErrEventsUnavailable
+		}
+		for i := range log.history {
+			event := log.history[(log.next+i)%len(log.history)]
+			if event.Seq > since && matchesWatch(event, collection, prefix) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	w := &Watcher{
+		log:        log,
+		collection: collection,
+		prefix:     prefix,
+		events:     make(chan Event, WATCH_BUFFER_SIZE),
+		done:       make(chan struct{}),
+	}
+	if log.closed {
+		w.closeLocked(ErrWatchClosed)
+	} else {
+		log.watchers[w] = struct{}{}
+	}
+	return w, backlog, log.seq, nil
+}
+
+// CloseWatchers closes every watcher with ErrWatchClosed, as well as the
+// watchers created afterwards.
+func (cm *CollectionManager) CloseWatchers() {
+	log := cm.events
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	log.closed = true
+	for w := range log.watchers {
+		w.closeLocked(ErrWatchClosed)
+	}
+}
+
+// Events returns the channel delivering the events. It is never closed, use
+// Done to know when no more events will be delivered.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Done returns a channel closed once the watcher is closed.
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// Err returns why the watcher was closed: ErrSlowWatcher if it did not keep
+// up with the events, ErrWatchClosed, or nil if it is open or was closed by
+// Close.
+func (w *Watcher) Err() error {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+	return w.err
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+	w.closeLocked(nil)
+}
+
+// closeLocked removes the watcher from the log, the caller must hold log.mu.
+func (w *Watcher) closeLocked(err error) {
+	if w.closed {
+		return
+	}
+	delete(w.log.watchers, w)
+	w.closed = true
+	w.err = err
+	close(w.done)
+}
+
+func matchesWatch(event Event, collection string, prefix string) bool {
+	if collection != "" && event.Collection != collection {
+		return false
+	}
+	if event.Type == EVENT_ADD_COLLECTION || event.Type == EVENT_REMOVE_COLLECTION {
+		return true
+	}
+	return strings.HasPrefix(event.Key, prefix)
+}
+
+// publish reports the changes made by op, a deletion being reported as
+// removal: EVENT_DELETE, EVENT_EXPIRE or EVENT_EVICT. Operations that do not
+// change the keyspace, such as OP_EXPIRE, are not reported.
+func (log *eventLog) publish(op Operation, removal EventType) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	now := nowMillis()
+	log.publishLocked(op, removal, now)
+}
+
+func (log *eventLog) publishLocked(op Operation, removal EventType, now int64) {
+	event := Event{Collection: op.Collection, Key: op.Key, Timestamp: now}
+	switch op.Type {
+	case OP_TRANSACTION:
+		for _, sub := range op.Ops {
+			log.publishLocked(sub, removal, now)
+		}
+		return
+	case OP_ADD_COLLECTION:
+		event.Type = EVENT_ADD_COLLECTION
+	case OP_REMOVE_COLLECTION:
+		event.Type = EVENT_REMOVE_COLLECTION
+	case OP_DELETE:
+		event.Type = removal
+	case OP_SET:
+		event.Type = EVENT_SET
+		event.Version = op.Version
+	case OP_LPUSH, OP_RPUSH, OP_LPOP, OP_RPOP, OP_LTRIM, OP_SADD, OP_SREM, OP_ZADD, OP_ZREM, OP_HSET, OP_HDEL:
+		// The operations on typed values delete the key they empty, which
+		// is then left without a version
+		if op.Version == 0 {
+			event.Type = removal
+		} else {
+			event.Type = EVENT_SET
+			event.Version = op.Version
+		}
+	default:
+		return
+	}
+
+	log.seq++
+	event.Seq = log.seq
+	if len(log.history) < EVENT_HISTORY_SIZE {
+		log.history = append(log.history, event)
+	} else {
+		log.history[log.next] = event
+		log.next = (log.next + 1) % EVENT_HISTORY_SIZE
+	}
+
+	for w := range log.watchers {
+		if !matchesWatch(event, w.collection, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			w.closeLocked(ErrSlowWatcher)
+		}
+	}
+}
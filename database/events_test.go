@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveEvents(t *testing.T, w *Watcher, count int) []Event {
+	t.Helper()
+	events := make([]Event, 0, count)
+	for len(events) < count {
+		select {
+		case event := <-w.Events():
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %d events, received %v", count, events)
+		}
+	}
+	return events
+}
+
+func TestCollectionManager_Watch(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	db := cm.GetDefaultCollection()
+
+	w, backlog, seq, err := cm.Watch("", "user:", 0)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Empty(t, backlog)
+	assert.Equal(t, uint64(1), seq, "Expected the creation of the default collection to be the first event")
+
+	require.NoError(t, db.Set("user:1", "Ada"))
+	require.NoError(t, db.Set("order:1", "book"))
+	_, err = db.RPush("user:2", "a")
+	require.NoError(t, err)
+	_, err = db.LPop("user:2", 1)
+	require.NoError(t, err)
+	require.NoError(t, db.Delete("user:1"))
+	cm.AddCollection("users")
+
+	events := receiveEvents(t, w, 5)
+	assert.Equal(t, EVENT_SET, events[0].Type)
+	assert.Equal(t, DEFAULT_COLLECTION, events[0].Collection)
+	assert.Equal(t, "user:1", events[0].Key)
+	assert.NotZero(t, events[0].Version)
+	assert.NotZero(t, events[0].Timestamp)
+	assert.Equal(t, EVENT_SET, events[1].Type)
+	assert.Equal(t, "user:2", events[1].Key)
+	assert.Equal(t, EVENT_DELETE, events[2].Type, "Expected popping the last element to delete the key")
+	assert.Equal(t, "user:2", events[2].Key)
+	assert.Equal(t, EVENT_DELETE, events[3].Type)
+	assert.Equal(t, "user:1", events[3].Key)
+	assert.Equal(t, Event{Seq: events[4].Seq, Type: EVENT_ADD_COLLECTION, Collection: "users", Timestamp: events[4].Timestamp}, events[4])
+	for i := 1; i < len(events); i++ {
+		assert.Greater(t, events[i].Seq, events[i-1].Seq)
+	}
+	assert.Equal(t, uint64(7), events[4].Seq, "Expected the set of order:1 to be numbered although filtered out")
+}
+
+func TestCollectionManager_WatchCollection(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.AddCollection("users")
+
+	w, _, _, err := cm.Watch("users", "", 0)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, cm.GetDefaultCollection().Set("key", "value"))
+	require.NoError(t, cm.Execute(Transaction{Operations: []TransactionOperation{
+		{Type: OP_SET, Collection: "users", Key: "alice", Value: "1"},
+		{Type: OP_SET, Key: "other", Value: "1"},
+	}}))
+	cm.RemoveCollection("users")
+
+	events := receiveEvents(t, w, 2)
+	assert.Equal(t, EVENT_SET, events[0].Type)
+	assert.Equal(t, "alice", events[0].Key)
+	assert.Equal(t, EVENT_REMOVE_COLLECTION, events[1].Type)
+	assert.Equal(t, "users", events[1].Collection)
+}
+
+func TestCollectionManager_WatchExpireAndEvict(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	db := cm.GetDefaultCollection()
+
+	w, _, _, err := cm.Watch(DEFAULT_COLLECTION, "", 0)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, db.SetWithTTL("session", "value", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 1, NewExpirationSweeper(cm).Sweep())
+
+	cm.SetMaxMemory(entrySize("key0", "value"), ALLKEYS_RANDOM)
+	require.NoError(t, db.Set("key0", "value"))
+	require.NoError(t, db.Set("key1", "value"))
+
+	events := receiveEvents(t, w, 5)
+	assert.Equal(t, EVENT_SET, events[0].Type)
+	assert.Equal(t, Event{Seq: events[1].Seq, Type: EVENT_EXPIRE, Collection: DEFAULT_COLLECTION, Key: "session", Timestamp: events[1].Timestamp}, events[1])
+	assert.Equal(t, EVENT_SET, events[2].Type)
+	assert.Equal(t, EVENT_EVICT, events[3].Type)
+	assert.Equal(t, "key0", events[3].Key)
+	assert.Equal(t, EVENT_SET, events[4].Type)
+	assert.Equal(t, "key1", events[4].Key)
+}
+
+func TestCollectionManager_WatchResume(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	db := cm.GetDefaultCollection()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), "value"))
+	}
+
+	w, backlog, seq, err := cm.Watch("", "key", 3)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, uint64(6), seq)
+	require.Len(t, backlog, 3)
+	assert.Equal(t, "key2", backlog[0].Key)
+	assert.Equal(t, uint64(4), backlog[0].Seq)
+	assert.Equal(t, "key4", backlog[2].Key)
+
+	require.NoError(t, db.Set("key5", "value"))
+	assert.Equal(t, uint64(7), receiveEvents(t, w, 1)[0].Seq)
+
+	_, _, _, err = cm.Watch("", "", 100)
+	assert.ErrorIs(t, err, ErrEventsUnavailable, "Expected a sequence number ahead of the log to be rejected")
+
+	for i := 0; i < EVENT_HISTORY_SIZE; i++ {
+		require.NoError(t, db.Set("other", "value"))
+	}
+	_, _, _, err = cm.Watch("", "", 3)
+	assert.ErrorIs(t, err, ErrEventsUnavailable, "Expected dropped events to be reported")
+	_, backlog, _, err = cm.Watch("", "", seq+1)
+	require.NoError(t, err, "Expected the oldest retained events to be resumable")
+	assert.Len(t, backlog, EVENT_HISTORY_SIZE)
+}
+
+func TestCollectionManager_WatchSlowWatcher(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	db := cm.GetDefaultCollection()
+
+	w, _, _, err := cm.Watch("", "", 0)
+	require.NoError(t, err)
+	for i := 0; i <= WATCH_BUFFER_SIZE; i++ {
+		require.NoError(t, db.Set("key", "value"))
+	}
+
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the slow watcher to be closed")
+	}
+	assert.ErrorIs(t, w.Err(), ErrSlowWatcher)
+	assert.Len(t, w.Events(), WATCH_BUFFER_SIZE, "Expected the buffered events to stay readable")
+}
+
+func TestCollectionManager_CloseWatchers(t *testing.T) {
+	cm := NewCollectionManager()
+	w, _, _, err := cm.Watch("", "", 0)
+	require.NoError(t, err)
+
+	cm.CloseWatchers()
+	<-w.Done()
+	assert.ErrorIs(t, w.Err(), ErrWatchClosed)
+
+	w, _, _, err = cm.Watch("", "", 0)
+	require.NoError(t, err)
+	<-w.Done()
+	assert.ErrorIs(t, w.Err(), ErrWatchClosed, "Expected the watchers created afterwards to be closed")
+}
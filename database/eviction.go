@@ -0,0 +1,268 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+)
+
+type EvictionPolicy string
+
+const (
+	NO_EVICTION    EvictionPolicy = "noeviction"
+	ALLKEYS_LRU    EvictionPolicy = "allkeys-lru"
+	ALLKEYS_LFU    EvictionPolicy = "allkeys-lfu"
+	VOLATILE_TTL   EvictionPolicy = "volatile-ttl"
+	ALLKEYS_RANDOM EvictionPolicy = "random"
+)
+
+// ENTRY_OVERHEAD approximates the memory used by the dict entry and the
+// metadata of a key, on top of the key and value bytes.
+const ENTRY_OVERHEAD int64 = 96
+
+// EVICTION_SAMPLES is the number of keys sampled per collection to pick the
+// key to evict, as an approximation of the exact policy.
+const EVICTION_SAMPLES = 5
+
+// LFU counters are logarithmic: the higher the counter, the less likely an
+// access increments it. Counters decay by one every LFU_DECAY_MILLIS of idle time.
+const (
+	LFU_INIT_VAL     uint32 = 5
+	LFU_LOG_FACTOR          = 10
+	LFU_MAX_VAL      uint32 = 255
+	LFU_DECAY_MILLIS int64  = 60 * 1000
+)
+
+var ErrOutOfMemory = errors.New("out of memory: write rejected because used memory exceeds max memory")
+
+// ParseEvictionPolicy returns the policy matching name, defaulting to NO_EVICTION.
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch EvictionPolicy(name) {
+	case NO_EVICTION, ALLKEYS_LRU, ALLKEYS_LFU, VOLATILE_TTL, ALLKEYS_RANDOM:
+		return EvictionPolicy(name), nil
+	case "":
+		return NO_EVICTION, nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q", name)
+}
+
+type keyMeta struct {
+	// size and version are only changed while holding the stripe write lock
+	size       int64
+	version    uint64
+	lastAccess atomic.Int64
+	frequency  atomic.Uint32
+}
+
+func newKeyMeta(now int64) *keyMeta {
+	meta := &keyMeta{}
+	meta.frequency.Store(LFU_INIT_VAL)
+	meta.lastAccess.Store(now)
+	return meta
+}
+
+func entrySize(key string, value string) int64 {
+	return int64(len(key)+len(value)) + ENTRY_OVERHEAD
+}
+
+// touch records an access. It is safe to call while holding the read lock.
+func (meta *keyMeta) touch(now int64) {
+	frequency := meta.frequencyAt(now)
+	if frequency < LFU_MAX_VAL {
+		base := float64(0)
+		if frequency > LFU_INIT_VAL {
+			base = float64(frequency - LFU_INIT_VAL)
+		}
+		if rand.Float64() < 1.0/(base*LFU_LOG_FACTOR+1) {
+			frequency++
+		}
+	}
+	meta.frequency.Store(frequency)
+	meta.lastAccess.Store(now)
+}
+
+// frequencyAt returns the LFU counter decayed by the idle time.
+func (meta *keyMeta) frequencyAt(now int64) uint32 {
+	frequency := meta.frequency.Load()
+	periods := (now - meta.lastAccess.Load()) / LFU_DECAY_MILLIS
+	if periods <= 0 {
+		return frequency
+	}
+	if periods >= int64(frequency) {
+		return 0
+	}
+	return frequency - uint32(periods)
+}
+
+// SetMaxMemory limits the memory used by all collections. A limit lower or
+// equal to zero disables it.
+func (cm *CollectionManager) SetMaxMemory(maxMemory int64, policy EvictionPolicy) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.maxMemory = maxMemory
+	cm.policy = policy
+}
+
+// MaxMemory returns the memory limit and the eviction policy.
+func (cm *CollectionManager) MaxMemory() (int64, EvictionPolicy) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.maxMemory, cm.policy
+}
+
+// UsedMemory returns the approximate memory used by all collections, in bytes.
+func (cm *CollectionManager) UsedMemory() int64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	var used int64
+	for _, db := range cm.collections {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// CollectionsMemory returns the approximate memory used by each collection, in bytes.
+func (cm *CollectionManager) CollectionsMemory() map[string]int64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	memory := make(map[string]int64, len(cm.collections))
+	for name, db := range cm.collections {
+		memory[name] = db.UsedMemory()
+	}
+	return memory
+}
+
+// reserveMemory makes room for storing the value under key, evicting keys
+// according to the eviction policy. It must be called without holding the
+// lock of the stripe of key.
+func (db *Database) reserveMemory(key string, value string) error {
+	if db.manager == nil {
+		return nil
+	}
+
+	return db.manager.ensureMemory(db.memoryNeeded(key, value))
+}
+
+// memoryNeeded returns the memory added by storing the value under key.
+func (db *Database) memoryNeeded(key string, value string) int64 {
+	needed := entrySize(key, value)
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	if meta, ok := s.meta[key]; ok {
+		needed -= meta.size
+	}
+	s.mu.RUnlock()
+	return needed
+}
+
+// ensureMemory evicts keys until needed more bytes fit within the limit.
+func (cm *CollectionManager) ensureMemory(needed int64) error {
+	maxMemory, policy := cm.MaxMemory()
+	if maxMemory <= 0 || needed <= 0 {
+		return nil
+	}
+
+	for cm.UsedMemory()+needed > maxMemory {
+		if policy == NO_EVICTION || !cm.evictOne(policy) {
+			return ErrOutOfMemory
+		}
+	}
+	return nil
+}
+
+type evictionCandidate struct {
+	db    *Database
+	key   string
+	score int64
+}
+
+// evictOne samples keys from every collection and evicts the one with the
+// lowest score for the policy. It returns false if there is nothing to evict.
+func (cm *CollectionManager) evictOne(policy EvictionPolicy) bool {
+	cm.mu.RLock()
+	collections := make([]*Database, 0, len(cm.collections))
+	for _, db := range cm.collections {
+		collections = append(collections, db)
+	}
+	cm.mu.RUnlock()
+
+	now := nowMillis()
+	for attempt := 0; attempt < 3; attempt++ {
+		var best *evictionCandidate
+		for _, db := range collections {
+			if candidate := db.sampleEvictionCandidate(policy, now); candidate != nil && (best == nil || candidate.score < best.score) {
+				best = candidate
+			}
+		}
+		if best == nil {
+			return false
+		}
+		// The key may have been removed since it was sampled
+		if best.db.evict(best.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleEvictionCandidate returns the best key to evict among a few sampled
+// ones, taken from the stripes in turn starting from a random one.
+func (db *Database) sampleEvictionCandidate(policy EvictionPolicy, now int64) *evictionCandidate {
+	var best *evictionCandidate
+	consider := func(key string, score int64) {
+		if best == nil || score < best.score {
+			best = &evictionCandidate{db: db, key: key, score: score}
+		}
+	}
+
+	sampled := 0
+	start := rand.Intn(len(db.stripes))
+	for i := 0; i < len(db.stripes) && sampled < EVICTION_SAMPLES; i++ {
+		s := db.stripes[(start+i)%len(db.stripes)]
+		s.mu.RLock()
+		if policy == VOLATILE_TTL {
+			for key, expiresAt := range s.expires {
+				if sampled == EVICTION_SAMPLES {
+					break
+				}
+				sampled++
+				consider(key, expiresAt)
+			}
+			s.mu.RUnlock()
+			continue
+		}
+
+		for key, meta := range s.meta {
+			if sampled == EVICTION_SAMPLES {
+				break
+			}
+			sampled++
+			switch policy {
+			case ALLKEYS_LRU:
+				consider(key, meta.lastAccess.Load())
+			case ALLKEYS_LFU:
+				consider(key, int64(meta.frequencyAt(now)))
+			default:
+				consider(key, rand.Int63())
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return best
+}
+
+// evict removes a key chosen by the eviction policy.
+func (db *Database) evict(key string) bool {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !db.remove(key) {
+		return false
+	}
+	db.recordAs(Operation{Type: OP_DELETE, Key: key}, EVENT_EVICT)
+	return true
+}
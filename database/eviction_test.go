@@ -0,0 +1,135 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_MemoryAccounting(t *testing.T) {
+	db := NewDatabase()
+
+	require.NoError(t, db.Set("key", "value"))
+	assert.Equal(t, entrySize("key", "value"), db.UsedMemory())
+
+	require.NoError(t, db.Set("key", "longer value"))
+	assert.Equal(t, entrySize("key", "longer value"), db.UsedMemory())
+
+	require.NoError(t, db.Delete("key"))
+	assert.Equal(t, int64(0), db.UsedMemory())
+}
+
+func TestCollectionManager_UsedMemory(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection("collection1")
+	cm.AddCollection("collection2")
+	collection1, _ := cm.GetCollection("collection1")
+	collection2, _ := cm.GetCollection("collection2")
+	collection1.Set("key", "value")
+	collection2.Set("key", "value")
+
+	assert.Equal(t, 2*entrySize("key", "value"), cm.UsedMemory())
+	assert.Equal(t, map[string]int64{
+		"collection1": entrySize("key", "value"),
+		"collection2": entrySize("key", "value"),
+	}, cm.CollectionsMemory())
+}
+
+func TestEviction_NoEviction(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.SetMaxMemory(2*entrySize("key0", "value"), NO_EVICTION)
+	db := cm.GetDefaultCollection()
+
+	require.NoError(t, db.Set("key0", "value"))
+	require.NoError(t, db.Set("key1", "value"))
+	assert.ErrorIs(t, db.Set("key2", "value"), ErrOutOfMemory)
+
+	// Overwriting with a value of the same size needs no additional memory
+	require.NoError(t, db.Set("key1", "other"))
+	assert.Equal(t, "", db.Get("key2"))
+}
+
+func TestEviction_AllKeysLRU(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.SetMaxMemory(3*entrySize("key0", "value"), ALLKEYS_LRU)
+	db := cm.GetDefaultCollection()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), "value"))
+		time.Sleep(2 * time.Millisecond)
+	}
+	// key0 is now the most recently used key
+	db.Get("key0")
+
+	require.NoError(t, db.Set("key3", "value"))
+	assert.Equal(t, "value", db.Get("key0"))
+	assert.Equal(t, "", db.Get("key1"), "Expected the least recently used key to be evicted")
+	assert.LessOrEqual(t, cm.UsedMemory(), 3*entrySize("key0", "value"))
+}
+
+func TestEviction_AllKeysLFU(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.SetMaxMemory(2*entrySize("key0", "value"), ALLKEYS_LFU)
+	db := cm.GetDefaultCollection()
+
+	require.NoError(t, db.Set("key0", "value"))
+	require.NoError(t, db.Set("key1", "value"))
+	db.stripeOf("key0").meta["key0"].frequency.Store(100)
+
+	require.NoError(t, db.Set("key2", "value"))
+	assert.Equal(t, "value", db.Get("key0"))
+	assert.Equal(t, "", db.Get("key1"), "Expected the least frequently used key to be evicted")
+}
+
+func TestEviction_VolatileTTL(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.SetMaxMemory(3*entrySize("key0", "value"), VOLATILE_TTL)
+	db := cm.GetDefaultCollection()
+
+	require.NoError(t, db.Set("key0", "value"))
+	require.NoError(t, db.SetWithTTL("key1", "value", time.Hour))
+	require.NoError(t, db.SetWithTTL("key2", "value", time.Minute))
+
+	require.NoError(t, db.Set("key3", "value"))
+	assert.Equal(t, "", db.Get("key2"), "Expected the key closest to expiration to be evicted")
+	assert.Equal(t, "value", db.Get("key1"))
+
+	require.NoError(t, db.Set("key4", "value"))
+	// Only keys without a ttl are left
+	assert.ErrorIs(t, db.Set("key5", "value"), ErrOutOfMemory)
+}
+
+func TestEviction_Random(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection("collection1")
+	cm.AddCollection("collection2")
+	cm.SetMaxMemory(10*entrySize("key00", "value"), ALLKEYS_RANDOM)
+	collection1, _ := cm.GetCollection("collection1")
+	collection2, _ := cm.GetCollection("collection2")
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, collection1.Set(fmt.Sprintf("key%02d", i), "value"))
+		require.NoError(t, collection2.Set(fmt.Sprintf("key%02d", i), "value"))
+	}
+	assert.LessOrEqual(t, cm.UsedMemory(), 10*entrySize("key00", "value"))
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	policy, err := ParseEvictionPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, NO_EVICTION, policy)
+
+	policy, err = ParseEvictionPolicy("allkeys-lru")
+	assert.NoError(t, err)
+	assert.Equal(t, ALLKEYS_LRU, policy)
+
+	_, err = ParseEvictionPolicy("volatile-lru")
+	assert.Error(t, err)
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+const DEFAULT_EXPIRATION_SWEEP_INTERVAL = 100 * time.Millisecond
+
+// EXPIRATION_SWEEP_SAMPLE is the number of keys with a TTL checked at once.
+// Every stripe of a collection is sampled, and the collection is sampled
+// again while more than a quarter of the keys checked were expired, with the
+// write locks released between two samples.
+const EXPIRATION_SWEEP_SAMPLE = 20
+
+// EXPIRATION_SWEEP_MAX_DURATION bounds the time spent on a single sweep.
+const EXPIRATION_SWEEP_MAX_DURATION = 25 * time.Millisecond
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// Expire sets a ttl on an existing key. A ttl lower or equal to zero deletes
+// the key. It returns ErrKeyNotFound if the key does not exist.
+func (db *Database) Expire(key string, ttl time.Duration) error {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !db.exists(key, nowMillis()) {
+		return ErrKeyNotFound
+	}
+
+	if ttl <= 0 {
+		db.remove(key)
+		return db.record(Operation{Type: OP_DELETE, Key: key})
+	}
+
+	expiresAt := nowMillis() + ttl.Milliseconds()
+	s.expires[key] = expiresAt
+	return db.record(Operation{Type: OP_EXPIRE, Key: key, ExpiresAt: expiresAt})
+}
+
+// Persist removes the expiration of an existing key.
+// It returns ErrKeyNotFound if the key does not exist.
+func (db *Database) Persist(key string) error {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !db.exists(key, nowMillis()) {
+		return ErrKeyNotFound
+	}
+	if _, ok := s.expires[key]; !ok {
+		return nil
+	}
+
+	delete(s.expires, key)
+	return db.record(Operation{Type: OP_PERSIST, Key: key})
+}
+
+// TTL returns the remaining time to live of a key, or NO_EXPIRATION if the
+// key has no expiration. It returns ErrKeyNotFound if the key does not exist.
+func (db *Database) TTL(key string) (time.Duration, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := nowMillis()
+	if !db.exists(key, now) {
+		return 0, ErrKeyNotFound
+	}
+
+	expiresAt, ok := s.expires[key]
+	if !ok {
+		return NO_EXPIRATION, nil
+	}
+	return time.Duration(expiresAt-now) * time.Millisecond, nil
+}
+
+// Exists reports whether the key is stored and not expired.
+func (db *Database) Exists(key string) bool {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return db.exists(key, nowMillis())
+}
+
+// expiresAt returns the expiration time of the key, zero if it has none. The
+// caller must hold the lock of the stripe of key.
+func (db *Database) expiresAt(key string) int64 {
+	return db.stripeOf(key).expires[key]
+}
+
+// exists reports whether the key is stored and not expired. The caller must
+// hold the lock of the stripe of key.
+func (db *Database) exists(key string, now int64) bool {
+	s := db.stripeOf(key)
+	if _, ok := s.values[key]; !ok && s.dict.Get(key) == "" {
+		return false
+	}
+	return !db.isExpired(key, now)
+}
+
+// isExpired reports whether the key has an expiration in the past. The
+// caller must hold the lock of the stripe of key.
+func (db *Database) isExpired(key string, now int64) bool {
+	expiresAt, ok := db.stripeOf(key).expires[key]
+	return ok && expiresAt <= now
+}
+
+// SetLocalExpirations controls whether the deletions of expired keys are
+// journaled, the default. When local is set, every node replaying the same
+// journal deletes the expired keys on its own, from the expiration times
+// recorded in the journal, and the deletions are only reported to the watchers.
+func (cm *CollectionManager) SetLocalExpirations(local bool) {
+	cm.localExpirations.Store(local)
+}
+
+// recordExpiration records the deletion of an expired key. The caller must
+// hold the write lock of the stripe of key.
+func (db *Database) recordExpiration(key string) {
+	op := Operation{Type: OP_DELETE, Key: key}
+	if db.manager != nil && db.manager.localExpirations.Load() {
+		op.Collection = db.name
+		db.manager.events.publish(op, EVENT_EXPIRE)
+		return
+	}
+	db.recordAs(op, EVENT_EXPIRE)
+}
+
+// deleteIfExpired removes a key found expired while holding the read lock.
+func (db *Database) deleteIfExpired(key string) {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !db.isExpired(key, nowMillis()) {
+		return
+	}
+	db.remove(key)
+	db.recordExpiration(key)
+}
+
+// sweepExpired checks up to sample keys with a TTL in every stripe and
+// deletes the expired ones. It returns the number of keys checked and deleted.
+func (db *Database) sweepExpired(sample int) (int, int) {
+	checked, deleted := 0, 0
+	for _, s := range db.stripes {
+		stripeChecked, stripeDeleted := db.sweepStripe(s, sample)
+		checked += stripeChecked
+		deleted += stripeDeleted
+	}
+	return checked, deleted
+}
+
+// sweepStripe checks up to sample keys with a TTL of the stripe s, as sweepExpired.
+func (db *Database) sweepStripe(s *stripe, sample int) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := nowMillis()
+	checked, deleted := 0, 0
+	// Map iteration starts at a random position, which makes this a random sample
+	for key, expiresAt := range s.expires {
+		if checked == sample {
+			break
+		}
+		checked++
+		if expiresAt <= now {
+			db.remove(key)
+			db.recordExpiration(key)
+			deleted++
+		}
+	}
+	return checked, deleted
+}
+
+// ExpirationSweeper periodically evicts expired keys from every collection,
+// so that keys which are never read again do not stay in memory.
+type ExpirationSweeper struct {
+	collectionManager *CollectionManager
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewExpirationSweeper(collectionManager *CollectionManager) *ExpirationSweeper {
+	return &ExpirationSweeper{collectionManager: collectionManager}
+}
+
+// Sweep runs a single pass over all collections and returns the number of deleted keys.
+func (s *ExpirationSweeper) Sweep() int {
+	deadline := time.Now().Add(EXPIRATION_SWEEP_MAX_DURATION)
+	total := 0
+
+	for _, name := range s.collectionManager.GetCollectionNames() {
+		db, exists := s.collectionManager.GetCollection(name)
+		if !exists {
+			continue
+		}
+
+		for time.Now().Before(deadline) {
+			checked, deleted := db.sweepExpired(EXPIRATION_SWEEP_SAMPLE)
+			total += deleted
+			if checked == 0 || deleted*4 <= checked {
+				break
+			}
+		}
+	}
+	return total
+}
+
+// Start sweeps the collections every interval until Stop is called.
+func (s *ExpirationSweeper) Start(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Sweep()
+			case <-stop:
+				return
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Stop terminates the periodic sweeps started by Start.
+func (s *ExpirationSweeper) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+	s.done = nil
+}
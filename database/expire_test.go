@@ -0,0 +1,142 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_SetWithTTL(t *testing.T) {
+	db := NewDatabase()
+
+	require.NoError(t, db.SetWithTTL("key", "value", 50*time.Millisecond))
+	assert.Equal(t, "value", db.Get("key"))
+
+	ttl, err := db.TTL("key")
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 50*time.Millisecond, "Unexpected ttl %v", ttl)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "", db.Get("key"), "Expected the key to be expired")
+	assert.Empty(t, db.GetAllItems())
+
+	_, err = db.TTL("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestDatabase_SetClearsTTL(t *testing.T) {
+	db := NewDatabase()
+
+	require.NoError(t, db.SetWithTTL("key", "value", time.Minute))
+	require.NoError(t, db.Set("key", "value"))
+
+	ttl, err := db.TTL("key")
+	require.NoError(t, err)
+	assert.Equal(t, NO_EXPIRATION, ttl)
+}
+
+func TestDatabase_ExpireAndPersist(t *testing.T) {
+	db := NewDatabase()
+
+	assert.ErrorIs(t, db.Expire("missing", time.Minute), ErrKeyNotFound)
+	assert.ErrorIs(t, db.Persist("missing"), ErrKeyNotFound)
+
+	require.NoError(t, db.Set("key", "value"))
+	require.NoError(t, db.Expire("key", time.Minute))
+	ttl, err := db.TTL("key")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	require.NoError(t, db.Persist("key"))
+	ttl, err = db.TTL("key")
+	require.NoError(t, err)
+	assert.Equal(t, NO_EXPIRATION, ttl)
+
+	// A non positive ttl deletes the key
+	require.NoError(t, db.Expire("key", 0))
+	assert.Equal(t, "", db.Get("key"))
+}
+
+func TestExpirationSweeper_Sweep(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	db := cm.GetDefaultCollection()
+
+	for i := 0; i < 100; i++ {
+		db.SetWithTTL(fmt.Sprintf("expiring%d", i), "value", time.Millisecond)
+	}
+	db.Set("persistent", "value")
+	time.Sleep(5 * time.Millisecond)
+
+	deleted := NewExpirationSweeper(cm).Sweep()
+	assert.Equal(t, 100, deleted)
+	stored := 0
+	for _, s := range db.stripes {
+		stored += len(s.dict.GetAllItems())
+		assert.Empty(t, s.expires)
+	}
+	assert.Equal(t, 1, stored, "Expected expired keys to be removed from the dict")
+}
+
+func TestCollectionManager_SetLocalExpirations(t *testing.T) {
+	cm := NewCollectionManager()
+	journal := NewReplicationLog(nil, 0)
+	cm.SetJournal(journal)
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.SetLocalExpirations(true)
+	db := cm.GetDefaultCollection()
+	watcher, _, _, err := cm.Watch(DEFAULT_COLLECTION, "", 0)
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	db.SetWithTTL("swept", "value", time.Millisecond)
+	db.SetWithTTL("read", "value", time.Millisecond)
+	offset := journal.Offset()
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(t, "", db.Get("read"))
+	assert.Equal(t, 1, NewExpirationSweeper(cm).Sweep())
+	assert.Equal(t, offset, journal.Offset(), "Expected the deletions of expired keys not to be journaled")
+
+	expired := 0
+	for len(watcher.Events()) > 0 {
+		if event := <-watcher.Events(); event.Type == EVENT_EXPIRE {
+			expired++
+		}
+	}
+	assert.Equal(t, 2, expired, "Expected the watchers to be notified")
+}
+
+func TestExpirationSweeper_StartAndStop(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	db := cm.GetDefaultCollection()
+	db.SetWithTTL("key", "value", time.Millisecond)
+
+	sweeper := NewExpirationSweeper(cm)
+	sweeper.Start(5 * time.Millisecond)
+	defer sweeper.Stop()
+
+	assert.Eventually(t, func() bool {
+		s := db.stripeOf("key")
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.expires) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSnapshot_KeepsTTL(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.GetDefaultCollection().SetWithTTL("key", "value", time.Minute)
+
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(cm.Snapshot()))
+
+	ttl, err := restored.GetDefaultCollection().TTL("key")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// HASH_FIELD_OVERHEAD approximates the memory used by a hash field on top of the bytes of its name and value.
+const HASH_FIELD_OVERHEAD int64 = 64
+
+// hash is a hash value, a map of fields to string values.
+type hash struct {
+	fields map[string]string
+	bytes  int64
+}
+
+func newHash() *hash {
+	return &hash{fields: make(map[string]string)}
+}
+
+func unmarshalHash(data string) (typedValue, error) {
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, err
+	}
+	h := newHash()
+	for field, value := range fields {
+		h.set(field, value)
+	}
+	return h, nil
+}
+
+func (h *hash) valueType() ValueType {
+	return TYPE_HASH
+}
+
+func (h *hash) size() int64 {
+	return h.bytes + int64(len(h.fields))*HASH_FIELD_OVERHEAD
+}
+
+func (h *hash) len() int {
+	return len(h.fields)
+}
+
+func (h *hash) marshal() (string, error) {
+	data, err := json.Marshal(h.fields)
+	return string(data), err
+}
+
+// set stores the value of field, returning true if the field is new.
+func (h *hash) set(field string, value string) bool {
+	current, exists := h.fields[field]
+	if exists {
+		h.bytes -= int64(len(current))
+	} else {
+		h.bytes += int64(len(field))
+	}
+	h.fields[field] = value
+	h.bytes += int64(len(value))
+	return !exists
+}
+
+func (h *hash) remove(field string) bool {
+	value, ok := h.fields[field]
+	if !ok {
+		return false
+	}
+	delete(h.fields, field)
+	h.bytes -= int64(len(field)) + int64(len(value))
+	return true
+}
+
+// HSet stores the values of the fields in the hash stored under key,
+// creating the hash if needed. It returns the number of fields that were not
+// already in the hash.
+func (db *Database) HSet(key string, fields map[string]string) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	args := hashFieldsArgs(fields)
+	if err := db.reserveElements(key, args, HASH_FIELD_OVERHEAD/2); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, version, err := db.setHashFields(key, args, 0)
+	if err != nil {
+		return 0, err
+	}
+	return added, db.record(Operation{Type: OP_HSET, Key: key, Args: args, Version: version})
+}
+
+// HGet returns the value of field in the hash stored under key, and whether
+// the field exists.
+func (db *Database) HGet(key string, field string) (string, bool, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := db.typedValueOf(key, TYPE_HASH, nowMillis())
+	if value == nil {
+		return "", false, err
+	}
+	fieldValue, ok := value.(*hash).fields[field]
+	return fieldValue, ok, nil
+}
+
+// HExists reports whether field is in the hash stored under key.
+func (db *Database) HExists(key string, field string) (bool, error) {
+	_, ok, err := db.HGet(key, field)
+	return ok, err
+}
+
+// HGetAll returns the fields of the hash stored under key with their values.
+// A missing key is an empty hash.
+func (db *Database) HGetAll(key string) (map[string]string, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := db.typedValueOf(key, TYPE_HASH, nowMillis())
+	fields := make(map[string]string)
+	if value == nil {
+		return fields, err
+	}
+	for field, fieldValue := range value.(*hash).fields {
+		fields[field] = fieldValue
+	}
+	return fields, nil
+}
+
+// HDel removes the fields from the hash stored under key, deleting the key
+// once the hash is empty. It returns the number of fields removed.
+func (db *Database) HDel(key string, fields ...string) (int, error) {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, version, err := db.removeHashFields(key, fields, 0)
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	return removed, db.record(Operation{Type: OP_HDEL, Key: key, Args: fields, Version: version})
+}
+
+// HIncrBy adds delta to the integer stored in field of the hash stored under
+// key, a missing field counting as zero. It returns the new value,
+// ErrNotInteger if the field does not hold a 64-bit integer or ErrOverflow if
+// the result would not fit.
+func (db *Database) HIncrBy(key string, field string, delta int64) (int64, error) {
+	if err := db.reserveElements(key, []string{field, strconv.FormatInt(delta, 10)}, HASH_FIELD_OVERHEAD/2); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, err := db.typedValueOf(key, TYPE_HASH, nowMillis())
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if value != nil {
+		if fieldValue, ok := value.(*hash).fields[field]; ok {
+			if current, err = strconv.ParseInt(fieldValue, 10, 64); err != nil {
+				return 0, ErrNotInteger
+			}
+		}
+	}
+	result := current + delta
+	if (delta >= 0) != (result >= current) {
+		return 0, ErrOverflow
+	}
+
+	args := []string{field, strconv.FormatInt(result, 10)}
+	_, version, err := db.setHashFields(key, args, 0)
+	if err != nil {
+		return 0, err
+	}
+	return result, db.record(Operation{Type: OP_HSET, Key: key, Args: args, Version: version})
+}
+
+// setHashFields stores the field and value pairs of args in the hash stored
+// under key with the given version, zero for the next one. It returns the
+// number of fields added and the version of the key. The caller must hold the
+// write lock of the stripe of key.
+func (db *Database) setHashFields(key string, args []string, version uint64) (int, uint64, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return 0, 0, fmt.Errorf("hash fields must be given as field and value pairs, got %d arguments", len(args))
+	}
+	value, err := db.typedValueOf(key, TYPE_HASH, nowMillis())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var expiresAt int64
+	h, _ := value.(*hash)
+	if h == nil {
+		h = newHash()
+	} else {
+		expiresAt = db.expiresAt(key)
+	}
+
+	added := 0
+	for i := 0; i < len(args); i += 2 {
+		if h.set(args[i], args[i+1]) {
+			added++
+		}
+	}
+	return added, db.setTyped(key, h, expiresAt, version), nil
+}
+
+// removeHashFields removes the fields from the hash stored under key with the
+// given version, zero for the next one. The caller must hold the write lock
+// of the stripe of key.
+func (db *Database) removeHashFields(key string, fields []string, version uint64) (int, uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_HASH, nowMillis())
+	if value == nil {
+		return 0, 0, err
+	}
+
+	h := value.(*hash)
+	removed := 0
+	for _, field := range fields {
+		if h.remove(field) {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, 0, nil
+	}
+	return removed, db.setTyped(key, h, db.expiresAt(key), version), nil
+}
+
+// hashFieldsArgs encodes fields as the arguments of an OP_HSET: every field
+// followed by its value, in the order of the fields.
+func hashFieldsArgs(fields map[string]string) []string {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	args := make([]string, 0, 2*len(fields))
+	for _, field := range names {
+		args = append(args, field, fields[field])
+	}
+	return args
+}
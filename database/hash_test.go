@@ -0,0 +1,99 @@
+package database
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Hash(t *testing.T) {
+	db := NewDatabase()
+	added, err := db.HSet("user:1", map[string]string{"name": "Ada", "email": "ada@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	added, err = db.HSet("user:1", map[string]string{"name": "Ada Lovelace", "visits": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, TYPE_HASH, db.Type("user:1"))
+
+	value, ok, err := db.HGet("user:1", "name")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Ada Lovelace", value)
+	_, ok, err = db.HGet("user:1", "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	exists, err := db.HExists("user:1", "email")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	visits, err := db.HIncrBy("user:1", "visits", 41)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), visits)
+	visits, err = db.HIncrBy("user:1", "logins", -1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), visits)
+	_, err = db.HIncrBy("user:1", "name", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+	_, err = db.HIncrBy("user:1", "visits", math.MaxInt64)
+	assert.ErrorIs(t, err, ErrOverflow)
+
+	fields, err := db.HGetAll("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "Ada Lovelace", "email": "ada@example.com", "visits": "42", "logins": "-1"}, fields)
+
+	removed, err := db.HDel("user:1", "email", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = db.HDel("user:1", "name", "visits", "logins")
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.False(t, db.Exists("user:1"))
+
+	fields, err = db.HGetAll("user:1")
+	require.NoError(t, err)
+	assert.Empty(t, fields)
+
+	require.NoError(t, db.Set("string", "value"))
+	_, err = db.HSet("string", map[string]string{"field": "value"})
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = db.HGet("string", "field")
+	assert.ErrorIs(t, err, ErrWrongType)
+}
+
+func TestHash_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	db := cm.GetDefaultCollection()
+	_, err := db.HSet("user:1", map[string]string{"name": "Ada", "email": "ada@example.com"})
+	require.NoError(t, err)
+	_, err = db.HIncrBy("user:1", "visits", 3)
+	require.NoError(t, err)
+	_, err = db.HDel("user:1", "email")
+	require.NoError(t, err)
+
+	check := func(db *Database) {
+		fields, err := db.HGetAll("user:1")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"name": "Ada", "visits": "3"}, fields)
+	}
+
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(cm.Snapshot()))
+	check(restored.GetDefaultCollection())
+
+	require.NoError(t, aof.Close())
+	replayed, replayedLog := openTestAppendLog(t, path)
+	check(replayed.GetDefaultCollection())
+	require.NoError(t, replayedLog.Rewrite())
+	require.NoError(t, replayedLog.Close())
+
+	rewritten, rewrittenLog := openTestAppendLog(t, path)
+	defer rewrittenLog.Close()
+	check(rewritten.GetDefaultCollection())
+}
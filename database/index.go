@@ -0,0 +1,154 @@
+package database
+
+import (
+	"errors"
+)
+
+var ErrNoOrderedIndex = errors.New("collection has no ordered index")
+
+// Item is a key and its value.
+type Item struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// RangeOptions selects the keys returned by Range. Bounds left empty are unbounded.
+type RangeOptions struct {
+	// Start is the lowest key returned, inclusive
+	Start string
+	// End is the key following the highest key returned, exclusive
+	End string
+	// Prefix is a prefix the keys must start with
+	Prefix string
+	// Descending returns the keys from the highest to the lowest
+	Descending bool
+	// Limit is the maximum number of items returned, zero for no limit
+	Limit int
+}
+
+// bounds returns the range of keys, intersected with the prefix.
+func (options RangeOptions) bounds() (string, string) {
+	start, end := options.Start, options.End
+	if options.Prefix == "" {
+		return start, end
+	}
+
+	if options.Prefix > start {
+		start = options.Prefix
+	}
+	if prefixEnd := prefixEnd(options.Prefix); prefixEnd != "" && (end == "" || prefixEnd < end) {
+		end = prefixEnd
+	}
+	return start, end
+}
+
+// prefixEnd returns the lowest key greater than every key starting with
+// prefix, an empty string if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// CreateOrderedIndex maintains the keys of the database in lexicographic
+// order, as needed by Range. Keys already stored are indexed immediately.
+func (db *Database) CreateOrderedIndex() error {
+	db.lockAll()
+	defer db.unlockAll()
+
+	if db.index != nil {
+		return nil
+	}
+	db.buildOrderedIndex()
+	return db.record(Operation{Type: OP_CREATE_INDEX})
+}
+
+// DropOrderedIndex removes the ordered index of the database.
+func (db *Database) DropOrderedIndex() error {
+	db.lockAll()
+	defer db.unlockAll()
+
+	if db.index == nil {
+		return nil
+	}
+	db.index = nil
+	return db.record(Operation{Type: OP_DROP_INDEX})
+}
+
+// HasOrderedIndex reports whether the database maintains an ordered index.
+func (db *Database) HasOrderedIndex() bool {
+	// The index is only replaced while holding every stripe lock
+	s := db.stripes[0]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return db.index != nil
+}
+
+// Range returns, in order, the items with a key between the bounds of the
+// options. It returns ErrNoOrderedIndex if the database has no ordered index.
+// The second result reports whether more items were left out by the limit.
+func (db *Database) Range(options RangeOptions) ([]Item, bool, error) {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	if db.index == nil {
+		return nil, false, ErrNoOrderedIndex
+	}
+
+	start, end := options.bounds()
+	items := []Item{}
+	if end != "" && start >= end {
+		return items, false, nil
+	}
+
+	now := nowMillis()
+	var node *skiplistNode
+	if options.Descending {
+		if end == "" {
+			node = db.index.tail
+		} else {
+			node = db.index.seekLT(end)
+		}
+	} else {
+		node = db.index.seekGE(start)
+	}
+
+	for node != nil {
+		if options.Descending && node.key < start {
+			break
+		}
+		if !options.Descending && end != "" && node.key >= end {
+			break
+		}
+
+		if !db.isExpired(node.key, now) {
+			if options.Limit > 0 && len(items) == options.Limit {
+				return items, true, nil
+			}
+			items = append(items, Item{Key: node.key, Value: db.renderValue(node.key)})
+		}
+
+		if options.Descending {
+			node = node.backward
+		} else {
+			node = node.forward[0]
+		}
+	}
+	return items, false, nil
+}
+
+// buildOrderedIndex indexes every stored key. The caller must hold the write
+// lock of every stripe.
+func (db *Database) buildOrderedIndex() {
+	db.index = newSkiplist()
+	for _, s := range db.stripes {
+		for key := range s.meta {
+			db.index.insert(key)
+		}
+	}
+}
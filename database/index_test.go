@@ -0,0 +1,119 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rangeKeys(t *testing.T, db *Database, options RangeOptions) []string {
+	items, _, err := db.Range(options)
+	require.NoError(t, err)
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+func TestSkiplist(t *testing.T) {
+	sl := newSkiplist()
+	reference := make(map[string]bool)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key%d", rand.Intn(1000))
+		if rand.Intn(3) == 0 {
+			sl.delete(key)
+			delete(reference, key)
+		} else {
+			sl.insert(key)
+			reference[key] = true
+		}
+	}
+
+	expected := make([]string, 0, len(reference))
+	for key := range reference {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	var forward, backward []string
+	for node := sl.head.forward[0]; node != nil; node = node.forward[0] {
+		forward = append(forward, node.key)
+	}
+	for node := sl.tail; node != nil; node = node.backward {
+		backward = append([]string{node.key}, backward...)
+	}
+	assert.Equal(t, expected, forward)
+	assert.Equal(t, expected, backward)
+	assert.Equal(t, len(expected), sl.length)
+}
+
+func TestDatabase_Range(t *testing.T) {
+	db := NewDatabase()
+	_, _, err := db.Range(RangeOptions{})
+	assert.ErrorIs(t, err, ErrNoOrderedIndex)
+
+	for _, key := range []string{"tenant:1:user:1", "tenant:1:user:2", "tenant:2:user:1", "tenant:10:user:1", "other"} {
+		require.NoError(t, db.Set(key, "value"))
+	}
+	require.NoError(t, db.CreateOrderedIndex())
+	require.NoError(t, db.Set("tenant:1:user:3", "value"))
+	require.NoError(t, db.Delete("other"))
+
+	assert.Equal(t, []string{"tenant:1:user:1", "tenant:1:user:2", "tenant:1:user:3"}, rangeKeys(t, db, RangeOptions{Prefix: "tenant:1:"}))
+	assert.Equal(t, []string{"tenant:1:user:3", "tenant:1:user:2", "tenant:1:user:1"}, rangeKeys(t, db, RangeOptions{Prefix: "tenant:1:", Descending: true}))
+	assert.Equal(t, []string{"tenant:1:user:2", "tenant:1:user:3"}, rangeKeys(t, db, RangeOptions{Start: "tenant:1:user:2", End: "tenant:2"}))
+	assert.Equal(t, []string{"tenant:2:user:1", "tenant:1:user:3"}, rangeKeys(t, db, RangeOptions{Start: "tenant:1:user:3", Descending: true}))
+	// Keys are compared byte by byte
+	assert.Equal(t, []string{"tenant:10:user:1", "tenant:1:user:1"}, rangeKeys(t, db, RangeOptions{Prefix: "tenant:1", Limit: 2}))
+	assert.Equal(t, []string{}, rangeKeys(t, db, RangeOptions{Start: "z", End: "a"}))
+
+	items, more, err := db.Range(RangeOptions{Limit: 2})
+	require.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, []Item{{Key: "tenant:10:user:1", Value: "value"}, {Key: "tenant:1:user:1", Value: "value"}}, items)
+
+	_, more, err = db.Range(RangeOptions{Limit: 5})
+	require.NoError(t, err)
+	assert.False(t, more)
+
+	require.NoError(t, db.DropOrderedIndex())
+	assert.False(t, db.HasOrderedIndex())
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, "tenant;", prefixEnd("tenant:"))
+	assert.Equal(t, "b", prefixEnd("a\xff"))
+	assert.Equal(t, "", prefixEnd("\xff\xff"))
+}
+
+func TestOrderedIndex_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	cm.AddCollection("indexed")
+	indexed, _ := cm.GetCollection("indexed")
+	require.NoError(t, indexed.CreateOrderedIndex())
+	require.NoError(t, indexed.Set("b", "value"))
+	require.NoError(t, indexed.Set("a", "value"))
+
+	snapshot := cm.Snapshot()
+	assert.Equal(t, []string{"indexed"}, snapshot.Indexes)
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(snapshot))
+	collection, _ := restored.GetCollection("indexed")
+	assert.Equal(t, []string{"a", "b"}, rangeKeys(t, collection, RangeOptions{}))
+
+	require.NoError(t, aof.Rewrite())
+	require.NoError(t, aof.Close())
+	replayed, replayedLog := openTestAppendLog(t, path)
+	defer replayedLog.Close()
+	collection, _ = replayed.GetCollection("indexed")
+	assert.Equal(t, []string{"a", "b"}, rangeKeys(t, collection, RangeOptions{}))
+	assert.False(t, replayed.GetDefaultCollection().HasOrderedIndex())
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type OperationType string
+
+const (
+	OP_SET               OperationType = "set"
+	OP_DELETE            OperationType = "delete"
+	OP_ADD_COLLECTION    OperationType = "add_collection"
+	OP_REMOVE_COLLECTION OperationType = "remove_collection"
+	OP_EXPIRE            OperationType = "expire"
+	OP_PERSIST           OperationType = "persist"
+	OP_CREATE_INDEX      OperationType = "create_index"
+	OP_DROP_INDEX        OperationType = "drop_index"
+	OP_LPUSH             OperationType = "lpush"
+	OP_RPUSH             OperationType = "rpush"
+	OP_LPOP              OperationType = "lpop"
+	OP_RPOP              OperationType = "rpop"
+	OP_LTRIM             OperationType = "ltrim"
+	OP_SADD              OperationType = "sadd"
+	OP_SREM              OperationType = "srem"
+	OP_ZADD              OperationType = "zadd"
+	OP_ZREM              OperationType = "zrem"
+	OP_HSET              OperationType = "hset"
+	OP_HDEL              OperationType = "hdel"
+	// OP_RESET drops every collection. It starts a rewritten log, so that the
+	// operations following it describe the complete state.
+	OP_RESET OperationType = "reset"
+	// OP_TRANSACTION groups the operations of a transaction, applied atomically.
+	OP_TRANSACTION OperationType = "transaction"
+)
+
+// Operation is a single mutation of a CollectionManager, as recorded in a journal.
+type Operation struct {
+	Seq        uint64        `json:"seq"`
+	Type       OperationType `json:"op"`
+	Collection string        `json:"collection,omitempty"`
+	Key        string        `json:"key,omitempty"`
+	Value      string        `json:"value,omitempty"`
+	// ExpiresAt is the expiration time in unix milliseconds, zero if the key does not expire
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// ValueType is the type of the value stored by an OP_SET, encoded as JSON, empty for strings
+	ValueType ValueType `json:"value_type,omitempty"`
+	// Version is the version of the key written by the operation
+	Version uint64 `json:"version,omitempty"`
+	// Args holds the arguments of the operations on typed values, such as
+	// the elements of an OP_LPUSH or the count of an OP_LPOP
+	Args []string `json:"args,omitempty"`
+	// Ops holds the operations of an OP_TRANSACTION
+	Ops []Operation `json:"ops,omitempty"`
+}
+
+// Journal records the mutations applied to a CollectionManager.
+type Journal interface {
+	// Append assigns the next sequence number to the operation and records it.
+	Append(op Operation) error
+	// LastSeq returns the sequence number of the last recorded operation.
+	LastSeq() uint64
+}
+
+// Apply executes an operation read from a journal.
+func (cm *CollectionManager) Apply(op Operation) error {
+	switch op.Type {
+	case OP_RESET:
+		cm.mu.Lock()
+		cm.collections = make(map[string]*Database)
+		cm.record(op)
+		cm.mu.Unlock()
+	case OP_ADD_COLLECTION:
+		cm.AddCollection(op.Collection)
+	case OP_REMOVE_COLLECTION:
+		cm.RemoveCollection(op.Collection)
+	case OP_TRANSACTION:
+		return cm.applyTransaction(op)
+	case OP_SET, OP_DELETE, OP_EXPIRE, OP_PERSIST, OP_CREATE_INDEX, OP_DROP_INDEX,
+		OP_LPUSH, OP_RPUSH, OP_LPOP, OP_RPOP, OP_LTRIM, OP_SADD, OP_SREM, OP_ZADD, OP_ZREM, OP_HSET, OP_HDEL:
+		db, exists := cm.GetCollection(op.Collection)
+		if !exists {
+			return fmt.Errorf("collection %q not found", op.Collection)
+		}
+		return db.apply(op)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Type)
+	}
+	return nil
+}
+
+// apply executes a key level operation read from a journal.
+func (db *Database) apply(op Operation) error {
+	if op.Type == OP_CREATE_INDEX || op.Type == OP_DROP_INDEX {
+		db.lockAll()
+		defer db.unlockAll()
+	} else {
+		s := db.stripeOf(op.Key)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	switch op.Type {
+	case OP_SET:
+		entry := SnapshotEntry{Key: op.Key, Value: op.Value, Type: op.ValueType, ExpiresAt: op.ExpiresAt, Version: op.Version}
+		if err := db.restoreEntry(entry); err != nil {
+			return err
+		}
+	case OP_DELETE:
+		// Deleting a missing key is not an error on replay
+		db.remove(op.Key)
+	case OP_EXPIRE:
+		s := db.stripeOf(op.Key)
+		if _, ok := s.meta[op.Key]; ok {
+			s.expires[op.Key] = op.ExpiresAt
+		}
+	case OP_PERSIST:
+		delete(db.stripeOf(op.Key).expires, op.Key)
+	case OP_CREATE_INDEX:
+		if db.index == nil {
+			db.buildOrderedIndex()
+		}
+	case OP_DROP_INDEX:
+		db.index = nil
+	case OP_LPUSH, OP_RPUSH:
+		if _, _, err := db.pushElements(op.Key, op.Args, op.Type == OP_LPUSH, op.Version); err != nil {
+			return err
+		}
+	case OP_LPOP, OP_RPOP:
+		count, err := argInt(op, 0)
+		if err != nil {
+			return err
+		}
+		// Popping from a missing key is not an error on replay
+		if _, _, err := db.popElements(op.Key, count, op.Type == OP_LPOP, op.Version); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	case OP_LTRIM:
+		start, err := argInt(op, 0)
+		if err != nil {
+			return err
+		}
+		stop, err := argInt(op, 1)
+		if err != nil {
+			return err
+		}
+		if _, err := db.trimList(op.Key, start, stop, op.Version); err != nil {
+			return err
+		}
+	case OP_SADD:
+		if _, _, err := db.addSetMembers(op.Key, op.Args, op.Version); err != nil {
+			return err
+		}
+	case OP_SREM:
+		if _, _, err := db.removeSetMembers(op.Key, op.Args, op.Version); err != nil {
+			return err
+		}
+	case OP_ZADD:
+		members, err := parseScoredMembersArgs(op.Args)
+		if err != nil {
+			return err
+		}
+		if _, _, _, err := db.addSortedSetMembers(op.Key, members, op.Version); err != nil {
+			return err
+		}
+	case OP_ZREM:
+		if _, _, err := db.removeSortedSetMembers(op.Key, op.Args, op.Version); err != nil {
+			return err
+		}
+	case OP_HSET:
+		if _, _, err := db.setHashFields(op.Key, op.Args, op.Version); err != nil {
+			return err
+		}
+	case OP_HDEL:
+		if _, _, err := db.removeHashFields(op.Key, op.Args, op.Version); err != nil {
+			return err
+		}
+	}
+	return db.record(op)
+}
+
+// argInt returns the argument i of the operation as an integer.
+func argInt(op Operation, i int) (int, error) {
+	if i >= len(op.Args) {
+		return 0, fmt.Errorf("missing argument %d of operation %q", i, op.Type)
+	}
+	return strconv.Atoi(op.Args[i])
+}
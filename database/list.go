@@ -0,0 +1,443 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// LIST_MIN_CAPACITY is the smallest capacity of the ring buffer of a list.
+const LIST_MIN_CAPACITY = 8
+
+// LIST_ELEMENT_OVERHEAD approximates the memory used by a list element on top of its bytes.
+const LIST_ELEMENT_OVERHEAD int64 = 16
+
+var ErrTimeout = errors.New("timeout waiting for an element")
+
+// list is a list value, kept in a ring buffer with a power of two capacity
+// so that elements are pushed and popped at both ends in constant time.
+type list struct {
+	elements []string
+	head     int
+	length   int
+	bytes    int64
+}
+
+func newList() *list {
+	return &list{elements: make([]string, LIST_MIN_CAPACITY)}
+}
+
+func unmarshalList(data string) (typedValue, error) {
+	var elements []string
+	if err := json.Unmarshal([]byte(data), &elements); err != nil {
+		return nil, err
+	}
+	l := newList()
+	for _, element := range elements {
+		l.pushRight(element)
+	}
+	return l, nil
+}
+
+func (l *list) valueType() ValueType {
+	return TYPE_LIST
+}
+
+func (l *list) size() int64 {
+	return l.bytes + int64(l.length)*LIST_ELEMENT_OVERHEAD
+}
+
+func (l *list) len() int {
+	return l.length
+}
+
+func (l *list) marshal() (string, error) {
+	data, err := json.Marshal(l.slice(0, l.length-1))
+	return string(data), err
+}
+
+func (l *list) position(i int) int {
+	return (l.head + i) & (len(l.elements) - 1)
+}
+
+func (l *list) resize(capacity int) {
+	elements := make([]string, capacity)
+	for i := 0; i < l.length; i++ {
+		elements[i] = l.elements[l.position(i)]
+	}
+	l.elements = elements
+	l.head = 0
+}
+
+func (l *list) pushLeft(element string) {
+	if l.length == len(l.elements) {
+		l.resize(2 * len(l.elements))
+	}
+	l.head = l.position(-1)
+	l.elements[l.head] = element
+	l.length++
+	l.bytes += int64(len(element))
+}
+
+func (l *list) pushRight(element string) {
+	if l.length == len(l.elements) {
+		l.resize(2 * len(l.elements))
+	}
+	l.elements[l.position(l.length)] = element
+	l.length++
+	l.bytes += int64(len(element))
+}
+
+func (l *list) popLeft() string {
+	element := l.elements[l.head]
+	l.elements[l.head] = ""
+	l.head = l.position(1)
+	l.length--
+	l.bytes -= int64(len(element))
+	l.shrink()
+	return element
+}
+
+func (l *list) popRight() string {
+	i := l.position(l.length - 1)
+	element := l.elements[i]
+	l.elements[i] = ""
+	l.length--
+	l.bytes -= int64(len(element))
+	l.shrink()
+	return element
+}
+
+func (l *list) shrink() {
+	if len(l.elements) > LIST_MIN_CAPACITY && l.length < len(l.elements)/4 {
+		l.resize(len(l.elements) / 2)
+	}
+}
+
+// slice returns the elements from start to stop, both included and within bounds.
+func (l *list) slice(start int, stop int) []string {
+	elements := make([]string, 0, max(stop-start+1, 0))
+	for i := start; i <= stop; i++ {
+		elements = append(elements, l.elements[l.position(i)])
+	}
+	return elements
+}
+
+// trim keeps only the elements from start to stop, both included and within bounds.
+func (l *list) trim(start int, stop int) {
+	kept := l.slice(start, stop)
+	*l = *newList()
+	for _, element := range kept {
+		l.pushRight(element)
+	}
+}
+
+// listRange resolves the start and stop indexes of a range of a list of the
+// given length, negative indexes counting from the end. The range is empty
+// if start is greater than stop.
+func listRange(start int, stop int, length int) (int, int) {
+	if start < 0 {
+		start = max(start+length, 0)
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop
+}
+
+// LPush inserts the elements at the head of the list stored under key, one
+// after the other, creating the list if needed. It returns the length of the list.
+func (db *Database) LPush(key string, elements ...string) (int, error) {
+	return db.push(key, elements, true)
+}
+
+// RPush appends the elements at the tail of the list stored under key,
+// creating the list if needed. It returns the length of the list.
+func (db *Database) RPush(key string, elements ...string) (int, error) {
+	return db.push(key, elements, false)
+}
+
+// LPop removes and returns up to count elements, at least one, from the head
+// of the list stored under key. The key is deleted once the list is empty.
+// It returns ErrKeyNotFound if the key does not exist.
+func (db *Database) LPop(key string, count int) ([]string, error) {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return db.pop(key, count, true)
+}
+
+// RPop removes and returns up to count elements from the tail of the list
+// stored under key, as LPop.
+func (db *Database) RPop(key string, count int) ([]string, error) {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return db.pop(key, count, false)
+}
+
+// LRange returns the elements of the list stored under key from start to
+// stop, both included. Negative indexes count from the end of the list, -1
+// being the last element. A missing key is an empty list.
+func (db *Database) LRange(key string, start int, stop int) ([]string, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := db.typedValueOf(key, TYPE_LIST, nowMillis())
+	if value == nil {
+		return []string{}, err
+	}
+	l := value.(*list)
+	start, stop = listRange(start, stop, l.length)
+	return l.slice(start, stop), nil
+}
+
+// LLen returns the length of the list stored under key, zero if the key does not exist.
+func (db *Database) LLen(key string) (int, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := db.typedValueOf(key, TYPE_LIST, nowMillis())
+	if value == nil {
+		return 0, err
+	}
+	return value.len(), nil
+}
+
+// LTrim keeps only the elements of the list stored under key from start to
+// stop, both included, with the indexes of LRange. The key is deleted if no
+// element is left.
+func (db *Database) LTrim(key string, start int, stop int) error {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version, err := db.trimList(key, start, stop, 0)
+	if err != nil {
+		return err
+	}
+	return db.record(Operation{Type: OP_LTRIM, Key: key, Args: []string{strconv.Itoa(start), strconv.Itoa(stop)}, Version: version})
+}
+
+// BLPop pops the head of the first non-empty list among keys, waiting up to
+// timeout for an element to be pushed if they are all empty, or forever if
+// timeout is zero. It returns the key and the element, ErrTimeout once the
+// timeout expires or the error of ctx if it is done first.
+func (db *Database) BLPop(ctx context.Context, keys []string, timeout time.Duration) (string, string, error) {
+	return db.blockingPop(ctx, keys, timeout, true)
+}
+
+// BRPop pops the tail of the first non-empty list among keys, as BLPop.
+func (db *Database) BRPop(ctx context.Context, keys []string, timeout time.Duration) (string, string, error) {
+	return db.blockingPop(ctx, keys, timeout, false)
+}
+
+func (db *Database) push(key string, elements []string, left bool) (int, error) {
+	if err := db.reserveElements(key, elements, LIST_ELEMENT_OVERHEAD); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	length, version, err := db.pushElements(key, elements, left, 0)
+	if err != nil || len(elements) == 0 {
+		return length, err
+	}
+	opType := OP_RPUSH
+	if left {
+		opType = OP_LPUSH
+	}
+	return length, db.record(Operation{Type: opType, Key: key, Args: elements, Version: version})
+}
+
+// pushElements pushes the elements into the list stored under key, with the
+// given version, zero for the next one, and wakes up the blocked pops. It
+// returns the length of the list and its version. The caller must hold the
+// write lock of the stripe of key.
+func (db *Database) pushElements(key string, elements []string, left bool, version uint64) (int, uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_LIST, nowMillis())
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(elements) == 0 {
+		if value == nil {
+			return 0, 0, nil
+		}
+		return value.len(), db.stripeOf(key).meta[key].version, nil
+	}
+
+	var expiresAt int64
+	l, _ := value.(*list)
+	if l == nil {
+		l = newList()
+	} else {
+		expiresAt = db.expiresAt(key)
+	}
+	for _, element := range elements {
+		if left {
+			l.pushLeft(element)
+		} else {
+			l.pushRight(element)
+		}
+	}
+	version = db.setTyped(key, l, expiresAt, version)
+	db.wakeListWaiters(key)
+	return l.length, version, nil
+}
+
+// pop removes up to count elements from the list stored under key and records
+// the operation. The caller must hold the write lock of the stripe of key.
+func (db *Database) pop(key string, count int, left bool) ([]string, error) {
+	elements, version, err := db.popElements(key, count, left, 0)
+	if err != nil {
+		return nil, err
+	}
+	opType := OP_RPOP
+	if left {
+		opType = OP_LPOP
+	}
+	return elements, db.record(Operation{Type: opType, Key: key, Args: []string{strconv.Itoa(len(elements))}, Version: version})
+}
+
+// popElements removes up to count elements from the list stored under key,
+// with the given version, zero for the next one. The caller must hold the
+// write lock of the stripe of key.
+func (db *Database) popElements(key string, count int, left bool, version uint64) ([]string, uint64, error) {
+	if count < 1 {
+		count = 1
+	}
+	value, err := db.typedValueOf(key, TYPE_LIST, nowMillis())
+	if err != nil {
+		return nil, 0, err
+	}
+	if value == nil {
+		return nil, 0, ErrKeyNotFound
+	}
+
+	l := value.(*list)
+	elements := make([]string, 0, min(count, l.length))
+	for len(elements) < count && l.length > 0 {
+		if left {
+			elements = append(elements, l.popLeft())
+		} else {
+			elements = append(elements, l.popRight())
+		}
+	}
+	version = db.setTyped(key, l, db.expiresAt(key), version)
+	return elements, version, nil
+}
+
+// trimList keeps the elements of the list stored under key from start to
+// stop, with the given version, zero for the next one. The caller must hold
+// the write lock of the stripe of key.
+func (db *Database) trimList(key string, start int, stop int, version uint64) (uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_LIST, nowMillis())
+	if value == nil {
+		return 0, err
+	}
+
+	l := value.(*list)
+	start, stop = listRange(start, stop, l.length)
+	if start > stop {
+		db.remove(key)
+		return 0, nil
+	}
+	l.trim(start, stop)
+	return db.setTyped(key, l, db.expiresAt(key), version), nil
+}
+
+func (db *Database) blockingPop(ctx context.Context, keys []string, timeout time.Duration, left bool) (string, string, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	wake := make(chan struct{}, 1)
+	for {
+		unlock := db.lockKeys(keys)
+		db.unwatchLists(keys, wake)
+		for _, key := range keys {
+			elements, err := db.pop(key, 1, left)
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			unlock()
+			if err != nil {
+				return "", "", err
+			}
+			return key, elements[0], nil
+		}
+		db.watchLists(keys, wake)
+		unlock()
+
+		select {
+		case <-wake:
+		case <-expired:
+			unlock = db.lockKeys(keys)
+			db.unwatchLists(keys, wake)
+			unlock()
+			return "", "", ErrTimeout
+		case <-ctx.Done():
+			unlock = db.lockKeys(keys)
+			db.unwatchLists(keys, wake)
+			unlock()
+			return "", "", ctx.Err()
+		}
+	}
+}
+
+// watchLists registers wake to be signaled by the next push on any of the
+// keys. The caller must hold the write locks of the stripes of the keys.
+func (db *Database) watchLists(keys []string, wake chan struct{}) {
+	for _, key := range keys {
+		s := db.stripeOf(key)
+		if s.listWaiters == nil {
+			s.listWaiters = make(map[string][]chan struct{})
+		}
+		s.listWaiters[key] = append(s.listWaiters[key], wake)
+	}
+}
+
+// unwatchLists unregisters wake from the keys. The caller must hold the write
+// locks of the stripes of the keys.
+func (db *Database) unwatchLists(keys []string, wake chan struct{}) {
+	for _, key := range keys {
+		s := db.stripeOf(key)
+		waiters := s.listWaiters[key]
+		for i, waiter := range waiters {
+			if waiter == wake {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(s.listWaiters, key)
+		} else {
+			s.listWaiters[key] = waiters
+		}
+	}
+}
+
+// wakeListWaiters signals the blocked pops waiting on key, which all retry to
+// pop. The caller must hold the write lock of the stripe of key.
+func (db *Database) wakeListWaiters(key string) {
+	s := db.stripeOf(key)
+	for _, wake := range s.listWaiters[key] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	delete(s.listWaiters, key)
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList_RingBuffer(t *testing.T) {
+	l := newList()
+	for i := 0; i < 20; i++ {
+		l.pushRight(string(rune('a' + i)))
+		l.pushLeft(string(rune('A' + i)))
+	}
+	assert.Equal(t, 40, l.len())
+	assert.Equal(t, "T", l.popLeft())
+	assert.Equal(t, "t", l.popRight())
+
+	for l.len() > 2 {
+		l.popLeft()
+	}
+	assert.Equal(t, []string{"r", "s"}, l.slice(0, 1))
+	assert.Equal(t, LIST_MIN_CAPACITY, len(l.elements))
+	assert.Equal(t, int64(2), l.bytes)
+}
+
+func TestListRange(t *testing.T) {
+	start, stop := listRange(0, -1, 5)
+	assert.Equal(t, []int{0, 4}, []int{start, stop})
+	start, stop = listRange(-3, 10, 5)
+	assert.Equal(t, []int{2, 4}, []int{start, stop})
+	start, stop = listRange(-10, -6, 5)
+	assert.Greater(t, start, stop)
+}
+
+func TestDatabase_List(t *testing.T) {
+	db := NewDatabase()
+	length, err := db.RPush("queue", "b", "c")
+	require.NoError(t, err)
+	assert.Equal(t, 2, length)
+	length, err = db.LPush("queue", "a", "z")
+	require.NoError(t, err)
+	assert.Equal(t, 4, length)
+	assert.Equal(t, TYPE_LIST, db.Type("queue"))
+
+	elements, err := db.LRange("queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"z", "a", "b", "c"}, elements)
+
+	elements, err = db.LPop("queue", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"z"}, elements)
+	elements, err = db.RPop("queue", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "b"}, elements)
+
+	length, err = db.LLen("queue")
+	require.NoError(t, err)
+	assert.Equal(t, 1, length)
+
+	_, err = db.LPop("queue", 5)
+	require.NoError(t, err)
+	assert.False(t, db.Exists("queue"))
+	_, err = db.LPop("queue", 1)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, int64(0), db.UsedMemory())
+
+	_, err = db.RPush("queue", "1", "2", "3", "4", "5")
+	require.NoError(t, err)
+	require.NoError(t, db.LTrim("queue", 1, -2))
+	elements, _ = db.LRange("queue", 0, -1)
+	assert.Equal(t, []string{"2", "3", "4"}, elements)
+	require.NoError(t, db.LTrim("queue", 5, 10))
+	assert.False(t, db.Exists("queue"))
+}
+
+func TestDatabase_ListWrongType(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.Set("string", "value"))
+	_, err := db.RPush("string", "a")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = db.LRange("string", 0, -1)
+	assert.ErrorIs(t, err, ErrWrongType)
+
+	_, err = db.RPush("queue", "a")
+	require.NoError(t, err)
+	_, err = db.IncrBy("queue", 1)
+	assert.ErrorIs(t, err, ErrWrongType)
+	assert.Equal(t, "", db.Get("queue"))
+	assert.Equal(t, map[string]string{"string": "value", "queue": `["a"]`}, db.GetAllItems())
+
+	require.NoError(t, db.Set("queue", "overwritten"))
+	assert.Equal(t, TYPE_STRING, db.Type("queue"))
+	require.NoError(t, db.Delete("queue"))
+	assert.Equal(t, TYPE_NONE, db.Type("queue"))
+}
+
+func TestDatabase_BlockingPop(t *testing.T) {
+	db := NewDatabase()
+
+	start := time.Now()
+	_, _, err := db.BLPop(context.Background(), []string{"queue"}, 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	type popped struct{ key, element string }
+	results := make(chan popped, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			key, element, err := db.BRPop(context.Background(), []string{"other", "queue"}, 0)
+			assert.NoError(t, err)
+			results <- popped{key, element}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = db.RPush("queue", "a")
+	require.NoError(t, err)
+	_, err = db.RPush("other", "b")
+	require.NoError(t, err)
+
+	received := []popped{<-results, <-results}
+	assert.ElementsMatch(t, []popped{{"queue", "a"}, {"other", "b"}}, received)
+	for _, s := range db.stripes {
+		assert.Empty(t, s.listWaiters)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = db.BLPop(ctx, []string{"queue"}, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	for _, s := range db.stripes {
+		assert.Empty(t, s.listWaiters)
+	}
+}
+
+func TestList_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	db := cm.GetDefaultCollection()
+	_, err := db.RPush("queue", "a", "b", "c", "d")
+	require.NoError(t, err)
+	_, err = db.LPop("queue", 1)
+	require.NoError(t, err)
+	require.NoError(t, db.LTrim("queue", 0, 1))
+	require.NoError(t, db.Expire("queue", time.Hour))
+	version := db.Version("queue")
+
+	snapshot := cm.Snapshot()
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(snapshot))
+	elements, err := restored.GetDefaultCollection().LRange("queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, elements)
+
+	require.NoError(t, aof.Close())
+	replayed, replayedLog := openTestAppendLog(t, path)
+	elements, err = replayed.GetDefaultCollection().LRange("queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, elements)
+	assert.Equal(t, version, replayed.GetDefaultCollection().Version("queue"))
+
+	require.NoError(t, replayedLog.Rewrite())
+	require.NoError(t, replayedLog.Close())
+	rewritten, rewrittenLog := openTestAppendLog(t, path)
+	defer rewrittenLog.Close()
+	elements, err = rewritten.GetDefaultCollection().LRange("queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, elements)
+	ttl, err := rewritten.GetDefaultCollection().TTL("queue")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Minute)
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DEFAULT_REPLICATION_BACKLOG_SIZE is the number of recent operations kept
+// by a ReplicationLog, so that followers reconnecting after a short
+// interruption resume the stream instead of copying every collection again.
+const DEFAULT_REPLICATION_BACKLOG_SIZE = 10000
+
+// REPLICA_BUFFER_SIZE is the number of pending operations buffered per follower.
+const REPLICA_BUFFER_SIZE = 4096
+
+var ErrReplicaTooSlow = errors.New("follower too slow, its buffer of pending operations is full")
+var ErrReplicationClosed = errors.New("replication log closed")
+
+// ReplicationLog is a Journal streaming the operations of a leader to its
+// followers. Every operation is forwarded to the journal persisting the
+// operations, if any, and numbered with an offset: a follower at offset n
+// has applied the first n operations of the log identified by ID.
+type ReplicationLog struct {
+	id          string
+	backlogSize int
+
+	mu       sync.Mutex
+	journal  Journal
+	offset   uint64
+	backlog  []Operation
+	next     int
+	replicas map[*Replica]struct{}
+	closed   bool
+}
+
+// NewReplicationLog creates a replication log forwarding the operations to
+// journal, which may be nil, and keeping the last backlogSize operations,
+// DEFAULT_REPLICATION_BACKLOG_SIZE if backlogSize is not positive.
+func NewReplicationLog(journal Journal, backlogSize int) *ReplicationLog {
+	if backlogSize <= 0 {
+		backlogSize = DEFAULT_REPLICATION_BACKLOG_SIZE
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &ReplicationLog{
+		id:          hex.EncodeToString(id),
+		backlogSize: backlogSize,
+		journal:     journal,
+		replicas:    make(map[*Replica]struct{}),
+	}
+}
+
+// ID returns the random identifier of the log. Offsets are only meaningful
+// for the log that produced them: a follower of another log, or of a
+// restarted leader, must copy every collection again.
+func (l *ReplicationLog) ID() string {
+	return l.id
+}
+
+// Offset returns the offset of the last operation.
+func (l *ReplicationLog) Offset() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.offset
+}
+
+// Append forwards op to the journal and sends it to the followers, closing
+// the replicas of slow followers. The operation is replicated even if the
+// journal fails, as it was already applied.
+func (l *ReplicationLog) Append(op Operation) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	if l.journal != nil {
+		err = l.journal.Append(op)
+	}
+
+	l.offset++
+	op.Seq = l.offset
+	if len(l.backlog) < l.backlogSize {
+		l.backlog = append(l.backlog, op)
+	} else {
+		l.backlog[l.next] = op
+		l.next = (l.next + 1) % l.backlogSize
+	}
+
+	for replica := range l.replicas {
+		select {
+		case replica.ops <- op:
+			replica.queued = op.Seq
+		default:
+			replica.closeLocked(ErrReplicaTooSlow)
+		}
+	}
+	return err
+}
+
+// LastSeq returns the sequence number of the journal, zero without one, so
+// that snapshots are numbered as if the log was not there.
+func (l *ReplicationLog) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.journal == nil {
+		return 0
+	}
+	return l.journal.LastSeq()
+}
+
+// Follow registers a follower named name, which applied the operations of
+// the log id up to offset. If the operations following offset are still in
+// the backlog they are returned, otherwise so is a snapshot of the
+// collections of cm. The follower then receives the operations following
+// the returned offset, the one of the snapshot or the given one.
+func (l *ReplicationLog) Follow(cm *CollectionManager, name string, id string, offset uint64) (*Replica, *Snapshot, []Operation, uint64) {
+	l.mu.Lock()
+	if id == l.id && offset <= l.offset && offset+uint64(len(l.backlog)) >= l.offset {
+		var ops []Operation
+		for i := range l.backlog {
+			op := l.backlog[(l.next+i)%len(l.backlog)]
+			if op.Seq > offset {
+				ops = append(ops, op)
+			}
+		}
+		replica := l.addReplicaLocked(name, offset)
+		l.mu.Unlock()
+		return replica, nil, ops, offset
+	}
+	l.mu.Unlock()
+
+	// No operation can be appended while the snapshot is taken, the
+	// follower receives exactly the operations following it
+	var replica *Replica
+	snapshot := cm.snapshot(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		offset = l.offset
+		replica = l.addReplicaLocked(name, offset)
+	})
+	return replica, snapshot, nil, offset
+}
+
+// Close closes every replica with ErrReplicationClosed, as well as the
+// replicas created afterwards.
+func (l *ReplicationLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for replica := range l.replicas {
+		replica.closeLocked(ErrReplicationClosed)
+	}
+}
+
+// ReplicaStatus describes a follower connected to a leader. Offset is the
+// offset of the last operation taken from its buffer, Lag the number of
+// operations appended since.
+type ReplicaStatus struct {
+	Name        string    `json:"name"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Offset      uint64    `json:"offset"`
+	Lag         uint64    `json:"lag"`
+}
+
+// Replicas returns the status of the connected followers.
+func (l *ReplicationLog) Replicas() []ReplicaStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	replicas := make([]ReplicaStatus, 0, len(l.replicas))
+	for replica := range l.replicas {
+		offset := replica.queued - uint64(len(replica.ops))
+		replicas = append(replicas, ReplicaStatus{
+			Name:        replica.name,
+			ConnectedAt: replica.connectedAt,
+			Offset:      offset,
+			Lag:         l.offset - offset,
+		})
+	}
+	return replicas
+}
+
+// addReplicaLocked creates a replica at offset, the caller must hold l.mu.
+func (l *ReplicationLog) addReplicaLocked(name string, offset uint64) *Replica {
+	replica := &Replica{
+		log:         l,
+		name:        name,
+		connectedAt: time.Now().UTC(),
+		ops:         make(chan Operation, REPLICA_BUFFER_SIZE),
+		done:        make(chan struct{}),
+		queued:      offset,
+	}
+	if l.closed {
+		replica.closeLocked(ErrReplicationClosed)
+	} else {
+		l.replicas[replica] = struct{}{}
+	}
+	return replica
+}
+
+// Replica receives the operations of a ReplicationLog for a follower.
+type Replica struct {
+	log         *ReplicationLog
+	name        string
+	connectedAt time.Time
+	ops         chan Operation
+	done        chan struct{}
+
+	// The fields below are guarded by log.mu
+	queued uint64
+	closed bool
+	err    error
+}
+
+// Operations returns the channel delivering the operations, in offset
+// order. It is never closed, use Done to know when no more operations will
+// be delivered.
+func (r *Replica) Operations() <-chan Operation {
+	return r.ops
+}
+
+// Done returns a channel closed once the replica is closed.
+func (r *Replica) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns why the replica was closed: ErrReplicaTooSlow,
+// ErrReplicationClosed, or nil if it is open or was closed by Close.
+func (r *Replica) Err() error {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	return r.err
+}
+
+// Close stops the replica.
+func (r *Replica) Close() {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	r.closeLocked(nil)
+}
+
+// closeLocked removes the replica from the log, the caller must hold log.mu.
+func (r *Replica) closeLocked(err error) {
+	if r.closed {
+		return
+	}
+	delete(r.log.replicas, r)
+	r.closed = true
+	r.err = err
+	close(r.done)
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplicatedCollectionManager(backlogSize int) (*CollectionManager, *ReplicationLog) {
+	log := NewReplicationLog(nil, backlogSize)
+	cm := NewCollectionManager()
+	cm.SetJournal(log)
+	cm.AddCollection(DEFAULT_COLLECTION)
+	return cm, log
+}
+
+// applyReplicated applies to follower the operations received by replica, up to offset.
+func applyReplicated(t *testing.T, follower *CollectionManager, replica *Replica, offset uint64, until uint64) uint64 {
+	t.Helper()
+	for offset < until {
+		select {
+		case op := <-replica.Operations():
+			require.Equal(t, offset+1, op.Seq, "Expected the operations in offset order")
+			require.NoError(t, follower.Apply(op))
+			offset = op.Seq
+		case <-time.After(time.Second):
+			t.Fatalf("Expected operations up to offset %d, received up to %d", until, offset)
+		}
+	}
+	return offset
+}
+
+func TestReplicationLog_FullSync(t *testing.T) {
+	leader, log := newReplicatedCollectionManager(0)
+	leader.GetDefaultCollection().Set("key1", "value1")
+	_, err := leader.GetDefaultCollection().RPush("list", "a", "b")
+	require.NoError(t, err)
+
+	replica, snapshot, ops, offset := log.Follow(leader, "follower", "", 0)
+	defer replica.Close()
+	require.NotNil(t, snapshot)
+	assert.Empty(t, ops)
+	assert.Equal(t, log.Offset(), offset)
+
+	follower := NewCollectionManager()
+	require.NoError(t, follower.Restore(snapshot))
+	assert.Equal(t, "value1", follower.GetDefaultCollection().Get("key1"))
+
+	leader.AddCollection("users")
+	users, _ := leader.GetCollection("users")
+	users.Set("alice", "1")
+	_, err = leader.GetDefaultCollection().LPop("list", 1)
+	require.NoError(t, err)
+	leader.GetDefaultCollection().Delete("key1")
+
+	applyReplicated(t, follower, replica, offset, log.Offset())
+	assert.Equal(t, "", follower.GetDefaultCollection().Get("key1"))
+	elements, err := follower.GetDefaultCollection().LRange("list", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, elements)
+	followerUsers, exists := follower.GetCollection("users")
+	require.True(t, exists)
+	assert.Equal(t, "1", followerUsers.Get("alice"))
+}
+
+func TestReplicationLog_PartialSync(t *testing.T) {
+	leader, log := newReplicatedCollectionManager(4)
+	replica, snapshot, _, offset := log.Follow(leader, "follower", "", 0)
+	require.NotNil(t, snapshot)
+	follower := NewCollectionManager()
+	require.NoError(t, follower.Restore(snapshot))
+	leader.GetDefaultCollection().Set("key1", "value1")
+	offset = applyReplicated(t, follower, replica, offset, log.Offset())
+	replica.Close()
+
+	// The follower reconnects after missing a few operations
+	leader.GetDefaultCollection().Set("key2", "value2")
+	leader.GetDefaultCollection().Set("key3", "value3")
+	replica, snapshot, ops, resumed := log.Follow(leader, "follower", log.ID(), offset)
+	assert.Nil(t, snapshot, "Expected the missed operations to be taken from the backlog")
+	assert.Equal(t, offset, resumed)
+	require.Len(t, ops, 2)
+	for _, op := range ops {
+		require.NoError(t, follower.Apply(op))
+		offset = op.Seq
+	}
+	leader.GetDefaultCollection().Set("key4", "value4")
+	offset = applyReplicated(t, follower, replica, offset, log.Offset())
+	replica.Close()
+	for i := 1; i <= 4; i++ {
+		assert.Equal(t, fmt.Sprintf("value%d", i), follower.GetDefaultCollection().Get(fmt.Sprintf("key%d", i)))
+	}
+
+	for i := 0; i < 5; i++ {
+		leader.GetDefaultCollection().Set("key", "value")
+	}
+	_, snapshot, _, _ = log.Follow(leader, "follower", log.ID(), offset)
+	assert.NotNil(t, snapshot, "Expected a full sync once the backlog no longer holds the missed operations")
+	_, snapshot, _, _ = log.Follow(leader, "follower", "other", log.Offset())
+	assert.NotNil(t, snapshot, "Expected a full sync when following another log")
+	_, snapshot, _, _ = log.Follow(leader, "follower", log.ID(), log.Offset()+1)
+	assert.NotNil(t, snapshot, "Expected a full sync when ahead of the log")
+}
+
+func TestReplicationLog_Journal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+	cm, aof := openTestAppendLog(t, path)
+	defer aof.Close()
+	log := NewReplicationLog(aof, 0)
+	cm.SetJournal(log)
+
+	cm.GetDefaultCollection().Set("key1", "value1")
+	cm.GetDefaultCollection().Set("key2", "value2")
+	assert.Equal(t, uint64(2), log.Offset())
+	assert.Equal(t, uint64(2), aof.LastSeq(), "Expected the operations to be forwarded to the journal")
+	assert.Equal(t, aof.LastSeq(), cm.Snapshot().Seq, "Expected snapshots to be numbered by the journal")
+}
+
+func TestReplicationLog_SlowFollower(t *testing.T) {
+	leader, log := newReplicatedCollectionManager(0)
+	replica, _, _, _ := log.Follow(leader, "follower", "", 0)
+
+	for i := 0; i < REPLICA_BUFFER_SIZE/2; i++ {
+		leader.GetDefaultCollection().Set("key", "value")
+	}
+	status := log.Replicas()
+	require.Len(t, status, 1)
+	assert.Equal(t, "follower", status[0].Name)
+	assert.Equal(t, uint64(REPLICA_BUFFER_SIZE/2), status[0].Lag)
+
+	for i := 0; i <= REPLICA_BUFFER_SIZE/2; i++ {
+		leader.GetDefaultCollection().Set("key", "value")
+	}
+	<-replica.Done()
+	assert.ErrorIs(t, replica.Err(), ErrReplicaTooSlow)
+	assert.Empty(t, log.Replicas())
+
+	log.Close()
+	replica, _, _, _ = log.Follow(leader, "follower", "", 0)
+	<-replica.Done()
+	assert.ErrorIs(t, replica.Err(), ErrReplicationClosed)
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"strings"
+
+	"github.com/dmarro89/dare-db/utils"
+)
+
+// DEFAULT_SCAN_COUNT is the number of keys returned by Scan when no count is given.
+const DEFAULT_SCAN_COUNT = 10
+
+// SCAN_MIN_BUCKETS is the smallest size of the key buckets scanned by Scan.
+const SCAN_MIN_BUCKETS = 16
+
+// ScanOptions filters the keys returned by Scan.
+type ScanOptions struct {
+	// Count is a hint of the number of keys examined by a call
+	Count int
+	// Match is a glob pattern the keys must match
+	Match string
+	// Prefix is a prefix the keys must start with
+	Prefix string
+}
+
+func (options ScanOptions) matches(key string) bool {
+	if options.Prefix != "" && !strings.HasPrefix(key, options.Prefix) {
+		return false
+	}
+	return options.Match == "" || utils.GlobMatch(options.Match, key)
+}
+
+// keyBuckets distributes the keys of a database into a power of two number
+// of buckets, which Scan visits in reverse binary order of their index, as
+// Redis does: a bucket split or merged by a resize between two calls is then
+// either entirely visited or entirely left to visit.
+type keyBuckets struct {
+	seed    maphash.Seed
+	buckets [][]string
+	count   int
+}
+
+func newKeyBuckets() *keyBuckets {
+	return &keyBuckets{
+		seed:    maphash.MakeSeed(),
+		buckets: make([][]string, SCAN_MIN_BUCKETS),
+	}
+}
+
+func (kb *keyBuckets) index(key string, size int) uint64 {
+	return maphash.String(kb.seed, key) & uint64(size-1)
+}
+
+func (kb *keyBuckets) add(key string) {
+	i := kb.index(key, len(kb.buckets))
+	kb.buckets[i] = append(kb.buckets[i], key)
+	kb.count++
+	if kb.count > 2*len(kb.buckets) {
+		kb.resize(2 * len(kb.buckets))
+	}
+}
+
+func (kb *keyBuckets) remove(key string) {
+	i := kb.index(key, len(kb.buckets))
+	bucket := kb.buckets[i]
+	for j, candidate := range bucket {
+		if candidate == key {
+			bucket[j] = bucket[len(bucket)-1]
+			bucket[len(bucket)-1] = ""
+			kb.buckets[i] = bucket[:len(bucket)-1]
+			kb.count--
+			break
+		}
+	}
+	if len(kb.buckets) > SCAN_MIN_BUCKETS && kb.count < len(kb.buckets)/8 {
+		kb.resize(len(kb.buckets) / 2)
+	}
+}
+
+func (kb *keyBuckets) resize(size int) {
+	buckets := make([][]string, size)
+	for _, bucket := range kb.buckets {
+		for _, key := range bucket {
+			i := kb.index(key, size)
+			buckets[i] = append(buckets[i], key)
+		}
+	}
+	kb.buckets = buckets
+}
+
+// next returns the cursor following cursor, incrementing its reversed bits
+// under the mask of the current size. It returns zero once every bucket was visited.
+func (kb *keyBuckets) next(cursor uint64) uint64 {
+	mask := uint64(len(kb.buckets) - 1)
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// Scan returns a batch of items of the database starting at cursor, zero to
+// start a new scan, and the cursor of the next batch, zero when the scan is
+// complete. Every key present from the start to the end of a scan is
+// returned at least once; keys added or removed meanwhile may or may not be.
+// The cursor is opaque and only valid for this database.
+//
+// The stripes are scanned one after the other, the low bits of the cursor
+// holding the index of the current stripe and the high bits its bucket
+// cursor, so that a batch only holds the lock of one stripe at a time.
+func (db *Database) Scan(cursor uint64, options ScanOptions) (map[string]string, uint64) {
+	if options.Count <= 0 {
+		options.Count = DEFAULT_SCAN_COUNT
+	}
+
+	items := make(map[string]string)
+	stripeBits := bits.Len(uint(len(db.stripes) - 1))
+	index := int(cursor & (1<<stripeBits - 1))
+	cursor >>= stripeBits
+	if index >= len(db.stripes) {
+		return items, 0
+	}
+
+	now := nowMillis()
+	examined := 0
+	for {
+		s := db.stripes[index]
+		s.mu.RLock()
+		mask := uint64(len(s.keys.buckets) - 1)
+		for {
+			for _, key := range s.keys.buckets[cursor&mask] {
+				examined++
+				if db.isExpired(key, now) || !options.matches(key) {
+					continue
+				}
+				items[key] = db.renderValue(key)
+			}
+
+			cursor = s.keys.next(cursor)
+			if cursor == 0 || examined >= options.Count {
+				break
+			}
+		}
+		s.mu.RUnlock()
+
+		if cursor == 0 {
+			index++
+			if index == len(db.stripes) {
+				return items, 0
+			}
+		}
+		if examined >= options.Count {
+			return items, cursor<<stripeBits | uint64(index)
+		}
+	}
+}
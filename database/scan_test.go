@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scanAll(db *Database, options ScanOptions, between func(calls int)) map[string]string {
+	items := make(map[string]string)
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		batch, next := db.Scan(cursor, options)
+		for key, value := range batch {
+			items[key] = value
+		}
+		if next == 0 {
+			return items
+		}
+		cursor = next
+		if between != nil {
+			between(calls)
+		}
+	}
+}
+
+func TestDatabase_Scan(t *testing.T) {
+	db := NewDatabase()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	items := scanAll(db, ScanOptions{Count: 7}, nil)
+	assert.Len(t, items, 1000)
+	assert.Equal(t, "value42", items["key42"])
+
+	batch, cursor := db.Scan(0, ScanOptions{})
+	assert.NotZero(t, cursor)
+	assert.Less(t, len(batch), 1000)
+}
+
+func TestDatabase_Scan_Match(t *testing.T) {
+	db := NewDatabase()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("user:%d", i), "value"))
+		require.NoError(t, db.Set(fmt.Sprintf("book:%d", i), "value"))
+	}
+
+	items := scanAll(db, ScanOptions{Prefix: "user:"}, nil)
+	assert.Len(t, items, 100)
+	items = scanAll(db, ScanOptions{Match: "book:?"}, nil)
+	assert.Len(t, items, 10)
+	items = scanAll(db, ScanOptions{Prefix: "book:", Match: "*5"}, nil)
+	assert.Len(t, items, 10)
+}
+
+func TestDatabase_Scan_WhileGrowing(t *testing.T) {
+	db := NewDatabaseWithStripes(1)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), "value"))
+	}
+
+	added := 0
+	items := scanAll(db, ScanOptions{Count: 5}, func(calls int) {
+		for i := 0; i < 50 && added < 1000; i++ {
+			db.Set(fmt.Sprintf("new%d", added), "value")
+			added++
+		}
+	})
+
+	for i := 0; i < 100; i++ {
+		assert.Contains(t, items, fmt.Sprintf("key%d", i))
+	}
+	assert.Greater(t, len(db.stripes[0].keys.buckets), SCAN_MIN_BUCKETS)
+}
+
+func TestDatabase_Scan_WhileShrinking(t *testing.T) {
+	db := NewDatabaseWithStripes(1)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("removed%d", i), "value"))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), "value"))
+	}
+	buckets := len(db.stripes[0].keys.buckets)
+
+	removed := 0
+	items := scanAll(db, ScanOptions{Count: 3}, func(calls int) {
+		for i := 0; i < 100 && removed < 1000; i++ {
+			db.Delete(fmt.Sprintf("removed%d", removed))
+			removed++
+		}
+	})
+
+	for i := 0; i < 20; i++ {
+		assert.Contains(t, items, fmt.Sprintf("key%d", i))
+	}
+	assert.Less(t, len(db.stripes[0].keys.buckets), buckets)
+}
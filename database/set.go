@@ -0,0 +1,255 @@
+package database
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// SET_MEMBER_OVERHEAD approximates the memory used by a set member on top of its bytes.
+const SET_MEMBER_OVERHEAD int64 = 48
+
+// set is a set value, an unordered collection of unique members.
+type set struct {
+	members map[string]struct{}
+	bytes   int64
+}
+
+func newSet() *set {
+	return &set{members: make(map[string]struct{})}
+}
+
+func unmarshalSet(data string) (typedValue, error) {
+	var members []string
+	if err := json.Unmarshal([]byte(data), &members); err != nil {
+		return nil, err
+	}
+	s := newSet()
+	for _, member := range members {
+		s.add(member)
+	}
+	return s, nil
+}
+
+func (s *set) valueType() ValueType {
+	return TYPE_SET
+}
+
+func (s *set) size() int64 {
+	return s.bytes + int64(len(s.members))*SET_MEMBER_OVERHEAD
+}
+
+func (s *set) len() int {
+	return len(s.members)
+}
+
+func (s *set) marshal() (string, error) {
+	data, err := json.Marshal(s.sorted())
+	return string(data), err
+}
+
+func (s *set) add(member string) bool {
+	if _, ok := s.members[member]; ok {
+		return false
+	}
+	s.members[member] = struct{}{}
+	s.bytes += int64(len(member))
+	return true
+}
+
+func (s *set) remove(member string) bool {
+	if _, ok := s.members[member]; !ok {
+		return false
+	}
+	delete(s.members, member)
+	s.bytes -= int64(len(member))
+	return true
+}
+
+func (s *set) contains(member string) bool {
+	_, ok := s.members[member]
+	return ok
+}
+
+// sorted returns the members in lexicographic order.
+func (s *set) sorted() []string {
+	members := make([]string, 0, len(s.members))
+	for member := range s.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// SAdd adds the members to the set stored under key, creating the set if
+// needed. It returns the number of members that were not already in the set.
+func (db *Database) SAdd(key string, members ...string) (int, error) {
+	if err := db.reserveElements(key, members, SET_MEMBER_OVERHEAD); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, version, err := db.addSetMembers(key, members, 0)
+	if err != nil || added == 0 {
+		return 0, err
+	}
+	return added, db.record(Operation{Type: OP_SADD, Key: key, Args: members, Version: version})
+}
+
+// SRem removes the members from the set stored under key, deleting the key
+// once the set is empty. It returns the number of members removed.
+func (db *Database) SRem(key string, members ...string) (int, error) {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, version, err := db.removeSetMembers(key, members, 0)
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	return removed, db.record(Operation{Type: OP_SREM, Key: key, Args: members, Version: version})
+}
+
+// SMembers returns the members of the set stored under key in lexicographic
+// order. A missing key is an empty set.
+func (db *Database) SMembers(key string) ([]string, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := db.typedValueOf(key, TYPE_SET, nowMillis())
+	if value == nil {
+		return []string{}, err
+	}
+	return value.(*set).sorted(), nil
+}
+
+// SIsMember reports whether member is in the set stored under key.
+func (db *Database) SIsMember(key string, member string) (bool, error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := db.typedValueOf(key, TYPE_SET, nowMillis())
+	if value == nil {
+		return false, err
+	}
+	return value.(*set).contains(member), nil
+}
+
+// SInter returns, in lexicographic order, the members of every set stored
+// under keys. Missing keys are empty sets.
+func (db *Database) SInter(keys ...string) ([]string, error) {
+	return db.combineSets(keys, func(member string, sets []*set) bool {
+		for _, s := range sets[1:] {
+			if s == nil || !s.contains(member) {
+				return false
+			}
+		}
+		return true
+	}, false)
+}
+
+// SUnion returns, in lexicographic order, the members of any set stored under keys.
+func (db *Database) SUnion(keys ...string) ([]string, error) {
+	return db.combineSets(keys, func(string, []*set) bool { return true }, true)
+}
+
+// SDiff returns, in lexicographic order, the members of the set stored
+// under the first key that are in none of the sets stored under the others.
+func (db *Database) SDiff(keys ...string) ([]string, error) {
+	return db.combineSets(keys, func(member string, sets []*set) bool {
+		for _, s := range sets[1:] {
+			if s != nil && s.contains(member) {
+				return false
+			}
+		}
+		return true
+	}, false)
+}
+
+// combineSets returns the members kept by keep, considering the members of
+// the first set, or of all sets if all is true.
+func (db *Database) combineSets(keys []string, keep func(member string, sets []*set) bool, all bool) ([]string, error) {
+	unlock := db.rlockKeys(keys)
+	defer unlock()
+
+	now := nowMillis()
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		value, err := db.typedValueOf(key, TYPE_SET, now)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			sets[i] = value.(*set)
+		}
+	}
+
+	result := newSet()
+	for i, s := range sets {
+		if s == nil || (i > 0 && !all) {
+			continue
+		}
+		for member := range s.members {
+			if keep(member, sets) {
+				result.add(member)
+			}
+		}
+	}
+	return result.sorted(), nil
+}
+
+// addSetMembers adds the members to the set stored under key with the given
+// version, zero for the next one. It returns the number of members added and
+// the version of the key. The caller must hold the write lock of the stripe
+// of key.
+func (db *Database) addSetMembers(key string, members []string, version uint64) (int, uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_SET, nowMillis())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var expiresAt int64
+	s, _ := value.(*set)
+	if s == nil {
+		s = newSet()
+	} else {
+		expiresAt = db.expiresAt(key)
+	}
+
+	added := 0
+	for _, member := range members {
+		if s.add(member) {
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, 0, nil
+	}
+	return added, db.setTyped(key, s, expiresAt, version), nil
+}
+
+// removeSetMembers removes the members from the set stored under key with the
+// given version, zero for the next one. The caller must hold the write lock
+// of the stripe of key.
+func (db *Database) removeSetMembers(key string, members []string, version uint64) (int, uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_SET, nowMillis())
+	if value == nil {
+		return 0, 0, err
+	}
+
+	s := value.(*set)
+	removed := 0
+	for _, member := range members {
+		if s.remove(member) {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, 0, nil
+	}
+	return removed, db.setTyped(key, s, db.expiresAt(key), version), nil
+}
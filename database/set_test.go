@@ -0,0 +1,100 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Set(t *testing.T) {
+	db := NewDatabase()
+	added, err := db.SAdd("tags:1", "go", "db", "go")
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	added, err = db.SAdd("tags:1", "db")
+	require.NoError(t, err)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, TYPE_SET, db.Type("tags:1"))
+
+	_, err = db.SAdd("tags:2", "go", "cache")
+	require.NoError(t, err)
+
+	members, err := db.SMembers("tags:1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db", "go"}, members)
+
+	isMember, err := db.SIsMember("tags:1", "go")
+	require.NoError(t, err)
+	assert.True(t, isMember)
+	isMember, err = db.SIsMember("missing", "go")
+	require.NoError(t, err)
+	assert.False(t, isMember)
+
+	members, err = db.SInter("tags:1", "tags:2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go"}, members)
+	members, err = db.SUnion("tags:1", "tags:2", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cache", "db", "go"}, members)
+	members, err = db.SDiff("tags:1", "tags:2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db"}, members)
+	members, err = db.SInter("tags:1", "missing")
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	removed, err := db.SRem("tags:1", "go", "db", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.False(t, db.Exists("tags:1"))
+
+	require.NoError(t, db.Set("string", "value"))
+	_, err = db.SInter("tags:2", "string")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = db.SAdd("string", "member")
+	assert.ErrorIs(t, err, ErrWrongType)
+}
+
+func TestSet_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	db := cm.GetDefaultCollection()
+	_, err := db.SAdd("tags", "a", "b", "c")
+	require.NoError(t, err)
+	_, err = db.SRem("tags", "b")
+	require.NoError(t, err)
+	_, err = db.ZAdd("board", ScoredMember{"alice", 10}, ScoredMember{"bob", 5})
+	require.NoError(t, err)
+	_, err = db.ZIncrBy("board", "bob", 7.5)
+	require.NoError(t, err)
+	_, err = db.ZRem("board", "alice")
+	require.NoError(t, err)
+	_, err = db.ZAdd("board", ScoredMember{"carol", 1})
+	require.NoError(t, err)
+
+	check := func(db *Database) {
+		members, err := db.SMembers("tags")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, members)
+		scored, err := db.ZRange("board", 0, -1, false)
+		require.NoError(t, err)
+		assert.Equal(t, []ScoredMember{{"carol", 1}, {"bob", 12.5}}, scored)
+	}
+
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(cm.Snapshot()))
+	check(restored.GetDefaultCollection())
+
+	require.NoError(t, aof.Close())
+	replayed, replayedLog := openTestAppendLog(t, path)
+	check(replayed.GetDefaultCollection())
+	require.NoError(t, replayedLog.Rewrite())
+	require.NoError(t, replayedLog.Close())
+
+	rewritten, rewrittenLog := openTestAppendLog(t, path)
+	defer rewrittenLog.Close()
+	check(rewritten.GetDefaultCollection())
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"math/rand"
+)
+
+const SKIPLIST_MAX_LEVEL = 32
+const SKIPLIST_P = 0.25
+
+type skiplistNode struct {
+	key      string
+	backward *skiplistNode
+	forward  []*skiplistNode
+}
+
+// skiplist keeps keys in lexicographic order. Only the first level is
+// doubly linked, for descending iteration.
+type skiplist struct {
+	head   *skiplistNode
+	tail   *skiplistNode
+	level  int
+	length int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skiplistNode{forward: make([]*skiplistNode, SKIPLIST_MAX_LEVEL)},
+		level: 1,
+	}
+}
+
+func randomSkiplistLevel() int {
+	level := 1
+	for level < SKIPLIST_MAX_LEVEL && rand.Float64() < SKIPLIST_P {
+		level++
+	}
+	return level
+}
+
+// path returns, for every level, the last node with a key lower than key.
+func (sl *skiplist) path(key string) [SKIPLIST_MAX_LEVEL]*skiplistNode {
+	var update [SKIPLIST_MAX_LEVEL]*skiplistNode
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.forward[i] != nil && node.forward[i].key < key {
+			node = node.forward[i]
+		}
+		update[i] = node
+	}
+	return update
+}
+
+func (sl *skiplist) insert(key string) {
+	update := sl.path(key)
+	if next := update[0].forward[0]; next != nil && next.key == key {
+		return
+	}
+
+	level := randomSkiplistLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+		}
+		sl.level = level
+	}
+
+	node := &skiplistNode{key: key, forward: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		node.forward[i] = update[i].forward[i]
+		update[i].forward[i] = node
+	}
+	if update[0] != sl.head {
+		node.backward = update[0]
+	}
+	if node.forward[0] != nil {
+		node.forward[0].backward = node
+	} else {
+		sl.tail = node
+	}
+	sl.length++
+}
+
+func (sl *skiplist) delete(key string) {
+	update := sl.path(key)
+	node := update[0].forward[0]
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].forward[i] == node {
+			update[i].forward[i] = node.forward[i]
+		}
+	}
+	if node.forward[0] != nil {
+		node.forward[0].backward = node.backward
+	} else {
+		sl.tail = node.backward
+	}
+	for sl.level > 1 && sl.head.forward[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// seekGE returns the first node with a key greater or equal to key.
+func (sl *skiplist) seekGE(key string) *skiplistNode {
+	return sl.path(key)[0].forward[0]
+}
+
+// seekLT returns the last node with a key lower than key.
+func (sl *skiplist) seekLT(key string) *skiplistNode {
+	node := sl.path(key)[0]
+	if node == sl.head {
+		return nil
+	}
+	return node
+}
@@ -0,0 +1,130 @@
+package database
+
+import "strings"
+
+// HASH_SLOTS is the number of hash slots partitioning the keys of a sharded cluster.
+const HASH_SLOTS = 16384
+
+// KeySlot returns the hash slot of the key, the CRC16 of the key modulo
+// HASH_SLOTS as in Redis Cluster. When the key holds a non-empty hash tag,
+// the part between the first { and the following }, only the tag is hashed:
+// keys sharing a tag, such as {user1}.name and {user1}.email, belong to the
+// same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % HASH_SLOTS
+}
+
+// crc16 computes the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ExportSlots copies the keys of every collection belonging to one of the
+// slots selected by inSlots. Every collection is listed, even without such
+// keys, so that importing the copy creates the collections.
+func (cm *CollectionManager) ExportSlots(inSlots func(slot int) bool) *Snapshot {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	snapshot := &Snapshot{Collections: make(map[string][]SnapshotEntry, len(cm.collections))}
+	for name, db := range cm.collections {
+		db.rlockAll()
+		snapshot.Collections[name] = db.slotEntries(inSlots)
+		if db.index != nil {
+			snapshot.Indexes = append(snapshot.Indexes, name)
+		}
+		db.runlockAll()
+	}
+	return snapshot
+}
+
+// Import stores the keys of the snapshot into the collections, creating the
+// missing ones, and replacing the keys already stored. Unlike Restore, the
+// other keys are kept. Every key is journaled.
+func (cm *CollectionManager) Import(snapshot *Snapshot) error {
+	indexes := make(map[string]bool, len(snapshot.Indexes))
+	for _, name := range snapshot.Indexes {
+		indexes[name] = true
+	}
+
+	for name, entries := range snapshot.Collections {
+		db, exists := cm.GetCollection(name)
+		if !exists {
+			cm.AddCollection(name)
+			db, _ = cm.GetCollection(name)
+		}
+		if indexes[name] {
+			if err := db.apply(Operation{Type: OP_CREATE_INDEX}); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			op := Operation{Type: OP_SET, Key: entry.Key, Value: entry.Value, ValueType: entry.Type, ExpiresAt: entry.ExpiresAt, Version: entry.Version}
+			if err := db.apply(op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteSlots deletes from every collection the keys belonging to one of the
+// slots selected by inSlots, and returns the number of keys deleted.
+func (cm *CollectionManager) DeleteSlots(inSlots func(slot int) bool) int {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	deleted := 0
+	for _, db := range cm.collections {
+		for _, s := range db.stripes {
+			s.mu.Lock()
+			for key := range s.meta {
+				if !inSlots(KeySlot(key)) {
+					continue
+				}
+				if db.remove(key) {
+					deleted++
+					db.record(Operation{Type: OP_DELETE, Key: key})
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+	return deleted
+}
+
+// slotEntries returns the content of the keys belonging to one of the slots
+// selected by inSlots. The caller must hold the lock of every stripe.
+func (db *Database) slotEntries(inSlots func(slot int) bool) []SnapshotEntry {
+	entries := []SnapshotEntry{}
+	now := nowMillis()
+	for _, s := range db.stripes {
+		for key, meta := range s.meta {
+			if !inSlots(KeySlot(key)) || db.isExpired(key, now) {
+				continue
+			}
+			entry := SnapshotEntry{Key: key, Value: db.renderValue(key), ExpiresAt: s.expires[key], Version: meta.version}
+			if value, typed := s.values[key]; typed {
+				entry.Type = value.valueType()
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySlot(t *testing.T) {
+	// Slots computed by Redis Cluster
+	assert.Equal(t, 12739, KeySlot("123456789"))
+	assert.Equal(t, 12182, KeySlot("foo"))
+	assert.Equal(t, 5061, KeySlot("bar"))
+
+	assert.Equal(t, KeySlot("user1000"), KeySlot("{user1000}.following"))
+	assert.Equal(t, KeySlot("{user1000}.followers"), KeySlot("{user1000}.following"))
+	// Empty tags and unclosed braces hash the whole key
+	assert.Equal(t, int(crc16("foo{}{bar}"))%HASH_SLOTS, KeySlot("foo{}{bar}"))
+	assert.Equal(t, int(crc16("foo{bar"))%HASH_SLOTS, KeySlot("foo{bar"))
+	assert.Equal(t, KeySlot("bar"), KeySlot("foo{bar}{zap}"))
+}
+
+func TestCollectionManager_ExportImportAndDeleteSlots(t *testing.T) {
+	source := NewCollectionManager()
+	source.AddCollection(DEFAULT_COLLECTION)
+	source.AddCollection("users")
+	require.NoError(t, source.GetDefaultCollection().SetWithTTL("{a}.string", "value", time.Hour))
+	_, err := source.GetDefaultCollection().RPush("{a}.list", "x", "y")
+	require.NoError(t, err)
+	require.NoError(t, source.GetDefaultCollection().Set("{b}.string", "other"))
+	users, _ := source.GetCollection("users")
+	require.NoError(t, users.CreateOrderedIndex())
+	require.NoError(t, users.Set("{a}.name", "alice"))
+	version := users.Version("{a}.name")
+
+	slot := KeySlot("a")
+	inSlot := func(candidate int) bool { return candidate == slot }
+	snapshot := source.ExportSlots(inSlot)
+	assert.Len(t, snapshot.Collections[DEFAULT_COLLECTION], 2)
+	assert.Len(t, snapshot.Collections["users"], 1)
+	assert.Equal(t, []string{"users"}, snapshot.Indexes)
+
+	target := NewCollectionManager()
+	target.AddCollection(DEFAULT_COLLECTION)
+	require.NoError(t, target.GetDefaultCollection().Set("{c}.string", "kept"))
+	require.NoError(t, target.Import(snapshot))
+	assert.Equal(t, "value", target.GetDefaultCollection().Get("{a}.string"))
+	ttl, err := target.GetDefaultCollection().TTL("{a}.string")
+	require.NoError(t, err)
+	assert.Positive(t, ttl)
+	elements, err := target.GetDefaultCollection().LRange("{a}.list", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, elements)
+	assert.Equal(t, "kept", target.GetDefaultCollection().Get("{c}.string"))
+	assert.Empty(t, target.GetDefaultCollection().Get("{b}.string"))
+	importedUsers, exists := target.GetCollection("users")
+	require.True(t, exists)
+	assert.Equal(t, version, importedUsers.Version("{a}.name"), "Expected the versions to be kept")
+	items, _, err := importedUsers.Range(RangeOptions{})
+	require.NoError(t, err, "Expected the ordered index to be created")
+	assert.Equal(t, []Item{{Key: "{a}.name", Value: "alice"}}, items)
+
+	assert.Equal(t, 3, source.DeleteSlots(inSlot))
+	assert.Empty(t, source.GetDefaultCollection().Get("{a}.string"))
+	assert.Equal(t, "other", source.GetDefaultCollection().Get("{b}.string"))
+	assert.Empty(t, users.Get("{a}.name"))
+}
@@ -0,0 +1,339 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmarro89/dare-db/logger"
+)
+
+const SNAPSHOT_FILE_PREFIX = "snapshot-"
+const SNAPSHOT_FILE_EXT = ".json"
+const DEFAULT_SNAPSHOT_RETENTION = 3
+
+type SnapshotEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Type is the type of the value, encoded as JSON, empty for strings
+	Type      ValueType `json:"type,omitempty"`
+	ExpiresAt int64     `json:"expires_at,omitempty"`
+	Version   uint64    `json:"version,omitempty"`
+}
+
+// Snapshot is a point-in-time copy of every collection held by a CollectionManager.
+type Snapshot struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Seq is the sequence number of the last journaled operation included in the snapshot.
+	Seq         uint64                     `json:"seq"`
+	Collections map[string][]SnapshotEntry `json:"collections"`
+	// Indexes lists the collections with an ordered index
+	Indexes []string `json:"indexes,omitempty"`
+}
+
+// Snapshot copies all collections while holding every collection read lock,
+// so no write can interleave with the copy.
+func (cm *CollectionManager) Snapshot() *Snapshot {
+	return cm.snapshot(nil)
+}
+
+// snapshot copies all collections, calling during, unless nil, while no
+// write can run.
+func (cm *CollectionManager) snapshot(during func()) *Snapshot {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	for _, db := range cm.collections {
+		db.rlockAll()
+	}
+	defer func() {
+		for _, db := range cm.collections {
+			db.runlockAll()
+		}
+	}()
+
+	snapshot := &Snapshot{
+		CreatedAt:   time.Now().UTC(),
+		Collections: make(map[string][]SnapshotEntry, len(cm.collections)),
+	}
+	if cm.journal != nil {
+		snapshot.Seq = cm.journal.LastSeq()
+	}
+	if during != nil {
+		during()
+	}
+	for name, db := range cm.collections {
+		snapshot.Collections[name] = db.entries()
+		if db.index != nil {
+			snapshot.Indexes = append(snapshot.Indexes, name)
+		}
+	}
+	return snapshot
+}
+
+// Restore replaces all collections with the content of the given snapshot.
+func (cm *CollectionManager) Restore(snapshot *Snapshot) error {
+	collections := make(map[string]*Database, len(snapshot.Collections))
+	for name, entries := range snapshot.Collections {
+		db := NewDatabaseWithStripes(cm.stripes)
+		db.name = name
+		db.manager = cm
+		for _, entry := range entries {
+			if err := db.restoreEntry(entry); err != nil {
+				return fmt.Errorf("failed to restore key %q in collection %q: %w", entry.Key, name, err)
+			}
+		}
+		collections[name] = db
+	}
+	for _, name := range snapshot.Indexes {
+		if db, exists := collections[name]; exists {
+			db.buildOrderedIndex()
+		}
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, db := range collections {
+		db.journal = cm.journal
+	}
+	cm.collections = collections
+	return nil
+}
+
+// entries returns the content of the database. The caller must hold the lock
+// of every stripe.
+func (db *Database) entries() []SnapshotEntry {
+	entries := []SnapshotEntry{}
+	now := nowMillis()
+	for _, s := range db.stripes {
+		for key, value := range s.dict.GetAllItems() {
+			if db.isExpired(key, now) {
+				continue
+			}
+			entries = append(entries, SnapshotEntry{Key: key, Value: value, ExpiresAt: s.expires[key], Version: s.meta[key].version})
+		}
+		for key, value := range s.values {
+			if db.isExpired(key, now) {
+				continue
+			}
+			entries = append(entries, SnapshotEntry{Key: key, Value: db.renderValue(key), Type: value.valueType(), ExpiresAt: s.expires[key], Version: s.meta[key].version})
+		}
+	}
+	return entries
+}
+
+// restoreEntry stores the key of a snapshot entry. The caller must hold the
+// write lock of the stripe of the key or own the database.
+func (db *Database) restoreEntry(entry SnapshotEntry) error {
+	if entry.Type == "" || entry.Type == TYPE_STRING {
+		_, err := db.set(entry.Key, entry.Value, entry.ExpiresAt, entry.Version)
+		return err
+	}
+
+	value, err := unmarshalTypedValue(entry.Type, entry.Value)
+	if err != nil {
+		return err
+	}
+	db.setTyped(entry.Key, value, entry.ExpiresAt, entry.Version)
+	return nil
+}
+
+// Snapshotter writes snapshots of a CollectionManager into a directory,
+// either on demand or periodically, and keeps only the most recent ones.
+type Snapshotter struct {
+	collectionManager *CollectionManager
+	dir               string
+	retention         int
+	logger            logger.Logger
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSnapshotter(collectionManager *CollectionManager, dir string, retention int) *Snapshotter {
+	if retention <= 0 {
+		retention = DEFAULT_SNAPSHOT_RETENTION
+	}
+	return &Snapshotter{
+		collectionManager: collectionManager,
+		dir:               dir,
+		retention:         retention,
+		logger:            logger.NewDareLogger(),
+	}
+}
+
+// Save writes a new snapshot atomically: the data is written to a temporary
+// file, synced and then renamed into place. It returns the snapshot path.
+func (s *Snapshotter) Save() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	snapshot := s.collectionManager.Snapshot()
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", SNAPSHOT_FILE_PREFIX, snapshot.CreatedAt.UnixNano(), SNAPSHOT_FILE_EXT))
+
+	if err := writeFileAtomic(path, func(file *os.File) error {
+		return json.NewEncoder(file).Encode(snapshot)
+	}); err != nil {
+		return "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		s.logger.Error("Error removing old snapshots: ", err)
+	}
+
+	return path, nil
+}
+
+// LoadLatest restores the most recent snapshot found in the directory.
+// It returns nil if there is no snapshot to load.
+func (s *Snapshotter) LoadLatest() (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	path := files[len(files)-1]
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(file).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+
+	if err := s.collectionManager.Restore(&snapshot); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Loaded snapshot: ", path)
+	return &snapshot, nil
+}
+
+// Start saves a snapshot every interval until Stop is called.
+func (s *Snapshotter) Start(interval time.Duration) {
+	if interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if path, err := s.Save(); err != nil {
+					s.logger.Error("Error saving snapshot: ", err)
+				} else {
+					s.logger.Debug("Saved snapshot: ", path)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the periodic snapshots started by Start.
+func (s *Snapshotter) Stop() {
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+	s.done = nil
+}
+
+// list returns the snapshot files in the directory, oldest first.
+func (s *Snapshotter) list() ([]string, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, SNAPSHOT_FILE_PREFIX) || !strings.HasSuffix(name, SNAPSHOT_FILE_EXT) {
+			continue
+		}
+		files = append(files, filepath.Join(s.dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *Snapshotter) prune() error {
+	files, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for len(files) > s.retention {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// writeFileAtomic writes path through a temporary file in the same directory,
+// so readers either see the previous content or the complete new one.
+func writeFileAtomic(path string, write func(*os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionManager_SnapshotAndRestore(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.AddCollection("collection1")
+	cm.GetDefaultCollection().Set("key1", "value1")
+	collection, _ := cm.GetCollection("collection1")
+	collection.Set("key2", "value2")
+
+	snapshot := cm.Snapshot()
+	assert.Len(t, snapshot.Collections, 2)
+	assert.ElementsMatch(t, []SnapshotEntry{{Key: "key2", Value: "value2", Version: 1}}, snapshot.Collections["collection1"])
+
+	restored := NewCollectionManager()
+	require.NoError(t, restored.Restore(snapshot))
+	assert.ElementsMatch(t, []string{DEFAULT_COLLECTION, "collection1"}, restored.GetCollectionNames())
+	assert.Equal(t, "value1", restored.GetDefaultCollection().Get("key1"))
+	collection, _ = restored.GetCollection("collection1")
+	assert.Equal(t, "value2", collection.Get("key2"))
+	assert.Equal(t, uint64(1), collection.Version("key2"))
+}
+
+func TestSnapshotter_SaveAndLoadLatest(t *testing.T) {
+	dir := t.TempDir()
+
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.GetDefaultCollection().Set("key1", "value1")
+
+	snapshotter := NewSnapshotter(cm, dir, 2)
+	_, err := snapshotter.Save()
+	require.NoError(t, err)
+
+	cm.GetDefaultCollection().Set("key1", "value2")
+	path, err := snapshotter.Save()
+	require.NoError(t, err)
+	assert.FileExists(t, path)
+
+	loaded := NewCollectionManager()
+	snapshot, err := NewSnapshotter(loaded, dir, 2).LoadLatest()
+	require.NoError(t, err)
+	assert.NotNil(t, snapshot)
+	assert.Equal(t, "value2", loaded.GetDefaultCollection().Get("key1"))
+}
+
+func TestSnapshotter_Retention(t *testing.T) {
+	dir := t.TempDir()
+
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	snapshotter := NewSnapshotter(cm, dir, 2)
+
+	for i := 0; i < 5; i++ {
+		_, err := snapshotter.Save()
+		require.NoError(t, err)
+	}
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "Expected only the 2 most recent snapshots to be kept")
+}
+
+func TestSnapshotter_LoadLatestWithoutSnapshots(t *testing.T) {
+	cm := NewCollectionManager()
+	snapshot, err := NewSnapshotter(cm, t.TempDir(), 1).LoadLatest()
+	require.NoError(t, err)
+	assert.Nil(t, snapshot)
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+
+	"github.com/dmarro89/go-redis-hashtable/structure"
+)
+
+// DEFAULT_LOCK_STRIPES is the number of stripes of the databases created
+// without an explicit number.
+const DEFAULT_LOCK_STRIPES = 16
+
+// stripe holds the keys of a database hashing to it. Each stripe has its own
+// lock, so that writes on keys of different stripes run in parallel.
+type stripe struct {
+	mu   sync.RWMutex
+	dict structure.IDict
+	// values holds the keys storing a value of a type other than string
+	values map[string]typedValue
+	// expires holds the expiration time, in unix milliseconds, of the keys with a TTL
+	expires map[string]int64
+	// meta holds the memory and access statistics of every key
+	meta map[string]*keyMeta
+	// keys holds the keys in buckets scanned by Scan
+	keys *keyBuckets
+	// listWaiters holds, by key, the blocked pops to wake up on a push
+	listWaiters map[string][]chan struct{}
+}
+
+func newStripe() *stripe {
+	return &stripe{
+		dict:    structure.NewSipHashDict(),
+		values:  make(map[string]typedValue),
+		expires: make(map[string]int64),
+		meta:    make(map[string]*keyMeta),
+		keys:    newKeyBuckets(),
+	}
+}
+
+// stripeIndex returns the index of the stripe holding key.
+func (db *Database) stripeIndex(key string) int {
+	return int(maphash.String(db.seed, key) % uint64(len(db.stripes)))
+}
+
+// stripeOf returns the stripe holding key.
+func (db *Database) stripeOf(key string) *stripe {
+	return db.stripes[db.stripeIndex(key)]
+}
+
+// stripesOf returns the stripes holding the keys, in index order and
+// without duplicates, the order in which they must be locked.
+func (db *Database) stripesOf(keys []string) []*stripe {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, db.stripeIndex(key))
+	}
+	sort.Ints(indexes)
+
+	stripes := make([]*stripe, 0, len(indexes))
+	for i, index := range indexes {
+		if i == 0 || index != indexes[i-1] {
+			stripes = append(stripes, db.stripes[index])
+		}
+	}
+	return stripes
+}
+
+// lockKeys write locks the stripes holding the keys and returns the function
+// unlocking them.
+func (db *Database) lockKeys(keys []string) func() {
+	stripes := db.stripesOf(keys)
+	for _, s := range stripes {
+		s.mu.Lock()
+	}
+	return func() {
+		for _, s := range stripes {
+			s.mu.Unlock()
+		}
+	}
+}
+
+// rlockKeys read locks the stripes holding the keys and returns the function
+// unlocking them.
+func (db *Database) rlockKeys(keys []string) func() {
+	stripes := db.stripesOf(keys)
+	for _, s := range stripes {
+		s.mu.RLock()
+	}
+	return func() {
+		for _, s := range stripes {
+			s.mu.RUnlock()
+		}
+	}
+}
+
+// lockAll write locks every stripe, for the changes of the whole database.
+func (db *Database) lockAll() {
+	for _, s := range db.stripes {
+		s.mu.Lock()
+	}
+}
+
+func (db *Database) unlockAll() {
+	for _, s := range db.stripes {
+		s.mu.Unlock()
+	}
+}
+
+// rlockAll read locks every stripe, for a consistent view of the whole database.
+func (db *Database) rlockAll() {
+	for _, s := range db.stripes {
+		s.mu.RLock()
+	}
+}
+
+func (db *Database) runlockAll() {
+	for _, s := range db.stripes {
+		s.mu.RUnlock()
+	}
+}
+
+// restripe spreads the keys over count stripes. The database must not be in
+// use.
+func (db *Database) restripe(count int) {
+	if count <= 0 {
+		count = DEFAULT_LOCK_STRIPES
+	}
+	if count == len(db.stripes) {
+		return
+	}
+
+	previous := db.stripes
+	db.stripes = make([]*stripe, count)
+	for i := range db.stripes {
+		db.stripes[i] = newStripe()
+	}
+	for _, old := range previous {
+		for key, meta := range old.meta {
+			s := db.stripeOf(key)
+			if value, typed := old.values[key]; typed {
+				s.values[key] = value
+			} else {
+				s.dict.Set(key, old.dict.Get(key))
+			}
+			if expiresAt, ok := old.expires[key]; ok {
+				s.expires[key] = expiresAt
+			}
+			s.meta[key] = meta
+			s.keys.add(key)
+		}
+		for key, waiters := range old.listWaiters {
+			s := db.stripeOf(key)
+			if s.listWaiters == nil {
+				s.listWaiters = make(map[string][]chan struct{})
+			}
+			s.listWaiters[key] = append(s.listWaiters[key], waiters...)
+		}
+	}
+}
+
+// SetLockStripes sets the number of stripes of the collections, the keys of
+// a collection being spread over its stripes by their hash. The existing
+// collections are spread again, so it must be called before the collections
+// are used, typically on startup.
+func (cm *CollectionManager) SetLockStripes(count int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if count <= 0 {
+		count = DEFAULT_LOCK_STRIPES
+	}
+	cm.stripes = count
+	for _, db := range cm.collections {
+		db.restripe(count)
+	}
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Stripes(t *testing.T) {
+	db := NewDatabaseWithStripes(4)
+	assert.Len(t, db.stripes, 4)
+	assert.Len(t, NewDatabaseWithStripes(0).stripes, DEFAULT_LOCK_STRIPES)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.NoError(t, db.Set(fmt.Sprintf("key%d-%d", worker, i), "value"))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	assert.Len(t, db.GetAllItems(), 800)
+	assert.Len(t, scanAll(db, ScanOptions{Count: 7}, nil), 800)
+	for _, s := range db.stripes {
+		assert.NotEmpty(t, s.meta, "Expected the keys to be spread over every stripe")
+	}
+}
+
+func TestDatabase_Restripe(t *testing.T) {
+	db := NewDatabaseWithStripes(2)
+	require.NoError(t, db.Set("string", "value"))
+	require.NoError(t, db.SetWithTTL("volatile", "value", time.Hour))
+	_, err := db.SAdd("set", "a", "b")
+	require.NoError(t, err)
+	require.NoError(t, db.CreateOrderedIndex())
+	memory, version := db.UsedMemory(), db.Version("string")
+
+	db.restripe(8)
+	assert.Len(t, db.stripes, 8)
+	assert.Equal(t, "value", db.Get("string"))
+	ttl, err := db.TTL("volatile")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+	members, err := db.SMembers("set")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, members)
+	assert.Equal(t, memory, db.UsedMemory())
+	assert.Equal(t, version, db.Version("string"))
+
+	items, _, err := db.Range(RangeOptions{})
+	require.NoError(t, err)
+	assert.Len(t, items, 3)
+	assert.Len(t, scanAll(db, ScanOptions{}, nil), 3)
+}
+
+func TestCollectionManager_SetLockStripes(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	require.NoError(t, cm.GetDefaultCollection().Set("key", "value"))
+
+	cm.SetLockStripes(4)
+	assert.Len(t, cm.GetDefaultCollection().stripes, 4)
+	assert.Equal(t, "value", cm.GetDefaultCollection().Get("key"))
+
+	cm.AddCollection("other")
+	other, _ := cm.GetCollection("other")
+	assert.Len(t, other.stripes, 4)
+
+	require.NoError(t, cm.Restore(cm.Snapshot()))
+	assert.Len(t, cm.GetDefaultCollection().stripes, 4)
+	assert.Equal(t, "value", cm.GetDefaultCollection().Get("key"))
+}
+
+func TestDatabase_BLPop_AcrossStripes(t *testing.T) {
+	db := NewDatabaseWithStripes(8)
+	keys := make([]string, 0, 16)
+	for i := 0; i < 16; i++ {
+		keys = append(keys, fmt.Sprintf("queue%d", i))
+	}
+
+	var popped atomic.Int32
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, _, err := db.BLPop(context.Background(), keys, 100*time.Millisecond)
+				if err != nil {
+					return
+				}
+				popped.Add(1)
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		_, err := db.RPush(keys[i%len(keys)], "element")
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(200), popped.Load())
+}
+
+func benchmarkStripes(b *testing.B, run func(b *testing.B, db *Database)) {
+	for _, count := range []int{1, DEFAULT_LOCK_STRIPES, 64} {
+		b.Run(fmt.Sprintf("stripes=%d", count), func(b *testing.B) {
+			db := NewDatabaseWithStripes(count)
+			for i := 0; i < 10000; i++ {
+				db.Set(fmt.Sprintf("key%d", i), "value")
+			}
+			b.ResetTimer()
+			run(b, db)
+		})
+	}
+}
+
+// BenchmarkDatabase_SetParallel measures the write throughput; run it with
+// -cpu 1,2,4,8 to see how it scales with GOMAXPROCS.
+func BenchmarkDatabase_SetParallel(b *testing.B) {
+	benchmarkStripes(b, func(b *testing.B, db *Database) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				db.Set(fmt.Sprintf("key%d", i%10000), "value")
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkDatabase_GetParallel(b *testing.B) {
+	benchmarkStripes(b, func(b *testing.B, db *Database) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				db.Get(fmt.Sprintf("key%d", i%10000))
+				i++
+			}
+		})
+	})
+}
+
+// BenchmarkDatabase_MixedParallel runs one write for every four reads.
+func BenchmarkDatabase_MixedParallel(b *testing.B) {
+	benchmarkStripes(b, func(b *testing.B, db *Database) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				key := fmt.Sprintf("key%d", i%10000)
+				if i%5 == 0 {
+					db.Set(key, "value")
+				} else {
+					db.Get(key)
+				}
+				i++
+			}
+		})
+	})
+}
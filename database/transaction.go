@@ -0,0 +1,310 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type PreconditionType string
+
+const (
+	PRECONDITION_EXISTS     PreconditionType = "exists"
+	PRECONDITION_NOT_EXISTS PreconditionType = "not_exists"
+	PRECONDITION_EQUALS     PreconditionType = "equals"
+)
+
+var ErrInvalidTransaction = errors.New("invalid transaction")
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Precondition is a check on a key that must hold for a transaction to be applied.
+type Precondition struct {
+	Type       PreconditionType
+	Collection string
+	Key        string
+	// Value is the expected value for PRECONDITION_EQUALS
+	Value string
+}
+
+func (precondition Precondition) String() string {
+	if precondition.Type == PRECONDITION_EQUALS {
+		return fmt.Sprintf("key %q of collection %q must equal %q", precondition.Key, precondition.Collection, precondition.Value)
+	}
+	return fmt.Sprintf("key %q of collection %q must satisfy %s", precondition.Key, precondition.Collection, precondition.Type)
+}
+
+// PreconditionError reports the precondition that aborted a transaction.
+type PreconditionError struct {
+	Index        int
+	Precondition Precondition
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("precondition %d failed: %s", e.Index, e.Precondition)
+}
+
+func (e *PreconditionError) Unwrap() error {
+	return ErrPreconditionFailed
+}
+
+// TransactionOperation is a write of a transaction, either OP_SET or OP_DELETE.
+type TransactionOperation struct {
+	Type       OperationType
+	Collection string
+	Key        string
+	Value      string
+	// TTL of the value for OP_SET, lower or equal to zero for no expiration
+	TTL time.Duration
+}
+
+// Transaction is an ordered list of writes across collections, applied only
+// if every precondition holds. An empty collection name stands for the
+// default collection.
+type Transaction struct {
+	Preconditions []Precondition
+	Operations    []TransactionOperation
+}
+
+func (tx *Transaction) normalize() error {
+	if len(tx.Operations) == 0 {
+		return fmt.Errorf("%w: no operations", ErrInvalidTransaction)
+	}
+
+	for i := range tx.Preconditions {
+		precondition := &tx.Preconditions[i]
+		if precondition.Collection == "" {
+			precondition.Collection = DEFAULT_COLLECTION
+		}
+		if precondition.Key == "" {
+			return fmt.Errorf("%w: precondition %d has no key", ErrInvalidTransaction, i)
+		}
+		switch precondition.Type {
+		case PRECONDITION_EXISTS, PRECONDITION_NOT_EXISTS, PRECONDITION_EQUALS:
+		default:
+			return fmt.Errorf("%w: unknown precondition %q", ErrInvalidTransaction, precondition.Type)
+		}
+	}
+
+	for i := range tx.Operations {
+		operation := &tx.Operations[i]
+		if operation.Collection == "" {
+			operation.Collection = DEFAULT_COLLECTION
+		}
+		if operation.Key == "" {
+			return fmt.Errorf("%w: operation %d has no key", ErrInvalidTransaction, i)
+		}
+		switch operation.Type {
+		case OP_SET:
+			if operation.Value == "" {
+				return fmt.Errorf("%w: operation %d has no value", ErrInvalidTransaction, i)
+			}
+		case OP_DELETE:
+		default:
+			return fmt.Errorf("%w: unsupported operation %q", ErrInvalidTransaction, operation.Type)
+		}
+	}
+	return nil
+}
+
+// Execute applies the transaction atomically: either every operation is
+// applied or none is, and no reader observes a partially applied
+// transaction. Collections written by the transaction are created if needed.
+// It returns a PreconditionError if a precondition does not hold.
+func (cm *CollectionManager) Execute(tx Transaction) error {
+	if err := tx.normalize(); err != nil {
+		return err
+	}
+	if err := cm.ensureMemory(cm.transactionMemory(tx)); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	keys := make(map[string][]string)
+	for _, precondition := range tx.Preconditions {
+		keys[precondition.Collection] = append(keys[precondition.Collection], precondition.Key)
+	}
+	for _, operation := range tx.Operations {
+		keys[operation.Collection] = append(keys[operation.Collection], operation.Key)
+	}
+	collections, unlock := cm.lockKeys(keys)
+	defer unlock()
+
+	now := nowMillis()
+	for i, precondition := range tx.Preconditions {
+		if !collections[precondition.Collection].checkPrecondition(precondition, now) {
+			return &PreconditionError{Index: i, Precondition: precondition}
+		}
+	}
+
+	ops := make([]Operation, 0, len(tx.Operations))
+	created := make(map[string]*Database)
+	var undo []func()
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+
+	for _, operation := range tx.Operations {
+		db := collections[operation.Collection]
+		if db == nil {
+			if operation.Type == OP_DELETE {
+				continue
+			}
+			db = cm.newCollection(operation.Collection)
+			collections[operation.Collection] = db
+			created[operation.Collection] = db
+			ops = append(ops, Operation{Type: OP_ADD_COLLECTION, Collection: operation.Collection})
+		}
+
+		undo = append(undo, db.saveState(operation.Key, now))
+		switch operation.Type {
+		case OP_SET:
+			var expiresAt int64
+			if operation.TTL > 0 {
+				expiresAt = now + operation.TTL.Milliseconds()
+			}
+			version, err := db.set(operation.Key, operation.Value, expiresAt, 0)
+			if err != nil {
+				rollback()
+				return err
+			}
+			ops = append(ops, Operation{Type: OP_SET, Collection: operation.Collection, Key: operation.Key, Value: operation.Value, ExpiresAt: expiresAt, Version: version})
+		case OP_DELETE:
+			if !db.exists(operation.Key, now) {
+				continue
+			}
+			db.remove(operation.Key)
+			ops = append(ops, Operation{Type: OP_DELETE, Collection: operation.Collection, Key: operation.Key})
+		}
+	}
+
+	for name, db := range created {
+		cm.collections[name] = db
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	cm.events.publish(Operation{Type: OP_TRANSACTION, Ops: ops}, EVENT_DELETE)
+	if cm.journal == nil {
+		return nil
+	}
+	return cm.journal.Append(Operation{Type: OP_TRANSACTION, Ops: ops})
+}
+
+// applyTransaction executes an OP_TRANSACTION read from a journal.
+func (cm *CollectionManager) applyTransaction(op Operation) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	keys := make(map[string][]string)
+	for _, sub := range op.Ops {
+		keys[sub.Collection] = append(keys[sub.Collection], sub.Key)
+	}
+	collections, unlock := cm.lockKeys(keys)
+	defer unlock()
+
+	for _, sub := range op.Ops {
+		db := collections[sub.Collection]
+		switch sub.Type {
+		case OP_ADD_COLLECTION:
+			if db == nil {
+				db = cm.newCollection(sub.Collection)
+				collections[sub.Collection] = db
+				cm.collections[sub.Collection] = db
+			}
+		case OP_SET:
+			if db == nil {
+				return fmt.Errorf("collection %q not found", sub.Collection)
+			}
+			if _, err := db.set(sub.Key, sub.Value, sub.ExpiresAt, sub.Version); err != nil {
+				return err
+			}
+		case OP_DELETE:
+			if db != nil {
+				db.remove(sub.Key)
+			}
+		default:
+			return fmt.Errorf("unsupported transaction operation %q", sub.Type)
+		}
+	}
+
+	cm.record(op)
+	return nil
+}
+
+// lockKeys write locks the stripes holding the keys, given by collection
+// name, of the existing collections, in collection name order and then in
+// stripe order so that concurrent transactions cannot deadlock. Missing
+// collections are mapped to nil. The caller must hold cm.mu.
+func (cm *CollectionManager) lockKeys(keys map[string][]string) (map[string]*Database, func()) {
+	collections := make(map[string]*Database, len(keys))
+	sorted := make([]string, 0, len(keys))
+	for name := range keys {
+		collections[name] = cm.collections[name]
+		if collections[name] != nil {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	unlocks := make([]func(), 0, len(sorted))
+	for _, name := range sorted {
+		unlocks = append(unlocks, collections[name].lockKeys(keys[name]))
+	}
+	return collections, func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+}
+
+// transactionMemory returns the memory added by the writes of a transaction.
+func (cm *CollectionManager) transactionMemory(tx Transaction) int64 {
+	var needed int64
+	for _, operation := range tx.Operations {
+		if operation.Type != OP_SET {
+			continue
+		}
+		if db, exists := cm.GetCollection(operation.Collection); exists {
+			needed += db.memoryNeeded(operation.Key, operation.Value)
+		} else {
+			needed += entrySize(operation.Key, operation.Value)
+		}
+	}
+	return needed
+}
+
+// checkPrecondition reports whether the precondition holds. A nil database
+// is a missing collection. The caller must hold the lock of the stripe of
+// the key.
+func (db *Database) checkPrecondition(precondition Precondition, now int64) bool {
+	exists := db != nil && db.exists(precondition.Key, now)
+	switch precondition.Type {
+	case PRECONDITION_EXISTS:
+		return exists
+	case PRECONDITION_NOT_EXISTS:
+		return !exists
+	case PRECONDITION_EQUALS:
+		return exists && db.stripeOf(precondition.Key).dict.Get(precondition.Key) == precondition.Value
+	}
+	return false
+}
+
+// saveState returns a function restoring the current value and expiration of
+// the key. The caller must hold the write lock of the stripe of key.
+func (db *Database) saveState(key string, now int64) func() {
+	if !db.exists(key, now) {
+		return func() { db.remove(key) }
+	}
+	s := db.stripeOf(key)
+	expiresAt, version := s.expires[key], s.meta[key].version
+	if typed, ok := s.values[key]; ok {
+		return func() { db.setTyped(key, typed, expiresAt, version) }
+	}
+	value := s.dict.Get(key)
+	return func() { db.set(key, value, expiresAt, version) }
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionManager_Execute(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.GetDefaultCollection().Set("pending", "1")
+
+	err := cm.Execute(Transaction{
+		Preconditions: []Precondition{{Type: PRECONDITION_EXISTS, Key: "pending"}},
+		Operations: []TransactionOperation{
+			{Type: OP_SET, Collection: "accounts", Key: "alice", Value: "50"},
+			{Type: OP_SET, Collection: "accounts", Key: "bob", Value: "50", TTL: time.Minute},
+			{Type: OP_DELETE, Key: "pending"},
+			{Type: OP_DELETE, Collection: "missing", Key: "key"},
+		},
+	})
+	require.NoError(t, err)
+
+	accounts, exists := cm.GetCollection("accounts")
+	require.True(t, exists)
+	assert.Equal(t, "50", accounts.Get("alice"))
+	assert.Equal(t, "50", accounts.Get("bob"))
+	ttl, err := accounts.TTL("bob")
+	require.NoError(t, err)
+	assert.True(t, ttl > 0)
+	assert.False(t, cm.GetDefaultCollection().Exists("pending"))
+	_, exists = cm.GetCollection("missing")
+	assert.False(t, exists)
+}
+
+func TestCollectionManager_Execute_PreconditionFailed(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.GetDefaultCollection().Set("balance", "100")
+
+	tests := []Precondition{
+		{Type: PRECONDITION_EXISTS, Key: "missing"},
+		{Type: PRECONDITION_NOT_EXISTS, Key: "balance"},
+		{Type: PRECONDITION_EQUALS, Key: "balance", Value: "99"},
+		{Type: PRECONDITION_EXISTS, Collection: "missing", Key: "balance"},
+	}
+	for _, precondition := range tests {
+		err := cm.Execute(Transaction{
+			Preconditions: []Precondition{{Type: PRECONDITION_EQUALS, Key: "balance", Value: "100"}, precondition},
+			Operations: []TransactionOperation{
+				{Type: OP_SET, Key: "balance", Value: "0"},
+				{Type: OP_SET, Collection: "created", Key: "key", Value: "value"},
+			},
+		})
+		var preconditionErr *PreconditionError
+		require.ErrorAs(t, err, &preconditionErr, "precondition %s", precondition)
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+		assert.Equal(t, 1, preconditionErr.Index)
+	}
+
+	assert.Equal(t, "100", cm.GetDefaultCollection().Get("balance"))
+	_, exists := cm.GetCollection("created")
+	assert.False(t, exists)
+}
+
+func TestCollectionManager_Execute_Invalid(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+
+	invalid := []Transaction{
+		{},
+		{Operations: []TransactionOperation{{Type: OP_SET, Value: "value"}}},
+		{Operations: []TransactionOperation{{Type: OP_SET, Key: "key"}}},
+		{Operations: []TransactionOperation{{Type: OP_EXPIRE, Key: "key"}}},
+		{
+			Preconditions: []Precondition{{Type: "unknown", Key: "key"}},
+			Operations:    []TransactionOperation{{Type: OP_DELETE, Key: "key"}},
+		},
+	}
+	for _, tx := range invalid {
+		assert.ErrorIs(t, cm.Execute(tx), ErrInvalidTransaction)
+	}
+}
+
+func TestCollectionManager_Execute_Isolation(t *testing.T) {
+	cm := NewCollectionManager()
+	cm.AddCollection(DEFAULT_COLLECTION)
+	cm.AddCollection("other")
+	other, _ := cm.GetCollection("other")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			value := fmt.Sprint(i)
+			require.NoError(t, cm.Execute(Transaction{Operations: []TransactionOperation{
+				{Type: OP_SET, Key: "key", Value: value},
+				{Type: OP_SET, Collection: "other", Key: "key", Value: value},
+			}}))
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		snapshot := cm.Snapshot()
+		if len(snapshot.Collections[DEFAULT_COLLECTION]) == 0 {
+			continue
+		}
+		assert.Equal(t, snapshot.Collections[DEFAULT_COLLECTION], snapshot.Collections["other"])
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, cm.GetDefaultCollection().Get("key"), other.Get("key"))
+}
+
+func TestAppendLog_ReplayTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	cm.GetDefaultCollection().Set("pending", "1")
+	require.NoError(t, cm.Execute(Transaction{Operations: []TransactionOperation{
+		{Type: OP_SET, Collection: "accounts", Key: "alice", Value: "50"},
+		{Type: OP_DELETE, Key: "pending"},
+	}}))
+	require.NoError(t, aof.Close())
+	assert.Equal(t, uint64(2), aof.LastSeq())
+
+	replayed, replayedLog := openTestAppendLog(t, path)
+	defer replayedLog.Close()
+
+	accounts, exists := replayed.GetCollection("accounts")
+	require.True(t, exists)
+	assert.Equal(t, "50", accounts.Get("alice"))
+	assert.False(t, replayed.GetDefaultCollection().Exists("pending"))
+}
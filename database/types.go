@@ -0,0 +1,125 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ValueType is the type of the value stored under a key.
+type ValueType string
+
+const (
+	TYPE_NONE   ValueType = "none"
+	TYPE_STRING ValueType = "string"
+	TYPE_LIST   ValueType = "list"
+	TYPE_SET    ValueType = "set"
+	TYPE_ZSET   ValueType = "zset"
+	TYPE_HASH   ValueType = "hash"
+)
+
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// typedValue is a value of a type other than string. Typed values are kept
+// out of the dict, in Database.values, and share the expiration, metadata
+// and journal of the string values.
+type typedValue interface {
+	valueType() ValueType
+	// size returns the approximate memory used by the elements, in bytes
+	size() int64
+	// len returns the number of elements, a key is removed once empty
+	len() int
+	// marshal encodes the value as JSON, for snapshots and listings
+	marshal() (string, error)
+}
+
+// unmarshalTypedValue decodes a value encoded by typedValue.marshal.
+func unmarshalTypedValue(valueType ValueType, data string) (typedValue, error) {
+	switch valueType {
+	case TYPE_LIST:
+		return unmarshalList(data)
+	case TYPE_SET:
+		return unmarshalSet(data)
+	case TYPE_ZSET:
+		return unmarshalSortedSet(data)
+	case TYPE_HASH:
+		return unmarshalHash(data)
+	}
+	return nil, fmt.Errorf("unknown value type %q", valueType)
+}
+
+// Type returns the type of the value stored under key, TYPE_NONE if the key does not exist.
+func (db *Database) Type(key string) ValueType {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !db.exists(key, nowMillis()) {
+		return TYPE_NONE
+	}
+	if value, ok := s.values[key]; ok {
+		return value.valueType()
+	}
+	return TYPE_STRING
+}
+
+// typedValueOf returns the value of type valueType stored under key, nil if
+// the key does not exist, or ErrWrongType if it holds another type. The
+// caller must hold the lock of the stripe of key.
+func (db *Database) typedValueOf(key string, valueType ValueType, now int64) (typedValue, error) {
+	if !db.exists(key, now) {
+		return nil, nil
+	}
+	value, ok := db.stripeOf(key).values[key]
+	if !ok || value.valueType() != valueType {
+		return nil, ErrWrongType
+	}
+	return value, nil
+}
+
+// setTyped stores a typed value with the given expiration and version, as
+// set does for strings. An empty value removes the key and returns zero.
+// The caller must hold the write lock of the stripe of key.
+func (db *Database) setTyped(key string, value typedValue, expiresAt int64, version uint64) uint64 {
+	if value.len() == 0 {
+		db.remove(key)
+		return 0
+	}
+
+	s := db.stripeOf(key)
+	if _, ok := s.values[key]; !ok {
+		s.dict.Delete(key)
+	}
+	s.values[key] = value
+	return db.store(key, int64(len(key))+value.size()+ENTRY_OVERHEAD, expiresAt, version)
+}
+
+// reserveElements makes room for adding the elements to the typed value
+// stored under key, each taking overhead bytes on top of its own, as
+// reserveMemory does for strings. It must be called without holding the
+// lock of the stripe of key.
+func (db *Database) reserveElements(key string, elements []string, overhead int64) error {
+	if db.manager == nil {
+		return nil
+	}
+
+	needed := int64(len(key)) + ENTRY_OVERHEAD
+	for _, element := range elements {
+		needed += int64(len(element)) + overhead
+	}
+	return db.manager.ensureMemory(needed)
+}
+
+// renderValue returns the value stored under key as a string, typed values
+// being encoded as JSON. The caller must hold the lock of the stripe of key.
+func (db *Database) renderValue(key string) string {
+	s := db.stripeOf(key)
+	value, ok := s.values[key]
+	if !ok {
+		return s.dict.Get(key)
+	}
+	rendered, err := value.marshal()
+	if err != nil {
+		return ""
+	}
+	return rendered
+}
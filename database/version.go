@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// Every write of a key assigns it a new version, greater than any version
+// previously assigned in the same database, so a version identifies a value
+// even across a deletion and a new write of the key. Zero stands for a
+// missing key.
+
+// Version returns the version of the key, zero if the key does not exist.
+func (db *Database) Version(key string) uint64 {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return db.versionOf(key, nowMillis())
+}
+
+// SetIf stores the value with an expiration after ttl if condition, called
+// with the current version of the key, returns true. A nil condition always
+// holds. It returns the new version of the key, or ErrVersionMismatch if the
+// condition does not hold.
+func (db *Database) SetIf(key string, value string, ttl time.Duration, condition func(version uint64) bool) (uint64, error) {
+	if err := db.reserveMemory(key, value); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := nowMillis()
+	if condition != nil && !condition(db.versionOf(key, now)) {
+		return 0, ErrVersionMismatch
+	}
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = now + ttl.Milliseconds()
+	}
+
+	version, err := db.set(key, value, expiresAt, 0)
+	if err != nil {
+		return 0, err
+	}
+	return version, db.record(Operation{Type: OP_SET, Key: key, Value: value, ExpiresAt: expiresAt, Version: version})
+}
+
+// DeleteIf deletes the key if condition, called with the current version of
+// the key, returns true. A nil condition always holds. It returns
+// ErrVersionMismatch if the condition does not hold and ErrKeyNotFound if
+// the key does not exist.
+func (db *Database) DeleteIf(key string, condition func(version uint64) bool) error {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if condition != nil && !condition(db.versionOf(key, nowMillis())) {
+		return ErrVersionMismatch
+	}
+	if !db.remove(key) {
+		return ErrKeyNotFound
+	}
+	return db.record(Operation{Type: OP_DELETE, Key: key})
+}
+
+// CompareAndSwap stores the value only if the current version of the key is
+// version, zero meaning that the key must not exist. It returns the new
+// version of the key, or ErrVersionMismatch.
+func (db *Database) CompareAndSwap(key string, version uint64, value string) (uint64, error) {
+	return db.SetIf(key, value, 0, func(current uint64) bool {
+		return current == version
+	})
+}
+
+// CompareAndDelete deletes the key only if its current version is version.
+func (db *Database) CompareAndDelete(key string, version uint64) error {
+	return db.DeleteIf(key, func(current uint64) bool {
+		return current == version
+	})
+}
+
+// versionOf returns the version of the key, zero if the key does not exist.
+// The caller must hold the lock of the stripe of key.
+func (db *Database) versionOf(key string, now int64) uint64 {
+	if !db.exists(key, now) {
+		return 0
+	}
+	return db.stripeOf(key).meta[key].version
+}
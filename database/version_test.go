@@ -0,0 +1,78 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Version(t *testing.T) {
+	db := NewDatabase()
+	assert.Equal(t, uint64(0), db.Version("key"))
+
+	require.NoError(t, db.Set("key", "value1"))
+	value, version := db.GetWithVersion("key")
+	assert.Equal(t, "value1", value)
+	assert.Equal(t, uint64(1), version)
+
+	require.NoError(t, db.Set("other", "value"))
+	require.NoError(t, db.Set("key", "value2"))
+	assert.Equal(t, uint64(3), db.Version("key"))
+
+	// A key written again after a deletion never gets an old version back
+	require.NoError(t, db.Delete("key"))
+	assert.Equal(t, uint64(0), db.Version("key"))
+	require.NoError(t, db.Set("key", "value1"))
+	assert.Equal(t, uint64(4), db.Version("key"))
+}
+
+func TestDatabase_CompareAndSwap(t *testing.T) {
+	db := NewDatabase()
+
+	version, err := db.CompareAndSwap("key", 0, "value1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), version)
+
+	_, err = db.CompareAndSwap("key", 0, "value2")
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	version, err = db.CompareAndSwap("key", version, "value2")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", db.Get("key"))
+
+	_, err = db.CompareAndSwap("key", version-1, "value3")
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	assert.Equal(t, "value2", db.Get("key"))
+}
+
+func TestDatabase_CompareAndDelete(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.Set("key", "value"))
+	version := db.Version("key")
+
+	assert.ErrorIs(t, db.CompareAndDelete("key", version+1), ErrVersionMismatch)
+	assert.Equal(t, "value", db.Get("key"))
+
+	require.NoError(t, db.CompareAndDelete("key", version))
+	assert.Equal(t, "", db.Get("key"))
+}
+
+func TestAppendLog_ReplayVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AOF_FILE_NAME)
+
+	cm, aof := openTestAppendLog(t, path)
+	cm.GetDefaultCollection().Set("key1", "value1")
+	cm.GetDefaultCollection().Set("key2", "value2")
+	cm.GetDefaultCollection().Set("key1", "value3")
+	require.NoError(t, aof.Close())
+
+	replayed, replayedLog := openTestAppendLog(t, path)
+	defer replayedLog.Close()
+
+	assert.Equal(t, uint64(3), replayed.GetDefaultCollection().Version("key1"))
+	assert.Equal(t, uint64(2), replayed.GetDefaultCollection().Version("key2"))
+	require.NoError(t, replayed.GetDefaultCollection().Set("key2", "value4"))
+	assert.Equal(t, uint64(4), replayed.GetDefaultCollection().Version("key2"))
+}
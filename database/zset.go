@@ -0,0 +1,417 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ZSET_MEMBER_OVERHEAD approximates the memory used by a sorted set member on top of its bytes.
+const ZSET_MEMBER_OVERHEAD int64 = 96
+
+var ErrInvalidScoreBound = errors.New("score bound is not a number, -inf or +inf, optionally preceded by ( for an exclusive bound")
+
+// ScoredMember is a member of a sorted set with its score.
+type ScoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// ScoreBound is a minimum or maximum score of a range of a sorted set.
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// ParseScoreBound parses a score bound written as a number, -inf or +inf,
+// preceded by ( when the bound is exclusive.
+func ParseScoreBound(bound string) (ScoreBound, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	bound = strings.TrimPrefix(bound, "(")
+	switch strings.ToLower(bound) {
+	case "-inf":
+		return ScoreBound{Value: math.Inf(-1), Exclusive: exclusive}, nil
+	case "+inf", "inf":
+		return ScoreBound{Value: math.Inf(1), Exclusive: exclusive}, nil
+	}
+	value, err := strconv.ParseFloat(bound, 64)
+	if err != nil || math.IsNaN(value) {
+		return ScoreBound{}, ErrInvalidScoreBound
+	}
+	return ScoreBound{Value: value, Exclusive: exclusive}, nil
+}
+
+// allowsAbove reports whether score satisfies the bound as a minimum.
+func (bound ScoreBound) allowsAbove(score float64) bool {
+	if bound.Exclusive {
+		return score > bound.Value
+	}
+	return score >= bound.Value
+}
+
+// allowsBelow reports whether score satisfies the bound as a maximum.
+func (bound ScoreBound) allowsBelow(score float64) bool {
+	if bound.Exclusive {
+		return score < bound.Value
+	}
+	return score <= bound.Value
+}
+
+// sortedSet is a sorted set value: unique members ordered by score, then by
+// member. Scores are looked up in the map, ranks in the skiplist.
+type sortedSet struct {
+	scores map[string]float64
+	list   *zskiplist
+	bytes  int64
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64), list: newZskiplist()}
+}
+
+func unmarshalSortedSet(data string) (typedValue, error) {
+	var members []ScoredMember
+	if err := json.Unmarshal([]byte(data), &members); err != nil {
+		return nil, err
+	}
+	z := newSortedSet()
+	for _, member := range members {
+		z.set(member.Member, member.Score)
+	}
+	return z, nil
+}
+
+func (z *sortedSet) valueType() ValueType {
+	return TYPE_ZSET
+}
+
+func (z *sortedSet) size() int64 {
+	return z.bytes + int64(len(z.scores))*ZSET_MEMBER_OVERHEAD
+}
+
+func (z *sortedSet) len() int {
+	return len(z.scores)
+}
+
+func (z *sortedSet) marshal() (string, error) {
+	members := make([]ScoredMember, 0, len(z.scores))
+	for node := z.list.head.level[0].forward; node != nil; node = node.level[0].forward {
+		members = append(members, ScoredMember{Member: node.member, Score: node.score})
+	}
+	data, err := json.Marshal(members)
+	return string(data), err
+}
+
+// set stores the score of a member, returning true if the member was added.
+func (z *sortedSet) set(member string, score float64) bool {
+	current, ok := z.scores[member]
+	if ok {
+		if current == score {
+			return false
+		}
+		z.list.delete(current, member)
+	} else {
+		z.bytes += int64(len(member))
+	}
+	z.scores[member] = score
+	z.list.insert(score, member)
+	return !ok
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	z.list.delete(score, member)
+	delete(z.scores, member)
+	z.bytes -= int64(len(member))
+	return true
+}
+
+func validScore(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+// ZAdd sets the scores of the members in the sorted set stored under key,
+// creating the sorted set if needed. Scores must be finite numbers. It
+// returns the number of members that were not already in the sorted set.
+func (db *Database) ZAdd(key string, members ...ScoredMember) (int, error) {
+	names := make([]string, len(members))
+	for i, member := range members {
+		if !validScore(member.Score) {
+			return 0, ErrNotNumber
+		}
+		names[i] = member.Member
+	}
+	if err := db.reserveElements(key, names, ZSET_MEMBER_OVERHEAD); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, changed, version, err := db.addSortedSetMembers(key, members, 0)
+	if err != nil || !changed {
+		return added, err
+	}
+	return added, db.record(Operation{Type: OP_ZADD, Key: key, Args: scoredMembersArgs(members), Version: version})
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set stored under
+// key, a missing member starting from zero. It returns the new score.
+func (db *Database) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	if !validScore(delta) {
+		return 0, ErrNotNumber
+	}
+	if err := db.reserveElements(key, []string{member}, ZSET_MEMBER_OVERHEAD); err != nil {
+		return 0, err
+	}
+
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, err := db.typedValueOf(key, TYPE_ZSET, nowMillis())
+	if err != nil {
+		return 0, err
+	}
+	var score float64
+	if value != nil {
+		score = value.(*sortedSet).scores[member]
+	}
+	score += delta
+	if !validScore(score) {
+		return 0, ErrOverflow
+	}
+
+	members := []ScoredMember{{Member: member, Score: score}}
+	_, _, version, err := db.addSortedSetMembers(key, members, 0)
+	if err != nil {
+		return 0, err
+	}
+	return score, db.record(Operation{Type: OP_ZADD, Key: key, Args: scoredMembersArgs(members), Version: version})
+}
+
+// ZRem removes the members from the sorted set stored under key, deleting
+// the key once the sorted set is empty. It returns the number of members removed.
+func (db *Database) ZRem(key string, members ...string) (int, error) {
+	s := db.stripeOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, version, err := db.removeSortedSetMembers(key, members, 0)
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	return removed, db.record(Operation{Type: OP_ZREM, Key: key, Args: members, Version: version})
+}
+
+// ZScore returns the score of member in the sorted set stored under key, and
+// whether the member exists.
+func (db *Database) ZScore(key string, member string) (float64, bool, error) {
+	z, release, err := db.sortedSetForRead(key)
+	if z == nil {
+		return 0, false, err
+	}
+	defer release()
+
+	score, ok := z.scores[member]
+	return score, ok, nil
+}
+
+// ZRank returns the zero based rank of member in the sorted set stored under
+// key, ordered from the lowest score, and whether the member exists.
+func (db *Database) ZRank(key string, member string) (int, bool, error) {
+	return db.zrank(key, member, false)
+}
+
+// ZRevRank returns the rank of member ordered from the highest score, as ZRank.
+func (db *Database) ZRevRank(key string, member string) (int, bool, error) {
+	return db.zrank(key, member, true)
+}
+
+// ZRange returns the members of the sorted set stored under key with a rank
+// from start to stop, both included, ordered from the lowest score or from
+// the highest if reverse is true. Negative ranks count from the end. A
+// missing key is an empty sorted set.
+func (db *Database) ZRange(key string, start int, stop int, reverse bool) ([]ScoredMember, error) {
+	z, release, err := db.sortedSetForRead(key)
+	if z == nil {
+		return []ScoredMember{}, err
+	}
+	defer release()
+
+	start, stop = listRange(start, stop, z.list.length)
+	members := make([]ScoredMember, 0, max(stop-start+1, 0))
+	if start > stop {
+		return members, nil
+	}
+
+	rank := start
+	if reverse {
+		rank = z.list.length - 1 - start
+	}
+	node := z.list.byRank(rank)
+	for i := start; i <= stop && node != nil; i++ {
+		members = append(members, ScoredMember{Member: node.member, Score: node.score})
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return members, nil
+}
+
+// ZRangeByScore returns the members of the sorted set stored under key with
+// a score between min and max, ordered from the lowest score or from the
+// highest if reverse is true, skipping offset members and returning at most
+// count members, all of them if count is lower or equal to zero.
+func (db *Database) ZRangeByScore(key string, min ScoreBound, max ScoreBound, reverse bool, offset int, count int) ([]ScoredMember, error) {
+	z, release, err := db.sortedSetForRead(key)
+	if z == nil {
+		return []ScoredMember{}, err
+	}
+	defer release()
+
+	members := []ScoredMember{}
+	var node *zskiplistNode
+	if reverse {
+		node = z.list.lastBelow(max)
+	} else {
+		node = z.list.firstAbove(min)
+	}
+	for ; node != nil && (count <= 0 || len(members) < count); offset-- {
+		if !min.allowsAbove(node.score) || !max.allowsBelow(node.score) {
+			break
+		}
+		if offset <= 0 {
+			members = append(members, ScoredMember{Member: node.member, Score: node.score})
+		}
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return members, nil
+}
+
+func (db *Database) zrank(key string, member string, reverse bool) (int, bool, error) {
+	z, release, err := db.sortedSetForRead(key)
+	if z == nil {
+		return 0, false, err
+	}
+	defer release()
+
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false, nil
+	}
+	rank := z.list.rank(score, member)
+	if reverse {
+		rank = z.list.length - 1 - rank
+	}
+	return rank, true, nil
+}
+
+// sortedSetForRead returns the sorted set stored under key while holding the
+// read lock of its stripe, and the function releasing it, which the caller
+// must call. It releases the lock and returns nil if the key does not exist
+// or holds another type.
+func (db *Database) sortedSetForRead(key string) (*sortedSet, func(), error) {
+	s := db.stripeOf(key)
+	s.mu.RLock()
+	value, err := db.typedValueOf(key, TYPE_ZSET, nowMillis())
+	if value == nil {
+		s.mu.RUnlock()
+		return nil, nil, err
+	}
+	return value.(*sortedSet), s.mu.RUnlock, nil
+}
+
+// addSortedSetMembers sets the scores of the members in the sorted set
+// stored under key with the given version, zero for the next one. It returns
+// the number of members added, whether any score changed and the version of
+// the key. The caller must hold the write lock of the stripe of key.
+func (db *Database) addSortedSetMembers(key string, members []ScoredMember, version uint64) (int, bool, uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_ZSET, nowMillis())
+	if err != nil {
+		return 0, false, 0, err
+	}
+
+	var expiresAt int64
+	z, _ := value.(*sortedSet)
+	if z == nil {
+		z = newSortedSet()
+	} else {
+		expiresAt = db.expiresAt(key)
+	}
+
+	added, changed := 0, false
+	for _, member := range members {
+		if current, ok := z.scores[member.Member]; ok && current == member.Score {
+			continue
+		}
+		changed = true
+		if z.set(member.Member, member.Score) {
+			added++
+		}
+	}
+	if !changed {
+		return 0, false, 0, nil
+	}
+	return added, true, db.setTyped(key, z, expiresAt, version), nil
+}
+
+// removeSortedSetMembers removes the members from the sorted set stored under
+// key with the given version, zero for the next one. The caller must hold the
+// write lock of the stripe of key.
+func (db *Database) removeSortedSetMembers(key string, members []string, version uint64) (int, uint64, error) {
+	value, err := db.typedValueOf(key, TYPE_ZSET, nowMillis())
+	if value == nil {
+		return 0, 0, err
+	}
+
+	z := value.(*sortedSet)
+	removed := 0
+	for _, member := range members {
+		if z.remove(member) {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, 0, nil
+	}
+	return removed, db.setTyped(key, z, db.expiresAt(key), version), nil
+}
+
+// scoredMembersArgs encodes members as the arguments of an OP_ZADD: every
+// score followed by its member.
+func scoredMembersArgs(members []ScoredMember) []string {
+	args := make([]string, 0, 2*len(members))
+	for _, member := range members {
+		args = append(args, strconv.FormatFloat(member.Score, 'g', -1, 64), member.Member)
+	}
+	return args
+}
+
+// parseScoredMembersArgs decodes the arguments of an OP_ZADD.
+func parseScoredMembersArgs(args []string) ([]ScoredMember, error) {
+	if len(args)%2 != 0 {
+		return nil, errors.New("odd number of arguments for a sorted set operation")
+	}
+	members := make([]ScoredMember, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ScoredMember{Member: args[i+1], Score: score})
+	}
+	return members, nil
+}
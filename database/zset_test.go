@@ -0,0 +1,120 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZskiplist(t *testing.T) {
+	zsl := newZskiplist()
+	scores := make(map[string]float64)
+	for i := 0; i < 3000; i++ {
+		member := fmt.Sprintf("member%d", rand.Intn(300))
+		if score, ok := scores[member]; ok {
+			require.True(t, zsl.delete(score, member))
+			delete(scores, member)
+		}
+		if rand.Intn(4) > 0 {
+			score := float64(rand.Intn(50))
+			zsl.insert(score, member)
+			scores[member] = score
+		}
+	}
+
+	expected := make([]ScoredMember, 0, len(scores))
+	for member, score := range scores {
+		expected = append(expected, ScoredMember{member, score})
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		return zless(expected[i].Score, expected[i].Member, expected[j].Score, expected[j].Member)
+	})
+
+	require.Equal(t, len(expected), zsl.length)
+	for rank, member := range expected {
+		assert.Equal(t, rank, zsl.rank(member.Score, member.Member))
+		node := zsl.byRank(rank)
+		require.NotNil(t, node)
+		assert.Equal(t, member.Member, node.member)
+	}
+	assert.Nil(t, zsl.byRank(len(expected)))
+	assert.Equal(t, -1, zsl.rank(1, "missing"))
+
+	var backward []ScoredMember
+	for node := zsl.tail; node != nil; node = node.backward {
+		backward = append([]ScoredMember{{node.member, node.score}}, backward...)
+	}
+	assert.Equal(t, expected, backward)
+}
+
+func TestParseScoreBound(t *testing.T) {
+	bound, err := ParseScoreBound("(1.5")
+	require.NoError(t, err)
+	assert.Equal(t, ScoreBound{Value: 1.5, Exclusive: true}, bound)
+	bound, err = ParseScoreBound("-inf")
+	require.NoError(t, err)
+	assert.Equal(t, math.Inf(-1), bound.Value)
+	_, err = ParseScoreBound("abc")
+	assert.ErrorIs(t, err, ErrInvalidScoreBound)
+}
+
+func TestDatabase_SortedSet(t *testing.T) {
+	db := NewDatabase()
+	added, err := db.ZAdd("board", ScoredMember{"alice", 30}, ScoredMember{"bob", 10}, ScoredMember{"carol", 20}, ScoredMember{"dave", 20})
+	require.NoError(t, err)
+	assert.Equal(t, 4, added)
+	added, err = db.ZAdd("board", ScoredMember{"bob", 40})
+	require.NoError(t, err)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, TYPE_ZSET, db.Type("board"))
+
+	members, err := db.ZRange("board", 0, -1, false)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{"carol", 20}, {"dave", 20}, {"alice", 30}, {"bob", 40}}, members)
+	members, err = db.ZRange("board", 0, 1, true)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{"bob", 40}, {"alice", 30}}, members)
+
+	rank, ok, err := db.ZRank("board", "alice")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, rank)
+	rank, _, _ = db.ZRevRank("board", "alice")
+	assert.Equal(t, 1, rank)
+	_, ok, err = db.ZRank("board", "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	score, err := db.ZIncrBy("board", "carol", 15)
+	require.NoError(t, err)
+	assert.Equal(t, 35.0, score)
+	score, ok, err = db.ZScore("board", "carol")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 35.0, score)
+
+	members, err = db.ZRangeByScore("board", ScoreBound{Value: 20}, ScoreBound{Value: 40, Exclusive: true}, false, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{"dave", 20}, {"alice", 30}, {"carol", 35}}, members)
+	members, err = db.ZRangeByScore("board", ScoreBound{Value: math.Inf(-1)}, ScoreBound{Value: math.Inf(1)}, true, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{"carol", 35}, {"alice", 30}}, members)
+
+	removed, err := db.ZRem("board", "alice", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	_, err = db.ZAdd("board", ScoredMember{"nan", math.NaN()})
+	assert.ErrorIs(t, err, ErrNotNumber)
+	_, err = db.SAdd("board", "member")
+	assert.ErrorIs(t, err, ErrWrongType)
+
+	members, err = db.ZRange("missing", 0, -1, false)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+}
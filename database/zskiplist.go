@@ -0,0 +1,182 @@
+package database
+
+// zskiplistLevel is a forward link of a sorted set node. span is the number
+// of nodes it skips, used to compute ranks.
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+// zskiplist keeps the members of a sorted set ordered by score, then by
+// member, and finds the member at a given rank in logarithmic time.
+type zskiplist struct {
+	head   *zskiplistNode
+	tail   *zskiplistNode
+	level  int
+	length int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		head:  &zskiplistNode{level: make([]zskiplistLevel, SKIPLIST_MAX_LEVEL)},
+		level: 1,
+	}
+}
+
+// zless reports whether the member a with score scoreA sorts before b.
+func zless(scoreA float64, a string, scoreB float64, b string) bool {
+	return scoreA < scoreB || (scoreA == scoreB && a < b)
+}
+
+// insert adds a member, which must not be in the list.
+func (zsl *zskiplist) insert(score float64, member string) {
+	var update [SKIPLIST_MAX_LEVEL]*zskiplistNode
+	var rank [SKIPLIST_MAX_LEVEL]int
+	node := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for next := node.level[i].forward; next != nil && zless(next.score, next.member, score, member); next = node.level[i].forward {
+			rank[i] += node.level[i].span
+			node = next
+		}
+		update[i] = node
+	}
+
+	level := randomSkiplistLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.head
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	node = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		node.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = node
+		node.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.head {
+		node.backward = update[0]
+	}
+	if node.level[0].forward != nil {
+		node.level[0].forward.backward = node
+	} else {
+		zsl.tail = node
+	}
+	zsl.length++
+}
+
+// delete removes a member with its current score, returning false if it is not in the list.
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [SKIPLIST_MAX_LEVEL]*zskiplistNode
+	node := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := node.level[i].forward; next != nil && zless(next.score, next.member, score, member); next = node.level[i].forward {
+			node = next
+		}
+		update[i] = node
+	}
+
+	node = node.level[0].forward
+	if node == nil || node.score != score || node.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == node {
+			update[i].level[i].span += node.level[i].span - 1
+			update[i].level[i].forward = node.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if node.level[0].forward != nil {
+		node.level[0].forward.backward = node.backward
+	} else {
+		zsl.tail = node.backward
+	}
+	for zsl.level > 1 && zsl.head.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the zero based rank of a member with its current score, -1
+// if it is not in the list.
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	node := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := node.level[i].forward; next != nil && !zless(score, member, next.score, next.member); next = node.level[i].forward {
+			rank += node.level[i].span
+			node = next
+		}
+		if node != zsl.head && node.score == score && node.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the node at the zero based rank, nil if out of range.
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	if rank < 0 || rank >= zsl.length {
+		return nil
+	}
+
+	traversed := 0
+	node := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for node.level[i].forward != nil && traversed+node.level[i].span <= rank+1 {
+			traversed += node.level[i].span
+			node = node.level[i].forward
+		}
+		if traversed == rank+1 {
+			return node
+		}
+	}
+	return nil
+}
+
+// firstAbove returns the first node with a score above the bound, nil if there is none.
+func (zsl *zskiplist) firstAbove(min ScoreBound) *zskiplistNode {
+	node := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := node.level[i].forward; next != nil && !min.allowsAbove(next.score); next = node.level[i].forward {
+			node = next
+		}
+	}
+	return node.level[0].forward
+}
+
+// lastBelow returns the last node with a score below the bound, nil if there is none.
+func (zsl *zskiplist) lastBelow(max ScoreBound) *zskiplistNode {
+	node := zsl.head
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := node.level[i].forward; next != nil && max.allowsBelow(next.score); next = node.level[i].forward {
+			node = next
+		}
+	}
+	if node == zsl.head {
+		return nil
+	}
+	return node
+}
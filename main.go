@@ -1,6 +1,8 @@
 package main
 
 import (
+	"path/filepath"
+
 	"github.com/dmarro89/dare-db/auth"
 	"github.com/dmarro89/dare-db/database"
 	"github.com/dmarro89/dare-db/logger"
@@ -11,9 +13,21 @@ func main() {
 	logger := logger.NewDareLogger()
 	configuration := server.NewConfiguration("")
 	database := database.NewDatabase()
-	userStore := auth.NewUserStore()
-	userStore.AddUser(configuration.GetString("server.admin_user"), configuration.GetString("server.admin_password"))
-	dareServer := server.NewDareServer(database, userStore)
+	userStore, err := auth.NewUserStoreWithFile(filepath.Join(configuration.GetString("settings.settings_dir"), auth.USERS_FILE))
+	if err != nil {
+		logger.Fatal("Error loading users: ", err)
+	}
+	// The admin is only created on the first start, its password is then changed through the API
+	if !userStore.HasUser(configuration.GetString("server.admin_user")) {
+		if err := userStore.AddUser(configuration.GetString("server.admin_user"), configuration.GetString("server.admin_password")); err != nil {
+			logger.Fatal("Error creating the admin user: ", err)
+		}
+	}
+	dareServer, err := server.NewDareServerWithConfig(database, userStore, configuration)
+	if err != nil {
+		logger.Fatal("Error loading data: ", err)
+	}
+	defer dareServer.Close()
 	server := server.NewFactory(configuration, logger).GetWebServer(dareServer)
 
 	server.Start()
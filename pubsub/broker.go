@@ -0,0 +1,270 @@
+// Package pubsub delivers messages published to named channels to the
+// subscribers of these channels, or of glob patterns matching them.
+//
+// Messages are not persisted: a message is delivered to the subscribers
+// present when it is published. Publishing never blocks. Every subscription
+// buffers a bounded number of pending messages; a subscriber whose buffer is
+// full when a message arrives is a slow consumer and its subscription is
+// closed with ErrSlowConsumer, so that it knows it missed messages instead of
+// silently losing them.
+package pubsub
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/dmarro89/dare-db/utils"
+)
+
+// DEFAULT_BUFFER_SIZE is the number of pending messages buffered per subscription.
+const DEFAULT_BUFFER_SIZE = 256
+
+var ErrSlowConsumer = errors.New("subscriber too slow, its buffer of pending messages is full")
+var ErrClosed = errors.New("pub/sub broker closed")
+
+// Message is a message published to a channel. Pattern is the pattern
+// matching the channel, for the subscriptions to patterns.
+type Message struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Payload string `json:"message"`
+}
+
+// Broker routes published messages to the subscriptions.
+type Broker struct {
+	mu         sync.RWMutex
+	channels   map[string]map[*Subscription]struct{}
+	patterns   map[string]map[*Subscription]struct{}
+	bufferSize int
+	closed     bool
+}
+
+// NewBroker creates a broker buffering up to bufferSize pending messages per
+// subscription, DEFAULT_BUFFER_SIZE if bufferSize is not positive.
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_BUFFER_SIZE
+	}
+	return &Broker{
+		channels:   make(map[string]map[*Subscription]struct{}),
+		patterns:   make(map[string]map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Subscription receives the messages published to its channels and to the
+// channels matching its patterns.
+type Subscription struct {
+	broker   *Broker
+	messages chan Message
+	done     chan struct{}
+
+	// The fields below are guarded by broker.mu
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	closed   bool
+	err      error
+}
+
+// Subscribe creates a subscription to the channels and the glob patterns,
+// both of which may be extended later.
+func (b *Broker) Subscribe(channels []string, patterns []string) *Subscription {
+	s := &Subscription{
+		broker:   b,
+		messages: make(chan Message, b.bufferSize),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.closeLocked(ErrClosed)
+		return s
+	}
+	s.subscribeLocked(b.channels, s.channels, channels)
+	s.subscribeLocked(b.patterns, s.patterns, patterns)
+	return s
+}
+
+// Publish sends a message to the subscribers of channel and of the patterns
+// matching it, closing the subscriptions of slow consumers. It returns the
+// number of subscriptions the message was delivered to.
+func (b *Broker) Publish(channel string, payload string) int {
+	receivers := 0
+	var slow []*Subscription
+	deliver := func(s *Subscription, message Message) {
+		select {
+		case s.messages <- message:
+			receivers++
+		default:
+			slow = append(slow, s)
+		}
+	}
+
+	b.mu.RLock()
+	for s := range b.channels[channel] {
+		deliver(s, Message{Channel: channel, Payload: payload})
+	}
+	for pattern, subscriptions := range b.patterns {
+		if !utils.GlobMatch(pattern, channel) {
+			continue
+		}
+		for s := range subscriptions {
+			deliver(s, Message{Channel: channel, Pattern: pattern, Payload: payload})
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range slow {
+		s.close(ErrSlowConsumer)
+	}
+	return receivers
+}
+
+// Close closes every subscription with ErrClosed, as well as the
+// subscriptions created afterwards.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, index := range []map[string]map[*Subscription]struct{}{b.channels, b.patterns} {
+		for _, subscriptions := range index {
+			for s := range subscriptions {
+				s.closeLocked(ErrClosed)
+			}
+		}
+	}
+}
+
+// Messages returns the channel delivering the messages. It is never closed,
+// use Done to know when no more messages will be delivered.
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Done returns a channel closed once the subscription is closed.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription was closed: ErrSlowConsumer, ErrClosed,
+// or nil if it is open or was closed by Close.
+func (s *Subscription) Err() error {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return s.err
+}
+
+// Subscribe adds channels to the subscription.
+func (s *Subscription) Subscribe(channels ...string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if !s.closed {
+		s.subscribeLocked(s.broker.channels, s.channels, channels)
+	}
+}
+
+// Unsubscribe removes channels from the subscription, all of them if none is given.
+func (s *Subscription) Unsubscribe(channels ...string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.unsubscribeLocked(s.broker.channels, s.channels, channels)
+}
+
+// PSubscribe adds glob patterns to the subscription.
+func (s *Subscription) PSubscribe(patterns ...string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if !s.closed {
+		s.subscribeLocked(s.broker.patterns, s.patterns, patterns)
+	}
+}
+
+// PUnsubscribe removes glob patterns from the subscription, all of them if none is given.
+func (s *Subscription) PUnsubscribe(patterns ...string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.unsubscribeLocked(s.broker.patterns, s.patterns, patterns)
+}
+
+// Channels returns the channels of the subscription, sorted.
+func (s *Subscription) Channels() []string {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return sortedNames(s.channels)
+}
+
+// Patterns returns the patterns of the subscription, sorted.
+func (s *Subscription) Patterns() []string {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return sortedNames(s.patterns)
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.close(nil)
+}
+
+func (s *Subscription) close(err error) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.closeLocked(err)
+}
+
+// closeLocked removes the subscription from the broker, the caller must hold broker.mu.
+func (s *Subscription) closeLocked(err error) {
+	if s.closed {
+		return
+	}
+	s.unsubscribeLocked(s.broker.channels, s.channels, nil)
+	s.unsubscribeLocked(s.broker.patterns, s.patterns, nil)
+	s.closed = true
+	s.err = err
+	close(s.done)
+}
+
+// subscribeLocked adds names to the subscription, in both the index of the
+// broker and own. The caller must hold broker.mu.
+func (s *Subscription) subscribeLocked(index map[string]map[*Subscription]struct{}, own map[string]struct{}, names []string) {
+	for _, name := range names {
+		subscriptions, ok := index[name]
+		if !ok {
+			subscriptions = make(map[*Subscription]struct{})
+			index[name] = subscriptions
+		}
+		subscriptions[s] = struct{}{}
+		own[name] = struct{}{}
+	}
+}
+
+// unsubscribeLocked removes names from the subscription, all of its names if
+// names is empty. The caller must hold broker.mu.
+func (s *Subscription) unsubscribeLocked(index map[string]map[*Subscription]struct{}, own map[string]struct{}, names []string) {
+	if len(names) == 0 {
+		names = sortedNames(own)
+	}
+	for _, name := range names {
+		if _, ok := own[name]; !ok {
+			continue
+		}
+		delete(own, name)
+		delete(index[name], s)
+		if len(index[name]) == 0 {
+			delete(index, name)
+		}
+	}
+}
+
+func sortedNames(names map[string]struct{}) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, s *Subscription) Message {
+	select {
+	case message := <-s.Messages():
+		return message
+	default:
+		require.Fail(t, "no pending message")
+		return Message{}
+	}
+}
+
+func TestBroker_PublishSubscribe(t *testing.T) {
+	broker := NewBroker(0)
+	orders := broker.Subscribe([]string{"orders"}, nil)
+	events := broker.Subscribe(nil, []string{"orders.*", "user?"})
+	defer orders.Close()
+	defer events.Close()
+
+	assert.Equal(t, 1, broker.Publish("orders", "created"))
+	assert.Equal(t, Message{Channel: "orders", Payload: "created"}, receive(t, orders))
+
+	assert.Equal(t, 1, broker.Publish("orders.eu", "shipped"))
+	assert.Equal(t, Message{Channel: "orders.eu", Pattern: "orders.*", Payload: "shipped"}, receive(t, events))
+	assert.Equal(t, 0, broker.Publish("accounts", "ignored"))
+
+	orders.Subscribe("users")
+	orders.PSubscribe("orders.*")
+	assert.Equal(t, []string{"orders", "users"}, orders.Channels())
+	assert.Equal(t, []string{"orders.*"}, orders.Patterns())
+	assert.Equal(t, 2, broker.Publish("orders.us", "paid"))
+	receive(t, orders)
+	receive(t, events)
+
+	orders.Unsubscribe()
+	orders.PUnsubscribe("orders.*")
+	assert.Empty(t, orders.Channels())
+	assert.Equal(t, 0, broker.Publish("orders", "nobody"))
+	assert.Equal(t, 1, broker.Publish("user1", "matched"))
+	receive(t, events)
+}
+
+func TestBroker_SlowConsumer(t *testing.T) {
+	broker := NewBroker(2)
+	slow := broker.Subscribe([]string{"ticks"}, nil)
+	fast := broker.Subscribe([]string{"ticks"}, nil)
+
+	for i := 0; i < 3; i++ {
+		broker.Publish("ticks", fmt.Sprint(i))
+		if i < 2 {
+			receive(t, fast)
+		}
+	}
+
+	<-slow.Done()
+	assert.ErrorIs(t, slow.Err(), ErrSlowConsumer)
+	assert.Equal(t, "0", receive(t, slow).Payload)
+	assert.NoError(t, fast.Err())
+	assert.Equal(t, 1, broker.Publish("ticks", "3"))
+
+	fast.Close()
+	<-fast.Done()
+	assert.NoError(t, fast.Err())
+	assert.Equal(t, 0, broker.Publish("ticks", "4"))
+}
+
+func TestBroker_Close(t *testing.T) {
+	broker := NewBroker(0)
+	s := broker.Subscribe([]string{"a"}, []string{"b*"})
+	broker.Close()
+	<-s.Done()
+	assert.ErrorIs(t, s.Err(), ErrClosed)
+
+	late := broker.Subscribe([]string{"a"}, nil)
+	<-late.Done()
+	assert.ErrorIs(t, late.Err(), ErrClosed)
+	assert.Equal(t, 0, broker.Publish("a", "message"))
+}
+
+func TestBroker_Concurrency(t *testing.T) {
+	broker := NewBroker(1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := broker.Subscribe([]string{"chat"}, []string{"ch*"})
+			for j := 0; j < 50; j++ {
+				broker.Publish("chat", fmt.Sprint(i, j))
+			}
+			s.Unsubscribe("chat")
+			s.Close()
+		}(i)
+	}
+	wg.Wait()
+	assert.Empty(t, broker.channels)
+	assert.Empty(t, broker.patterns)
+}
@@ -0,0 +1,1061 @@
+// Package raft replicates a log of commands across a cluster of nodes with
+// the Raft consensus algorithm.
+//
+// A leader is elected by a majority of the servers of the cluster. Commands
+// are proposed to the leader, which appends them to its log and replicates
+// them to the other servers; a command is committed once a majority stored
+// it, and committed entries are applied in order to the state machine of
+// every node. Committed entries survive the failure of any minority of the
+// servers.
+//
+// ReadIndex lets the leader serve linearizable reads without appending to
+// the log: it confirms with a majority that it is still the leader, then
+// waits for the state machine to catch up with the entries committed when
+// the read started.
+//
+// Servers are added and removed one at a time through configuration entries,
+// which take effect as soon as they are appended. The log is compacted by
+// snapshots of the state machine, sent to the servers lagging behind them.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dmarro89/dare-db/logger"
+)
+
+const DEFAULT_HEARTBEAT_INTERVAL = 100 * time.Millisecond
+const DEFAULT_ELECTION_TIMEOUT = time.Second
+
+// DEFAULT_SNAPSHOT_THRESHOLD is the number of entries applied after a
+// snapshot before the next one is taken and the log compacted.
+const DEFAULT_SNAPSHOT_THRESHOLD = 8192
+
+// MAX_APPEND_ENTRIES is the maximum number of entries sent in a single request.
+const MAX_APPEND_ENTRIES = 256
+
+// Roles of a node
+const (
+	ROLE_FOLLOWER  = "follower"
+	ROLE_CANDIDATE = "candidate"
+	ROLE_LEADER    = "leader"
+)
+
+var ErrNotLeader = errors.New("raft node is not the leader")
+var ErrNotReady = errors.New("raft leader is still applying the entries of the previous leaders")
+var ErrLeadershipLost = errors.New("raft leadership lost before the entry was committed")
+var ErrConfigurationChange = errors.New("a configuration change is already in progress")
+var ErrUnknownServer = errors.New("unknown raft server")
+var ErrStopped = errors.New("raft node stopped")
+
+type EntryType string
+
+const (
+	ENTRY_COMMAND EntryType = "command"
+	// ENTRY_NOOP is appended by every new leader, committing the entries of the previous terms.
+	ENTRY_NOOP EntryType = "noop"
+	// ENTRY_CONFIGURATION holds the servers of the cluster, encoded as JSON.
+	ENTRY_CONFIGURATION EntryType = "configuration"
+)
+
+// Entry is an entry of the replicated log.
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// Server is a member of the cluster. Address is passed to the Transport.
+type Server struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// Snapshot is the state of the state machine after applying the entries up
+// to Index, replacing them in the log. Servers is the configuration at Index.
+type Snapshot struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Servers []Server `json:"servers"`
+	Data    []byte   `json:"data,omitempty"`
+}
+
+// StateMachine is the state replicated by a Node.
+type StateMachine interface {
+	// Apply applies a committed entry. Every entry is applied once, in index
+	// order, including the noop and configuration entries.
+	Apply(entry Entry)
+	// Snapshot returns the state and the index of the last entry it
+	// includes, which must be committed.
+	Snapshot() ([]byte, uint64, error)
+	// Restore replaces the state with the one of a snapshot.
+	Restore(snapshot Snapshot) error
+}
+
+// Transport sends the requests of a node to the other servers. The receiving
+// node answers them with HandleRequestVote, HandleAppendEntries and
+// HandleInstallSnapshot.
+type Transport interface {
+	RequestVote(ctx context.Context, server Server, request *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, server Server, request *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, server Server, request *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+// Config configures a Node. Zero durations and thresholds select the defaults.
+type Config struct {
+	// ID identifies the node among Servers
+	ID string
+	// Servers is the initial configuration of the cluster, used until the
+	// log holds a configuration entry. A node joining an existing cluster
+	// starts without servers and waits for the leader to add it.
+	Servers           []Server
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is the minimum time without a leader before a follower
+	// starts an election, randomized up to twice its value.
+	ElectionTimeout   time.Duration
+	SnapshotThreshold uint64
+	Logger            logger.Logger
+}
+
+// Node is a member of a Raft cluster.
+type Node struct {
+	id                string
+	bootstrap         []Server
+	heartbeatInterval time.Duration
+	electionTimeout   time.Duration
+	snapshotThreshold uint64
+	sm                StateMachine
+	storage           Storage
+	transport         Transport
+	logger            logger.Logger
+
+	mu          sync.Mutex
+	role        string
+	term        uint64
+	vote        string
+	leader      string
+	servers     []Server
+	configIndex uint64
+	snapshot    Snapshot
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+	// termStart is the index of the noop entry appended when the node became leader
+	termStart        uint64
+	leaderSince      time.Time
+	electionDeadline time.Time
+	peers            map[string]*peer
+	readRound        uint64
+	pendingSnapshot  *Snapshot
+	// changed is closed and replaced whenever the state of the node changes
+	changed chan struct{}
+	started bool
+	stopped bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// peer is the replication state of a follower, kept by the leader.
+type peer struct {
+	server     Server
+	nextIndex  uint64
+	matchIndex uint64
+	inflight   bool
+	pending    bool
+	// ackedRound is the last read round confirmed by the follower
+	ackedRound  uint64
+	lastContact time.Time
+}
+
+// NewNode creates a node, restoring the snapshot and the log persisted in
+// storage. The entries of the log are applied again once known to be committed.
+func NewNode(config Config, sm StateMachine, storage Storage, transport Transport) (*Node, error) {
+	if config.ID == "" {
+		return nil, errors.New("raft node id cannot be empty")
+	}
+	n := &Node{
+		id:                config.ID,
+		bootstrap:         append([]Server(nil), config.Servers...),
+		heartbeatInterval: config.HeartbeatInterval,
+		electionTimeout:   config.ElectionTimeout,
+		snapshotThreshold: config.SnapshotThreshold,
+		sm:                sm,
+		storage:           storage,
+		transport:         transport,
+		logger:            config.Logger,
+		role:              ROLE_FOLLOWER,
+		changed:           make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	if n.heartbeatInterval <= 0 {
+		n.heartbeatInterval = DEFAULT_HEARTBEAT_INTERVAL
+	}
+	if n.electionTimeout <= 0 {
+		n.electionTimeout = DEFAULT_ELECTION_TIMEOUT
+	}
+	if n.snapshotThreshold == 0 {
+		n.snapshotThreshold = DEFAULT_SNAPSHOT_THRESHOLD
+	}
+	if n.logger == nil {
+		n.logger = logger.NewDareLogger()
+	}
+
+	state, snapshot, entries, err := storage.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the raft state: %w", err)
+	}
+	n.term, n.vote = state.Term, state.Vote
+	if snapshot != nil {
+		if err := sm.Restore(*snapshot); err != nil {
+			return nil, fmt.Errorf("failed to restore the raft snapshot: %w", err)
+		}
+		n.snapshot = *snapshot
+		n.commitIndex = snapshot.Index
+		n.lastApplied = snapshot.Index
+	}
+	n.log = entries
+	n.recomputeConfigurationLocked()
+	return n, nil
+}
+
+// Start runs the node in the background until Stop is called.
+func (n *Node) Start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.started {
+		return
+	}
+	n.started = true
+	n.resetElectionLocked()
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+}
+
+// Stop stops the node, failing the pending proposals and reads with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.done)
+	n.notifyLocked()
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// ID returns the id of the node.
+func (n *Node) ID() string {
+	return n.id
+}
+
+// IsLeader reports whether the node believes it is the leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == ROLE_LEADER
+}
+
+// Leader returns the last known leader, false if none is known.
+func (n *Node) Leader() (Server, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.leader == "" {
+		return Server{}, false
+	}
+	for _, server := range n.servers {
+		if server.ID == n.leader {
+			return server, true
+		}
+	}
+	return Server{ID: n.leader}, true
+}
+
+// CommitIndex returns the index of the last committed entry known to the node.
+func (n *Node) CommitIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.commitIndex
+}
+
+// AppliedIndex returns the index of the last entry applied to the state machine.
+func (n *Node) AppliedIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastApplied
+}
+
+// Propose appends a command to the log of the leader and waits until it is
+// committed. It returns the index and the term of the entry; if the entry
+// was appended but the wait failed, with ErrLeadershipLost or the error of
+// ctx, the entry may still be committed later. Proposals are rejected with
+// ErrNotReady until the leader applied the entries of the previous terms.
+func (n *Node) Propose(ctx context.Context, command []byte) (uint64, uint64, error) {
+	n.mu.Lock()
+	if err := n.checkLeaderLocked(); err != nil {
+		n.mu.Unlock()
+		return 0, 0, err
+	}
+	if n.lastApplied < n.termStart {
+		n.mu.Unlock()
+		return 0, 0, ErrNotReady
+	}
+	entry, err := n.appendNewLocked(ENTRY_COMMAND, command)
+	n.mu.Unlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	return entry.Index, entry.Term, n.waitCommitted(ctx, entry)
+}
+
+// ReadIndex waits until a read of the state machine reflects every entry
+// committed before the call, making the read linearizable. It fails with
+// ErrNotLeader unless the node is the leader and a majority confirms it.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	var term, readIndex, round uint64
+	err := n.wait(ctx, func() (bool, error) {
+		if err := n.checkLeaderLocked(); err != nil {
+			return false, err
+		}
+		// The commit index is only known once an entry of the term is committed
+		if n.commitIndex < n.termStart {
+			return false, nil
+		}
+		term, readIndex = n.term, n.commitIndex
+		n.readRound++
+		round = n.readRound
+		n.broadcastLocked()
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = n.wait(ctx, func() (bool, error) {
+		if n.role != ROLE_LEADER || n.term != term {
+			return false, ErrNotLeader
+		}
+		acks := 0
+		if n.isVoterLocked(n.id) {
+			acks++
+		}
+		for _, p := range n.peers {
+			if p.ackedRound >= round && n.isVoterLocked(p.server.ID) {
+				acks++
+			}
+		}
+		return acks >= n.quorumLocked(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return n.wait(ctx, func() (bool, error) {
+		return n.lastApplied >= readIndex, nil
+	})
+}
+
+// AddServer adds a server to the cluster, or changes its address, and waits
+// until the new configuration is committed. It must be called on the leader.
+func (n *Node) AddServer(ctx context.Context, server Server) error {
+	if server.ID == "" || server.Address == "" {
+		return errors.New("raft server id and address cannot be empty")
+	}
+	return n.changeConfiguration(ctx, func(servers []Server) ([]Server, error) {
+		for i := range servers {
+			if servers[i].ID == server.ID {
+				servers[i] = server
+				return servers, nil
+			}
+		}
+		return append(servers, server), nil
+	})
+}
+
+// RemoveServer removes a server from the cluster and waits until the new
+// configuration is committed. A leader removing itself steps down afterwards.
+func (n *Node) RemoveServer(ctx context.Context, id string) error {
+	return n.changeConfiguration(ctx, func(servers []Server) ([]Server, error) {
+		for i := range servers {
+			if servers[i].ID == id {
+				return append(servers[:i], servers[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w %q", ErrUnknownServer, id)
+	})
+}
+
+func (n *Node) changeConfiguration(ctx context.Context, change func(servers []Server) ([]Server, error)) error {
+	n.mu.Lock()
+	if err := n.checkLeaderLocked(); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	// A single change at a time keeps a majority of the old and of the new
+	// configuration overlapping
+	if n.configIndex > n.commitIndex || n.commitIndex < n.termStart {
+		n.mu.Unlock()
+		return ErrConfigurationChange
+	}
+	servers, err := change(append([]Server(nil), n.servers...))
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	entry, err := n.appendNewLocked(ENTRY_CONFIGURATION, data)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return n.waitCommitted(ctx, entry)
+}
+
+// TakeSnapshot snapshots the state machine and compacts the log.
+func (n *Node) TakeSnapshot() error {
+	data, index, err := n.sm.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index <= n.snapshot.Index {
+		return nil
+	}
+	if index > n.commitIndex {
+		return fmt.Errorf("raft snapshot at index %d includes uncommitted entries", index)
+	}
+	term, _ := n.termAtLocked(index)
+	snapshot := Snapshot{Index: index, Term: term, Servers: n.configurationAtLocked(index), Data: data}
+	remaining := append([]Entry(nil), n.log[index-n.snapshot.Index:]...)
+	if err := n.storage.SaveSnapshot(&snapshot, remaining); err != nil {
+		return fmt.Errorf("failed to save the raft snapshot: %w", err)
+	}
+	n.snapshot = snapshot
+	n.log = remaining
+	if index > n.lastApplied {
+		n.lastApplied = index
+		n.notifyLocked()
+	}
+	return nil
+}
+
+// ServerStatus describes a member of the cluster. MatchIndex, the last entry
+// known to be stored by the server, is only reported by the leader.
+type ServerStatus struct {
+	ID          string     `json:"id"`
+	Address     string     `json:"address"`
+	MatchIndex  uint64     `json:"match_index,omitempty"`
+	LastContact *time.Time `json:"last_contact,omitempty"`
+}
+
+// Status describes the state of a node.
+type Status struct {
+	ID            string         `json:"id"`
+	Role          string         `json:"role"`
+	Term          uint64         `json:"term"`
+	Leader        string         `json:"leader,omitempty"`
+	CommitIndex   uint64         `json:"commit_index"`
+	AppliedIndex  uint64         `json:"applied_index"`
+	LastIndex     uint64         `json:"last_index"`
+	SnapshotIndex uint64         `json:"snapshot_index"`
+	Servers       []ServerStatus `json:"servers"`
+}
+
+// Status returns the state of the node.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	lastIndex, _ := n.lastLocked()
+	status := Status{
+		ID:            n.id,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		LastIndex:     lastIndex,
+		SnapshotIndex: n.snapshot.Index,
+		Servers:       make([]ServerStatus, 0, len(n.servers)),
+	}
+	for _, server := range n.servers {
+		serverStatus := ServerStatus{ID: server.ID, Address: server.Address}
+		if server.ID == n.id && n.role == ROLE_LEADER {
+			serverStatus.MatchIndex = lastIndex
+		} else if p, ok := n.peers[server.ID]; ok {
+			serverStatus.MatchIndex = p.matchIndex
+			if !p.lastContact.IsZero() {
+				lastContact := p.lastContact
+				serverStatus.LastContact = &lastContact
+			}
+		}
+		status.Servers = append(status.Servers, serverStatus)
+	}
+	return status
+}
+
+// Log returns the latest snapshot, nil if none, and the committed entries
+// following it up to index. A state machine rebuilds its state from them
+// when it cannot undo a change it made outside of the log.
+func (n *Node) Log(index uint64) (*Snapshot, []Entry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var snapshot *Snapshot
+	if n.snapshot.Index > 0 {
+		current := n.snapshot
+		snapshot = &current
+	}
+	if index <= n.snapshot.Index {
+		return snapshot, nil
+	}
+	index = min(index, n.commitIndex)
+	return snapshot, append([]Entry(nil), n.log[:index-n.snapshot.Index]...)
+}
+
+// run drives the elections and the heartbeats of the leader.
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.role == ROLE_LEADER {
+			n.checkQuorumLocked()
+		}
+		if n.role == ROLE_LEADER {
+			n.broadcastLocked()
+		} else if time.Now().After(n.electionDeadline) && n.isVoterLocked(n.id) {
+			n.campaignLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// checkQuorumLocked steps down a leader which has not heard from a majority
+// of the servers for an election timeout, so that clients look for the new
+// leader instead of waiting for a leader cut off from the cluster.
+func (n *Node) checkQuorumLocked() {
+	contacts := 0
+	if n.isVoterLocked(n.id) {
+		contacts++
+	}
+	deadline := time.Now().Add(-n.electionTimeout)
+	for _, p := range n.peers {
+		if n.isVoterLocked(p.server.ID) && p.lastContact.After(deadline) {
+			contacts++
+		}
+	}
+	if contacts < n.quorumLocked() && time.Since(n.leaderSince) > n.electionTimeout {
+		n.logger.Warn("Raft leader ", n.id, " lost contact with a majority of the cluster, stepping down")
+		n.becomeFollowerLocked(n.term, "")
+	}
+}
+
+// campaignLocked starts an election for the next term.
+func (n *Node) campaignLocked() {
+	n.role = ROLE_CANDIDATE
+	n.term++
+	n.vote = n.id
+	n.leader = ""
+	n.resetElectionLocked()
+	if err := n.persistStateLocked(); err != nil {
+		n.becomeFollowerLocked(n.term, "")
+		return
+	}
+	n.logger.Info("Raft node ", n.id, " starting an election for term ", n.term)
+
+	term := n.term
+	votes := 1
+	if votes >= n.quorumLocked() {
+		n.becomeLeaderLocked()
+		return
+	}
+	lastIndex, lastTerm := n.lastLocked()
+	request := &VoteRequest{Term: term, Candidate: n.id, LastLogIndex: lastIndex, LastLogTerm: lastTerm}
+	for _, server := range n.servers {
+		if server.ID == n.id {
+			continue
+		}
+		go func(server Server) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+			defer cancel()
+			response, err := n.transport.RequestVote(ctx, server, request)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if response.Term > n.term {
+				n.becomeFollowerLocked(response.Term, "")
+				return
+			}
+			if n.role != ROLE_CANDIDATE || n.term != term || !response.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorumLocked() {
+				n.becomeLeaderLocked()
+			}
+		}(server)
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	n.logger.Info("Raft node ", n.id, " elected leader for term ", n.term)
+	n.role = ROLE_LEADER
+	n.leader = n.id
+	n.leaderSince = time.Now()
+	n.peers = make(map[string]*peer)
+	n.syncPeersLocked()
+	// Entries of the previous terms are only committed along with an entry of this term
+	entry, err := n.appendNewLocked(ENTRY_NOOP, nil)
+	if err != nil {
+		n.becomeFollowerLocked(n.term, "")
+		return
+	}
+	n.termStart = entry.Index
+	n.notifyLocked()
+}
+
+// becomeFollowerLocked makes the node a follower of leader in term, which
+// may be unknown.
+func (n *Node) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.vote = ""
+		n.persistStateLocked()
+	}
+	if n.role == ROLE_LEADER {
+		n.logger.Info("Raft node ", n.id, " is no longer the leader")
+	}
+	n.role = ROLE_FOLLOWER
+	n.leader = leader
+	n.peers = nil
+	n.resetElectionLocked()
+	n.notifyLocked()
+}
+
+// appendNewLocked appends an entry of the current term to the log of the
+// leader and starts replicating it.
+func (n *Node) appendNewLocked(entryType EntryType, data []byte) (Entry, error) {
+	last, _ := n.lastLocked()
+	entry := Entry{Index: last + 1, Term: n.term, Type: entryType, Data: data}
+	if err := n.appendLocked([]Entry{entry}); err != nil {
+		return Entry{}, err
+	}
+	n.advanceCommitLocked()
+	n.broadcastLocked()
+	return entry, nil
+}
+
+// appendLocked stores entries at the end of the log. Configuration entries
+// take effect immediately.
+func (n *Node) appendLocked(entries []Entry) error {
+	if err := n.storage.Append(entries); err != nil {
+		n.logger.Error("Failed to append to the raft log: ", err)
+		return err
+	}
+	n.log = append(n.log, entries...)
+	for _, entry := range entries {
+		if entry.Type == ENTRY_CONFIGURATION {
+			n.recomputeConfigurationLocked()
+			break
+		}
+	}
+	return nil
+}
+
+// truncateLocked removes the entries from index on.
+func (n *Node) truncateLocked(index uint64) error {
+	if err := n.storage.Truncate(index); err != nil {
+		n.logger.Error("Failed to truncate the raft log: ", err)
+		return err
+	}
+	n.log = n.log[:index-n.snapshot.Index-1]
+	n.recomputeConfigurationLocked()
+	return nil
+}
+
+// recomputeConfigurationLocked takes the configuration of the last
+// configuration entry of the log, or else of the snapshot.
+func (n *Node) recomputeConfigurationLocked() {
+	last, _ := n.lastLocked()
+	n.servers = n.configurationAtLocked(last)
+	n.configIndex = n.snapshot.Index
+	for i := len(n.log) - 1; i >= 0; i-- {
+		if n.log[i].Type == ENTRY_CONFIGURATION {
+			n.configIndex = n.log[i].Index
+			break
+		}
+	}
+	if n.role == ROLE_LEADER {
+		n.syncPeersLocked()
+	}
+}
+
+// configurationAtLocked returns the servers of the configuration in effect at index.
+func (n *Node) configurationAtLocked(index uint64) []Server {
+	for i := len(n.log) - 1; i >= 0; i-- {
+		entry := n.log[i]
+		if entry.Index > index || entry.Type != ENTRY_CONFIGURATION {
+			continue
+		}
+		var servers []Server
+		if err := json.Unmarshal(entry.Data, &servers); err != nil {
+			n.logger.Error("Invalid raft configuration entry ", entry.Index, ": ", err)
+			continue
+		}
+		return servers
+	}
+	if n.snapshot.Index > 0 {
+		return append([]Server(nil), n.snapshot.Servers...)
+	}
+	return append([]Server(nil), n.bootstrap...)
+}
+
+// syncPeersLocked creates the replication state of the servers added to the
+// configuration and drops the one of the servers removed.
+func (n *Node) syncPeersLocked() {
+	last, _ := n.lastLocked()
+	members := make(map[string]bool, len(n.servers))
+	for _, server := range n.servers {
+		members[server.ID] = true
+		if server.ID == n.id {
+			continue
+		}
+		if p, ok := n.peers[server.ID]; ok {
+			p.server = server
+			continue
+		}
+		n.peers[server.ID] = &peer{server: server, nextIndex: last + 1}
+	}
+	for id := range n.peers {
+		if !members[id] {
+			delete(n.peers, id)
+		}
+	}
+}
+
+// advanceCommitLocked commits the last entry of the current term stored by
+// a majority, and every entry preceding it.
+func (n *Node) advanceCommitLocked() {
+	if n.role != ROLE_LEADER {
+		return
+	}
+	last, _ := n.lastLocked()
+	for index := last; index > n.commitIndex; index-- {
+		if term, _ := n.termAtLocked(index); term != n.term {
+			break
+		}
+		stored := 0
+		if n.isVoterLocked(n.id) {
+			stored++
+		}
+		for _, p := range n.peers {
+			if p.matchIndex >= index && n.isVoterLocked(p.server.ID) {
+				stored++
+			}
+		}
+		if stored >= n.quorumLocked() {
+			n.commitIndex = index
+			n.notifyLocked()
+			break
+		}
+	}
+
+	if n.configIndex <= n.commitIndex && !n.isVoterLocked(n.id) {
+		n.logger.Info("Raft leader ", n.id, " removed from the cluster, stepping down")
+		n.becomeFollowerLocked(n.term, "")
+	}
+}
+
+// broadcastLocked sends the missing entries, or a heartbeat, to every follower.
+func (n *Node) broadcastLocked() {
+	for _, p := range n.peers {
+		n.replicateLocked(p)
+	}
+}
+
+// replicateLocked sends the missing entries to p, unless a request to p is
+// already in flight, in which case another one follows its response.
+func (n *Node) replicateLocked(p *peer) {
+	if n.stopped {
+		return
+	}
+	if p.inflight {
+		p.pending = true
+		return
+	}
+	p.inflight = true
+	go n.replicate(p, n.term)
+}
+
+// replicate sends requests to p until it stores the whole log of the leader of term.
+func (n *Node) replicate(p *peer, term uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	defer func() { p.inflight = false }()
+
+	for !n.stopped && n.role == ROLE_LEADER && n.term == term && n.peers[p.server.ID] == p {
+		p.pending = false
+		round := n.readRound
+
+		if p.nextIndex <= n.snapshot.Index {
+			request := &SnapshotRequest{Term: term, Leader: n.id, Snapshot: n.snapshot}
+			n.mu.Unlock()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*n.electionTimeout)
+			response, err := n.transport.InstallSnapshot(ctx, p.server, request)
+			cancel()
+			n.mu.Lock()
+			if err != nil {
+				n.logger.Debug("Failed to send the raft snapshot to ", p.server.ID, ": ", err)
+				return
+			}
+			if response.Term > n.term {
+				n.becomeFollowerLocked(response.Term, "")
+				return
+			}
+			if n.role != ROLE_LEADER || n.term != term {
+				return
+			}
+			p.matchIndex = max(p.matchIndex, request.Snapshot.Index)
+			p.nextIndex = p.matchIndex + 1
+		} else {
+			prevIndex := p.nextIndex - 1
+			prevTerm, _ := n.termAtLocked(prevIndex)
+			last, _ := n.lastLocked()
+			end := min(last, prevIndex+MAX_APPEND_ENTRIES)
+			request := &AppendRequest{
+				Term:         term,
+				Leader:       n.id,
+				PrevLogIndex: prevIndex,
+				PrevLogTerm:  prevTerm,
+				Entries:      append([]Entry(nil), n.log[prevIndex-n.snapshot.Index:end-n.snapshot.Index]...),
+				LeaderCommit: n.commitIndex,
+			}
+			n.mu.Unlock()
+			ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+			response, err := n.transport.AppendEntries(ctx, p.server, request)
+			cancel()
+			n.mu.Lock()
+			if err != nil {
+				n.logger.Debug("Failed to replicate the raft log to ", p.server.ID, ": ", err)
+				return
+			}
+			if response.Term > n.term {
+				n.becomeFollowerLocked(response.Term, "")
+				return
+			}
+			if n.role != ROLE_LEADER || n.term != term {
+				return
+			}
+			if response.Success {
+				p.matchIndex = max(p.matchIndex, end)
+				p.nextIndex = p.matchIndex + 1
+				n.advanceCommitLocked()
+			} else {
+				p.nextIndex = max(1, min(p.nextIndex-1, response.LastIndex+1))
+			}
+		}
+
+		p.lastContact = time.Now()
+		p.ackedRound = max(p.ackedRound, round)
+		n.notifyLocked()
+		if last, _ := n.lastLocked(); !p.pending && p.nextIndex > last {
+			return
+		}
+	}
+}
+
+// applyLoop applies the committed entries and the snapshots received from
+// the leader to the state machine, and takes snapshots.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+
+		if snapshot := n.pendingSnapshot; snapshot != nil {
+			n.pendingSnapshot = nil
+			n.mu.Unlock()
+			if err := n.sm.Restore(*snapshot); err != nil {
+				n.logger.Error("Failed to restore the raft snapshot ", snapshot.Index, ": ", err)
+			}
+			n.mu.Lock()
+			n.lastApplied = max(n.lastApplied, snapshot.Index)
+			n.notifyLocked()
+			n.mu.Unlock()
+			continue
+		}
+
+		if n.commitIndex > n.lastApplied {
+			if n.lastApplied < n.snapshot.Index {
+				n.lastApplied = n.snapshot.Index
+				n.mu.Unlock()
+				continue
+			}
+			from := n.lastApplied + 1
+			to := min(n.commitIndex, n.lastApplied+MAX_APPEND_ENTRIES)
+			entries := append([]Entry(nil), n.log[from-n.snapshot.Index-1:to-n.snapshot.Index]...)
+			n.mu.Unlock()
+
+			for _, entry := range entries {
+				n.sm.Apply(entry)
+				n.mu.Lock()
+				n.lastApplied = max(n.lastApplied, entry.Index)
+				n.notifyLocked()
+				n.mu.Unlock()
+			}
+
+			n.mu.Lock()
+			due := n.lastApplied-n.snapshot.Index >= n.snapshotThreshold
+			n.mu.Unlock()
+			if due {
+				if err := n.TakeSnapshot(); err != nil {
+					n.logger.Debug("Raft snapshot postponed: ", err)
+				}
+			}
+			continue
+		}
+
+		changed := n.changed
+		n.mu.Unlock()
+		select {
+		case <-changed:
+		case <-n.done:
+		}
+	}
+}
+
+// waitCommitted waits until entry is committed, or replaced by the entry of
+// another leader.
+func (n *Node) waitCommitted(ctx context.Context, entry Entry) error {
+	return n.wait(ctx, func() (bool, error) {
+		if n.commitIndex >= entry.Index {
+			if term, ok := n.termAtLocked(entry.Index); ok && term != entry.Term {
+				return false, ErrLeadershipLost
+			}
+			return true, nil
+		}
+		if n.term != entry.Term || n.role != ROLE_LEADER {
+			return false, ErrLeadershipLost
+		}
+		return false, nil
+	})
+}
+
+// wait calls condition while holding n.mu, whenever the state of the node
+// changes, until it returns true or an error.
+func (n *Node) wait(ctx context.Context, condition func() (bool, error)) error {
+	for {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			return ErrStopped
+		}
+		ok, err := condition()
+		changed := n.changed
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (n *Node) notifyLocked() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *Node) checkLeaderLocked() error {
+	if n.stopped {
+		return ErrStopped
+	}
+	if n.role != ROLE_LEADER {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+func (n *Node) resetElectionLocked() {
+	timeout := n.electionTimeout + time.Duration(rand.Int63n(int64(n.electionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) persistStateLocked() error {
+	if err := n.storage.SaveState(HardState{Term: n.term, Vote: n.vote}); err != nil {
+		n.logger.Error("Failed to persist the raft state: ", err)
+		return err
+	}
+	return nil
+}
+
+func (n *Node) isVoterLocked(id string) bool {
+	for _, server := range n.servers {
+		if server.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) quorumLocked() int {
+	return len(n.servers)/2 + 1
+}
+
+// lastLocked returns the index and the term of the last entry of the log.
+func (n *Node) lastLocked() (uint64, uint64) {
+	if len(n.log) == 0 {
+		return n.snapshot.Index, n.snapshot.Term
+	}
+	last := n.log[len(n.log)-1]
+	return last.Index, last.Term
+}
+
+// termAtLocked returns the term of the entry at index, false if the entry is
+// not in the log nor the last one of the snapshot.
+func (n *Node) termAtLocked(index uint64) (uint64, bool) {
+	if index == n.snapshot.Index {
+		return n.snapshot.Term, true
+	}
+	if index < n.snapshot.Index || index-n.snapshot.Index > uint64(len(n.log)) {
+		return 0, false
+	}
+	return n.log[index-n.snapshot.Index-1].Term, true
+}
@@ -0,0 +1,377 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHeartbeat = 20 * time.Millisecond
+const testElectionTimeout = 150 * time.Millisecond
+
+// kvStateMachine applies commands of the form key=value.
+type kvStateMachine struct {
+	mu      sync.Mutex
+	values  map[string]string
+	applied uint64
+}
+
+func newKVStateMachine() *kvStateMachine {
+	return &kvStateMachine{values: make(map[string]string)}
+}
+
+func (sm *kvStateMachine) Apply(entry Entry) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if entry.Type == ENTRY_COMMAND {
+		key, value, _ := strings.Cut(string(entry.Data), "=")
+		sm.values[key] = value
+	}
+	sm.applied = entry.Index
+}
+
+func (sm *kvStateMachine) Snapshot() ([]byte, uint64, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	data, err := json.Marshal(sm.values)
+	return data, sm.applied, err
+}
+
+func (sm *kvStateMachine) Restore(snapshot Snapshot) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	values := make(map[string]string)
+	if err := json.Unmarshal(snapshot.Data, &values); err != nil {
+		return err
+	}
+	sm.values = values
+	sm.applied = snapshot.Index
+	return nil
+}
+
+func (sm *kvStateMachine) get(key string) string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.values[key]
+}
+
+// memoryNetwork delivers the requests between the nodes of a test cluster,
+// except to and from the disconnected ones.
+type memoryNetwork struct {
+	mu           sync.Mutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+func (network *memoryNetwork) node(from string, to Server) (*Node, error) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	node, ok := network.nodes[to.Address]
+	if !ok || network.disconnected[from] || network.disconnected[to.Address] {
+		return nil, errors.New("unreachable")
+	}
+	return node, nil
+}
+
+func (network *memoryNetwork) setConnected(id string, connected bool) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.disconnected[id] = !connected
+}
+
+type memoryTransport struct {
+	network *memoryNetwork
+	id      string
+}
+
+func (t *memoryTransport) RequestVote(ctx context.Context, server Server, request *VoteRequest) (*VoteResponse, error) {
+	node, err := t.network.node(t.id, server)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(request), nil
+}
+
+func (t *memoryTransport) AppendEntries(ctx context.Context, server Server, request *AppendRequest) (*AppendResponse, error) {
+	node, err := t.network.node(t.id, server)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleAppendEntries(request), nil
+}
+
+func (t *memoryTransport) InstallSnapshot(ctx context.Context, server Server, request *SnapshotRequest) (*SnapshotResponse, error) {
+	node, err := t.network.node(t.id, server)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleInstallSnapshot(request), nil
+}
+
+type testCluster struct {
+	t       *testing.T
+	network *memoryNetwork
+	nodes   map[string]*Node
+	sms     map[string]*kvStateMachine
+}
+
+// newTestCluster starts a cluster of size nodes named n1, n2...
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	cluster := &testCluster{
+		t:       t,
+		network: &memoryNetwork{nodes: make(map[string]*Node), disconnected: make(map[string]bool)},
+		nodes:   make(map[string]*Node),
+		sms:     make(map[string]*kvStateMachine),
+	}
+	var servers []Server
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		servers = append(servers, Server{ID: id, Address: id})
+	}
+	for _, server := range servers {
+		cluster.start(server.ID, servers, NewMemoryStorage(), snapshotThreshold)
+	}
+	return cluster
+}
+
+func (cluster *testCluster) start(id string, servers []Server, storage Storage, snapshotThreshold uint64) *Node {
+	sm := newKVStateMachine()
+	node, err := NewNode(Config{
+		ID:                id,
+		Servers:           servers,
+		HeartbeatInterval: testHeartbeat,
+		ElectionTimeout:   testElectionTimeout,
+		SnapshotThreshold: snapshotThreshold,
+	}, sm, storage, &memoryTransport{network: cluster.network, id: id})
+	require.NoError(cluster.t, err)
+
+	cluster.network.mu.Lock()
+	cluster.network.nodes[id] = node
+	cluster.network.mu.Unlock()
+	cluster.nodes[id] = node
+	cluster.sms[id] = sm
+	node.Start()
+	cluster.t.Cleanup(node.Stop)
+	return node
+}
+
+// leader waits for a single leader among the connected nodes.
+func (cluster *testCluster) leader() *Node {
+	var leader *Node
+	require.Eventually(cluster.t, func() bool {
+		leader = nil
+		for id, node := range cluster.nodes {
+			cluster.network.mu.Lock()
+			disconnected := cluster.network.disconnected[id]
+			cluster.network.mu.Unlock()
+			if disconnected || !node.IsLeader() {
+				continue
+			}
+			if leader != nil {
+				return false
+			}
+			leader = node
+		}
+		return leader != nil
+	}, 5*time.Second, 10*time.Millisecond, "Expected a leader to be elected")
+	return leader
+}
+
+func (cluster *testCluster) propose(node *Node, command string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := node.Propose(ctx, []byte(command))
+	require.NoError(cluster.t, err)
+}
+
+// waitValue waits until the key holds value on the nodes ids.
+func (cluster *testCluster) waitValue(key string, value string, ids ...string) {
+	for _, id := range ids {
+		require.Eventually(cluster.t, func() bool {
+			return cluster.sms[id].get(key) == value
+		}, 5*time.Second, 10*time.Millisecond, "Expected %s to hold %s=%s", id, key, value)
+	}
+}
+
+// proposeOnLeader proposes a command, retrying while the leader is not ready.
+func (cluster *testCluster) proposeOnLeader(command string) *Node {
+	var leader *Node
+	require.Eventually(cluster.t, func() bool {
+		leader = cluster.leader()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, _, err := leader.Propose(ctx, []byte(command))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func TestNode_ElectionAndReplication(t *testing.T) {
+	cluster := newTestCluster(t, 3, 0)
+	leader := cluster.proposeOnLeader("key0=value0")
+	for i := 1; i < 10; i++ {
+		cluster.propose(leader, fmt.Sprintf("key%d=value%d", i, i))
+	}
+	cluster.waitValue("key9", "value9", "n1", "n2", "n3")
+
+	status := leader.Status()
+	assert.Equal(t, ROLE_LEADER, status.Role)
+	assert.Equal(t, leader.ID(), status.Leader)
+	assert.Len(t, status.Servers, 3)
+	for _, node := range cluster.nodes {
+		if node != leader {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, _, err := node.Propose(ctx, []byte("key=value"))
+			cancel()
+			assert.ErrorIs(t, err, ErrNotLeader)
+			server, known := node.Leader()
+			assert.True(t, known)
+			assert.Equal(t, leader.ID(), server.ID)
+		}
+	}
+}
+
+func TestNode_LeaderFailover(t *testing.T) {
+	cluster := newTestCluster(t, 3, 0)
+	oldLeader := cluster.proposeOnLeader("key=1")
+	cluster.waitValue("key", "1", "n1", "n2", "n3")
+
+	// The isolated leader cannot commit anything
+	cluster.network.setConnected(oldLeader.ID(), false)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	_, _, err := oldLeader.Propose(ctx, []byte("key=lost"))
+	cancel()
+	require.Error(t, err)
+
+	newLeader := cluster.proposeOnLeader("key=2")
+	assert.NotEqual(t, oldLeader.ID(), newLeader.ID())
+
+	// Once reconnected, the old leader follows and drops its uncommitted entry
+	cluster.network.setConnected(oldLeader.ID(), true)
+	cluster.propose(newLeader, "other=3")
+	cluster.waitValue("other", "3", "n1", "n2", "n3")
+	for _, sm := range cluster.sms {
+		assert.Equal(t, "2", sm.get("key"))
+	}
+	assert.False(t, oldLeader.IsLeader())
+}
+
+func TestNode_ReadIndex(t *testing.T) {
+	cluster := newTestCluster(t, 3, 0)
+	leader := cluster.proposeOnLeader("key=1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, leader.ReadIndex(ctx))
+	assert.Equal(t, "1", cluster.sms[leader.ID()].get("key"), "Expected the read to reflect the committed entries")
+
+	for _, node := range cluster.nodes {
+		if node != leader {
+			assert.ErrorIs(t, node.ReadIndex(ctx), ErrNotLeader)
+		}
+	}
+
+	// A leader cut off from the majority cannot confirm its leadership
+	cluster.network.setConnected(leader.ID(), false)
+	isolated, cancelIsolated := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancelIsolated()
+	assert.Error(t, leader.ReadIndex(isolated))
+}
+
+func TestNode_Membership(t *testing.T) {
+	cluster := newTestCluster(t, 3, 0)
+	leader := cluster.proposeOnLeader("key=1")
+
+	// A new node starts without configuration and waits to be added
+	cluster.start("n4", nil, NewMemoryStorage(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, leader.AddServer(ctx, Server{ID: "n4", Address: "n4"}))
+	cluster.waitValue("key", "1", "n4")
+	assert.Len(t, cluster.nodes["n4"].Status().Servers, 4)
+
+	follower := "n1"
+	if leader.ID() == follower {
+		follower = "n2"
+	}
+	require.NoError(t, leader.RemoveServer(ctx, follower))
+	cluster.network.setConnected(follower, false)
+	delete(cluster.nodes, follower)
+	cluster.propose(leader, "key=2")
+	cluster.waitValue("key", "2", "n4")
+	var ids []string
+	for _, server := range leader.Status().Servers {
+		ids = append(ids, server.ID)
+	}
+	assert.NotContains(t, ids, follower)
+	assert.Contains(t, ids, "n4")
+	assert.ErrorIs(t, leader.RemoveServer(ctx, "unknown"), ErrUnknownServer)
+
+	// The leader removing itself steps down, the others elect a new leader
+	require.NoError(t, leader.RemoveServer(ctx, leader.ID()))
+	require.Eventually(t, func() bool { return !leader.IsLeader() }, time.Second, 10*time.Millisecond)
+	cluster.network.setConnected(leader.ID(), false)
+	delete(cluster.nodes, leader.ID())
+	newLeader := cluster.proposeOnLeader("key=3")
+	assert.Len(t, newLeader.Status().Servers, 2)
+}
+
+func TestNode_SnapshotInstall(t *testing.T) {
+	cluster := newTestCluster(t, 3, 5)
+	leader := cluster.proposeOnLeader("key0=0")
+	lagging := "n1"
+	if leader.ID() == lagging {
+		lagging = "n2"
+	}
+	cluster.network.setConnected(lagging, false)
+	for i := 1; i <= 20; i++ {
+		cluster.propose(leader, fmt.Sprintf("key%d=%d", i, i))
+	}
+	require.Eventually(t, func() bool {
+		return leader.Status().SnapshotIndex > 0
+	}, 5*time.Second, 10*time.Millisecond, "Expected the leader to compact its log")
+
+	cluster.network.setConnected(lagging, true)
+	cluster.waitValue("key20", "20", lagging)
+	assert.Equal(t, "0", cluster.sms[lagging].get("key0"))
+	assert.Positive(t, cluster.nodes[lagging].Status().SnapshotIndex, "Expected the lagging node to receive a snapshot")
+}
+
+func TestNode_RestartFromFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	cluster := &testCluster{
+		t:       t,
+		network: &memoryNetwork{nodes: make(map[string]*Node), disconnected: make(map[string]bool)},
+		nodes:   make(map[string]*Node),
+		sms:     make(map[string]*kvStateMachine),
+	}
+	servers := []Server{{ID: "n1", Address: "n1"}}
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	node := cluster.start("n1", servers, storage, 4)
+	for i := 0; i < 10; i++ {
+		cluster.proposeOnLeader(fmt.Sprintf("key%d=%d", i, i))
+	}
+	// The applier snapshots the state machine once the entries are applied
+	require.Eventually(t, func() bool {
+		return node.Status().SnapshotIndex > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	node.Stop()
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	node = cluster.start("n1", servers, storage, 4)
+	assert.Equal(t, "0", cluster.sms["n1"].get("key0"), "Expected the snapshot to be restored")
+	cluster.waitValue("key9", "9", "n1")
+	assert.Positive(t, node.Status().Term)
+}
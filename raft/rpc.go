@@ -0,0 +1,181 @@
+package raft
+
+// VoteRequest asks a server to vote for Candidate in Term.
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest replicates the entries following PrevLogIndex, or is a
+// heartbeat without entries.
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+// AppendResponse reports whether the entries were stored. On failure,
+// LastIndex is the last entry which may match the log of the leader.
+type AppendResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"`
+}
+
+// SnapshotRequest replaces the state of a server lagging behind the log of the leader.
+type SnapshotRequest struct {
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// HandleRequestVote answers the vote request of a candidate.
+func (n *Node) HandleRequestVote(request *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if request.Term > n.term {
+		n.becomeFollowerLocked(request.Term, "")
+	}
+	response := &VoteResponse{Term: n.term}
+	if request.Term < n.term {
+		return response
+	}
+
+	// Only a candidate with all the committed entries can become leader
+	lastIndex, lastTerm := n.lastLocked()
+	upToDate := request.LastLogTerm > lastTerm || (request.LastLogTerm == lastTerm && request.LastLogIndex >= lastIndex)
+	if (n.vote == "" || n.vote == request.Candidate) && upToDate {
+		n.vote = request.Candidate
+		if n.persistStateLocked() != nil {
+			n.vote = ""
+			return response
+		}
+		response.Granted = true
+		n.resetElectionLocked()
+	}
+	return response
+}
+
+// HandleAppendEntries stores the entries sent by the leader.
+func (n *Node) HandleAppendEntries(request *AppendRequest) *AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	lastIndex, _ := n.lastLocked()
+	response := &AppendResponse{Term: n.term, LastIndex: lastIndex}
+	if request.Term < n.term {
+		return response
+	}
+	n.followLocked(request.Term, request.Leader)
+	response.Term = n.term
+
+	if request.PrevLogIndex > lastIndex {
+		return response
+	}
+	entries := request.Entries
+	if request.PrevLogIndex < n.snapshot.Index {
+		// The entries up to the snapshot are committed and match
+		skip := n.snapshot.Index - request.PrevLogIndex
+		if skip >= uint64(len(entries)) {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+	} else if term, _ := n.termAtLocked(request.PrevLogIndex); term != request.PrevLogTerm {
+		// Skip the whole conflicting term at once
+		index := request.PrevLogIndex
+		for index-1 > n.snapshot.Index {
+			if previous, _ := n.termAtLocked(index - 1); previous != term {
+				break
+			}
+			index--
+		}
+		response.LastIndex = index - 1
+		return response
+	}
+
+	for i, entry := range entries {
+		if term, ok := n.termAtLocked(entry.Index); ok {
+			if term == entry.Term {
+				continue
+			}
+			if n.truncateLocked(entry.Index) != nil {
+				return response
+			}
+		}
+		if n.appendLocked(entries[i:]) != nil {
+			return response
+		}
+		break
+	}
+
+	matched := request.PrevLogIndex + uint64(len(request.Entries))
+	if commitIndex := min(request.LeaderCommit, matched); commitIndex > n.commitIndex {
+		n.commitIndex = commitIndex
+		n.notifyLocked()
+	}
+	response.Success = true
+	response.LastIndex = matched
+	return response
+}
+
+// HandleInstallSnapshot replaces the state of the node with the snapshot of
+// the leader, unless the node already committed the entries it includes.
+func (n *Node) HandleInstallSnapshot(request *SnapshotRequest) *SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if request.Term < n.term {
+		return &SnapshotResponse{Term: n.term}
+	}
+	n.followLocked(request.Term, request.Leader)
+	response := &SnapshotResponse{Term: n.term}
+
+	snapshot := request.Snapshot
+	if snapshot.Index <= n.commitIndex {
+		return response
+	}
+
+	// Keep the entries following the snapshot if the log matches it
+	var remaining []Entry
+	if term, ok := n.termAtLocked(snapshot.Index); ok && term == snapshot.Term && snapshot.Index > n.snapshot.Index {
+		remaining = append(remaining, n.log[snapshot.Index-n.snapshot.Index:]...)
+	}
+	if err := n.storage.SaveSnapshot(&snapshot, remaining); err != nil {
+		n.logger.Error("Failed to save the raft snapshot of the leader: ", err)
+		return response
+	}
+	n.logger.Info("Raft node ", n.id, " installing the snapshot of the leader at index ", snapshot.Index)
+	n.snapshot = snapshot
+	n.log = remaining
+	n.commitIndex = snapshot.Index
+	n.pendingSnapshot = &snapshot
+	n.recomputeConfigurationLocked()
+	n.notifyLocked()
+	return response
+}
+
+// followLocked makes the node a follower of the leader of term, which is not
+// older than the current one.
+func (n *Node) followLocked(term uint64, leader string) {
+	if term > n.term || n.role != ROLE_FOLLOWER || n.leader != leader {
+		n.becomeFollowerLocked(term, leader)
+		return
+	}
+	n.resetElectionLocked()
+}
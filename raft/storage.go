@@ -0,0 +1,309 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const STATE_FILE_NAME = "state.json"
+const SNAPSHOT_FILE_NAME = "snapshot.json"
+const LOG_FILE_NAME = "log.jsonl"
+
+// HardState is the state a node must persist before answering a request:
+// its current term and the candidate it voted for in this term.
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote,omitempty"`
+}
+
+// Storage persists the state of a node, its log and its latest snapshot.
+// Every method returns once the data is durable.
+type Storage interface {
+	// Load returns the persisted state, the latest snapshot, nil if none,
+	// and the entries following the snapshot.
+	Load() (HardState, *Snapshot, []Entry, error)
+	// SaveState persists the term and the vote.
+	SaveState(state HardState) error
+	// Append adds entries at the end of the log.
+	Append(entries []Entry) error
+	// Truncate removes the entries from index on.
+	Truncate(index uint64) error
+	// SaveSnapshot replaces the snapshot, and the log with the entries following it.
+	SaveSnapshot(snapshot *Snapshot, log []Entry) error
+}
+
+// MemoryStorage keeps the state in memory, for tests and nodes which can
+// always catch up from the other members of their cluster.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	state    HardState
+	snapshot *Snapshot
+	entries  []Entry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, *Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.snapshot, append([]Entry(nil), s.entries...), nil
+}
+
+func (s *MemoryStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *MemoryStorage) Truncate(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = truncateEntries(s.entries, index)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snapshot *Snapshot, log []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = snapshot
+	s.entries = append([]Entry(nil), log...)
+	return nil
+}
+
+// FileStorage persists the state into a directory: the hard state and the
+// snapshot are rewritten atomically, the log is appended to a file of JSON
+// lines and only rewritten on truncation and compaction.
+type FileStorage struct {
+	dir string
+
+	mu      sync.Mutex
+	entries []Entry
+	file    *os.File
+}
+
+// NewFileStorage creates a storage in dir, created if missing.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (s *FileStorage) Load() (HardState, *Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var state HardState
+	if err := readJSONFile(filepath.Join(s.dir, STATE_FILE_NAME), &state); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return state, nil, nil, err
+	}
+
+	var snapshot *Snapshot
+	var stored Snapshot
+	err := readJSONFile(filepath.Join(s.dir, SNAPSHOT_FILE_NAME), &stored)
+	if err == nil {
+		snapshot = &stored
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return state, nil, nil, err
+	}
+
+	entries, partial, err := s.readLog()
+	if err != nil {
+		return state, nil, nil, err
+	}
+	if snapshot != nil {
+		// The log may not have been rewritten after the snapshot was saved
+		for len(entries) > 0 && entries[0].Index <= snapshot.Index {
+			entries = entries[1:]
+		}
+	}
+	if partial {
+		if err := s.rewriteLog(entries); err != nil {
+			return state, nil, nil, err
+		}
+	}
+	s.entries = entries
+	return state, snapshot, append([]Entry(nil), entries...), nil
+}
+
+// readLog reads the entries of the log file. A partial last line, written
+// when the process stopped, is ignored and reported.
+func (s *FileStorage) readLog() ([]Entry, bool, error) {
+	file, err := os.Open(filepath.Join(s.dir, LOG_FILE_NAME))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, len(line) > 0, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, false, fmt.Errorf("corrupted raft log entry after index %d: %w", len(entries), err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (s *FileStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(filepath.Join(s.dir, STATE_FILE_NAME), state)
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		file, err := os.OpenFile(filepath.Join(s.dir, LOG_FILE_NAME), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		s.file = file
+	}
+
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err := s.file.Write(data); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *FileStorage) Truncate(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rewriteLog(truncateEntries(s.entries, index))
+}
+
+func (s *FileStorage) SaveSnapshot(snapshot *Snapshot, log []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeJSONFile(filepath.Join(s.dir, SNAPSHOT_FILE_NAME), snapshot); err != nil {
+		return err
+	}
+	return s.rewriteLog(append([]Entry(nil), log...))
+}
+
+// Close closes the log file.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// rewriteLog atomically replaces the log file with entries. The caller must hold s.mu.
+func (s *FileStorage) rewriteLog(entries []Entry) error {
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if err := writeFileAtomically(filepath.Join(s.dir, LOG_FILE_NAME), data); err != nil {
+		return err
+	}
+	s.entries = entries
+	return nil
+}
+
+// truncateEntries returns the entries preceding index.
+func truncateEntries(entries []Entry, index uint64) []Entry {
+	for i, entry := range entries {
+		if entry.Index >= index {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, data)
+}
+
+// writeFileAtomically writes data to a temporary file synced to disk, then
+// renames it to path, so that path holds either the old or the new content.
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
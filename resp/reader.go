@@ -0,0 +1,239 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const MAX_BULK_LENGTH = 512 * 1024 * 1024
+const MAX_ARRAY_LENGTH = 1024 * 1024
+const MAX_INLINE_LENGTH = 64 * 1024
+
+// The limits of the commands of the clients not authenticated yet, so that
+// they cannot make the server hold large commands.
+const UNAUTHENTICATED_MAX_BULK_LENGTH = 16 * 1024
+const UNAUTHENTICATED_MAX_ARRAY_LENGTH = 10
+
+var ErrProtocol = errors.New("protocol error")
+
+// Reader reads RESP commands and replies, rejecting the arrays and bulk
+// strings longer than its limits.
+type Reader struct {
+	reader         *bufio.Reader
+	maxArrayLength int
+	maxBulkLength  int
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader:         bufio.NewReader(reader),
+		maxArrayLength: MAX_ARRAY_LENGTH,
+		maxBulkLength:  MAX_BULK_LENGTH,
+	}
+}
+
+// SetLimits sets the longest arrays and bulk strings read next.
+func (r *Reader) SetLimits(maxArrayLength int, maxBulkLength int) {
+	r.maxArrayLength = maxArrayLength
+	r.maxBulkLength = maxBulkLength
+}
+
+// Buffered returns the number of bytes already read from the connection and
+// not yet consumed, which is non zero while a pipeline is being processed.
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// ReadCommand reads a command sent either as an array of bulk strings or as
+// an inline command separated by spaces.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		prefix, err := r.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if prefix[0] != '*' {
+			line, err := r.readLine()
+			if err != nil {
+				return nil, err
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			return fields, nil
+		}
+
+		r.reader.ReadByte()
+		length, err := r.readLength(r.maxArrayLength)
+		if err != nil {
+			return nil, err
+		}
+		if length <= 0 {
+			continue
+		}
+
+		args := make([]string, 0, length)
+		for i := 0; i < length; i++ {
+			kind, err := r.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if kind != '$' {
+				return nil, fmt.Errorf("%w: expected '$', got '%c'", ErrProtocol, kind)
+			}
+			arg, _, err := r.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+// ReadReply reads a reply. Simple and bulk strings are returned as string,
+// integers as int64, arrays, sets and pushes as []interface{}, maps as
+// map[string]interface{}, null as nil and errors as Error.
+func (r *Reader) ReadReply() (interface{}, error) {
+	kind, err := r.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case '+':
+		return r.readLine()
+	case '-':
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return Error(line), nil
+	case ':':
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(line, 10, 64)
+	case ',':
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(line, 64)
+	case '#':
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return line == "t", nil
+	case '_':
+		_, err := r.readLine()
+		return nil, err
+	case '$':
+		value, isNull, err := r.readBulk()
+		if err != nil || isNull {
+			return nil, err
+		}
+		return value, nil
+	case '*', '~', '>':
+		length, err := r.readLength(r.maxArrayLength)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, 0, length)
+		for i := 0; i < length; i++ {
+			value, err := r.ReadReply()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case '%':
+		length, err := r.readLength(r.maxArrayLength)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, length)
+		for i := 0; i < length; i++ {
+			key, err := r.ReadReply()
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.ReadReply()
+			if err != nil {
+				return nil, err
+			}
+			values[fmt.Sprint(key)] = value
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%w: unknown reply type '%c'", ErrProtocol, kind)
+}
+
+// readBulk reads a bulk string. Its bytes are buffered as they arrive,
+// rather than allocated upfront from the length announced.
+func (r *Reader) readBulk() (string, bool, error) {
+	length, err := r.readLength(r.maxBulkLength)
+	if err != nil {
+		return "", false, err
+	}
+	if length < 0 {
+		return "", true, nil
+	}
+
+	var value strings.Builder
+	if _, err := io.CopyN(&value, r.reader, int64(length)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", false, err
+	}
+	terminator := make([]byte, 2)
+	if _, err := io.ReadFull(r.reader, terminator); err != nil {
+		return "", false, err
+	}
+	if terminator[0] != '\r' || terminator[1] != '\n' {
+		return "", false, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return value.String(), false, nil
+}
+
+func (r *Reader) readLength(max int) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	length, err := strconv.Atoi(line)
+	if err != nil || length < -1 || length > max {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, line)
+	}
+	return length, nil
+}
+
+// readLine reads a line of up to MAX_INLINE_LENGTH bytes, which may be
+// longer than the buffer of the reader.
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > MAX_INLINE_LENGTH {
+			return "", fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
@@ -0,0 +1,135 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader_ReadCommand(t *testing.T) {
+	reader := NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\nPING hello\r\n\r\n*1\r\n$4\r\nPING\r\n"))
+
+	args, err := reader.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SET", "key", "va\r\nl"}, args)
+
+	args, err = reader.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PING", "hello"}, args)
+
+	args, err = reader.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PING"}, args)
+}
+
+func TestReader_ReadCommand_ProtocolError(t *testing.T) {
+	_, err := NewReader(strings.NewReader("*1\r\n:1\r\n")).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	_, err = NewReader(strings.NewReader("*1\r\n$-5\r\n")).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	_, err = NewReader(strings.NewReader("*1\r\n$3\r\nabcde\r\n")).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	_, err = NewReader(strings.NewReader("*99999999\r\n")).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestReader_Limits(t *testing.T) {
+	command := "*11\r\n" + strings.Repeat("$1\r\na\r\n", 11)
+	reader := NewReader(strings.NewReader(command))
+	reader.SetLimits(UNAUTHENTICATED_MAX_ARRAY_LENGTH, UNAUTHENTICATED_MAX_BULK_LENGTH)
+	_, err := reader.ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	value := strings.Repeat("a", UNAUTHENTICATED_MAX_BULK_LENGTH+1)
+	command = fmt.Sprintf("*1\r\n$%d\r\n%s\r\n", len(value), value)
+	reader = NewReader(strings.NewReader(command))
+	reader.SetLimits(UNAUTHENTICATED_MAX_ARRAY_LENGTH, UNAUTHENTICATED_MAX_BULK_LENGTH)
+	_, err = reader.ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	// The default limits accept the same command
+	args, err := NewReader(strings.NewReader(command)).ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{value}, args)
+}
+
+func TestReader_ReadCommand_AnnouncedLength(t *testing.T) {
+	// The bytes of a bulk string are not allocated before they arrive
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := NewReader(strings.NewReader(fmt.Sprintf("*1\r\n$%d\r\nabc", MAX_BULK_LENGTH))).ReadCommand()
+	runtime.ReadMemStats(&after)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1024*1024))
+}
+
+func TestReader_ReadCommand_InlineLength(t *testing.T) {
+	// Inline commands may be longer than the buffer of the reader
+	value := strings.Repeat("a", 10000)
+	args, err := NewReader(strings.NewReader("PING " + value + "\r\n")).ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PING", value}, args)
+
+	_, err = NewReader(strings.NewReader("PING " + strings.Repeat("a", MAX_INLINE_LENGTH) + "\r\n")).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestWriter_RESP2(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+
+	writer.WriteOK()
+	writer.WriteError("ERR failure")
+	writer.WriteInteger(-2)
+	writer.WriteBulkString("value")
+	writer.WriteNull()
+	writer.WriteStringArray([]string{"a", "b"})
+	writer.WriteMapHeader(1)
+	writer.WriteBulkString("key")
+	writer.WriteInteger(1)
+	require.NoError(t, writer.Flush())
+
+	assert.Equal(t, "+OK\r\n-ERR failure\r\n:-2\r\n$5\r\nvalue\r\n$-1\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n*2\r\n$3\r\nkey\r\n:1\r\n", buffer.String())
+}
+
+func TestWriter_RESP3(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+	writer.Protocol = PROTOCOL_RESP3
+
+	writer.WriteNull()
+	writer.WriteMapHeader(1)
+	writer.WriteBulkString("key")
+	writer.WriteInteger(1)
+	require.NoError(t, writer.Flush())
+
+	assert.Equal(t, "_\r\n%1\r\n$3\r\nkey\r\n:1\r\n", buffer.String())
+}
+
+func TestReader_ReadReply(t *testing.T) {
+	reader := NewReader(strings.NewReader("+OK\r\n-ERR failure\r\n:7\r\n$-1\r\n_\r\n*2\r\n$1\r\na\r\n:1\r\n%1\r\n$3\r\nkey\r\n#t\r\n"))
+
+	expected := []interface{}{
+		"OK",
+		Error("ERR failure"),
+		int64(7),
+		nil,
+		nil,
+		[]interface{}{"a", int64(1)},
+		map[string]interface{}{"key": true},
+	}
+	for _, value := range expected {
+		reply, err := reader.ReadReply()
+		require.NoError(t, err)
+		assert.Equal(t, value, reply)
+	}
+}
@@ -0,0 +1,97 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+const (
+	PROTOCOL_RESP2 = 2
+	PROTOCOL_RESP3 = 3
+)
+
+// Error is an error reply.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Writer writes RESP replies. Replies are buffered until Flush is called.
+type Writer struct {
+	writer *bufio.Writer
+	// Protocol selects how nulls and maps are encoded, PROTOCOL_RESP2 by default.
+	Protocol int
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{writer: bufio.NewWriter(writer), Protocol: PROTOCOL_RESP2}
+}
+
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
+}
+
+func (w *Writer) WriteSimpleString(value string) {
+	w.writer.WriteByte('+')
+	w.writer.WriteString(value)
+	w.writer.WriteString("\r\n")
+}
+
+func (w *Writer) WriteOK() {
+	w.WriteSimpleString("OK")
+}
+
+func (w *Writer) WriteError(message string) {
+	w.writer.WriteByte('-')
+	w.writer.WriteString(message)
+	w.writer.WriteString("\r\n")
+}
+
+func (w *Writer) WriteInteger(value int64) {
+	w.writer.WriteByte(':')
+	w.writer.WriteString(strconv.FormatInt(value, 10))
+	w.writer.WriteString("\r\n")
+}
+
+func (w *Writer) WriteBulkString(value string) {
+	w.writer.WriteByte('$')
+	w.writer.WriteString(strconv.Itoa(len(value)))
+	w.writer.WriteString("\r\n")
+	w.writer.WriteString(value)
+	w.writer.WriteString("\r\n")
+}
+
+func (w *Writer) WriteNull() {
+	if w.Protocol == PROTOCOL_RESP3 {
+		w.writer.WriteString("_\r\n")
+		return
+	}
+	w.writer.WriteString("$-1\r\n")
+}
+
+func (w *Writer) WriteArrayHeader(length int) {
+	w.writer.WriteByte('*')
+	w.writer.WriteString(strconv.Itoa(length))
+	w.writer.WriteString("\r\n")
+}
+
+func (w *Writer) WriteStringArray(values []string) {
+	w.WriteArrayHeader(len(values))
+	for _, value := range values {
+		w.WriteBulkString(value)
+	}
+}
+
+// WriteMapHeader starts a map of length pairs, written as a flat array of
+// keys and values with RESP2.
+func (w *Writer) WriteMapHeader(length int) {
+	if w.Protocol == PROTOCOL_RESP3 {
+		w.writer.WriteByte('%')
+		w.writer.WriteString(strconv.Itoa(length))
+		w.writer.WriteString("\r\n")
+		return
+	}
+	w.WriteArrayHeader(2 * length)
+}
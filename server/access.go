@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+)
+
+// keyAccesses returns the accesses of a request on the keys returned by
+// keysOf, in the collection of the path: reading or writing each of them by
+// the HTTP method.
+func keyAccesses(keysOf shardKeys) func(r *http.Request) ([]auth.Access, error) {
+	return func(r *http.Request) ([]auth.Access, error) {
+		keys, err := keysOf(r)
+		if err != nil {
+			return nil, err
+		}
+		collection := r.PathValue(COLLECTION_NAME_PARAM)
+		if collection == "" {
+			collection = database.DEFAULT_COLLECTION
+		}
+		action := auth.MethodAction(r.Method)
+		accesses := make([]auth.Access, 0, len(keys))
+		for _, key := range keys {
+			accesses = append(accesses, auth.Access{Action: action, Asset: auth.KeyAsset(collection, key)})
+		}
+		return accesses, nil
+	}
+}
+
+// transactionAccesses returns the accesses of a transaction: reading the key
+// of every precondition and writing the key of every operation, in their own
+// collections.
+func transactionAccesses(r *http.Request) ([]auth.Access, error) {
+	var request transactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	var accesses []auth.Access
+	for _, precondition := range request.Preconditions {
+		accesses = append(accesses, auth.Access{Action: auth.ACTION_READ, Asset: transactionKeyAsset(precondition.Collection, precondition.Key)})
+	}
+	for _, operation := range request.Operations {
+		accesses = append(accesses, auth.Access{Action: auth.ACTION_WRITE, Asset: transactionKeyAsset(operation.Collection, operation.Key)})
+	}
+	return accesses, nil
+}
+
+// transactionKeyAsset returns the asset of a key of a transaction, in the
+// default collection when none is given.
+func transactionKeyAsset(collection string, key string) string {
+	if collection == "" {
+		collection = database.DEFAULT_COLLECTION
+	}
+	return auth.KeyAsset(collection, key)
+}
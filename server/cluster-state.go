@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/raft"
+)
+
+// CLUSTER_REQUEST_TIMEOUT bounds the wait for the cluster to commit an
+// operation or to confirm the leadership of the node.
+const CLUSTER_REQUEST_TIMEOUT = 5 * time.Second
+
+var ErrSnapshotPostponed = errors.New("the collections hold operations not committed yet")
+
+// ClusterStore replicates the collections of a CollectionManager through a
+// raft node: it is both the journal of the collections, proposing every
+// operation to the cluster, and the state machine of the node, applying the
+// committed operations.
+//
+// On the leader, requests change the collections first and propose the
+// operations while holding the lock of the collection, so that the log
+// orders them as they were applied. When a committed entry is one of these
+// operations it is not applied again. An operation which could not be
+// appended to the log, or which was replaced by the entry of another leader,
+// leaves the collections ahead of the log: they are then rebuilt from the
+// latest snapshot and the committed entries.
+type ClusterStore struct {
+	collectionManager *database.CollectionManager
+	node              *raft.Node
+	logger            logger.Logger
+
+	// writeMu is shared by the requests using the collections, and held
+	// exclusively to apply the entries of other nodes, to rebuild, restore
+	// or snapshot the collections
+	writeMu sync.RWMutex
+
+	mu sync.Mutex
+	// applied is the index of the last entry included in the collections
+	applied uint64
+	// pending holds the term of the entries proposed by this node and
+	// already applied to the collections, by index
+	pending map[uint64]uint64
+	// dirty is set when the collections hold an operation missing from the log
+	dirty bool
+	// applying is set while entries are applied, their operations are not proposed again
+	applying bool
+}
+
+// NewClusterStore creates the raft node replicating the collections of
+// collectionManager and attaches the store as their journal. The node
+// restores the snapshot of storage, if any, and must then be started.
+func NewClusterStore(collectionManager *database.CollectionManager, config raft.Config, storage raft.Storage, transport raft.Transport) (*ClusterStore, error) {
+	store := &ClusterStore{
+		collectionManager: collectionManager,
+		logger:            logger.NewDareLogger(),
+		pending:           make(map[uint64]uint64),
+	}
+	node, err := raft.NewNode(config, store, storage, transport)
+	if err != nil {
+		return nil, err
+	}
+	store.node = node
+	// Every node expires the keys from the replicated expiration times
+	collectionManager.SetLocalExpirations(true)
+	collectionManager.SetJournal(store)
+	return store, nil
+}
+
+// Node returns the raft node of the store.
+func (s *ClusterStore) Node() *raft.Node {
+	return s.node
+}
+
+// Append proposes op to the cluster and waits until it is committed. The
+// operations of the entries being applied are not proposed again.
+func (s *ClusterStore) Append(op database.Operation) error {
+	s.mu.Lock()
+	applying := s.applying
+	s.mu.Unlock()
+	if applying {
+		return nil
+	}
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		s.markDirty()
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CLUSTER_REQUEST_TIMEOUT)
+	defer cancel()
+	index, term, err := s.node.Propose(ctx, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index == 0 {
+		s.dirty = true
+		return err
+	}
+	// The entry may still be committed after an error, or replaced
+	s.pending[index] = term
+	return err
+}
+
+// LastSeq returns the index of the last entry included in the collections.
+func (s *ClusterStore) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applied
+}
+
+// Apply applies a committed entry to the collections, unless it is an
+// operation of this node already applied.
+func (s *ClusterStore) Apply(entry raft.Entry) {
+	s.mu.Lock()
+	if s.ownLocked(entry) {
+		delete(s.pending, entry.Index)
+		s.applied = entry.Index
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	// The requests in progress either register their entries or fail
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if s.ownLocked(entry) {
+		delete(s.pending, entry.Index)
+		s.applied = entry.Index
+		s.mu.Unlock()
+		return
+	}
+	if s.dirty || s.staleLocked(entry) {
+		s.mu.Unlock()
+		s.rebuildLocked(entry.Index - 1)
+		s.mu.Lock()
+	}
+	if entry.Index <= s.applied {
+		s.mu.Unlock()
+		return
+	}
+	s.applying = true
+	s.mu.Unlock()
+
+	if entry.Type == raft.ENTRY_COMMAND {
+		if err := s.applyCommand(entry); err != nil {
+			s.logger.Error("Failed to apply the cluster entry ", entry.Index, ": ", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.applying = false
+	s.applied = entry.Index
+	s.mu.Unlock()
+}
+
+// ownLocked reports whether entry is an operation proposed by this node and
+// already applied. The caller must hold s.mu.
+func (s *ClusterStore) ownLocked(entry raft.Entry) bool {
+	term, ok := s.pending[entry.Index]
+	return ok && term == entry.Term && !s.dirty && !s.staleLocked(entry)
+}
+
+// staleLocked reports whether entry replaces operations of this node which
+// will never be committed. Entries of a later term follow every entry of
+// the log of an earlier term. The caller must hold s.mu.
+func (s *ClusterStore) staleLocked(entry raft.Entry) bool {
+	for index, term := range s.pending {
+		if term < entry.Term || (index == entry.Index && term != entry.Term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot copies the collections, provided they hold exactly the applied entries.
+func (s *ClusterStore) Snapshot() ([]byte, uint64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if s.dirty || len(s.pending) > 0 {
+		s.mu.Unlock()
+		return nil, 0, ErrSnapshotPostponed
+	}
+	applied := s.applied
+	s.mu.Unlock()
+
+	data, err := json.Marshal(s.collectionManager.Snapshot())
+	return data, applied, err
+}
+
+// Restore replaces the collections with a snapshot of the cluster.
+func (s *ClusterStore) Restore(snapshot raft.Snapshot) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var collections database.Snapshot
+	if err := json.Unmarshal(snapshot.Data, &collections); err != nil {
+		return fmt.Errorf("invalid cluster snapshot: %w", err)
+	}
+	if err := s.collectionManager.Restore(&collections); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = snapshot.Index
+	s.pending = make(map[uint64]uint64)
+	s.dirty = false
+	return nil
+}
+
+// Serve runs a request using the collections while no entry of another
+// node is applied, and repairs the collections afterwards if an operation
+// of the request could not be committed.
+func (s *ClusterStore) Serve(request func()) {
+	func() {
+		s.writeMu.RLock()
+		defer s.writeMu.RUnlock()
+		request()
+	}()
+	s.repair()
+}
+
+// repair rebuilds the collections if they hold an operation missing from the log.
+func (s *ClusterStore) repair() {
+	s.mu.Lock()
+	dirty := s.dirty
+	s.mu.Unlock()
+	if !dirty {
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	dirty, applied := s.dirty, s.applied
+	s.mu.Unlock()
+	if dirty {
+		s.rebuildLocked(applied)
+	}
+}
+
+// rebuildLocked replaces the collections with the latest snapshot and the
+// committed entries following it, up to index. The caller must hold writeMu
+// exclusively.
+func (s *ClusterStore) rebuildLocked(index uint64) {
+	snapshot, entries := s.node.Log(index)
+	s.logger.Warn("Rebuilding the collections from the cluster log up to entry ", index)
+
+	collections := &database.Snapshot{Collections: map[string][]database.SnapshotEntry{database.DEFAULT_COLLECTION: nil}}
+	var applied uint64
+	if snapshot != nil {
+		collections = &database.Snapshot{}
+		if err := json.Unmarshal(snapshot.Data, collections); err != nil {
+			s.logger.Error("Invalid cluster snapshot ", snapshot.Index, ": ", err)
+		}
+		applied = snapshot.Index
+	}
+
+	s.mu.Lock()
+	s.applying = true
+	s.mu.Unlock()
+	if err := s.collectionManager.Restore(collections); err != nil {
+		s.logger.Error("Failed to restore the cluster snapshot: ", err)
+	}
+	for _, entry := range entries {
+		if entry.Type == raft.ENTRY_COMMAND {
+			if err := s.applyCommand(entry); err != nil {
+				s.logger.Error("Failed to apply the cluster entry ", entry.Index, ": ", err)
+			}
+		}
+		applied = entry.Index
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applying = false
+	s.applied = applied
+	s.pending = make(map[uint64]uint64)
+	s.dirty = false
+}
+
+func (s *ClusterStore) applyCommand(entry raft.Entry) error {
+	var op database.Operation
+	if err := json.Unmarshal(entry.Data, &op); err != nil {
+		return err
+	}
+	return s.collectionManager.Apply(op)
+}
+
+func (s *ClusterStore) markDirty() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+}
+
+// blockingPop pops like Database.BLPop and BRPop from the collection
+// named collectionName. The caller must hold writeMu for reading, as every
+// request run by Serve: it is released while waiting, so that entries can be
+// applied, and the pop is attempted again after every change of the collection.
+func (s *ClusterStore) blockingPop(ctx context.Context, collectionName string, keys []string, timeout time.Duration, left bool) (string, string, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		// Watch first, not to miss a push following the attempt
+		watcher, _, _, err := s.collectionManager.Watch(collectionName, "", 0)
+		if err != nil {
+			return "", "", err
+		}
+		key, element, err := s.tryPop(collectionName, keys, left)
+		if !errors.Is(err, database.ErrKeyNotFound) {
+			watcher.Close()
+			return key, element, err
+		}
+
+		s.writeMu.RUnlock()
+		s.repair()
+		select {
+		case <-watcher.Events():
+		case <-watcher.Done():
+		case <-expired:
+			err = database.ErrTimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		watcher.Close()
+		s.writeMu.RLock()
+		if !errors.Is(err, database.ErrKeyNotFound) {
+			return "", "", err
+		}
+	}
+}
+
+// tryPop pops an element from the first non-empty list among keys, or
+// returns ErrKeyNotFound.
+func (s *ClusterStore) tryPop(collectionName string, keys []string, left bool) (string, string, error) {
+	collection, exists := s.collectionManager.GetCollection(collectionName)
+	if !exists {
+		return "", "", database.ErrKeyNotFound
+	}
+	for _, key := range keys {
+		var elements []string
+		var err error
+		if left {
+			elements, err = collection.LPop(key, 1)
+		} else {
+			elements, err = collection.RPop(key, 1)
+		}
+		if errors.Is(err, database.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return key, elements[0], nil
+	}
+	return "", "", database.ErrKeyNotFound
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dmarro89/dare-db/raft"
+)
+
+// ClusterTransport sends the raft requests of a node to the other members
+// of its cluster as JSON over HTTP, with a token of each member issued to
+// the cluster user.
+type ClusterTransport struct {
+	client *http.Client
+	tokens *nodeTokens
+}
+
+func NewClusterTransport(username string, password string) *ClusterTransport {
+	client := &http.Client{}
+	return &ClusterTransport{
+		client: client,
+		tokens: newNodeTokens(username, password, client),
+	}
+}
+
+// Close closes the idle connections of the transport to the other nodes.
+func (t *ClusterTransport) Close() {
+	t.client.CloseIdleConnections()
+}
+
+func (t *ClusterTransport) RequestVote(ctx context.Context, server raft.Server, request *raft.VoteRequest) (*raft.VoteResponse, error) {
+	var response raft.VoteResponse
+	return &response, t.post(ctx, server, "/raft/vote", request, &response)
+}
+
+func (t *ClusterTransport) AppendEntries(ctx context.Context, server raft.Server, request *raft.AppendRequest) (*raft.AppendResponse, error) {
+	var response raft.AppendResponse
+	return &response, t.post(ctx, server, "/raft/append", request, &response)
+}
+
+func (t *ClusterTransport) InstallSnapshot(ctx context.Context, server raft.Server, request *raft.SnapshotRequest) (*raft.SnapshotResponse, error) {
+	var response raft.SnapshotResponse
+	return &response, t.post(ctx, server, "/raft/snapshot", request, &response)
+}
+
+func (t *ClusterTransport) post(ctx context.Context, server raft.Server, path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := t.tokens.do(ctx, server.Address, httpRequest, body)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 1024))
+		return fmt.Errorf("node %s answered %s: %s", server.ID, httpResponse.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/raft"
+)
+
+const NODE_ID_PARAM = "id"
+
+// CLUSTER_DIR is the directory of settings.data_dir holding the raft state and log.
+const CLUSTER_DIR = "raft"
+
+// clusterNodeRequest is the body of the requests adding a node to the cluster.
+type clusterNodeRequest struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// startCluster makes the server a member of the cluster described by the
+// cluster.* configuration keys and starts its raft node.
+func (srv *DareServer) startCluster(configuration Config) error {
+	peers, err := parseClusterPeers(configuration.GetString("cluster.peers"))
+	if err != nil {
+		return err
+	}
+	storage, err := raft.NewFileStorage(filepath.Join(configuration.GetString("settings.data_dir"), CLUSTER_DIR))
+	if err != nil {
+		return err
+	}
+
+	username, password := configuration.GetString("cluster.user"), configuration.GetString("cluster.password")
+	if username == "" {
+		username, password = configuration.GetString("server.admin_user"), configuration.GetString("server.admin_password")
+	}
+	config := raft.Config{
+		ID:                configuration.GetString("cluster.node_id"),
+		Servers:           peers,
+		HeartbeatInterval: configuration.GetDuration("cluster.heartbeat_interval"),
+		ElectionTimeout:   configuration.GetDuration("cluster.election_timeout"),
+		SnapshotThreshold: uint64(configuration.GetInt("cluster.snapshot_threshold")),
+	}
+	srv.cluster, err = NewClusterStore(srv.collectionManager, config, storage, NewClusterTransport(username, password))
+	if err != nil {
+		storage.Close()
+		return err
+	}
+	srv.clusterStorage = storage
+	srv.cluster.Node().Start()
+	return nil
+}
+
+// parseClusterPeers parses a list of nodes such as
+// "node1=http://10.0.0.1:2605,node2=http://10.0.0.2:2605".
+func parseClusterPeers(value string) ([]raft.Server, error) {
+	var servers []raft.Server
+	for _, peer := range strings.Split(value, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		id, address, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || address == "" {
+			return nil, fmt.Errorf(`invalid cluster peer "%s", expected id=address`, peer)
+		}
+		servers = append(servers, raft.Server{ID: strings.TrimSpace(id), Address: strings.TrimSpace(address)})
+	}
+	return servers, nil
+}
+
+// HandlerClusterStatus returns the state of the raft node and the members of the cluster.
+func (srv *DareServer) HandlerClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.clusterEnabled(w) {
+		return
+	}
+	writeJSON(w, srv.cluster.Node().Status())
+}
+
+// HandlerClusterAddNode adds a node to the cluster, or changes its address.
+// The node must be started with the same cluster.node_id and no peers: it
+// receives the collections once the new configuration is committed.
+func (srv *DareServer) HandlerClusterAddNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.clusterEnabled(w) || srv.redirectToLeader(w, r) {
+		return
+	}
+
+	var request clusterNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if request.ID == "" || request.Address == "" {
+		http.Error(w, `Fields "id" and "address" are required`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), CLUSTER_REQUEST_TIMEOUT)
+	defer cancel()
+	err := srv.cluster.Node().AddServer(ctx, raft.Server{ID: request.ID, Address: request.Address})
+	if !srv.writeClusterChangeError(w, r, err) {
+		return
+	}
+	writeJSON(w, srv.cluster.Node().Status())
+}
+
+// HandlerClusterRemoveNode removes a node from the cluster. The leader
+// removing itself steps down once the new configuration is committed.
+func (srv *DareServer) HandlerClusterRemoveNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.clusterEnabled(w) || srv.redirectToLeader(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), CLUSTER_REQUEST_TIMEOUT)
+	defer cancel()
+	err := srv.cluster.Node().RemoveServer(ctx, r.PathValue(NODE_ID_PARAM))
+	if !srv.writeClusterChangeError(w, r, err) {
+		return
+	}
+	writeJSON(w, srv.cluster.Node().Status())
+}
+
+// writeClusterChangeError answers a failed change of the members of the
+// cluster. It returns true if err is nil.
+func (srv *DareServer) writeClusterChangeError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, raft.ErrNotLeader):
+		srv.redirectToLeader(w, r)
+	case errors.Is(err, raft.ErrUnknownServer):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, raft.ErrConfigurationChange):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("Cluster configuration not committed: %v", err), http.StatusServiceUnavailable)
+	}
+	return false
+}
+
+// takeClusterSnapshot snapshots the collections into the raft log of the
+// node, which drops the entries included in the snapshot.
+func (srv *DareServer) takeClusterSnapshot(w http.ResponseWriter) {
+	if err := srv.cluster.Node().TakeSnapshot(); err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("Error saving snapshot: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]uint64{"snapshot_index": srv.cluster.Node().Status().SnapshotIndex})
+}
+
+func (srv *DareServer) HandlerRaftVote(w http.ResponseWriter, r *http.Request) {
+	var request raft.VoteRequest
+	if srv.decodeRaftRequest(w, r, &request) {
+		writeJSON(w, srv.cluster.Node().HandleRequestVote(&request))
+	}
+}
+
+func (srv *DareServer) HandlerRaftAppend(w http.ResponseWriter, r *http.Request) {
+	var request raft.AppendRequest
+	if srv.decodeRaftRequest(w, r, &request) {
+		writeJSON(w, srv.cluster.Node().HandleAppendEntries(&request))
+	}
+}
+
+func (srv *DareServer) HandlerRaftSnapshot(w http.ResponseWriter, r *http.Request) {
+	var request raft.SnapshotRequest
+	if srv.decodeRaftRequest(w, r, &request) {
+		writeJSON(w, srv.cluster.Node().HandleInstallSnapshot(&request))
+	}
+}
+
+// decodeRaftRequest reads the raft request sent by another node. It returns
+// false once the request was answered with an error.
+func (srv *DareServer) decodeRaftRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !srv.clusterEnabled(w) {
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// authenticateNode lets the requests of the other nodes of the cluster
+// through if they carry a token of a user allowed to administer the server.
+func (srv *DareServer) authenticateNode(authorizer auth.Authorizer, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, err := srv.authenticator().VerifyToken(r.Header.Get("Authorization"))
+		if err != nil {
+			http.Error(w, "Unauthorized: missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+		if !authorizer.HasPermission(username, auth.ACTION_ADMIN, auth.SERVER_ASSET) {
+			http.Error(w, "Forbidden: you do not have permission to access this resource", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clusterEnabled answers with an error if the server is not a member of a
+// cluster. It returns true if it is.
+func (srv *DareServer) clusterEnabled(w http.ResponseWriter) bool {
+	if srv.cluster == nil {
+		http.Error(w, "Cluster mode is not enabled, set cluster.node_id", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// redirectToLeader answers r with a redirection to the same request on the
+// leader of the cluster, or with an error if no leader is known. It returns
+// false, without answering, if this node is the leader.
+func (srv *DareServer) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	node := srv.cluster.Node()
+	if node.IsLeader() {
+		return false
+	}
+	leader, known := node.Leader()
+	if !known {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "No leader is elected in the cluster, retry later", http.StatusServiceUnavailable)
+		return true
+	}
+	address := strings.TrimSuffix(leader.Address, "/")
+	w.Header().Set("Location", address+r.URL.RequestURI())
+	http.Error(w, fmt.Sprintf("This node is not the leader of the cluster, send the request to the leader %s at %s", leader.ID, address), http.StatusTemporaryRedirect)
+	return true
+}
+
+// serveFromLeader routes the requests using the collections to the leader
+// of the cluster. The leader confirms its leadership and applies the
+// committed entries before serving a request, so that every request
+// observes the writes completed before it started.
+func (srv *DareServer) serveFromLeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if srv.cluster == nil || !isCollectionRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if srv.redirectToLeader(w, r) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), CLUSTER_REQUEST_TIMEOUT)
+		err := srv.cluster.Node().ReadIndex(ctx)
+		cancel()
+		if errors.Is(err, raft.ErrNotLeader) {
+			srv.redirectToLeader(w, r)
+			return
+		}
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("The leadership of the node could not be confirmed: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		srv.cluster.Serve(func() {
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+// isCollectionRequest reports whether r reads or writes the collections.
+// Logging in and out, refreshing tokens, pub/sub, the keyspace watches, the
+// replication and the administration endpoints are served by every node.
+func isCollectionRequest(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return false
+	}
+	path := r.URL.Path
+	for _, prefix := range []string{"/raft/", "/admin/", "/publish/", "/replication/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	switch path {
+	case "/login", "/logout", "/refresh", "/subscribe", "/subscribe/ws", "/watch":
+		return false
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return !(len(segments) == 3 && segments[0] == "collections" && segments[2] == "watch")
+}
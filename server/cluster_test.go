@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/raft"
+	"github.com/dmarro89/dare-db/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The timings of the test clusters leave room for the race detector, which
+// slows the nodes down by an order of magnitude.
+const CLUSTER_TEST_HEARTBEAT_INTERVAL = 100 * time.Millisecond
+const CLUSTER_TEST_ELECTION_TIMEOUT = time.Second
+const CLUSTER_TEST_WAIT = 10 * time.Second
+
+// clusterTestNode is a server of a test cluster, with its URL and a token.
+type clusterTestNode struct {
+	id     string
+	srv    *DareServer
+	server *httptest.Server
+	url    string
+	token  string
+}
+
+// clusterTestHandler lets the HTTP server start before the mux exists, as
+// the nodes need the addresses of each other.
+type clusterTestHandler struct {
+	mu      sync.Mutex
+	handler http.Handler
+}
+
+func (h *clusterTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	handler := h.handler
+	h.mu.Unlock()
+	if handler == nil {
+		http.Error(w, "Starting", http.StatusServiceUnavailable)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// startClusterNodes starts a node for each of ids, the first bootstrapped
+// ones forming the initial cluster and the others waiting to be added.
+func startClusterNodes(t *testing.T, ids []string, bootstrapped int, snapshotThreshold uint64) []*clusterTestNode {
+	var nodes []*clusterTestNode
+	var handlers []*clusterTestHandler
+	var peers []raft.Server
+	for i, id := range ids {
+		handler := &clusterTestHandler{}
+		server := httptest.NewServer(handler)
+		t.Cleanup(func() {
+			server.CloseClientConnections()
+			server.Close()
+		})
+		nodes = append(nodes, &clusterTestNode{id: id, server: server, url: server.URL})
+		handlers = append(handlers, handler)
+		if i < bootstrapped {
+			peers = append(peers, raft.Server{ID: id, Address: server.URL})
+		}
+	}
+
+	for i, node := range nodes {
+		userStore := auth.NewUserStore()
+		userStore.AddUser("user", "password")
+		node.srv = NewDareServer(database.NewDatabase(), userStore)
+		var servers []raft.Server
+		if i < bootstrapped {
+			servers = peers
+		}
+		transport := NewClusterTransport("user", "password")
+		t.Cleanup(transport.Close)
+		store, err := NewClusterStore(node.srv.collectionManager, raft.Config{
+			ID:                node.id,
+			Servers:           servers,
+			HeartbeatInterval: CLUSTER_TEST_HEARTBEAT_INTERVAL,
+			ElectionTimeout:   CLUSTER_TEST_ELECTION_TIMEOUT,
+			SnapshotThreshold: snapshotThreshold,
+		}, raft.NewMemoryStorage(), transport)
+		require.NoError(t, err)
+		node.srv.cluster = store
+
+		handlers[i].mu.Lock()
+		handlers[i].handler = node.srv.CreateMux(allowAllAuthorizer{}, auth.NewJWTAutenticatorWithUsers(userStore))
+		handlers[i].mu.Unlock()
+		node.token = loginTestNode(t, node.url)
+		store.Node().Start()
+		t.Cleanup(store.Node().Stop)
+	}
+	return nodes
+}
+
+func loginTestNode(t *testing.T, url string) string {
+	request, _ := http.NewRequest(http.MethodPost, url+"/login", nil)
+	request.SetBasicAuth("user", "password")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	var tokenResponse map[string]string
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&tokenResponse))
+	return tokenResponse["token"]
+}
+
+// clusterLeader waits for a leader among nodes.
+func clusterLeader(t *testing.T, nodes []*clusterTestNode) *clusterTestNode {
+	var leader *clusterTestNode
+	require.Eventually(t, func() bool {
+		for _, node := range nodes {
+			if node.srv.cluster.Node().IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, CLUSTER_TEST_WAIT, 10*time.Millisecond, "Expected a leader to be elected")
+	return leader
+}
+
+// doClusterRequest sends a request without following the redirections.
+func doClusterRequest(t *testing.T, node *clusterTestNode, method string, path string, body string) (*http.Response, string) {
+	request, _ := http.NewRequest(method, node.url+path, strings.NewReader(body))
+	request.Header.Set("Authorization", node.token)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	content, _ := io.ReadAll(response.Body)
+	return response, string(content)
+}
+
+func TestCluster_ReplicatesWrites(t *testing.T) {
+	nodes := startClusterNodes(t, []string{"n1", "n2", "n3"}, 3, 0)
+	leader := clusterLeader(t, nodes)
+
+	response, _ := doClusterRequest(t, leader, http.MethodPost, "/set", `{"key":"value"}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	response, _ = doClusterRequest(t, leader, http.MethodPost, "/collections/users", "")
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	response, _ = doClusterRequest(t, leader, http.MethodPost, "/incr/counter?by=5", "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	for _, node := range nodes {
+		require.Eventually(t, func() bool {
+			_, exists := node.srv.collectionManager.GetCollection("users")
+			collection := node.srv.collectionManager.GetDefaultCollection()
+			return exists && collection.Get("key") == "value" && collection.Get("counter") == "5"
+		}, CLUSTER_TEST_WAIT, 10*time.Millisecond, "Expected %s to apply the writes of the leader", node.id)
+	}
+
+	// The leader serves the reads, the other nodes redirect them
+	response, body := doClusterRequest(t, leader, http.MethodGet, "/get/key", "")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.JSONEq(t, `{"key":"value"}`, body)
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		response, _ = doClusterRequest(t, node, http.MethodGet, "/get/key", "")
+		assert.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+		assert.Equal(t, leader.url+"/get/key", response.Header.Get("Location"))
+
+		response, body = doClusterRequest(t, node, http.MethodGet, "/admin/cluster", "")
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		var status raft.Status
+		require.NoError(t, json.Unmarshal([]byte(body), &status))
+		assert.Equal(t, raft.ROLE_FOLLOWER, status.Role)
+		assert.Equal(t, leader.id, status.Leader)
+		assert.Len(t, status.Servers, 3)
+	}
+}
+
+func TestCluster_BlockingPop(t *testing.T) {
+	nodes := startClusterNodes(t, []string{"n1", "n2", "n3"}, 3, 0)
+	leader := clusterLeader(t, nodes)
+
+	popped := make(chan string, 1)
+	go func() {
+		_, body := doClusterRequest(t, leader, http.MethodPost, "/lists/jobs/blpop?timeout=5", "")
+		popped <- body
+	}()
+	// The blocked pop does not prevent the other requests
+	time.Sleep(50 * time.Millisecond)
+	response, _ := doClusterRequest(t, leader, http.MethodPost, "/lists/jobs/rpush", `["job1"]`)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	select {
+	case body := <-popped:
+		assert.JSONEq(t, `{"value":"job1"}`, body)
+	case <-time.After(CLUSTER_TEST_WAIT):
+		t.Fatal("Expected the blocked pop to return the pushed element")
+	}
+	for _, node := range nodes {
+		require.Eventually(t, func() bool {
+			length, err := node.srv.collectionManager.GetDefaultCollection().LLen("jobs")
+			return err == nil && length == 0 && node.srv.cluster.Node().AppliedIndex() == leader.srv.cluster.Node().CommitIndex()
+		}, CLUSTER_TEST_WAIT, 10*time.Millisecond)
+	}
+}
+
+func TestCluster_LeaderFailover(t *testing.T) {
+	nodes := startClusterNodes(t, []string{"n1", "n2", "n3"}, 3, 0)
+	leader := clusterLeader(t, nodes)
+	response, _ := doClusterRequest(t, leader, http.MethodPost, "/set", `{"key":"before"}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+
+	leader.srv.cluster.Node().Stop()
+	leader.server.Close()
+	var remaining []*clusterTestNode
+	for _, node := range nodes {
+		if node != leader {
+			remaining = append(remaining, node)
+		}
+	}
+	newLeader := clusterLeader(t, remaining)
+	response, body := doClusterRequest(t, newLeader, http.MethodGet, "/get/key", "")
+	require.Equal(t, http.StatusOK, response.StatusCode, body)
+	assert.JSONEq(t, `{"key":"before"}`, body, "Expected the committed writes to survive the leader")
+
+	response, _ = doClusterRequest(t, newLeader, http.MethodPost, "/set", `{"key":"after"}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	for _, node := range remaining {
+		require.Eventually(t, func() bool {
+			return node.srv.collectionManager.GetDefaultCollection().Get("key") == "after"
+		}, CLUSTER_TEST_WAIT, 10*time.Millisecond)
+	}
+}
+
+func TestCluster_AddAndRemoveNode(t *testing.T) {
+	nodes := startClusterNodes(t, []string{"n1", "n2", "n3", "n4"}, 3, 5)
+	leader := clusterLeader(t, nodes[:3])
+	for i := 0; i < 20; i++ {
+		response, _ := doClusterRequest(t, leader, http.MethodPost, "/set", fmt.Sprintf(`{"key%d":"value%d"}`, i, i))
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+	}
+
+	var follower *clusterTestNode
+	for _, node := range nodes[:3] {
+		if node != leader {
+			follower = node
+		}
+	}
+	added := nodes[3]
+	response, _ := doClusterRequest(t, follower, http.MethodPost, "/admin/cluster/nodes", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+	response, _ = doClusterRequest(t, leader, http.MethodPost, "/admin/cluster/nodes", `{"id":"n4"}`)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	response, body := doClusterRequest(t, leader, http.MethodPost, "/admin/cluster/nodes", fmt.Sprintf(`{"id":"n4","address":"%s"}`, added.url))
+	require.Equal(t, http.StatusOK, response.StatusCode, body)
+	require.Eventually(t, func() bool {
+		return added.srv.collectionManager.GetDefaultCollection().Get("key19") == "value19"
+	}, CLUSTER_TEST_WAIT, 10*time.Millisecond, "Expected the new node to receive the collections")
+	assert.Positive(t, added.srv.cluster.Node().Status().SnapshotIndex, "Expected the new node to receive a snapshot")
+	assert.Equal(t, "value0", added.srv.collectionManager.GetDefaultCollection().Get("key0"))
+
+	response, _ = doClusterRequest(t, leader, http.MethodDelete, "/admin/cluster/nodes/unknown", "")
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, body = doClusterRequest(t, leader, http.MethodDelete, "/admin/cluster/nodes/n4", "")
+	require.Equal(t, http.StatusOK, response.StatusCode, body)
+	var status raft.Status
+	require.NoError(t, json.Unmarshal([]byte(body), &status))
+	assert.Len(t, status.Servers, 3)
+}
+
+func TestClusterStore_RebuildsAfterFailedProposal(t *testing.T) {
+	nodes := startClusterNodes(t, []string{"n1"}, 1, 0)
+	leader := clusterLeader(t, nodes)
+	response, _ := doClusterRequest(t, leader, http.MethodPost, "/set", `{"key":"committed"}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+
+	// A write failing to reach the log is undone
+	store := leader.srv.cluster
+	store.Node().Stop()
+	store.Serve(func() {
+		assert.Error(t, leader.srv.collectionManager.GetDefaultCollection().Set("key", "lost"))
+	})
+	assert.Equal(t, "committed", leader.srv.collectionManager.GetDefaultCollection().Get("key"))
+	assert.Equal(t, store.Node().CommitIndex(), store.LastSeq())
+}
+
+func TestCluster_RespServer(t *testing.T) {
+	t.Setenv("DARE_RESP_PORT", "0")
+	nodes := startClusterNodes(t, []string{"n1", "n2", "n3"}, 3, 0)
+	leader := clusterLeader(t, nodes)
+
+	for _, node := range nodes {
+		node.srv.userStore.AddUser("admin", "secret")
+		server := NewRespServer(node.srv, NewConfiguration(""), logger.NewDareLogger())
+		server.authorizer = allowAllAuthorizer{}
+		require.NoError(t, server.Start())
+		t.Cleanup(server.Stop)
+		client := dialTestRespServer(t, server)
+		require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+		if node == leader {
+			assert.Equal(t, "OK", client.do("SET", "key", "value"))
+			assert.Equal(t, "value", client.do("GET", "key"))
+			assert.Equal(t, int64(1), client.do("RPUSH", "jobs", "job1"))
+			assert.Equal(t, []interface{}{"jobs", "job1"}, client.do("BLPOP", "jobs", "1"))
+			continue
+		}
+		reply := client.do("GET", "key")
+		require.IsType(t, resp.Error(""), reply)
+		assert.Equal(t, fmt.Sprintf("NOTLEADER This node is not the leader of the cluster, the leader is %s at %s", leader.id, leader.url), string(reply.(resp.Error)))
+	}
+}
+
+func TestNewDareServerWithConfig_Cluster(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("DARE_DATA_DIR", dataDir)
+	t.Setenv("DARE_CLUSTER_NODE_ID", "n1")
+	t.Setenv("DARE_CLUSTER_PEERS", "n1=http://127.0.0.1:2605")
+	t.Setenv("DARE_CLUSTER_ELECTION_TIMEOUT", "100ms")
+	t.Setenv("DARE_CLUSTER_HEARTBEAT_INTERVAL", "20ms")
+
+	start := func() *DareServer {
+		srv, err := NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), NewConfiguration(""))
+		require.NoError(t, err)
+		require.NotNil(t, srv.cluster)
+		// A single node cluster elects itself
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.Eventually(t, srv.cluster.Node().IsLeader, CLUSTER_TEST_WAIT, 10*time.Millisecond)
+		require.NoError(t, srv.cluster.Node().ReadIndex(ctx))
+		return srv
+	}
+
+	srv := start()
+	srv.cluster.Serve(func() {
+		require.NoError(t, srv.collectionManager.GetDefaultCollection().Set("key", "value"))
+	})
+	// The snapshot is postponed until the node applies its own entries
+	require.Eventually(t, func() bool {
+		return srv.cluster.Node().TakeSnapshot() == nil
+	}, CLUSTER_TEST_WAIT, 10*time.Millisecond)
+	srv.cluster.Serve(func() {
+		require.NoError(t, srv.collectionManager.GetDefaultCollection().Set("other", "value"))
+	})
+	require.NoError(t, srv.Close())
+
+	srv = start()
+	defer srv.Close()
+	assert.Equal(t, "value", srv.collectionManager.GetDefaultCollection().Get("key"), "Expected the raft snapshot to be restored")
+	assert.Equal(t, "value", srv.collectionManager.GetDefaultCollection().Get("other"), "Expected the raft log to be applied again")
+
+	t.Setenv("DARE_REPLICATION_LEADER_URL", "http://127.0.0.1:2606")
+	_, err := NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), NewConfiguration(""))
+	assert.Error(t, err, "Expected cluster mode and replication to be exclusive")
+}
+
+func TestParseClusterPeers(t *testing.T) {
+	peers, err := parseClusterPeers(" n1=http://10.0.0.1:2605 ,n2=http://10.0.0.2:2605,")
+	require.NoError(t, err)
+	assert.Equal(t, []raft.Server{{ID: "n1", Address: "http://10.0.0.1:2605"}, {ID: "n2", Address: "http://10.0.0.2:2605"}}, peers)
+
+	_, err = parseClusterPeers("n1")
+	assert.Error(t, err)
+}
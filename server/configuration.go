@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"time"
 
 	"os"
 	"path/filepath"
@@ -17,6 +18,8 @@ type Config interface {
 	Get(key string) interface{}
 	GetString(key string) string
 	GetBool(key string) bool
+	GetInt(key string) int
+	GetDuration(key string) time.Duration
 	IsSet(key string) bool
 }
 
@@ -43,15 +46,17 @@ func (c *ViperConfig) createDirectory(dirPath string) {
 	}
 }
 
-func (c *ViperConfig) createDefaultConfigFile(cfgFile string) {
-	var passwordNew string = utils.GenerateRandomString(12)
-
-	c.logger.Info("Creating default configuration file")
-
+// setDefaults registers the default values of the configuration, used for
+// the keys missing from the configuration file, such as the keys added since
+// it was created.
+func (c *ViperConfig) setDefaults() {
 	c.viper.SetDefault("server.host", "127.0.0.1")
 	c.viper.SetDefault("server.port", "2605")
 	c.viper.SetDefault("server.admin_user", "admin")
-	c.viper.SetDefault("server.admin_password", passwordNew)
+	c.viper.SetDefault("server.access_token_ttl", DEFAULT_ACCESS_TOKEN_TTL)
+	c.viper.SetDefault("server.refresh_token_ttl", DEFAULT_REFRESH_TOKEN_TTL)
+	c.viper.SetDefault("server.token_issuer", "")
+	c.viper.SetDefault("server.token_audience", "")
 
 	c.viper.SetDefault("log.log_level", "INFO")
 	c.viper.SetDefault("log.log_file", "daredb.log")
@@ -59,10 +64,52 @@ func (c *ViperConfig) createDefaultConfigFile(cfgFile string) {
 	c.viper.SetDefault("settings.data_dir", DATA_DIR)
 	c.viper.SetDefault("settings.settings_dir", SETTINGS_DIR)
 
+	c.viper.SetDefault("persistence.snapshot_interval", DEFAULT_SNAPSHOT_INTERVAL)
+	c.viper.SetDefault("persistence.snapshot_retention", DEFAULT_SNAPSHOT_RETENTION)
+	c.viper.SetDefault("persistence.aof_enabled", true)
+	c.viper.SetDefault("persistence.aof_fsync", DEFAULT_AOF_FSYNC)
+	c.viper.SetDefault("persistence.aof_rewrite_min_size", DEFAULT_AOF_REWRITE_MIN_SIZE)
+
+	c.viper.SetDefault("database.expire_sweep_interval", DEFAULT_EXPIRE_SWEEP_INTERVAL)
+	c.viper.SetDefault("database.max_memory", 0)
+	c.viper.SetDefault("database.eviction_policy", DEFAULT_EVICTION_POLICY)
+	c.viper.SetDefault("database.lock_stripes", DEFAULT_LOCK_STRIPES)
+
+	c.viper.SetDefault("pubsub.buffer_size", DEFAULT_PUBSUB_BUFFER_SIZE)
+
+	c.viper.SetDefault("replication.leader_url", "")
+	c.viper.SetDefault("replication.user", "")
+	c.viper.SetDefault("replication.password", "")
+	c.viper.SetDefault("replication.backlog_size", DEFAULT_REPLICATION_BACKLOG_SIZE)
+
+	c.viper.SetDefault("cluster.node_id", "")
+	c.viper.SetDefault("cluster.peers", "")
+	c.viper.SetDefault("cluster.user", "")
+	c.viper.SetDefault("cluster.password", "")
+	c.viper.SetDefault("cluster.heartbeat_interval", DEFAULT_CLUSTER_HEARTBEAT_INTERVAL)
+	c.viper.SetDefault("cluster.election_timeout", DEFAULT_CLUSTER_ELECTION_TIMEOUT)
+	c.viper.SetDefault("cluster.snapshot_threshold", DEFAULT_CLUSTER_SNAPSHOT_THRESHOLD)
+
+	c.viper.SetDefault("sharding.node_id", "")
+	c.viper.SetDefault("sharding.nodes", "")
+	c.viper.SetDefault("sharding.user", "")
+	c.viper.SetDefault("sharding.password", "")
+
+	c.viper.SetDefault("resp.enabled", false)
+	c.viper.SetDefault("resp.host", "127.0.0.1")
+	c.viper.SetDefault("resp.port", DEFAULT_RESP_PORT)
+
 	c.viper.SetDefault("security.tls_enabled", false)
 	c.viper.SetDefault("security.cert_private", filepath.Join(SETTINGS_DIR, "cert_private.pem"))
 	c.viper.SetDefault("security.cert_public", filepath.Join(SETTINGS_DIR, "cert_public.pem"))
+}
+
+func (c *ViperConfig) createDefaultConfigFile(cfgFile string) {
+	var passwordNew string = utils.GenerateRandomString(12)
+
+	c.logger.Info("Creating default configuration file")
 
+	c.viper.SetDefault("server.admin_password", passwordNew)
 	c.viper.WriteConfigAs(cfgFile)
 
 	c.logger.Info("\n\nIMPORTANT! Generate default password for admin on initial start. Store it securely. Password: ", passwordNew, "\n")
@@ -73,6 +120,10 @@ func (c *ViperConfig) mappingEnvsToConfig() {
 	c.mapsEnvsToConfig["server.port"] = "DARE_PORT"
 	c.mapsEnvsToConfig["server.admin_user"] = "DARE_USER"
 	c.mapsEnvsToConfig["server.admin_password"] = "DARE_PASSWORD"
+	c.mapsEnvsToConfig["server.access_token_ttl"] = "DARE_ACCESS_TOKEN_TTL"
+	c.mapsEnvsToConfig["server.refresh_token_ttl"] = "DARE_REFRESH_TOKEN_TTL"
+	c.mapsEnvsToConfig["server.token_issuer"] = "DARE_TOKEN_ISSUER"
+	c.mapsEnvsToConfig["server.token_audience"] = "DARE_TOKEN_AUDIENCE"
 
 	c.mapsEnvsToConfig["log.log_level"] = "DARE_LOG_LEVEL"
 	c.mapsEnvsToConfig["log.log_file"] = "DARE_LOG_FILE"
@@ -81,6 +132,41 @@ func (c *ViperConfig) mappingEnvsToConfig() {
 	c.mapsEnvsToConfig["settings.base_dir"] = "DARE_BASE_DIR"
 	c.mapsEnvsToConfig["settings.settings_dir"] = "DARE_SETTINGS_DIR"
 
+	c.mapsEnvsToConfig["persistence.snapshot_interval"] = "DARE_SNAPSHOT_INTERVAL"
+	c.mapsEnvsToConfig["persistence.snapshot_retention"] = "DARE_SNAPSHOT_RETENTION"
+	c.mapsEnvsToConfig["persistence.aof_enabled"] = "DARE_AOF_ENABLED"
+	c.mapsEnvsToConfig["persistence.aof_fsync"] = "DARE_AOF_FSYNC"
+	c.mapsEnvsToConfig["persistence.aof_rewrite_min_size"] = "DARE_AOF_REWRITE_MIN_SIZE"
+
+	c.mapsEnvsToConfig["database.expire_sweep_interval"] = "DARE_EXPIRE_SWEEP_INTERVAL"
+	c.mapsEnvsToConfig["database.max_memory"] = "DARE_MAX_MEMORY"
+	c.mapsEnvsToConfig["database.eviction_policy"] = "DARE_EVICTION_POLICY"
+	c.mapsEnvsToConfig["database.lock_stripes"] = "DARE_LOCK_STRIPES"
+
+	c.mapsEnvsToConfig["pubsub.buffer_size"] = "DARE_PUBSUB_BUFFER_SIZE"
+
+	c.mapsEnvsToConfig["replication.leader_url"] = "DARE_REPLICATION_LEADER_URL"
+	c.mapsEnvsToConfig["replication.user"] = "DARE_REPLICATION_USER"
+	c.mapsEnvsToConfig["replication.password"] = "DARE_REPLICATION_PASSWORD"
+	c.mapsEnvsToConfig["replication.backlog_size"] = "DARE_REPLICATION_BACKLOG_SIZE"
+
+	c.mapsEnvsToConfig["cluster.node_id"] = "DARE_CLUSTER_NODE_ID"
+	c.mapsEnvsToConfig["cluster.peers"] = "DARE_CLUSTER_PEERS"
+	c.mapsEnvsToConfig["cluster.user"] = "DARE_CLUSTER_USER"
+	c.mapsEnvsToConfig["cluster.password"] = "DARE_CLUSTER_PASSWORD"
+	c.mapsEnvsToConfig["cluster.heartbeat_interval"] = "DARE_CLUSTER_HEARTBEAT_INTERVAL"
+	c.mapsEnvsToConfig["cluster.election_timeout"] = "DARE_CLUSTER_ELECTION_TIMEOUT"
+	c.mapsEnvsToConfig["cluster.snapshot_threshold"] = "DARE_CLUSTER_SNAPSHOT_THRESHOLD"
+
+	c.mapsEnvsToConfig["sharding.node_id"] = "DARE_SHARDING_NODE_ID"
+	c.mapsEnvsToConfig["sharding.nodes"] = "DARE_SHARDING_NODES"
+	c.mapsEnvsToConfig["sharding.user"] = "DARE_SHARDING_USER"
+	c.mapsEnvsToConfig["sharding.password"] = "DARE_SHARDING_PASSWORD"
+
+	c.mapsEnvsToConfig["resp.enabled"] = "DARE_RESP_ENABLED"
+	c.mapsEnvsToConfig["resp.host"] = "DARE_RESP_HOST"
+	c.mapsEnvsToConfig["resp.port"] = "DARE_RESP_PORT"
+
 	c.mapsEnvsToConfig["security.tls_enabled"] = "DARE_TLS_ENABLED"
 	c.mapsEnvsToConfig["security.cert_private"] = "DARE_CERT_PRIVATE"
 	c.mapsEnvsToConfig["security.cert_public"] = "DARE_CERT_PUBLIC"
@@ -104,7 +190,11 @@ func (c *ViperConfig) initDBDirectories() {
 	os.Setenv("DARE_BASE_DIR", dbBaseDir)
 
 	c.createDirectory(filepath.Join(dbBaseDir, SETTINGS_DIR))
-	c.createDirectory(filepath.Join(dbBaseDir, c.GetString("settings.data_dir")))
+	dataDir := c.GetString("settings.data_dir")
+	if !filepath.IsAbs(dataDir) {
+		dataDir = filepath.Join(dbBaseDir, dataDir)
+	}
+	c.createDirectory(dataDir)
 }
 
 func NewConfiguration(cfgFile string) Config {
@@ -119,6 +209,7 @@ func NewConfiguration(cfgFile string) Config {
 
 	c := &ViperConfig{viper: v, logger: logger, mapsEnvsToConfig: make(map[string]string)}
 	c.mappingEnvsToConfig()
+	c.setDefaults()
 
 	if !c.checkFileExists(cfgFile) {
 		c.logger.Info("Configuration file does not exist: ", cfgFile)
@@ -154,6 +245,14 @@ func (c *ViperConfig) GetBool(key string) bool {
 	return c.viper.GetBool(key)
 }
 
+func (c *ViperConfig) GetInt(key string) int {
+	return c.viper.GetInt(key)
+}
+
+func (c *ViperConfig) GetDuration(key string) time.Duration {
+	return c.viper.GetDuration(key)
+}
+
 func (c *ViperConfig) IsSet(key string) bool {
 	return c.viper.IsSet(key)
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,6 +74,8 @@ func TestDefaultParameters(t *testing.T) {
 	assert.Equal(t, "INFO", testConfig.GetString("log.log_level"), "Must be 'INFO'")
 	assert.Equal(t, "daredb.log", testConfig.GetString("log.log_file"), "Must be 'daredb.log'")
 	assert.Equal(t, false, testConfig.GetBool("security.tls_enabled"), "Must be 'false'")
+	assert.Equal(t, time.Hour, testConfig.GetDuration("server.access_token_ttl"), "Must be one hour")
+	assert.Equal(t, 7*24*time.Hour, testConfig.GetDuration("server.refresh_token_ttl"), "Must be one week")
 }
 
 func TestConfigurationConstants(t *testing.T) {
@@ -96,3 +99,22 @@ func TestConfiguratioReReadFeature(t *testing.T) {
 	// Check if the values are correctly set
 	assert.Equal(t, "2606", testConfig.GetString("server.port"), "Port should be '2606'")
 }
+
+func TestConfigurationDefaultsForExistingFile(t *testing.T) {
+	checkCorrectTestDirectory()
+	defer TeardownTestConfiguration()
+
+	// A configuration file written before the persistence settings existed
+	oldConfig := "[server]\nhost = '127.0.0.1'\nport = '2605'\nadmin_user = 'admin'\nadmin_password = 'secret'\n"
+	if err := os.WriteFile(TEST_CONFIG_FILE, []byte(oldConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testConfig := NewConfiguration(TEST_CONFIG_FILE)
+
+	assert.Equal(t, "secret", testConfig.GetString("server.admin_password"))
+	assert.Equal(t, true, testConfig.GetBool("persistence.aof_enabled"), "Must be 'true'")
+	assert.Equal(t, 5*time.Minute, testConfig.GetDuration("persistence.snapshot_interval"), "Must be five minutes")
+	assert.Equal(t, DEFAULT_LOCK_STRIPES, testConfig.GetInt("database.lock_stripes"))
+	assert.Equal(t, time.Hour, testConfig.GetDuration("server.access_token_ttl"), "Must be one hour")
+	assert.Equal(t, DEFAULT_CLUSTER_ELECTION_TIMEOUT, testConfig.GetString("cluster.election_timeout"))
+}
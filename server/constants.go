@@ -3,3 +3,19 @@ package server
 const DEFAULT_CONFIG_FILE string = "config.toml"
 const DATA_DIR string = "data"         // use to settings relevant to database instance
 const SETTINGS_DIR string = "settings" // use to settings relevant to database instance
+
+const DEFAULT_SNAPSHOT_INTERVAL string = "5m"             // interval between automatic snapshots of all collections
+const DEFAULT_SNAPSHOT_RETENTION int = 3                  // number of snapshots kept in the data directory
+const DEFAULT_AOF_FSYNC string = "everysec"               // fsync policy of the append only log: always, everysec or no
+const DEFAULT_AOF_REWRITE_MIN_SIZE int = 64 << 20         // minimum size in bytes before the append only log is rewritten
+const DEFAULT_EXPIRE_SWEEP_INTERVAL string = "100ms"      // interval between two passes evicting expired keys
+const DEFAULT_EVICTION_POLICY string = "noeviction"       // policy applied when database.max_memory is reached
+const DEFAULT_LOCK_STRIPES int = 16                       // independently locked stripes the keys of a collection are spread over
+const DEFAULT_RESP_PORT string = "6380"                   // port of the RESP listener, when resp.enabled is set
+const DEFAULT_PUBSUB_BUFFER_SIZE int = 256                // pending messages buffered per pub/sub subscriber before it is disconnected
+const DEFAULT_REPLICATION_BACKLOG_SIZE int = 10000        // operations kept by a leader for the followers resuming their stream
+const DEFAULT_CLUSTER_HEARTBEAT_INTERVAL string = "100ms" // interval between two heartbeats of the leader of a cluster
+const DEFAULT_CLUSTER_ELECTION_TIMEOUT string = "1s"      // silence of the leader after which a node of a cluster starts an election
+const DEFAULT_CLUSTER_SNAPSHOT_THRESHOLD int = 8192       // entries of the raft log applied before they are compacted into a snapshot
+const DEFAULT_ACCESS_TOKEN_TTL string = "60m"             // lifetime of the access tokens issued on login and refresh
+const DEFAULT_REFRESH_TOKEN_TTL string = "168h"           // lifetime of the refresh tokens, renewed on every refresh
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+func (srv *DareServer) HandlerIncr(w http.ResponseWriter, r *http.Request) {
+	srv.incr(w, r, srv.collectionManager.GetDefaultCollection(), 1)
+}
+
+func (srv *DareServer) HandlerCollectionIncr(w http.ResponseWriter, r *http.Request) {
+	srv.incr(w, r, srv.collectionForWrite(r), 1)
+}
+
+func (srv *DareServer) HandlerDecr(w http.ResponseWriter, r *http.Request) {
+	srv.incr(w, r, srv.collectionManager.GetDefaultCollection(), -1)
+}
+
+func (srv *DareServer) HandlerCollectionDecr(w http.ResponseWriter, r *http.Request) {
+	srv.incr(w, r, srv.collectionForWrite(r), -1)
+}
+
+func (srv *DareServer) HandlerIncrByFloat(w http.ResponseWriter, r *http.Request) {
+	srv.incrByFloat(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionIncrByFloat(w http.ResponseWriter, r *http.Request) {
+	srv.incrByFloat(w, r, srv.collectionForWrite(r))
+}
+
+// collectionForWrite returns the collection named in the path, creating it if needed.
+func (srv *DareServer) collectionForWrite(r *http.Request) *database.Database {
+	collectionName := r.PathValue(COLLECTION_NAME_PARAM)
+	collection, exists := srv.collectionManager.GetCollection(collectionName)
+	if !exists {
+		srv.collectionManager.AddCollection(collectionName)
+		collection, _ = srv.collectionManager.GetCollection(collectionName)
+	}
+	return collection
+}
+
+// incr adds the by query parameter, 1 by default, multiplied by sign to the
+// integer stored under the key.
+func (srv *DareServer) incr(w http.ResponseWriter, r *http.Request, collection *database.Database, sign int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	delta := int64(1)
+	if by := r.URL.Query().Get(BY_PARAM); by != "" {
+		var err error
+		delta, err = strconv.ParseInt(by, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`query param "%s" must be an integer`, BY_PARAM), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var value int64
+	var err error
+	if sign < 0 {
+		value, err = collection.DecrBy(key, delta)
+	} else {
+		value, err = collection.IncrBy(key, delta)
+	}
+	writeCounterResponse(w, key, value, err)
+}
+
+func (srv *DareServer) incrByFloat(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	delta, err := strconv.ParseFloat(r.URL.Query().Get(BY_PARAM), 64)
+	if err != nil || math.IsInf(delta, 0) || math.IsNaN(delta) {
+		http.Error(w, fmt.Sprintf(`query param "%s" must be a number`, BY_PARAM), http.StatusBadRequest)
+		return
+	}
+
+	value, err := collection.IncrByFloat(key, delta)
+	writeCounterResponse(w, key, value, err)
+}
+
+func writeCounterResponse(w http.ResponseWriter, key string, value interface{}, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotInteger), errors.Is(err, database.ErrNotNumber), errors.Is(err, database.ErrOverflow):
+		http.Error(w, fmt.Sprintf(`Key "%v": %s`, key, err.Error()), http.StatusConflict)
+		return
+	case errors.Is(err, database.ErrOutOfMemory):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	case err != nil:
+		http.Error(w, "Error saving data", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(map[string]interface{}{"value": value})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
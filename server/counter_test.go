@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerIncrAndDecr(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	counterRequest := func(url string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("POST", url, nil)
+		request.SetPathValue(KEY_PARAM, "counter")
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response
+	}
+
+	response := counterRequest("/incr/counter", srv.HandlerIncr)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"value":1}`, response.Body.String())
+
+	response = counterRequest("/incr/counter?by=10", srv.HandlerIncr)
+	assert.JSONEq(t, `{"value":11}`, response.Body.String())
+
+	response = counterRequest("/decr/counter?by=3", srv.HandlerDecr)
+	assert.JSONEq(t, `{"value":8}`, response.Body.String())
+
+	response = counterRequest("/incrbyfloat/counter?by=0.25", srv.HandlerIncrByFloat)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"value":8.25}`, response.Body.String())
+
+	response = counterRequest("/incr/counter", srv.HandlerIncr)
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), "not an integer")
+
+	response = counterRequest("/incr/counter?by=abc", srv.HandlerIncr)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = counterRequest("/incrbyfloat/counter", srv.HandlerIncrByFloat)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestHandlerCollectionIncr(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	request, _ := http.NewRequest("POST", "/collections/rates/incr/hits?by=2", nil)
+	request.SetPathValue(COLLECTION_NAME_PARAM, "rates")
+	request.SetPathValue(KEY_PARAM, "hits")
+	response := httptest.NewRecorder()
+	srv.HandlerCollectionIncr(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	rates, exists := srv.collectionManager.GetCollection("rates")
+	require.True(t, exists)
+	assert.Equal(t, "2", rates.Get("hits"))
+}
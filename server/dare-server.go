@@ -2,16 +2,23 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/dmarro89/dare-db/auth"
 	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/pubsub"
+	"github.com/dmarro89/dare-db/raft"
 )
 
-const KEY_PARAM = "key"
-const COLLECTION_NAME_PARAM = "collectionName"
+const KEY_PARAM = auth.KEY_PATH_VALUE
+const COLLECTION_NAME_PARAM = auth.COLLECTION_PATH_VALUE
 
 type IDare interface {
 	CreateMux(auth.Authorizer, auth.Authenticator) *http.ServeMux
@@ -24,50 +31,356 @@ type IDare interface {
 type DareServer struct {
 	userStore         *auth.UserStore
 	collectionManager *database.CollectionManager
+	snapshotter       *database.Snapshotter
+	appendLog         *database.AppendLog
+	sweeper           *database.ExpirationSweeper
+	broker            *pubsub.Broker
+	replication       *database.ReplicationLog
+	follower          *Follower
+	cluster           *ClusterStore
+	clusterStorage    *raft.FileStorage
+	shards            *Sharding
+
+	// authorizer is shared by the HTTP and RESP servers, created on first
+	// use with its policies in policyPath, or the default policy file.
+	// adminUser is given the admin role in policyPath
+	authorizer   *auth.CasbinAuth
+	policyPath   string
+	adminUser    string
+	authorizerMu sync.Mutex
+
+	// tokenOptions are the lifetimes, issuer and audience of the tokens
+	// issued on login and refresh
+	tokenOptions auth.TokenOptions
 }
 
 func NewDareServer(db *database.Database, userStore *auth.UserStore) *DareServer {
+	replication := database.NewReplicationLog(nil, database.DEFAULT_REPLICATION_BACKLOG_SIZE)
 	collectionManager := database.NewCollectionManager()
+	collectionManager.SetJournal(replication)
 	collectionManager.AddCollection(database.DEFAULT_COLLECTION)
 
 	return &DareServer{
 		userStore:         userStore,
 		collectionManager: collectionManager,
+		broker:            pubsub.NewBroker(pubsub.DEFAULT_BUFFER_SIZE),
+		replication:       replication,
+		tokenOptions:      auth.DefaultTokenOptions(),
+		adminUser:         auth.DEFAULT_USER,
 	}
 }
 
+// NewDareServerWithConfig creates a DareServer whose collections are persisted
+// into settings.data_dir, as snapshots and optionally as an append only log.
+// The latest snapshot, if any, and the operations logged after it are loaded
+// before the server is returned. When cluster.node_id is set, the
+// collections are instead replicated and persisted by the raft node of the
+// server, which is started. When sharding.node_id is set, the server only
+// holds the keys of the hash slots it owns in its sharded cluster.
+func NewDareServerWithConfig(db *database.Database, userStore *auth.UserStore, configuration Config) (*DareServer, error) {
+	srv := NewDareServer(db, userStore)
+	srv.policyPath = filepath.Join(configuration.GetString("settings.settings_dir"), auth.POLICY_FILE)
+	srv.adminUser = configuration.GetString("server.admin_user")
+	srv.tokenOptions = auth.TokenOptions{
+		AccessTimeToLive:  configuration.GetDuration("server.access_token_ttl"),
+		RefreshTimeToLive: configuration.GetDuration("server.refresh_token_ttl"),
+		Issuer:            configuration.GetString("server.token_issuer"),
+		Audience:          configuration.GetString("server.token_audience"),
+	}
+	srv.collectionManager.SetLockStripes(configuration.GetInt("database.lock_stripes"))
+	policy, err := database.ParseEvictionPolicy(configuration.GetString("database.eviction_policy"))
+	if err != nil {
+		return nil, err
+	}
+	maxMemory := int64(configuration.GetInt("database.max_memory"))
+
+	if configuration.GetString("sharding.node_id") != "" {
+		if configuration.GetString("cluster.node_id") != "" || configuration.GetString("replication.leader_url") != "" {
+			return nil, errors.New("sharding.node_id cannot be set with cluster.node_id or replication.leader_url")
+		}
+		if err := srv.startSharding(configuration); err != nil {
+			return nil, err
+		}
+	}
+
+	if configuration.GetString("cluster.node_id") != "" {
+		if configuration.GetString("replication.leader_url") != "" {
+			return nil, errors.New("replication.leader_url cannot be set in cluster mode")
+		}
+		// Evicting keys at random would make the nodes diverge
+		if maxMemory > 0 && policy != database.NO_EVICTION {
+			return nil, errors.New("database.eviction_policy must be noeviction in cluster mode")
+		}
+		if err := srv.startCluster(configuration); err != nil {
+			return nil, err
+		}
+	} else if err := srv.loadPersistence(configuration); err != nil {
+		return nil, err
+	}
+	srv.collectionManager.SetMaxMemory(maxMemory, policy)
+
+	srv.broker = pubsub.NewBroker(configuration.GetInt("pubsub.buffer_size"))
+
+	sweepInterval := configuration.GetDuration("database.expire_sweep_interval")
+	if sweepInterval <= 0 {
+		sweepInterval = database.DEFAULT_EXPIRATION_SWEEP_INTERVAL
+	}
+	srv.sweeper = database.NewExpirationSweeper(srv.collectionManager)
+	srv.sweeper.Start(sweepInterval)
+
+	if leaderURL := configuration.GetString("replication.leader_url"); leaderURL != "" {
+		username, password := configuration.GetString("replication.user"), configuration.GetString("replication.password")
+		if username == "" {
+			username, password = configuration.GetString("server.admin_user"), configuration.GetString("server.admin_password")
+		}
+		srv.Follow(leaderURL, username, password)
+	}
+	return srv, nil
+}
+
+// loadPersistence loads the latest snapshot and the append only log, then
+// starts the periodic snapshots.
+func (srv *DareServer) loadPersistence(configuration Config) error {
+	dataDir := configuration.GetString("settings.data_dir")
+
+	srv.snapshotter = database.NewSnapshotter(srv.collectionManager, dataDir, configuration.GetInt("persistence.snapshot_retention"))
+	snapshot, err := srv.snapshotter.LoadLatest()
+	if err != nil {
+		return err
+	}
+	if _, exists := srv.collectionManager.GetCollection(database.DEFAULT_COLLECTION); !exists {
+		srv.collectionManager.AddCollection(database.DEFAULT_COLLECTION)
+	}
+
+	if configuration.GetBool("persistence.aof_enabled") {
+		fsync, err := database.ParseFsyncPolicy(configuration.GetString("persistence.aof_fsync"))
+		if err != nil {
+			return err
+		}
+
+		var snapshotSeq uint64
+		if snapshot != nil {
+			snapshotSeq = snapshot.Seq
+		}
+
+		srv.appendLog = database.NewAppendLog(filepath.Join(dataDir, database.AOF_FILE_NAME), fsync, int64(configuration.GetInt("persistence.aof_rewrite_min_size")))
+		if _, err := srv.appendLog.Replay(srv.collectionManager, snapshotSeq); err != nil {
+			return err
+		}
+		if err := srv.appendLog.Open(srv.collectionManager); err != nil {
+			return err
+		}
+	}
+
+	// The replication log forwards the operations to the append only log
+	var journal database.Journal
+	if srv.appendLog != nil {
+		journal = srv.appendLog
+	}
+	srv.replication = database.NewReplicationLog(journal, configuration.GetInt("replication.backlog_size"))
+	srv.collectionManager.SetJournal(srv.replication)
+
+	srv.snapshotter.Start(configuration.GetDuration("persistence.snapshot_interval"))
+	return nil
+}
+
+// Close stops the replication of the leader, ends the streams of the
+// subscribers and followers, stops the expiration sweeper and the periodic
+// snapshots, writes a final one and closes the append only log. A member of
+// a cluster stops its raft node and closes its log instead.
+func (srv *DareServer) Close() error {
+	if srv.follower != nil {
+		srv.follower.Stop()
+	}
+	srv.CloseSubscriptions()
+	if srv.sweeper != nil {
+		srv.sweeper.Stop()
+	}
+	if srv.cluster != nil {
+		srv.cluster.Node().Stop()
+		return srv.clusterStorage.Close()
+	}
+	if srv.snapshotter == nil {
+		return nil
+	}
+
+	srv.snapshotter.Stop()
+	_, err := srv.snapshotter.Save()
+	if srv.appendLog != nil {
+		if closeErr := srv.appendLog.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// defaultAuthorizer returns the authorizer of the server, creating it on the
+// first call. Its roles and policies are kept in policyPath when set, where
+// the admin user of the server is given the admin role.
+func (srv *DareServer) defaultAuthorizer() *auth.CasbinAuth {
+	srv.authorizerMu.Lock()
+	defer srv.authorizerMu.Unlock()
+
+	if srv.authorizer == nil {
+		if srv.policyPath != "" {
+			srv.authorizer = auth.GetDefaultAuthWithPolicyFile(srv.policyPath, srv.adminUser)
+		} else {
+			srv.authorizer = auth.GetDefaultAuth()
+		}
+	}
+	return srv.authorizer
+}
+
+// authenticator returns the authenticator of the tokens of the users of the
+// server.
+func (srv *DareServer) authenticator() *auth.JWTAutenticator {
+	return auth.NewJWTAutenticatorWithOptions(srv.userStore, srv.tokenOptions)
+}
+
 func (srv *DareServer) CreateMux(authorizer auth.Authorizer, authenticator auth.Authenticator) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	if authorizer == nil {
-		authorizer = auth.GetDefaultAuth()
+		authorizer = srv.defaultAuthorizer()
 	}
 
 	if authenticator == nil {
-		authenticator = auth.NewJWTAutenticatorWithUsers(srv.userStore)
+		authenticator = srv.authenticator()
 	}
 
 	middleware := auth.NewCasbinMiddleware(authorizer, authenticator)
+	// keyed serves the requests on the key of the path on the node owning it, in a sharded cluster
+	keyed := func(handler http.HandlerFunc) http.HandlerFunc {
+		return srv.routeToOwner(middleware.HandleFunc, authorizer, pathKey, handler)
+	}
+	serverAction := func(action string) func(http.HandlerFunc) http.HandlerFunc {
+		return func(handler http.HandlerFunc) http.HandlerFunc {
+			return middleware.HandleServerFunc(action, handler)
+		}
+	}
+	serverAdmin := serverAction(auth.ACTION_ADMIN)
+	// eachKey authorizes the requests on every key returned by keysOf, such as the keys of a body
+	eachKey := func(keysOf shardKeys) func(http.HandlerFunc) http.HandlerFunc {
+		return func(handler http.HandlerFunc) http.HandlerFunc {
+			return middleware.HandleAccessFunc(keyAccesses(keysOf), handler)
+		}
+	}
+	// transactionAccess authorizes the transactions on the keys they read and write
+	transactionAccess := func(handler http.HandlerFunc) http.HandlerFunc {
+		return middleware.HandleAccessFunc(transactionAccesses, handler)
+	}
 	mux.HandleFunc(
-		fmt.Sprintf(`GET /get/{%s}`, KEY_PARAM), middleware.HandleFunc(srv.HandlerGetById))
-	mux.HandleFunc("POST /set", middleware.HandleFunc(srv.HandlerSet))
-	mux.HandleFunc(fmt.Sprintf(`DELETE /delete/{%s}`, KEY_PARAM), middleware.HandleFunc(srv.HandlerDelete))
+		fmt.Sprintf(`GET /get/{%s}`, KEY_PARAM), keyed(srv.HandlerGetById))
+	mux.HandleFunc("POST /set", srv.routeToOwner(eachKey(setBodyKeys), authorizer, setBodyKeys, srv.HandlerSet))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /delete/{%s}`, KEY_PARAM), keyed(srv.HandlerDelete))
 	mux.HandleFunc("POST /login", srv.HandlerLogin)
+	mux.HandleFunc("POST /logout", srv.HandlerLogout)
+	mux.HandleFunc("POST /refresh", srv.HandlerRefresh)
 	mux.HandleFunc(
-		fmt.Sprintf(`GET /collections/{%s}`, KEY_PARAM), middleware.HandleFunc(srv.HandlerGetCollection))
-	mux.HandleFunc(
-		`GET /collections`, middleware.HandleFunc(srv.HandlerGetCollections))
-	mux.HandleFunc(fmt.Sprintf("POST /collections/{%s}", COLLECTION_NAME_PARAM), middleware.HandleFunc(srv.HandlerCreateCollection))
-	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}`, COLLECTION_NAME_PARAM), middleware.HandleFunc(srv.HandlerDeleteCollection))
+		`GET /collections`, srv.routeToAll(serverAction(auth.ACTION_READ), authorizer, srv.HandlerGetCollections, srv.gatherCollectionNames))
+	mux.HandleFunc(fmt.Sprintf("POST /collections/{%s}", COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleAdminFunc, authorizer, srv.HandlerCreateCollection, srv.broadcast))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}`, COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleAdminFunc, authorizer, srv.HandlerDeleteCollection, srv.broadcast))
 	mux.HandleFunc(
-		fmt.Sprintf(`GET /collections/{%s}/get/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), middleware.HandleFunc(srv.HandlerCollectionGetById))
+		fmt.Sprintf(`GET /collections/{%s}/get/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionGetById))
 	mux.HandleFunc(
-		fmt.Sprintf(`GET /collections/{%s}/items`, COLLECTION_NAME_PARAM), middleware.HandleFunc(srv.HandlerGetPaginatedCollectionItems))
-	mux.HandleFunc(fmt.Sprintf("POST /collections/{%s}/set", COLLECTION_NAME_PARAM), middleware.HandleFunc(srv.HandlerCollectionSet))
-	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}/delete/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), middleware.HandleFunc(srv.HandlerCollectionDelete))
+		fmt.Sprintf(`GET /collections/{%s}/items`, COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleFunc, authorizer, srv.HandlerGetPaginatedCollectionItems, srv.gatherItems))
+	mux.HandleFunc(fmt.Sprintf("POST /collections/{%s}/set", COLLECTION_NAME_PARAM), srv.routeToOwner(eachKey(setBodyKeys), authorizer, setBodyKeys, srv.HandlerCollectionSet))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}/delete/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionDelete))
+	mux.HandleFunc("GET /scan", srv.routeToAll(middleware.HandleFunc, authorizer, srv.HandlerScan, srv.scanNodes))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/scan`, COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleFunc, authorizer, srv.HandlerCollectionScan, srv.scanNodes))
+	mux.HandleFunc("POST /index", srv.routeToAll(middleware.HandleAdminFunc, authorizer, srv.HandlerCreateIndex, srv.broadcast))
+	mux.HandleFunc("DELETE /index", srv.routeToAll(middleware.HandleAdminFunc, authorizer, srv.HandlerDropIndex, srv.broadcast))
+	mux.HandleFunc("GET /range", srv.routeToAll(middleware.HandleFunc, authorizer, srv.HandlerRange, srv.gatherRange))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/index`, COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleAdminFunc, authorizer, srv.HandlerCollectionCreateIndex, srv.broadcast))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}/index`, COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleAdminFunc, authorizer, srv.HandlerCollectionDropIndex, srv.broadcast))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/range`, COLLECTION_NAME_PARAM), srv.routeToAll(middleware.HandleFunc, authorizer, srv.HandlerCollectionRange, srv.gatherRange))
+	mux.HandleFunc(fmt.Sprintf(`GET /json/{%s}`, KEY_PARAM), keyed(srv.HandlerGetDocument))
+	mux.HandleFunc(fmt.Sprintf(`POST /json/{%s}`, KEY_PARAM), keyed(srv.HandlerSetDocument))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /json/{%s}`, KEY_PARAM), keyed(srv.HandlerDeleteDocumentPath))
+	mux.HandleFunc(fmt.Sprintf(`POST /json/{%s}/incr`, KEY_PARAM), keyed(srv.HandlerIncrDocumentPath))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/json/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionGetDocument))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/json/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionSetDocument))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}/json/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionDeleteDocumentPath))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/json/{%s}/incr`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionIncrDocumentPath))
+	mux.HandleFunc(fmt.Sprintf(`POST /incr/{%s}`, KEY_PARAM), keyed(srv.HandlerIncr))
+	mux.HandleFunc(fmt.Sprintf(`POST /decr/{%s}`, KEY_PARAM), keyed(srv.HandlerDecr))
+	mux.HandleFunc(fmt.Sprintf(`POST /incrbyfloat/{%s}`, KEY_PARAM), keyed(srv.HandlerIncrByFloat))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/incr/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionIncr))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/decr/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionDecr))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/incrbyfloat/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionIncrByFloat))
+	mux.HandleFunc(fmt.Sprintf(`GET /lists/{%s}`, KEY_PARAM), keyed(srv.HandlerListRange))
+	mux.HandleFunc(fmt.Sprintf(`GET /lists/{%s}/len`, KEY_PARAM), keyed(srv.HandlerListLen))
+	mux.HandleFunc(fmt.Sprintf(`POST /lists/{%s}/{%s}`, KEY_PARAM, LIST_COMMAND_PARAM), keyed(srv.HandlerListCommand))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/lists/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionListRange))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/lists/{%s}/len`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionListLen))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/lists/{%s}/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM, LIST_COMMAND_PARAM), keyed(srv.HandlerCollectionListCommand))
+	mux.HandleFunc(fmt.Sprintf(`GET /hashes/{%s}`, KEY_PARAM), keyed(srv.HandlerHashGetAll))
+	mux.HandleFunc(fmt.Sprintf(`POST /hashes/{%s}`, KEY_PARAM), keyed(srv.HandlerHashSet))
+	mux.HandleFunc(fmt.Sprintf(`GET /hashes/{%s}/{%s}`, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerHashGet))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /hashes/{%s}/{%s}`, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerHashDelete))
+	mux.HandleFunc(fmt.Sprintf(`GET /hashes/{%s}/{%s}/exists`, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerHashExists))
+	mux.HandleFunc(fmt.Sprintf(`POST /hashes/{%s}/{%s}/incr`, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerHashIncr))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/hashes/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionHashGetAll))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/hashes/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionHashSet))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/hashes/{%s}/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerCollectionHashGet))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /collections/{%s}/hashes/{%s}/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerCollectionHashDelete))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/hashes/{%s}/{%s}/exists`, COLLECTION_NAME_PARAM, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerCollectionHashExists))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/hashes/{%s}/{%s}/incr`, COLLECTION_NAME_PARAM, KEY_PARAM, FIELD_PARAM), keyed(srv.HandlerCollectionHashIncr))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/sets`, COLLECTION_NAME_PARAM), srv.routeToOwner(eachKey(queryKeys), authorizer, queryKeys, srv.HandlerCollectionSetCombine))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/sets/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionSetMembers))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/sets/{%s}/contains`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionSetContains))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/sets/{%s}/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM, SET_COMMAND_PARAM), keyed(srv.HandlerCollectionSetCommand))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/zsets/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionSortedSetRange))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/zsets/{%s}/rank`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionSortedSetRank))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/zsets/{%s}/score`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionSortedSetScore))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/zsets/{%s}/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM, SET_COMMAND_PARAM), keyed(srv.HandlerCollectionSortedSetCommand))
+	mux.HandleFunc(fmt.Sprintf(`POST /publish/{%s}`, CHANNEL_PARAM), middleware.HandleServerFunc(auth.ACTION_WRITE, srv.HandlerPublish))
+	mux.HandleFunc("GET /subscribe", middleware.HandleServerFunc(auth.ACTION_READ, srv.HandlerSubscribe))
+	mux.HandleFunc("GET /subscribe/ws", middleware.HandleServerFunc(auth.ACTION_READ, srv.HandlerSubscribeWebSocket))
+	mux.HandleFunc("GET /watch", middleware.HandleFunc(srv.HandlerWatch))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/watch`, COLLECTION_NAME_PARAM), middleware.HandleFunc(srv.HandlerCollectionWatch))
+	mux.HandleFunc("POST /transaction", srv.routeToOwner(transactionAccess, authorizer, transactionKeys, srv.HandlerTransaction))
+	mux.HandleFunc(fmt.Sprintf(`POST /expire/{%s}`, KEY_PARAM), keyed(srv.HandlerExpire))
+	mux.HandleFunc(fmt.Sprintf(`POST /persist/{%s}`, KEY_PARAM), keyed(srv.HandlerPersist))
+	mux.HandleFunc(fmt.Sprintf(`GET /ttl/{%s}`, KEY_PARAM), keyed(srv.HandlerTTL))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/expire/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionExpire))
+	mux.HandleFunc(fmt.Sprintf(`POST /collections/{%s}/persist/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionPersist))
+	mux.HandleFunc(fmt.Sprintf(`GET /collections/{%s}/ttl/{%s}`, COLLECTION_NAME_PARAM, KEY_PARAM), keyed(srv.HandlerCollectionTTL))
+	mux.HandleFunc("GET /admin/memory", serverAdmin(srv.HandlerMemory))
+	mux.HandleFunc("POST /admin/snapshot", serverAdmin(srv.HandlerSnapshot))
+	mux.HandleFunc("POST /admin/aof/rewrite", serverAdmin(srv.HandlerRewriteAppendLog))
+	mux.HandleFunc("GET /admin/replication", serverAdmin(srv.HandlerReplicationStatus))
+	mux.HandleFunc("GET /replication/stream", serverAdmin(srv.HandlerReplicationStream))
+	mux.HandleFunc("GET /admin/cluster", serverAdmin(srv.HandlerClusterStatus))
+	mux.HandleFunc("POST /admin/cluster/nodes", serverAdmin(srv.HandlerClusterAddNode))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /admin/cluster/nodes/{%s}`, NODE_ID_PARAM), serverAdmin(srv.HandlerClusterRemoveNode))
+	mux.HandleFunc("GET /admin/shards", serverAdmin(srv.HandlerShardStatus))
+	mux.HandleFunc("POST /admin/shards/migrate", serverAdmin(srv.HandlerShardMigrate))
+	mux.HandleFunc("GET /admin/users", serverAdmin(srv.HandlerListUsers))
+	mux.HandleFunc("POST /admin/users", serverAdmin(srv.HandlerCreateUser))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /admin/users/{%s}`, USERNAME_PARAM), serverAdmin(srv.HandlerDeleteUser))
+	mux.HandleFunc(fmt.Sprintf(`PUT /admin/users/{%s}/password`, USERNAME_PARAM), serverAdmin(srv.HandlerChangePassword))
+	mux.HandleFunc(fmt.Sprintf(`POST /admin/users/{%s}/reset`, USERNAME_PARAM), serverAdmin(srv.HandlerResetCredentials))
+	mux.HandleFunc(fmt.Sprintf(`GET /admin/users/{%s}/sessions`, USERNAME_PARAM), serverAdmin(srv.HandlerListSessions))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /admin/users/{%s}/sessions`, USERNAME_PARAM), serverAdmin(srv.HandlerRevokeSessions))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /admin/users/{%s}/sessions/{%s}`, USERNAME_PARAM, SESSION_ID_PARAM), serverAdmin(srv.HandlerRevokeSession))
+	mux.HandleFunc(fmt.Sprintf(`GET /admin/users/{%s}/roles`, USERNAME_PARAM), serverAdmin(srv.HandlerGetRoles))
+	mux.HandleFunc(fmt.Sprintf(`PUT /admin/users/{%s}/roles/{%s}`, USERNAME_PARAM, ROLE_PARAM), serverAdmin(srv.HandlerAddRole))
+	mux.HandleFunc(fmt.Sprintf(`DELETE /admin/users/{%s}/roles/{%s}`, USERNAME_PARAM, ROLE_PARAM), serverAdmin(srv.HandlerDeleteRole))
+	mux.HandleFunc(fmt.Sprintf(`GET /admin/users/{%s}/permissions`, USERNAME_PARAM), serverAdmin(srv.HandlerGetPermissions))
+	mux.HandleFunc("GET /admin/policies", serverAdmin(srv.HandlerGetPolicies))
+	mux.HandleFunc("POST /admin/policies", serverAdmin(srv.HandlerAddPolicy))
+	mux.HandleFunc("DELETE /admin/policies", serverAdmin(srv.HandlerRemovePolicy))
+	mux.HandleFunc("POST /admin/policies/reload", serverAdmin(srv.HandlerReloadPolicies))
+	mux.HandleFunc("GET /shard/slots", srv.authenticateNode(authorizer, srv.HandlerShardSlots))
+	mux.HandleFunc("POST /shard/slots", srv.authenticateNode(authorizer, srv.HandlerShardSlots))
+	mux.HandleFunc("POST /shard/import", srv.authenticateNode(authorizer, srv.HandlerShardImport))
+	mux.HandleFunc("POST /raft/vote", srv.authenticateNode(authorizer, srv.HandlerRaftVote))
+	mux.HandleFunc("POST /raft/append", srv.authenticateNode(authorizer, srv.HandlerRaftAppend))
+	mux.HandleFunc("POST /raft/snapshot", srv.authenticateNode(authorizer, srv.HandlerRaftSnapshot))
 
 	// Wrap the mux with the CORS handler
-	corsHandler := srv.setupCORS(mux)
+	corsHandler := srv.setupCORS(srv.rejectWritesOnFollower(srv.serveFromLeader(mux)))
 	// Create a new ServeMux that uses the CORS handler.
 	finalMux := http.NewServeMux()
 	finalMux.Handle("/", corsHandler)
@@ -87,12 +400,18 @@ func (srv *DareServer) HandlerGetById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	val := srv.collectionManager.GetDefaultCollection().Get(key)
+	val, version := srv.collectionManager.GetDefaultCollection().GetWithVersion(key)
 	if val == "" {
 		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
 		return
 	}
 
+	w.Header().Set("ETag", etag(version))
+	if matchesETags(parseETags(r.Header.Get("If-None-Match")), version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	response, err := json.Marshal(map[string]string{key: val})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -122,12 +441,18 @@ func (srv *DareServer) HandlerCollectionGetById(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	val := collection.Get(key)
+	val, version := collection.GetWithVersion(key)
 	if val == "" {
 		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
 		return
 	}
 
+	w.Header().Set("ETag", etag(version))
+	if matchesETags(parseETags(r.Header.Get("If-None-Match")), version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	response, err := json.Marshal(map[string]string{key: val})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -158,6 +483,9 @@ func (srv *DareServer) HandlerGetPaginatedCollectionItems(w http.ResponseWriter,
 
 	// Retrieve paginated items
 	items := collection.GetAllItems()
+	if srv.shards != nil {
+		srv.shards.filterItems(items)
+	}
 	paginatedItems := paginateItems(items, page, pageSize)
 
 	response, err := json.Marshal(map[string]interface{}{
@@ -184,6 +512,8 @@ func paginateItems(items map[string]string, page, pageSize int) []Item {
 	for key := range items {
 		keys = append(keys, key)
 	}
+	// Sorted so that the pages do not depend on the order of the map
+	sort.Strings(keys)
 
 	totalItems := len(keys)
 	startIndex := (page - 1) * pageSize
@@ -208,6 +538,17 @@ func paginateItems(items map[string]string, page, pageSize int) []Item {
 	return paginatedItems
 }
 
+// getCollectionOrNotFound returns the collection named in the path, writing a
+// 404 response if it does not exist.
+func (srv *DareServer) getCollectionOrNotFound(w http.ResponseWriter, r *http.Request) (*database.Database, bool) {
+	collectionName := r.PathValue(COLLECTION_NAME_PARAM)
+	collection, exists := srv.collectionManager.GetCollection(collectionName)
+	if !exists {
+		http.Error(w, fmt.Sprintf(`Collection "%s" not found`, collectionName), http.StatusNotFound)
+	}
+	return collection, exists
+}
+
 func parseQueryParam(r *http.Request, key string, defaultValue int) int {
 	queryValue := r.URL.Query().Get(key)
 	if queryValue == "" {
@@ -226,22 +567,19 @@ func (srv *DareServer) HandlerSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var data map[string]string
-	err := json.NewDecoder(r.Body).Decode(&data)
+	data, err := decodeSetBody(r)
 	if err != nil {
 		http.Error(w, "Invalid JSON format, the body must be in the form of {\"key\": \"value\"}", http.StatusBadRequest)
 		return
 	}
 
-	for key, value := range data {
-		err = srv.collectionManager.GetDefaultCollection().Set(key, value)
-		if err != nil {
-			http.Error(w, "Error saving data", http.StatusInternalServerError)
-			return
-		}
+	ttl, err := parseSetTTLParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	srv.storeItems(w, r, srv.collectionManager.GetDefaultCollection(), data, ttl)
 }
 
 func (srv *DareServer) HandlerCollectionSet(w http.ResponseWriter, r *http.Request) {
@@ -250,13 +588,18 @@ func (srv *DareServer) HandlerCollectionSet(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var data map[string]string
-	err := json.NewDecoder(r.Body).Decode(&data)
+	data, err := decodeSetBody(r)
 	if err != nil {
 		http.Error(w, "Invalid JSON format, the body must be in the form of {\"key\": \"value\"}", http.StatusBadRequest)
 		return
 	}
 
+	ttl, err := parseSetTTLParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	collectionName := r.PathValue(COLLECTION_NAME_PARAM)
 	collection, exists := srv.collectionManager.GetCollection(collectionName)
 	if !exists {
@@ -264,17 +607,73 @@ func (srv *DareServer) HandlerCollectionSet(w http.ResponseWriter, r *http.Reque
 		collection, _ = srv.collectionManager.GetCollection(collectionName)
 	}
 
-	for key, value := range data {
-		err = collection.Set(key, value)
+	srv.storeItems(w, r, collection, data, ttl)
+}
+
+// storeItems writes the items of a set request into the collection. With an
+// If-Match or If-None-Match header the request must hold a single key, which
+// is only written if its version satisfies the condition.
+func (srv *DareServer) storeItems(w http.ResponseWriter, r *http.Request, collection *database.Database, data map[string]setItem, ttl time.Duration) {
+	if err := validateSetItems(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	condition := parseVersionCondition(r)
+	if condition != nil && len(data) != 1 {
+		http.Error(w, "If-Match and If-None-Match require a single key in the body", http.StatusBadRequest)
+		return
+	}
+
+	var holds func(version uint64) bool
+	if condition != nil {
+		holds = condition.holds
+	}
+
+	var version uint64
+	for key, item := range data {
+		var err error
+		version, err = collection.SetIf(key, item.Value, item.ttlOrDefault(ttl), holds)
+		if errors.Is(err, database.ErrVersionMismatch) {
+			http.Error(w, fmt.Sprintf(`Key "%v" has been modified`, key), http.StatusPreconditionFailed)
+			return
+		}
+		if errors.Is(err, database.ErrOutOfMemory) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error saving data", http.StatusInternalServerError)
 			return
 		}
 	}
 
+	if len(data) == 1 {
+		w.Header().Set("ETag", etag(version))
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
+// deleteItem deletes the key from the collection, only if its version
+// satisfies the If-Match and If-None-Match headers of the request.
+func (srv *DareServer) deleteItem(w http.ResponseWriter, r *http.Request, collection *database.Database, key string) {
+	var holds func(version uint64) bool
+	if condition := parseVersionCondition(r); condition != nil {
+		holds = condition.holds
+	}
+
+	err := collection.DeleteIf(key, holds)
+	if errors.Is(err, database.ErrVersionMismatch) {
+		http.Error(w, fmt.Sprintf(`Key "%v" has been modified`, key), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deleting data", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (srv *DareServer) HandlerDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -287,12 +686,7 @@ func (srv *DareServer) HandlerDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := srv.collectionManager.GetDefaultCollection().Delete(key)
-	if err != nil {
-		http.Error(w, "Error deleting data", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
+	srv.deleteItem(w, r, srv.collectionManager.GetDefaultCollection(), key)
 }
 
 func (srv *DareServer) HandlerCollectionDelete(w http.ResponseWriter, r *http.Request) {
@@ -314,12 +708,7 @@ func (srv *DareServer) HandlerCollectionDelete(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	err := collection.Delete(key)
-	if err != nil {
-		http.Error(w, "Error deleting data", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
+	srv.deleteItem(w, r, collection, key)
 }
 
 func (srv *DareServer) HandlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -334,19 +723,15 @@ func (srv *DareServer) HandlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authenticator := auth.NewJWTAutenticatorWithUsers(srv.userStore)
-	token, err := authenticator.GenerateToken(username)
+	tokens, err := srv.authenticator().GenerateTokens(username)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
-	srv.userStore.SaveToken(username, token)
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(map[string]string{
-		"token": token,
-	})
+	err = json.NewEncoder(w).Encode(tokens)
 
 	if err != nil {
 		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
@@ -354,39 +739,46 @@ func (srv *DareServer) HandlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (srv *DareServer) HandlerGetCollection(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// HandlerRefresh exchanges the refresh token of the body for a new access
+// token and a new refresh token. A refresh token can only be used once:
+// using it again revokes the tokens issued from it.
+func (srv *DareServer) HandlerRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	collectionName := r.PathValue(COLLECTION_NAME_PARAM)
-	collection, exists := srv.collectionManager.GetCollection(collectionName)
-	if !exists {
-		http.Error(w, fmt.Sprintf(`Collection "%s" not found`, collectionName), http.StatusNotFound)
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-
-	key := r.PathValue(KEY_PARAM)
-	if key == "" {
-		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+	tokens, err := srv.authenticator().Refresh(request.RefreshToken)
+	if err != nil {
+		http.Error(w, "Unauthorized: invalid refresh token", http.StatusUnauthorized)
 		return
 	}
+	writeJSON(w, tokens)
+}
 
-	val := collection.Get(key)
-	if val == "" {
-		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+// HandlerLogout revokes the token of the request, leaving the other sessions
+// of its user valid.
+func (srv *DareServer) HandlerLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	response, err := json.Marshal(map[string]string{key: val})
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		http.Error(w, "Unauthorized: missing authorization token", http.StatusUnauthorized)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(response)
+	if err := srv.authenticator().RevokeToken(token); err != nil {
+		http.Error(w, "Unauthorized: invalid authorization token", http.StatusUnauthorized)
+	}
 }
 
 func (srv *DareServer) HandlerGetCollections(w http.ResponseWriter, r *http.Request) {
@@ -441,11 +833,85 @@ func (srv *DareServer) HandlerDeleteCollection(w http.ResponseWriter, r *http.Re
 	w.WriteHeader(http.StatusOK)
 }
 
+func (srv *DareServer) HandlerMemory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxMemory, policy := srv.collectionManager.MaxMemory()
+	response, err := json.Marshal(map[string]interface{}{
+		"used_memory":     srv.collectionManager.UsedMemory(),
+		"max_memory":      maxMemory,
+		"eviction_policy": policy,
+		"collections":     srv.collectionManager.CollectionsMemory(),
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (srv *DareServer) HandlerSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if srv.cluster != nil {
+		srv.takeClusterSnapshot(w)
+		return
+	}
+	if srv.snapshotter == nil {
+		http.Error(w, "Persistence is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	path, err := srv.snapshotter.Save()
+	if err != nil {
+		http.Error(w, "Error saving snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(map[string]string{"snapshot": filepath.Base(path)})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+func (srv *DareServer) HandlerRewriteAppendLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if srv.appendLog == nil {
+		http.Error(w, "Append only log is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !srv.appendLog.RewriteInBackground() {
+		http.Error(w, "Append only log rewrite already in progress", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (srv *DareServer) setupCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://127.0.0.1:5002") // Or "*" for all origins (less secure)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "OPTIONS" {
@@ -123,11 +123,11 @@ g = _, _
 e = some(where (p.eft == allow))
 
 [matchers]
-m = g(r.sub, p.sub) && (p.obj == "*" || keyMatch(r.obj, p.obj)) && regexMatch(r.act, p.act)
+m = g(r.sub, p.sub) && (p.obj == "*" || keyMatch(r.obj, p.obj) || keyMatch(r.obj, p.obj + "/*")) && regexMatch(r.act, "^(" + p.act + ")$")
 `
 
-const RBAC_POLICY = `p, role1, *, GET
-p, role2, *, POST
+const RBAC_POLICY = `p, role1, *, read
+p, role2, *, write
 
 g, user1, role1
 g, user2, role2
@@ -282,6 +282,61 @@ func TestMiddleware_ProtectedEndpoints(t *testing.T) {
 	require.Equal(t, http.StatusUnauthorized, rr.Code)
 }
 
+func TestMiddleware_CollectionPolicies(t *testing.T) {
+	usersStore := auth.NewUserStore()
+	require.NoError(t, usersStore.AddUser("alice", "password"))
+	srv := NewDareServer(database.NewDatabase(), usersStore)
+	srv.collectionManager.AddCollection("team")
+	srv.collectionManager.AddCollection("other")
+	authorizer, _ := newTestAuthorizer(t)
+	_, err := authorizer.AddPolicy(auth.Policy{Subject: "team", Asset: auth.CollectionAsset("team"), Action: "read|write"})
+	require.NoError(t, err)
+	_, err = authorizer.AddPolicy(auth.Policy{Subject: "team", Asset: auth.KeyAsset(database.DEFAULT_COLLECTION, "user:*"), Action: "read|write"})
+	require.NoError(t, err)
+	_, err = authorizer.AddRoleForUser("alice", "team")
+	require.NoError(t, err)
+	mux := srv.CreateMux(authorizer, auth.NewJWTAutenticatorWithUsers(usersStore))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.SetBasicAuth("alice", "password")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var tokenResponse map[string]string
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&tokenResponse))
+
+	for _, test := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/collections/team/set", `{"key":"value"}`, http.StatusCreated},
+		{http.MethodGet, "/collections/team/get/key", "", http.StatusOK},
+		{http.MethodPost, "/collections/other/set", `{"key":"value"}`, http.StatusForbidden},
+		{http.MethodGet, "/get/key", "", http.StatusForbidden},
+		{http.MethodPost, "/set", `{"user:1":"value","user:2":"value"}`, http.StatusCreated},
+		{http.MethodGet, "/get/user:1", "", http.StatusOK},
+		{http.MethodPost, "/set", `{"user:1":"value","key":"value"}`, http.StatusForbidden},
+		{http.MethodGet, "/collections/team/sets?op=union&key=tags", "", http.StatusOK},
+		{http.MethodGet, "/collections/other/sets?op=union&key=tags", "", http.StatusForbidden},
+		{http.MethodDelete, "/collections/team", "", http.StatusForbidden},
+		// The items of a collection are read with /items or /scan
+		{http.MethodGet, "/collections/team", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/collections/team/index", "", http.StatusForbidden},
+		{http.MethodPost, "/transaction", `{"operations": [{"op": "set", "collection": "team", "key": "key", "value": "value"}, {"op": "set", "key": "user:1", "value": "value"}]}`, http.StatusOK},
+		{http.MethodPost, "/transaction", `{"preconditions": [{"type": "exists", "collection": "team", "key": "key"}], "operations": [{"op": "delete", "collection": "team", "key": "key"}]}`, http.StatusOK},
+		{http.MethodPost, "/transaction", `{"operations": [{"op": "set", "collection": "team", "key": "key", "value": "value"}, {"op": "set", "collection": "other", "key": "key", "value": "value"}]}`, http.StatusForbidden},
+		{http.MethodPost, "/transaction", `{"preconditions": [{"type": "exists", "collection": "other", "key": "key"}], "operations": [{"op": "set", "collection": "team", "key": "key", "value": "value"}]}`, http.StatusForbidden},
+		{http.MethodPost, "/transaction", `{"operations": [{"op": "set", "key": "key", "value": "value"}]}`, http.StatusForbidden},
+		{http.MethodGet, "/admin/users", "", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+		req.Header.Set("Authorization", tokenResponse["token"])
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		assert.Equal(t, test.code, rr.Code, "%s %s", test.method, test.path)
+	}
+}
+
 func TestDareServer_HandlerLogin(t *testing.T) {
 	usersStore := auth.NewUserStore()
 	server := &DareServer{
@@ -759,3 +814,85 @@ func TestHandlerGetPaginatedCollectionItems_InvalidMethod(t *testing.T) {
 	resp := w.Result()
 	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "Expected status 405 Method Not Allowed")
 }
+
+func TestHandlerSnapshot(t *testing.T) {
+	t.Setenv("DARE_DATA_DIR", t.TempDir())
+	configuration := NewConfiguration("")
+
+	srv, err := NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), configuration)
+	require.NoError(t, err)
+	srv.collectionManager.GetDefaultCollection().Set("test-key", "test-value")
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil)
+	w := httptest.NewRecorder()
+	srv.HandlerSnapshot(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, srv.Close())
+
+	// A new server on the same data directory loads the latest snapshot
+	restored, err := NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), configuration)
+	require.NoError(t, err)
+	defer restored.Close()
+	assert.Equal(t, "test-value", restored.collectionManager.GetDefaultCollection().Get("test-key"))
+}
+
+func TestHandlerSnapshot_NotConfigured(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil)
+	w := httptest.NewRecorder()
+	srv.HandlerSnapshot(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHandlerRewriteAppendLog(t *testing.T) {
+	t.Setenv("DARE_DATA_DIR", t.TempDir())
+	t.Setenv("DARE_AOF_ENABLED", "true")
+
+	srv, err := NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), NewConfiguration(""))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/aof/rewrite", nil)
+	w := httptest.NewRecorder()
+	srv.HandlerRewriteAppendLog(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	notConfigured := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	w = httptest.NewRecorder()
+	notConfigured.HandlerRewriteAppendLog(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHandlerSet_OutOfMemory(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.SetMaxMemory(1, database.NO_EVICTION)
+
+	req := httptest.NewRequest(http.MethodPost, "/set", bytes.NewBuffer([]byte(`{"key": "value"}`)))
+	w := httptest.NewRecorder()
+	srv.HandlerSet(w, req)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/collections/default/set", bytes.NewBuffer([]byte(`{"key": "value"}`)))
+	req.SetPathValue(COLLECTION_NAME_PARAM, database.DEFAULT_COLLECTION)
+	w = httptest.NewRecorder()
+	srv.HandlerCollectionSet(w, req)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+}
+
+func TestHandlerMemory(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.SetMaxMemory(1024, database.ALLKEYS_LRU)
+	srv.collectionManager.GetDefaultCollection().Set("key", "value")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/memory", nil)
+	w := httptest.NewRecorder()
+	srv.HandlerMemory(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, float64(1024), body["max_memory"])
+	assert.Equal(t, "allkeys-lru", body["eviction_policy"])
+	assert.Greater(t, body["used_memory"], float64(0))
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const PATH_PARAM = "path"
+const FIELD_PARAM = "field"
+const BY_PARAM = "by"
+
+func (srv *DareServer) HandlerGetDocument(w http.ResponseWriter, r *http.Request) {
+	srv.getDocument(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionGetDocument(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.getDocument(w, r, collection)
+}
+
+func (srv *DareServer) HandlerSetDocument(w http.ResponseWriter, r *http.Request) {
+	srv.setDocument(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionSetDocument(w http.ResponseWriter, r *http.Request) {
+	srv.setDocument(w, r, srv.collectionForWrite(r))
+}
+
+func (srv *DareServer) HandlerDeleteDocumentPath(w http.ResponseWriter, r *http.Request) {
+	srv.deleteDocumentPath(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionDeleteDocumentPath(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.deleteDocumentPath(w, r, collection)
+}
+
+func (srv *DareServer) HandlerIncrDocumentPath(w http.ResponseWriter, r *http.Request) {
+	srv.incrDocumentPath(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionIncrDocumentPath(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.incrDocumentPath(w, r, collection)
+}
+
+// getDocument writes the JSON value at the path query parameter of the
+// document, or with field query parameters an object holding the value of
+// every field path found in the document.
+func (srv *DareServer) getDocument(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	var response []byte
+	var err error
+	if fields := r.URL.Query()[FIELD_PARAM]; len(fields) > 0 {
+		var projection map[string]json.RawMessage
+		if projection, err = collection.Project(key, fields); err == nil {
+			response, err = json.Marshal(projection)
+		}
+	} else {
+		response, err = collection.GetPath(key, r.URL.Query().Get(PATH_PARAM))
+	}
+	if err != nil {
+		writeDocumentError(w, key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// setDocument stores the JSON body at the path query parameter of the
+// document, the whole document if the path is empty.
+func (srv *DareServer) setDocument(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(body) {
+		http.Error(w, "Invalid JSON format, the body must be a JSON value", http.StatusBadRequest)
+		return
+	}
+
+	version, err := collection.SetPath(key, r.URL.Query().Get(PATH_PARAM), body)
+	if err != nil {
+		writeDocumentError(w, key, err)
+		return
+	}
+	w.Header().Set("ETag", etag(version))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (srv *DareServer) deleteDocumentPath(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	version, err := collection.DeletePath(key, r.URL.Query().Get(PATH_PARAM))
+	if err != nil {
+		writeDocumentError(w, key, err)
+		return
+	}
+	w.Header().Set("ETag", etag(version))
+	w.WriteHeader(http.StatusOK)
+}
+
+// incrDocumentPath adds the by query parameter, 1 by default, to the number
+// at the path query parameter of the document.
+func (srv *DareServer) incrDocumentPath(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	delta := json.Number("1")
+	if by := r.URL.Query().Get(BY_PARAM); by != "" {
+		delta = json.Number(by)
+	}
+	if _, err := delta.Float64(); err != nil {
+		http.Error(w, fmt.Sprintf(`query param "%s" must be a number`, BY_PARAM), http.StatusBadRequest)
+		return
+	}
+
+	value, version, err := collection.IncrPath(key, r.URL.Query().Get(PATH_PARAM), delta)
+	if err != nil {
+		writeDocumentError(w, key, err)
+		return
+	}
+
+	response, err := json.Marshal(map[string]json.Number{"value": value})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", etag(version))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func writeDocumentError(w http.ResponseWriter, key string, err error) {
+	switch {
+	case errors.Is(err, database.ErrKeyNotFound):
+		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+	case errors.Is(err, database.ErrPathNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidPath):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrNotDocument), errors.Is(err, database.ErrNotNumber):
+		http.Error(w, fmt.Sprintf(`Key "%v": %s`, key, err.Error()), http.StatusConflict)
+	case errors.Is(err, database.ErrOutOfMemory):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
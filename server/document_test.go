@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCollectionDocument(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	documentRequest := func(method string, url string, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		request.SetPathValue(COLLECTION_NAME_PARAM, "users")
+		request.SetPathValue(KEY_PARAM, "ada")
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response
+	}
+
+	response := documentRequest("POST", "/collections/users/json/ada", `{"name":"Ada","visits":1}`, srv.HandlerCollectionSetDocument)
+	require.Equal(t, http.StatusCreated, response.Code)
+	assert.NotEmpty(t, response.Header().Get("ETag"))
+
+	response = documentRequest("POST", "/collections/users/json/ada?path=address.city", `"London"`, srv.HandlerCollectionSetDocument)
+	require.Equal(t, http.StatusCreated, response.Code)
+
+	response = documentRequest("POST", "/collections/users/json/ada/incr?path=visits&by=2", "", srv.HandlerCollectionIncrDocumentPath)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"value":3}`, response.Body.String())
+
+	response = documentRequest("GET", "/collections/users/json/ada?path=$.address", "", srv.HandlerCollectionGetDocument)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"city":"London"}`, response.Body.String())
+
+	response = documentRequest("GET", "/collections/users/json/ada?field=name&field=address.city", "", srv.HandlerCollectionGetDocument)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"name":"Ada","address.city":"London"}`, response.Body.String())
+
+	response = documentRequest("DELETE", "/collections/users/json/ada?path=address", "", srv.HandlerCollectionDeleteDocumentPath)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	response = documentRequest("GET", "/collections/users/json/ada?path=address", "", srv.HandlerCollectionGetDocument)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	response = documentRequest("POST", "/collections/users/json/ada/incr?path=name", "", srv.HandlerCollectionIncrDocumentPath)
+	assert.Equal(t, http.StatusConflict, response.Code)
+	response = documentRequest("POST", "/collections/users/json/ada", `{"broken"`, srv.HandlerCollectionSetDocument)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = documentRequest("GET", "/collections/users/json/ada?path=a..b", "", srv.HandlerCollectionGetDocument)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
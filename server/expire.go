@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const TTL_PARAM = "ttl"
+
+// MAX_TTL_SECONDS is the longest ttl in seconds, the longest a time.Duration
+// holds.
+const MAX_TTL_SECONDS = math.MaxInt64 / int64(time.Second)
+
+// setItem is a value of the /set body. It is either a plain string or an
+// object carrying the value and its ttl in seconds, nil if it has none.
+type setItem struct {
+	Value string `json:"value"`
+	TTL   *int64 `json:"ttl"`
+}
+
+func (item *setItem) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &item.Value); err == nil {
+		return nil
+	}
+
+	type plainItem setItem
+	var value plainItem
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*item = setItem(value)
+	return nil
+}
+
+func (item setItem) ttlOrDefault(defaultTTL time.Duration) time.Duration {
+	if item.TTL != nil {
+		return time.Duration(*item.TTL) * time.Second
+	}
+	return defaultTTL
+}
+
+// validateSetItems returns an error if the ttl of an item is not positive,
+// as it would store the key without expiration, or longer than
+// MAX_TTL_SECONDS.
+func validateSetItems(data map[string]setItem) error {
+	for key, item := range data {
+		if item.TTL != nil && (*item.TTL <= 0 || *item.TTL > MAX_TTL_SECONDS) {
+			return fmt.Errorf(`ttl of key "%v" must be a positive number of seconds up to %d`, key, MAX_TTL_SECONDS)
+		}
+	}
+	return nil
+}
+
+// durationOf returns amount times unit, false if it overflows a
+// time.Duration.
+func durationOf(amount int64, unit time.Duration) (time.Duration, bool) {
+	if amount > math.MaxInt64/int64(unit) || amount < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(amount) * unit, true
+}
+
+// decodeSetBody decodes a body in the form of {"key": "value"} or
+// {"key": {"value": "value", "ttl": 60}}.
+func decodeSetBody(r *http.Request) (map[string]setItem, error) {
+	var data map[string]setItem
+	err := json.NewDecoder(r.Body).Decode(&data)
+	return data, err
+}
+
+// parseTTLParam returns the ttl query parameter in seconds, zero if it is missing.
+func parseTTLParam(r *http.Request) (time.Duration, error) {
+	queryValue := r.URL.Query().Get(TTL_PARAM)
+	if queryValue == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseInt(queryValue, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(`query param "%s" must be a number of seconds`, TTL_PARAM)
+	}
+	ttl, ok := durationOf(seconds, time.Second)
+	if !ok {
+		return 0, fmt.Errorf(`query param "%s" must be a number of seconds up to %d`, TTL_PARAM, MAX_TTL_SECONDS)
+	}
+	return ttl, nil
+}
+
+// parseSetTTLParam returns the ttl query parameter of a set request in
+// seconds, zero if it is missing. A ttl lower or equal to zero is rejected.
+func parseSetTTLParam(r *http.Request) (time.Duration, error) {
+	ttl, err := parseTTLParam(r)
+	if err == nil && r.URL.Query().Get(TTL_PARAM) != "" && ttl <= 0 {
+		return 0, fmt.Errorf(`query param "%s" must be a positive number of seconds`, TTL_PARAM)
+	}
+	return ttl, err
+}
+
+// ttlSeconds rounds a TTL to seconds, keeping database.NO_EXPIRATION as -1.
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl == database.NO_EXPIRATION {
+		return -1
+	}
+	return int64((ttl + 500*time.Millisecond) / time.Second)
+}
+
+func (srv *DareServer) HandlerExpire(w http.ResponseWriter, r *http.Request) {
+	srv.expire(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionExpire(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.expire(w, r, collection)
+}
+
+func (srv *DareServer) HandlerPersist(w http.ResponseWriter, r *http.Request) {
+	srv.persist(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionPersist(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.persist(w, r, collection)
+}
+
+func (srv *DareServer) HandlerTTL(w http.ResponseWriter, r *http.Request) {
+	srv.ttl(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionTTL(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.ttl(w, r, collection)
+}
+
+// expire sets the ttl of the query parameter on the key of the path. Unlike
+// on set, a ttl lower or equal to zero is accepted and deletes the key.
+func (srv *DareServer) expire(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get(TTL_PARAM) == "" {
+		http.Error(w, fmt.Sprintf(`query param "%s" cannot be empty`, TTL_PARAM), http.StatusBadRequest)
+		return
+	}
+	ttl, err := parseTTLParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = collection.Expire(key, ttl)
+	if errors.Is(err, database.ErrKeyNotFound) {
+		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving data", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *DareServer) persist(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	err := collection.Persist(key)
+	if errors.Is(err, database.ErrKeyNotFound) {
+		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving data", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *DareServer) ttl(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	if key == "" {
+		http.Error(w, `url path param "key" cannot be empty`, http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := collection.TTL(key)
+	if errors.Is(err, database.ErrKeyNotFound) {
+		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+		return
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"key": key,
+		"ttl": ttlSeconds(ttl),
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerSet_WithTTL(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	body := []byte(`{"plain": "value", "expiring": {"value": "value", "ttl": 60}}`)
+	req := httptest.NewRequest(http.MethodPost, "/set", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	srv.HandlerSet(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	collection := srv.collectionManager.GetDefaultCollection()
+	ttl, err := collection.TTL("plain")
+	require.NoError(t, err)
+	assert.Equal(t, database.NO_EXPIRATION, ttl)
+
+	ttl, err = collection.TTL("expiring")
+	require.NoError(t, err)
+	assert.InDelta(t, 60, ttl.Seconds(), 1)
+
+	// The ttl query parameter applies to every key of the body
+	req = httptest.NewRequest(http.MethodPost, "/set?ttl=30", bytes.NewBuffer([]byte(`{"plain": "value"}`)))
+	w = httptest.NewRecorder()
+	srv.HandlerSet(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	ttl, err = collection.TTL("plain")
+	require.NoError(t, err)
+	assert.InDelta(t, 30, ttl.Seconds(), 1)
+
+	req = httptest.NewRequest(http.MethodPost, "/set?ttl=abc", bytes.NewBuffer([]byte(`{"plain": "value"}`)))
+	w = httptest.NewRecorder()
+	srv.HandlerSet(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// A ttl lower or equal to zero would store the key without expiration
+	for _, request := range []struct{ target, body string }{
+		{"/set?ttl=-5", `{"negative": "value"}`},
+		{"/set?ttl=0", `{"negative": "value"}`},
+		{"/set", `{"negative": {"value": "value", "ttl": -5}}`},
+		{"/set", `{"plain": "value", "negative": {"value": "value", "ttl": 0}}`},
+		// Longer ttls overflow a time.Duration
+		{"/set?ttl=9300000000", `{"negative": "value"}`},
+		{"/set", `{"negative": {"value": "value", "ttl": 9300000000}}`},
+	} {
+		req = httptest.NewRequest(http.MethodPost, request.target, bytes.NewBufferString(request.body))
+		w = httptest.NewRecorder()
+		srv.HandlerSet(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, request.target+" "+request.body)
+	}
+	assert.False(t, collection.Exists("negative"))
+	ttl, err = collection.TTL("plain")
+	require.NoError(t, err)
+	assert.InDelta(t, 30, ttl.Seconds(), 1, "Expected the rejected request to leave the other keys unchanged")
+}
+
+func TestHandlerCollectionSet_WithTTL(t *testing.T) {
+	srv := &DareServer{
+		collectionManager: database.NewCollectionManager(),
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/collections/test-collection/set?ttl=1", bytes.NewBuffer([]byte(`{"key": "value"}`)))
+	req.SetPathValue(COLLECTION_NAME_PARAM, "test-collection")
+	w := httptest.NewRecorder()
+	srv.HandlerCollectionSet(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	collection, _ := srv.collectionManager.GetCollection("test-collection")
+	ttl, err := collection.TTL("key")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	req = httptest.NewRequest(http.MethodPost, "/collections/test-collection/set?ttl=-5", bytes.NewBuffer([]byte(`{"key": "value"}`)))
+	req.SetPathValue(COLLECTION_NAME_PARAM, "test-collection")
+	w = httptest.NewRecorder()
+	srv.HandlerCollectionSet(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandlerExpirePersistAndTTL(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.GetDefaultCollection().Set("key", "value")
+
+	getTTL := func() (int, float64) {
+		req := httptest.NewRequest(http.MethodGet, "/ttl/key", nil)
+		req.SetPathValue(KEY_PARAM, "key")
+		w := httptest.NewRecorder()
+		srv.HandlerTTL(w, req)
+
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		ttl, _ := body["ttl"].(float64)
+		return w.Code, ttl
+	}
+
+	code, ttl := getTTL()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(-1), ttl)
+
+	req := httptest.NewRequest(http.MethodPost, "/expire/key?ttl=100", nil)
+	req.SetPathValue(KEY_PARAM, "key")
+	w := httptest.NewRecorder()
+	srv.HandlerExpire(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	code, ttl = getTTL()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(100), ttl)
+
+	req = httptest.NewRequest(http.MethodPost, "/persist/key", nil)
+	req.SetPathValue(KEY_PARAM, "key")
+	w = httptest.NewRecorder()
+	srv.HandlerPersist(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	code, ttl = getTTL()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(-1), ttl)
+
+	// Missing ttl parameter
+	req = httptest.NewRequest(http.MethodPost, "/expire/key", nil)
+	req.SetPathValue(KEY_PARAM, "key")
+	w = httptest.NewRecorder()
+	srv.HandlerExpire(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Missing key
+	req = httptest.NewRequest(http.MethodPost, "/expire/missing?ttl=10", nil)
+	req.SetPathValue(KEY_PARAM, "missing")
+	w = httptest.NewRecorder()
+	srv.HandlerExpire(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// A ttl longer than a time.Duration holds is rejected
+	req = httptest.NewRequest(http.MethodPost, "/expire/key?ttl=-9300000000", nil)
+	req.SetPathValue(KEY_PARAM, "key")
+	w = httptest.NewRecorder()
+	srv.HandlerExpire(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, srv.collectionManager.GetDefaultCollection().Exists("key"))
+
+	// A ttl lower or equal to zero deletes the key
+	req = httptest.NewRequest(http.MethodPost, "/expire/key?ttl=-1", nil)
+	req.SetPathValue(KEY_PARAM, "key")
+	w = httptest.NewRecorder()
+	srv.HandlerExpire(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, srv.collectionManager.GetDefaultCollection().Exists("key"))
+}
+
+func TestHandlerCollectionTTL_CollectionNotFound(t *testing.T) {
+	srv := &DareServer{
+		collectionManager: database.NewCollectionManager(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/collections/missing/ttl/key", nil)
+	req.SetPathValue(COLLECTION_NAME_PARAM, "missing")
+	req.SetPathValue(KEY_PARAM, "key")
+	w := httptest.NewRecorder()
+	srv.HandlerCollectionTTL(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -14,9 +14,25 @@ func NewFactory(configuration Config, logger logger.Logger) *Factory {
 }
 
 func (f *Factory) GetWebServer(dareServer IDare) Server {
+	respServer := f.GetRespServer(dareServer)
+
 	if f.configuration.GetBool("security.tls_enabled") {
-		return NewHttpsServer(dareServer, f.configuration, f.logger)
+		server := NewHttpsServer(dareServer, f.configuration, f.logger)
+		server.respServer = respServer
+		return server
 	}
 
-	return NewHttpServer(dareServer, f.configuration, f.logger)
+	server := NewHttpServer(dareServer, f.configuration, f.logger)
+	server.respServer = respServer
+	return server
+}
+
+// GetRespServer returns the RESP listener started alongside the web server,
+// nil if resp.enabled is not set.
+func (f *Factory) GetRespServer(dareServer IDare) *RespServer {
+	dare, ok := dareServer.(*DareServer)
+	if !ok || !f.configuration.GetBool("resp.enabled") {
+		return nil
+	}
+	return NewRespServer(dare, f.configuration, f.logger)
 }
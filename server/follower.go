@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+)
+
+// REPLICATION_TIMEOUT is how long a follower waits for a message of its
+// leader before considering the link broken.
+const REPLICATION_TIMEOUT = 5 * REPLICATION_HEARTBEAT_INTERVAL
+
+// REPLICATION_RETRY_INTERVAL is the delay before a follower reconnects to its leader.
+const REPLICATION_RETRY_INTERVAL = time.Second
+
+// States of the link between a follower and its leader
+const (
+	FOLLOWER_CONNECTING   = "connecting"
+	FOLLOWER_SYNCING      = "syncing"
+	FOLLOWER_STREAMING    = "streaming"
+	FOLLOWER_DISCONNECTED = "disconnected"
+)
+
+// FollowerStatus describes the link between a follower and its leader. Lag
+// is the number of operations of the leader the follower has not applied
+// yet, as of the last message of the leader.
+type FollowerStatus struct {
+	LeaderURL    string    `json:"leader_url"`
+	State        string    `json:"state"`
+	LeaderID     string    `json:"leader_id,omitempty"`
+	Offset       uint64    `json:"offset"`
+	LeaderOffset uint64    `json:"leader_offset"`
+	Lag          uint64    `json:"lag"`
+	LastContact  time.Time `json:"last_contact"`
+	FullSyncs    int       `json:"full_syncs"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// Follower replicates the collections of a leader: it loads a copy of every
+// collection, then applies the operations streamed by the leader,
+// reconnecting whenever the link breaks. After a short interruption the
+// stream resumes after the last operation applied.
+type Follower struct {
+	leaderURL         string
+	username          string
+	password          string
+	collectionManager *database.CollectionManager
+	client            *http.Client
+	logger            logger.Logger
+
+	mu     sync.Mutex
+	status FollowerStatus
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewFollower(collectionManager *database.CollectionManager, leaderURL string, username string, password string) *Follower {
+	leaderURL = strings.TrimSuffix(leaderURL, "/")
+	return &Follower{
+		leaderURL:         leaderURL,
+		username:          username,
+		password:          password,
+		collectionManager: collectionManager,
+		client:            &http.Client{},
+		logger:            logger.NewDareLogger(),
+		status:            FollowerStatus{LeaderURL: leaderURL, State: FOLLOWER_DISCONNECTED},
+	}
+}
+
+// LeaderURL returns the URL of the leader.
+func (f *Follower) LeaderURL() string {
+	return f.leaderURL
+}
+
+// Start replicates the leader in the background until Stop is called.
+func (f *Follower) Start() {
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.done = make(chan struct{})
+	go f.run()
+}
+
+// Stop closes the link to the leader and waits for the replication to end.
+func (f *Follower) Stop() {
+	if f.cancel == nil {
+		return
+	}
+	f.cancel()
+	<-f.done
+	f.cancel = nil
+}
+
+// Status returns the state of the link to the leader.
+func (f *Follower) Status() FollowerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.status
+	status.Lag = status.LeaderOffset - status.Offset
+	return status
+}
+
+func (f *Follower) run() {
+	defer close(f.done)
+	for {
+		if err := f.sync(); err != nil && f.ctx.Err() == nil {
+			f.logger.Error("Replication link to ", f.leaderURL, " broken: ", err)
+			f.update(func(status *FollowerStatus) {
+				status.LastError = err.Error()
+			})
+		}
+		f.update(func(status *FollowerStatus) {
+			status.State = FOLLOWER_DISCONNECTED
+		})
+
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-time.After(REPLICATION_RETRY_INTERVAL):
+		}
+	}
+}
+
+// sync connects to the leader and applies its stream until the link breaks.
+func (f *Follower) sync() error {
+	ctx, cancel := context.WithCancel(f.ctx)
+	defer cancel()
+
+	f.update(func(status *FollowerStatus) {
+		status.State = FOLLOWER_CONNECTING
+	})
+	token, err := f.login(ctx)
+	if err != nil {
+		return err
+	}
+
+	status := f.Status()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/replication/stream?%s=%s&%s=%d",
+		f.leaderURL, REPLICATION_ID_PARAM, url.QueryEscape(status.LeaderID), OFFSET_PARAM, status.Offset), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", token)
+	response, err := f.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return leaderError(response)
+	}
+
+	f.update(func(status *FollowerStatus) {
+		status.State = FOLLOWER_SYNCING
+	})
+	// The leader sends heartbeats, a silent leader is unreachable
+	watchdog := time.AfterFunc(REPLICATION_TIMEOUT, cancel)
+	defer watchdog.Stop()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message replicationMessage
+		if err := decoder.Decode(&message); err != nil {
+			if ctx.Err() != nil && f.ctx.Err() == nil {
+				return errors.New("no message from the leader within the replication timeout")
+			}
+			return err
+		}
+		watchdog.Reset(REPLICATION_TIMEOUT)
+		f.update(func(status *FollowerStatus) {
+			status.LastContact = time.Now().UTC()
+		})
+		if err := f.handle(message); err != nil {
+			return err
+		}
+	}
+}
+
+// handle applies a message of the replication stream.
+func (f *Follower) handle(message replicationMessage) error {
+	current := f.Status()
+	switch message.Type {
+	case REPLICATION_FULL_SYNC:
+		if message.Snapshot == nil {
+			return errors.New("full sync without snapshot")
+		}
+		if err := f.collectionManager.Restore(message.Snapshot); err != nil {
+			return fmt.Errorf("failed to load the collections of the leader: %w", err)
+		}
+		f.logger.Info("Loaded the collections of the leader ", f.leaderURL, " at offset ", message.Offset)
+		f.update(func(status *FollowerStatus) {
+			status.State = FOLLOWER_STREAMING
+			status.LeaderID = message.ID
+			status.Offset = message.Offset
+			status.LeaderOffset = message.Offset
+			status.FullSyncs++
+		})
+	case REPLICATION_CONTINUE:
+		if message.ID != current.LeaderID {
+			return fmt.Errorf("leader resumed the stream of log %q, expected %q", message.ID, current.LeaderID)
+		}
+		f.update(func(status *FollowerStatus) {
+			status.State = FOLLOWER_STREAMING
+			status.LeaderOffset = max(message.Offset, status.Offset)
+		})
+	case REPLICATION_OPERATION:
+		op := message.Operation
+		if op == nil || op.Seq != current.Offset+1 {
+			f.forgetLeader()
+			return fmt.Errorf("expected operation %d from the leader", current.Offset+1)
+		}
+		if err := f.collectionManager.Apply(*op); err != nil {
+			// The follower diverged from the leader, copy it again
+			f.forgetLeader()
+			return fmt.Errorf("failed to apply operation %d: %w", op.Seq, err)
+		}
+		f.update(func(status *FollowerStatus) {
+			status.Offset = op.Seq
+			status.LeaderOffset = max(status.LeaderOffset, op.Seq)
+		})
+	case REPLICATION_PING:
+		f.update(func(status *FollowerStatus) {
+			status.LeaderOffset = max(message.Offset, status.Offset)
+		})
+	default:
+		return fmt.Errorf("unknown replication message %q", message.Type)
+	}
+	return nil
+}
+
+// login returns a token of the leader for the follower credentials.
+func (f *Follower) login(ctx context.Context) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, f.leaderURL+"/login", nil)
+	if err != nil {
+		return "", err
+	}
+	request.SetBasicAuth(f.username, f.password)
+	response, err := f.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", leaderError(response)
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid login response from the leader: %w", err)
+	}
+	return body["token"], nil
+}
+
+// forgetLeader makes the next connection copy every collection again.
+func (f *Follower) forgetLeader() {
+	f.update(func(status *FollowerStatus) {
+		status.LeaderID = ""
+		status.Offset = 0
+		status.LeaderOffset = 0
+	})
+}
+
+func (f *Follower) update(change func(status *FollowerStatus)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change(&f.status)
+}
+
+func leaderError(response *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("leader answered %s: %s", response.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+func (srv *DareServer) HandlerHashGetAll(w http.ResponseWriter, r *http.Request) {
+	srv.hashGetAll(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionHashGetAll(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.hashGetAll(w, r, collection)
+}
+
+func (srv *DareServer) HandlerHashSet(w http.ResponseWriter, r *http.Request) {
+	srv.hashSet(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionHashSet(w http.ResponseWriter, r *http.Request) {
+	srv.hashSet(w, r, srv.collectionForWrite(r))
+}
+
+func (srv *DareServer) HandlerHashGet(w http.ResponseWriter, r *http.Request) {
+	srv.hashGet(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionHashGet(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.hashGet(w, r, collection)
+}
+
+func (srv *DareServer) HandlerHashExists(w http.ResponseWriter, r *http.Request) {
+	srv.hashExists(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionHashExists(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.hashExists(w, r, collection)
+}
+
+func (srv *DareServer) HandlerHashDelete(w http.ResponseWriter, r *http.Request) {
+	srv.hashDelete(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionHashDelete(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.hashDelete(w, r, collection)
+}
+
+func (srv *DareServer) HandlerHashIncr(w http.ResponseWriter, r *http.Request) {
+	srv.hashIncr(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionHashIncr(w http.ResponseWriter, r *http.Request) {
+	srv.hashIncr(w, r, srv.collectionForWrite(r))
+}
+
+func (srv *DareServer) hashGetAll(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	fields, err := collection.HGetAll(key)
+	if err != nil {
+		writeHashError(w, key, err)
+		return
+	}
+	if len(fields) == 0 {
+		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]map[string]string{"fields": fields})
+}
+
+// hashSet stores the fields of the JSON object body, such as
+// {"name":"Ada","visits":"1"}, in the hash.
+func (srv *DareServer) hashSet(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var fields map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil || len(fields) == 0 {
+		http.Error(w, `Invalid JSON format, the body must be a non empty object of strings such as {"field":"value"}`, http.StatusBadRequest)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	added, err := collection.HSet(key, fields)
+	if err != nil {
+		writeHashError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string]int{"added": added})
+}
+
+func (srv *DareServer) hashGet(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	field := r.PathValue(FIELD_PARAM)
+	value, ok, err := collection.HGet(key, field)
+	if err != nil {
+		writeHashError(w, key, err)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf(`Field "%v" not found in key "%v"`, field, key), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"value": value})
+}
+
+func (srv *DareServer) hashExists(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	exists, err := collection.HExists(key, r.PathValue(FIELD_PARAM))
+	if err != nil {
+		writeHashError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string]bool{"exists": exists})
+}
+
+func (srv *DareServer) hashDelete(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	field := r.PathValue(FIELD_PARAM)
+	removed, err := collection.HDel(key, field)
+	if err != nil {
+		writeHashError(w, key, err)
+		return
+	}
+	if removed == 0 {
+		http.Error(w, fmt.Sprintf(`Field "%v" not found in key "%v"`, field, key), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// hashIncr adds the by query parameter, 1 by default, to the integer stored
+// in the field.
+func (srv *DareServer) hashIncr(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	delta := int64(1)
+	if queryValue := r.URL.Query().Get(BY_PARAM); queryValue != "" {
+		var err error
+		if delta, err = strconv.ParseInt(queryValue, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf(`query param "%s" must be an integer`, BY_PARAM), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	value, err := collection.HIncrBy(key, r.PathValue(FIELD_PARAM), delta)
+	if err != nil {
+		writeHashError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string]int64{"value": value})
+}
+
+func writeHashError(w http.ResponseWriter, key string, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotInteger), errors.Is(err, database.ErrOverflow):
+		http.Error(w, fmt.Sprintf(`Key "%v": %s`, key, err.Error()), http.StatusConflict)
+	default:
+		writeListError(w, key, err)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hashRequest(method string, url string, field string, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	request.SetPathValue(COLLECTION_NAME_PARAM, "users")
+	request.SetPathValue(KEY_PARAM, "user:1")
+	request.SetPathValue(FIELD_PARAM, field)
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func TestHandlerHash(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := hashRequest("POST", "/hashes/user:1", "", `{"name":"Ada","email":"ada@example.com"}`, srv.HandlerHashSet)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"added":2}`, response.Body.String())
+
+	response = hashRequest("GET", "/hashes/user:1/name", "name", "", srv.HandlerHashGet)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"value":"Ada"}`, response.Body.String())
+	response = hashRequest("GET", "/hashes/user:1/phone", "phone", "", srv.HandlerHashGet)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = hashRequest("GET", "/hashes/user:1/email/exists", "email", "", srv.HandlerHashExists)
+	assert.JSONEq(t, `{"exists":true}`, response.Body.String())
+
+	response = hashRequest("POST", "/hashes/user:1/visits/incr?by=5", "visits", "", srv.HandlerHashIncr)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"value":5}`, response.Body.String())
+	response = hashRequest("POST", "/hashes/user:1/name/incr", "name", "", srv.HandlerHashIncr)
+	assert.Equal(t, http.StatusConflict, response.Code)
+	response = hashRequest("POST", "/hashes/user:1/visits/incr?by=x", "visits", "", srv.HandlerHashIncr)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = hashRequest("DELETE", "/hashes/user:1/email", "email", "", srv.HandlerHashDelete)
+	assert.Equal(t, http.StatusOK, response.Code)
+	response = hashRequest("DELETE", "/hashes/user:1/email", "email", "", srv.HandlerHashDelete)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = hashRequest("GET", "/hashes/user:1", "", "", srv.HandlerHashGetAll)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"fields":{"name":"Ada","visits":"5"}}`, response.Body.String())
+
+	response = hashRequest("POST", "/hashes/user:1", "", `{}`, srv.HandlerHashSet)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = hashRequest("GET", "/hashes/user:1", "", "", srv.HandlerHashSet)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
+
+func TestHandlerCollectionHash(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := hashRequest("GET", "/collections/users/hashes/user:1", "", "", srv.HandlerCollectionHashGetAll)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = hashRequest("POST", "/collections/users/hashes/user:1", "", `{"name":"Ada"}`, srv.HandlerCollectionHashSet)
+	require.Equal(t, http.StatusOK, response.Code)
+	response = hashRequest("GET", "/collections/users/hashes/user:1/name", "name", "", srv.HandlerCollectionHashGet)
+	assert.JSONEq(t, `{"value":"Ada"}`, response.Body.String())
+	response = hashRequest("GET", "/hashes/user:1/name", "name", "", srv.HandlerHashGet)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	users, _ := srv.collectionManager.GetCollection("users")
+	require.NoError(t, users.Set("user:2", "string"))
+	request, _ := http.NewRequest("POST", "/collections/users/hashes/user:2", bytes.NewBufferString(`{"name":"Bob"}`))
+	request.SetPathValue(COLLECTION_NAME_PARAM, "users")
+	request.SetPathValue(KEY_PARAM, "user:2")
+	recorder := httptest.NewRecorder()
+	srv.HandlerCollectionHashSet(recorder, request)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+}
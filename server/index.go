@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const DEFAULT_RANGE_LIMIT = 100
+
+func (srv *DareServer) HandlerCreateIndex(w http.ResponseWriter, r *http.Request) {
+	srv.createIndex(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionCreateIndex(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.createIndex(w, r, collection)
+}
+
+func (srv *DareServer) HandlerDropIndex(w http.ResponseWriter, r *http.Request) {
+	srv.dropIndex(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionDropIndex(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.dropIndex(w, r, collection)
+}
+
+func (srv *DareServer) HandlerRange(w http.ResponseWriter, r *http.Request) {
+	srv.keyRange(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionRange(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.keyRange(w, r, collection)
+}
+
+func (srv *DareServer) createIndex(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := collection.CreateOrderedIndex(); err != nil {
+		http.Error(w, "Error creating index", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (srv *DareServer) dropIndex(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := collection.DropOrderedIndex(); err != nil {
+		http.Error(w, "Error dropping index", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// keyRange returns the items between the start (inclusive) and end
+// (exclusive) query parameters, restricted to keys starting with prefix, in
+// the order given by order, asc or desc, up to limit items.
+func (srv *DareServer) keyRange(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	options := database.RangeOptions{
+		Start:  query.Get("start"),
+		End:    query.Get("end"),
+		Prefix: query.Get("prefix"),
+		Limit:  parseQueryParam(r, "limit", DEFAULT_RANGE_LIMIT),
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		options.Descending = true
+	default:
+		http.Error(w, `query param "order" must be "asc" or "desc"`, http.StatusBadRequest)
+		return
+	}
+
+	items, more, err := collection.Range(options)
+	if errors.Is(err, database.ErrNoOrderedIndex) {
+		http.Error(w, fmt.Sprintf("%s, create one with POST on the index endpoint", err.Error()), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if srv.shards != nil {
+		items = srv.shards.filterRange(items)
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"items": items,
+		"more":  more,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
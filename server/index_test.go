@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCollectionRange(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.AddCollection("users")
+	users, _ := srv.collectionManager.GetCollection("users")
+	for _, key := range []string{"tenant:1:user:1", "tenant:1:user:2", "tenant:2:user:1"} {
+		users.Set(key, "value")
+	}
+
+	rangeRequest := func(query string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("GET", "/collections/users/range?"+query, nil)
+		request.SetPathValue(COLLECTION_NAME_PARAM, "users")
+		response := httptest.NewRecorder()
+		srv.HandlerCollectionRange(response, request)
+		return response
+	}
+
+	assert.Equal(t, http.StatusConflict, rangeRequest("prefix=tenant:1:").Code)
+
+	request, _ := http.NewRequest("POST", "/collections/users/index", nil)
+	request.SetPathValue(COLLECTION_NAME_PARAM, "users")
+	response := httptest.NewRecorder()
+	srv.HandlerCollectionCreateIndex(response, request)
+	require.Equal(t, http.StatusCreated, response.Code)
+
+	response = rangeRequest("prefix=tenant:1:&order=desc&limit=1")
+	require.Equal(t, http.StatusOK, response.Code)
+	var result struct {
+		Items []database.Item `json:"items"`
+		More  bool            `json:"more"`
+	}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, []database.Item{{Key: "tenant:1:user:2", Value: "value"}}, result.Items)
+	assert.True(t, result.More)
+
+	response = rangeRequest("start=tenant:1:user:2&end=tenant:3")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+	assert.Equal(t, []database.Item{{Key: "tenant:1:user:2", Value: "value"}, {Key: "tenant:2:user:1", Value: "value"}}, result.Items)
+	assert.False(t, result.More)
+
+	assert.Equal(t, http.StatusBadRequest, rangeRequest("order=random").Code)
+
+	request, _ = http.NewRequest("DELETE", "/collections/users/index", nil)
+	request.SetPathValue(COLLECTION_NAME_PARAM, "users")
+	response = httptest.NewRecorder()
+	srv.HandlerCollectionDropIndex(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, http.StatusConflict, rangeRequest("").Code)
+}
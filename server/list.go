@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const LIST_COMMAND_PARAM = "command"
+
+func (srv *DareServer) HandlerListRange(w http.ResponseWriter, r *http.Request) {
+	srv.listRange(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionListRange(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.listRange(w, r, collection)
+}
+
+func (srv *DareServer) HandlerListLen(w http.ResponseWriter, r *http.Request) {
+	srv.listLen(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionListLen(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.listLen(w, r, collection)
+}
+
+func (srv *DareServer) HandlerListCommand(w http.ResponseWriter, r *http.Request) {
+	srv.listCommand(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionListCommand(w http.ResponseWriter, r *http.Request) {
+	srv.listCommand(w, r, srv.collectionForWrite(r))
+}
+
+// listRange writes the elements of the list from the start to the stop
+// query parameters, both included, the whole list by default.
+func (srv *DareServer) listRange(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	start, stop, err := parseListRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	elements, err := collection.LRange(key, start, stop)
+	if err != nil {
+		writeListError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string][]string{"values": elements})
+}
+
+func (srv *DareServer) listLen(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	length, err := collection.LLen(key)
+	if err != nil {
+		writeListError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string]int{"length": length})
+}
+
+// listCommand executes the list command named in the path: lpush and rpush
+// take a JSON array of elements as body, lpop and rpop a count query
+// parameter, blpop and brpop a timeout query parameter in seconds and trim
+// the start and stop query parameters.
+func (srv *DareServer) listCommand(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	switch command := r.PathValue(LIST_COMMAND_PARAM); command {
+	case "lpush", "rpush":
+		var elements []string
+		if err := json.NewDecoder(r.Body).Decode(&elements); err != nil || len(elements) == 0 {
+			http.Error(w, `Invalid JSON format, the body must be a non empty array of strings such as ["value"]`, http.StatusBadRequest)
+			return
+		}
+
+		var length int
+		var err error
+		if command == "lpush" {
+			length, err = collection.LPush(key, elements...)
+		} else {
+			length, err = collection.RPush(key, elements...)
+		}
+		if err != nil {
+			writeListError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string]int{"length": length})
+
+	case "lpop", "rpop":
+		count := parseQueryParam(r, "count", 1)
+		var elements []string
+		var err error
+		if command == "lpop" {
+			elements, err = collection.LPop(key, count)
+		} else {
+			elements, err = collection.RPop(key, count)
+		}
+		if err != nil {
+			writeListError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string][]string{"values": elements})
+
+	case "blpop", "brpop":
+		timeout, err := parseBlockingTimeout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var element string
+		if srv.cluster != nil {
+			_, element, err = srv.cluster.blockingPop(r.Context(), collection.Name(), []string{key}, timeout, command == "blpop")
+		} else if command == "blpop" {
+			_, element, err = collection.BLPop(r.Context(), []string{key}, timeout)
+		} else {
+			_, element, err = collection.BRPop(r.Context(), []string{key}, timeout)
+		}
+		if errors.Is(err, database.ErrTimeout) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			writeListError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string]string{"value": element})
+
+	case "trim":
+		start, stop, err := parseListRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := collection.LTrim(key, start, stop); err != nil {
+			writeListError(w, key, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, fmt.Sprintf(`Unknown list command "%s"`, command), http.StatusNotFound)
+	}
+}
+
+// parseListRange returns the start and stop query parameters, 0 and -1 by default.
+func parseListRange(r *http.Request) (int, int, error) {
+	bounds := []int{0, -1}
+	for i, name := range []string{"start", "stop"} {
+		queryValue := r.URL.Query().Get(name)
+		if queryValue == "" {
+			continue
+		}
+		value, err := strconv.Atoi(queryValue)
+		if err != nil {
+			return 0, 0, fmt.Errorf(`query param "%s" must be an integer`, name)
+		}
+		bounds[i] = value
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// parseBlockingTimeout returns the timeout query parameter in seconds, zero
+// to wait until the client goes away.
+func parseBlockingTimeout(r *http.Request) (time.Duration, error) {
+	queryValue := r.URL.Query().Get("timeout")
+	if queryValue == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(queryValue, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, errors.New(`query param "timeout" must be a positive number of seconds`)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func writeListError(w http.ResponseWriter, key string, err error) {
+	switch {
+	case errors.Is(err, database.ErrKeyNotFound):
+		http.Error(w, fmt.Sprintf(`Key "%v" not found`, key), http.StatusNotFound)
+	case errors.Is(err, database.ErrWrongType):
+		http.Error(w, fmt.Sprintf(`Key "%v": %s`, key, err.Error()), http.StatusConflict)
+	case errors.Is(err, database.ErrOutOfMemory):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
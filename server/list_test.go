@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listRequest(srv *DareServer, method string, url string, command string, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	request.SetPathValue(COLLECTION_NAME_PARAM, "jobs")
+	request.SetPathValue(KEY_PARAM, "queue")
+	request.SetPathValue(LIST_COMMAND_PARAM, command)
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func TestHandlerCollectionList(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := listRequest(srv, "POST", "/collections/jobs/lists/queue/rpush", "rpush", `["a","b","c"]`, srv.HandlerCollectionListCommand)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"length":3}`, response.Body.String())
+
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/lpush", "lpush", `["z"]`, srv.HandlerCollectionListCommand)
+	assert.JSONEq(t, `{"length":4}`, response.Body.String())
+
+	response = listRequest(srv, "GET", "/collections/jobs/lists/queue?start=1&stop=-2", "", "", srv.HandlerCollectionListRange)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"values":["a","b"]}`, response.Body.String())
+
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/rpop?count=2", "rpop", "", srv.HandlerCollectionListCommand)
+	assert.JSONEq(t, `{"values":["c","b"]}`, response.Body.String())
+
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/trim?start=1", "trim", "", srv.HandlerCollectionListCommand)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	response = listRequest(srv, "GET", "/collections/jobs/lists/queue/len", "", "", srv.HandlerCollectionListLen)
+	assert.JSONEq(t, `{"length":1}`, response.Body.String())
+
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/lpop", "lpop", "", srv.HandlerCollectionListCommand)
+	assert.JSONEq(t, `{"values":["a"]}`, response.Body.String())
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/lpop", "lpop", "", srv.HandlerCollectionListCommand)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/rpush", "rpush", `[]`, srv.HandlerCollectionListCommand)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/unknown", "unknown", "", srv.HandlerCollectionListCommand)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	jobs, _ := srv.collectionManager.GetCollection("jobs")
+	require.NoError(t, jobs.Set("queue", "string"))
+	response = listRequest(srv, "GET", "/collections/jobs/lists/queue", "", "", srv.HandlerCollectionListRange)
+	assert.Equal(t, http.StatusConflict, response.Code)
+}
+
+func TestHandlerCollectionListBlockingPop(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := listRequest(srv, "POST", "/collections/jobs/lists/queue/blpop?timeout=0.05", "blpop", "", srv.HandlerCollectionListCommand)
+	assert.Equal(t, http.StatusNoContent, response.Code)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- listRequest(srv, "POST", "/collections/jobs/lists/queue/blpop?timeout=5", "blpop", "", srv.HandlerCollectionListCommand)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	jobs, _ := srv.collectionManager.GetCollection("jobs")
+	_, err := jobs.RPush("queue", "job")
+	require.NoError(t, err)
+
+	response = <-done
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"value":"job"}`, response.Body.String())
+
+	response = listRequest(srv, "POST", "/collections/jobs/lists/queue/blpop?timeout=-1", "blpop", "", srv.HandlerCollectionListCommand)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NODE_LOGIN_TIMEOUT bounds the login of a node on another node of its
+// cluster.
+const NODE_LOGIN_TIMEOUT = 10 * time.Second
+
+// nodeTokens sends the requests of a node to the other nodes of its cluster
+// with a token of each of them. The node logs in with the credentials of its
+// user on the first request to a node, and again once the token is refused,
+// so that the password is only checked on login rather than on every
+// request.
+type nodeTokens struct {
+	username string
+	password string
+	client   *http.Client
+
+	mu sync.Mutex
+	// tokens holds the token issued by each node, by address
+	tokens map[string]*nodeToken
+}
+
+// nodeToken is the token issued by a node, empty until the node logged in.
+// Its lock is held during the login, so that the requests to the node wait
+// for a single login.
+type nodeToken struct {
+	mu    sync.Mutex
+	token string
+}
+
+func newNodeTokens(username string, password string, client *http.Client) *nodeTokens {
+	return &nodeTokens{
+		username: username,
+		password: password,
+		client:   client,
+		tokens:   make(map[string]*nodeToken),
+	}
+}
+
+// do sends request to the node at address. The request is sent again with
+// a new token if its token was refused, body being its body.
+func (n *nodeTokens) do(ctx context.Context, address string, request *http.Request, body []byte) (*http.Response, error) {
+	address = strings.TrimSuffix(address, "/")
+	for retried := false; ; retried = true {
+		token, err := n.token(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		request.Header.Set("Authorization", token)
+		response, err := n.client.Do(request)
+		if err != nil || response.StatusCode != http.StatusUnauthorized || retried {
+			return response, err
+		}
+		// The token expired or was revoked
+		response.Body.Close()
+		n.forget(address, token)
+	}
+}
+
+// token returns the token of the node at address, logging in if there is
+// none. The login goes on when ctx is done, as the raft requests may time
+// out sooner than a password is checked.
+func (n *nodeTokens) token(ctx context.Context, address string) (string, error) {
+	n.mu.Lock()
+	entry, exists := n.tokens[address]
+	if !exists {
+		entry = &nodeToken{}
+		n.tokens[address] = entry
+	}
+	n.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.token != "" {
+		return entry.token, nil
+	}
+
+	loginCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), NODE_LOGIN_TIMEOUT)
+	defer cancel()
+	request, err := http.NewRequestWithContext(loginCtx, http.MethodPost, address+"/login", nil)
+	if err != nil {
+		return "", err
+	}
+	request.SetBasicAuth(n.username, n.password)
+	response, err := n.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return "", fmt.Errorf("login on node %s answered %s: %s", address, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	var tokenResponse map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("invalid login response from node %s: %w", address, err)
+	}
+	entry.token = tokenResponse["token"]
+	return entry.token, nil
+}
+
+// forget drops the token of the node at address, unless it was replaced
+// meanwhile.
+func (n *nodeTokens) forget(address string, token string) {
+	n.mu.Lock()
+	entry := n.tokens[address]
+	n.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.token == token {
+		entry.token = ""
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeTokens(t *testing.T) {
+	var mu sync.Mutex
+	logins, valid := 0, ""
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/login" {
+			if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "password" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			logins++
+			valid = fmt.Sprintf("token%d", logins)
+			writeJSON(w, map[string]string{"token": valid})
+			return
+		}
+		if r.Header.Get("Authorization") != valid {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(node.Close)
+
+	send := func(tokens *nodeTokens) int {
+		request, err := http.NewRequest(http.MethodPost, node.URL+"/raft/append", nil)
+		require.NoError(t, err)
+		response, err := tokens.do(context.Background(), node.URL, request, []byte("{}"))
+		require.NoError(t, err)
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// The node logs in once, then reuses its token
+	tokens := newNodeTokens("user", "password", &http.Client{})
+	assert.Equal(t, http.StatusOK, send(tokens))
+	assert.Equal(t, http.StatusOK, send(tokens))
+	assert.Equal(t, 1, logins)
+
+	// A refused token is replaced
+	mu.Lock()
+	valid = "revoked"
+	mu.Unlock()
+	assert.Equal(t, http.StatusOK, send(tokens))
+	assert.Equal(t, 2, logins)
+
+	// Invalid credentials fail the login
+	request, err := http.NewRequest(http.MethodPost, node.URL+"/raft/append", nil)
+	require.NoError(t, err)
+	_, err = newNodeTokens("user", "wrong", &http.Client{}).do(context.Background(), node.URL, request, nil)
+	assert.Error(t, err)
+}
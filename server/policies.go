@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmarro89/dare-db/auth"
+)
+
+const ROLE_PARAM = "role"
+
+// HandlerGetRoles returns the roles of the user named in the path.
+func (srv *DareServer) HandlerGetRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	writeJSON(w, map[string]interface{}{"roles": srv.defaultAuthorizer().GetRolesForUser(username)})
+}
+
+// HandlerAddRole assigns the role of the path to the user named in the path.
+func (srv *DareServer) HandlerAddRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	if !srv.userStore.HasUser(username) {
+		http.Error(w, fmt.Sprintf(`User "%v" not found`, username), http.StatusNotFound)
+		return
+	}
+	added, err := srv.defaultAuthorizer().AddRoleForUser(username, r.PathValue(ROLE_PARAM))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save the roles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if added {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// HandlerDeleteRole removes the role of the path from the user named in the
+// path.
+func (srv *DareServer) HandlerDeleteRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, role := r.PathValue(USERNAME_PARAM), r.PathValue(ROLE_PARAM)
+	deleted, err := srv.defaultAuthorizer().DeleteRoleForUser(username, role)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save the roles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, fmt.Sprintf(`User "%v" does not have role "%v"`, username, role), http.StatusNotFound)
+	}
+}
+
+// HandlerGetPermissions returns the policies granted to the user named in the
+// path, directly or through its roles.
+func (srv *DareServer) HandlerGetPermissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	writeJSON(w, map[string]interface{}{"permissions": srv.defaultAuthorizer().GetPermissionsForUser(username)})
+}
+
+// HandlerGetPolicies returns every policy of the authorizer.
+func (srv *DareServer) HandlerGetPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"policies": srv.defaultAuthorizer().GetPolicies()})
+}
+
+// HandlerAddPolicy adds the policy of the body.
+func (srv *DareServer) HandlerAddPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var policy auth.Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	added, err := srv.defaultAuthorizer().AddPolicy(policy)
+	switch {
+	case errors.Is(err, auth.ErrInvalidPolicy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to save the policies: %v", err), http.StatusInternalServerError)
+	case !added:
+		http.Error(w, "Policy already exists", http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// HandlerRemovePolicy removes the policy given by the subject, asset and
+// action query parameters.
+func (srv *DareServer) HandlerRemovePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	policy := auth.Policy{Subject: query.Get("subject"), Asset: query.Get("asset"), Action: query.Get("action")}
+	removed, err := srv.defaultAuthorizer().RemovePolicy(policy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save the policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Policy not found", http.StatusNotFound)
+	}
+}
+
+// HandlerReloadPolicies loads the roles and policies again from the policy
+// file, for the changes made to it while the server runs.
+func (srv *DareServer) HandlerReloadPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := srv.defaultAuthorizer().ReloadPolicy(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reload the policies: %v", err), http.StatusInternalServerError)
+	}
+}
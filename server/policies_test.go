@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAuthorizer returns the default authorizer with its policies copied
+// into a temporary file, and the path of the file.
+func newTestAuthorizer(t *testing.T) (*auth.CasbinAuth, string) {
+	policies, err := os.ReadFile("../" + auth.RBAC_POLICY_FILE)
+	require.NoError(t, err)
+	policyPath := filepath.Join(t.TempDir(), auth.POLICY_FILE)
+	require.NoError(t, os.WriteFile(policyPath, policies, 0644))
+	return auth.NewCasbinAuth("../"+auth.RBAC_CONFIG_FILE, policyPath, auth.Users{
+		auth.DEFAULT_USER: {Roles: []string{auth.DEFAULT_ROLE}},
+	}), policyPath
+}
+
+func TestHandlerPolicies(t *testing.T) {
+	userStore := auth.NewUserStore()
+	require.NoError(t, userStore.AddUser("reader", "password"))
+	srv := NewDareServer(database.NewDatabase(), userStore)
+	authorizer, policyPath := newTestAuthorizer(t)
+	srv.authorizer = authorizer
+
+	policyRequest := func(method string, target string, body string, role string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.SetPathValue(USERNAME_PARAM, "reader")
+		req.SetPathValue(ROLE_PARAM, role)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, field string, value interface{}) {
+		require.Equal(t, http.StatusOK, w.Code)
+		var body map[string]json.RawMessage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.NoError(t, json.Unmarshal(body[field], value))
+	}
+
+	assert.False(t, authorizer.HasPermission("reader", auth.ACTION_READ, "dare-db"))
+
+	w := policyRequest(http.MethodPost, "/admin/policies", `{"subject": "readers", "asset": "*", "action": "read"}`, "", srv.HandlerAddPolicy)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = policyRequest(http.MethodPost, "/admin/policies", `{"subject": "readers", "asset": "*", "action": "read"}`, "", srv.HandlerAddPolicy)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = policyRequest(http.MethodPost, "/admin/policies", `{"subject": "readers", "asset": "*", "action": "("}`, "", srv.HandlerAddPolicy)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = policyRequest(http.MethodPut, "/admin/users/reader/roles/readers", "", "readers", srv.HandlerAddRole)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = policyRequest(http.MethodPut, "/admin/users/reader/roles/readers", "", "readers", srv.HandlerAddRole)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, authorizer.HasPermission("reader", auth.ACTION_READ, "dare-db"))
+	assert.False(t, authorizer.HasPermission("reader", auth.ACTION_WRITE, "dare-db"))
+
+	var roles []string
+	decode(policyRequest(http.MethodGet, "/admin/users/reader/roles", "", "", srv.HandlerGetRoles), "roles", &roles)
+	assert.Equal(t, []string{"readers"}, roles)
+	var permissions []auth.Policy
+	decode(policyRequest(http.MethodGet, "/admin/users/reader/permissions", "", "", srv.HandlerGetPermissions), "permissions", &permissions)
+	assert.Equal(t, []auth.Policy{{Subject: "readers", Asset: "*", Action: auth.ACTION_READ}}, permissions)
+	var policies []auth.Policy
+	decode(policyRequest(http.MethodGet, "/admin/policies", "", "", srv.HandlerGetPolicies), "policies", &policies)
+	assert.Len(t, policies, 4)
+
+	// The changes made to the policy file are picked up on reload
+	require.NoError(t, os.WriteFile(policyPath, []byte("p, readers, *, read|write\ng, reader, readers\n"), 0644))
+	w = policyRequest(http.MethodPost, "/admin/policies/reload", "", "", srv.HandlerReloadPolicies)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, authorizer.HasPermission("reader", auth.ACTION_WRITE, "dare-db"))
+
+	w = policyRequest(http.MethodDelete, "/admin/policies?subject=readers&asset=*&action=read|write", "", "", srv.HandlerRemovePolicy)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = policyRequest(http.MethodDelete, "/admin/policies?subject=readers&asset=*&action=read|write", "", "", srv.HandlerRemovePolicy)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.False(t, authorizer.HasPermission("reader", auth.ACTION_READ, "dare-db"))
+
+	w = policyRequest(http.MethodDelete, "/admin/users/reader/roles/readers", "", "readers", srv.HandlerDeleteRole)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = policyRequest(http.MethodDelete, "/admin/users/reader/roles/readers", "", "readers", srv.HandlerDeleteRole)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/users/missing/roles/readers", nil)
+	req.SetPathValue(USERNAME_PARAM, "missing")
+	req.SetPathValue(ROLE_PARAM, "readers")
+	w = httptest.NewRecorder()
+	srv.HandlerAddRole(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
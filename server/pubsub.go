@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dmarro89/dare-db/pubsub"
+	"github.com/dmarro89/dare-db/websocket"
+)
+
+const CHANNEL_PARAM = "channel"
+const PATTERN_PARAM = "pattern"
+
+// PUBSUB_HEARTBEAT_INTERVAL is the interval between two keep alive messages
+// sent to idle subscribers, so that proxies do not close their connections.
+const PUBSUB_HEARTBEAT_INTERVAL = 15 * time.Second
+
+// PUBSUB_WRITE_TIMEOUT is how long a subscriber may take to accept a message
+// before it is disconnected.
+const PUBSUB_WRITE_TIMEOUT = 10 * time.Second
+
+// PUBSUB_MAX_MESSAGE_SIZE is the largest published message, in bytes.
+const PUBSUB_MAX_MESSAGE_SIZE = 1 << 20
+
+// pubsubEvent is an event sent to WebSocket subscribers: a message, the
+// subscriptions after a subscription command, or an error.
+type pubsubEvent struct {
+	Type     string   `json:"type"`
+	Channel  string   `json:"channel,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Message  *string  `json:"message,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// pubsubCommand is a subscription command sent by WebSocket subscribers.
+type pubsubCommand struct {
+	Action   string   `json:"action"`
+	Channels []string `json:"channels"`
+	Patterns []string `json:"patterns"`
+}
+
+// CloseSubscriptions ends the streams of every pub/sub subscriber, keyspace
+// watcher and follower, on shutdown.
+func (srv *DareServer) CloseSubscriptions() {
+	srv.broker.Close()
+	srv.collectionManager.CloseWatchers()
+	srv.replication.Close()
+}
+
+// HandlerPublish publishes the request body to the channel of the path.
+func (srv *DareServer) HandlerPublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, PUBSUB_MAX_MESSAGE_SIZE))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Message too large, the limit is %d bytes", PUBSUB_MAX_MESSAGE_SIZE), http.StatusRequestEntityTooLarge)
+		return
+	}
+	receivers := srv.broker.Publish(r.PathValue(CHANNEL_PARAM), string(message))
+	writeJSON(w, map[string]int{"receivers": receivers})
+}
+
+// HandlerSubscribe streams the messages of the channel and pattern query
+// parameters as Server-Sent Events: a subscribe event listing the
+// subscriptions, then a message event per message. A slow consumer receives
+// an error event before the stream ends.
+func (srv *DareServer) HandlerSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if len(query[CHANNEL_PARAM]) == 0 && len(query[PATTERN_PARAM]) == 0 {
+		http.Error(w, `At least one "channel" or "pattern" query param is required`, http.StatusBadRequest)
+		return
+	}
+
+	subscription := srv.broker.Subscribe(query[CHANNEL_PARAM], query[PATTERN_PARAM])
+	defer subscription.Close()
+
+	stream := newEventStream(w)
+	if !stream.send("", "subscribe", map[string][]string{"channels": subscription.Channels(), "patterns": subscription.Patterns()}) {
+		return
+	}
+
+	heartbeat := time.NewTicker(PUBSUB_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case message := <-subscription.Messages():
+			if !stream.send("", "message", message) {
+				return
+			}
+		case <-subscription.Done():
+			if err := subscription.Err(); err != nil {
+				stream.send("", "error", map[string]string{"error": err.Error()})
+			}
+			return
+		case <-heartbeat.C:
+			if !stream.ping() {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// eventStream writes Server-Sent Events to a response, flushing each of them.
+type eventStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+// newEventStream sends the headers of an event stream.
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	return &eventStream{w: w, controller: http.NewResponseController(w)}
+}
+
+func (s *eventStream) write(text string) bool {
+	s.controller.SetWriteDeadline(time.Now().Add(PUBSUB_WRITE_TIMEOUT))
+	if _, err := io.WriteString(s.w, text); err != nil {
+		return false
+	}
+	return s.controller.Flush() == nil
+}
+
+// send writes an event with data encoded as JSON, and id unless empty. It
+// returns false once the client cannot be written to.
+func (s *eventStream) send(id string, event string, data interface{}) bool {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return false
+	}
+	if id != "" {
+		return s.write(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", id, event, encoded))
+	}
+	return s.write(fmt.Sprintf("event: %s\ndata: %s\n\n", event, encoded))
+}
+
+// ping writes a comment, keeping an idle stream open.
+func (s *eventStream) ping() bool {
+	return s.write(": ping\n\n")
+}
+
+// HandlerSubscribeWebSocket streams the messages of the channel and pattern
+// query parameters over a WebSocket, as JSON text messages. Subscriptions
+// are changed by sending commands such as
+// {"action":"subscribe","channels":["orders"]}, the actions being subscribe,
+// unsubscribe, psubscribe and punsubscribe. A slow consumer is disconnected
+// with the close code 1008.
+func (srv *DareServer) HandlerSubscribeWebSocket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.MaxMessage = PUBSUB_MAX_MESSAGE_SIZE
+
+	subscription := srv.broker.Subscribe(query[CHANNEL_PARAM], query[PATTERN_PARAM])
+	defer subscription.Close()
+
+	send := func(event pubsubEvent) bool {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return false
+		}
+		conn.SetWriteDeadline(time.Now().Add(PUBSUB_WRITE_TIMEOUT))
+		return conn.WriteMessage(websocket.TEXT_MESSAGE, encoded) == nil
+	}
+	subscriptions := func() pubsubEvent {
+		return pubsubEvent{Type: "subscriptions", Channels: subscription.Channels(), Patterns: subscription.Patterns()}
+	}
+
+	if !send(subscriptions()) {
+		return
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			var command pubsubCommand
+			if err := json.Unmarshal(data, &command); err != nil {
+				send(pubsubEvent{Type: "error", Error: "Invalid JSON format, commands are such as {\"action\":\"subscribe\",\"channels\":[\"name\"]}"})
+				continue
+			}
+			switch command.Action {
+			case "subscribe":
+				subscription.Subscribe(command.Channels...)
+			case "unsubscribe":
+				subscription.Unsubscribe(command.Channels...)
+			case "psubscribe":
+				subscription.PSubscribe(command.Patterns...)
+			case "punsubscribe":
+				subscription.PUnsubscribe(command.Patterns...)
+			default:
+				send(pubsubEvent{Type: "error", Error: fmt.Sprintf(`Unknown action "%s"`, command.Action)})
+				continue
+			}
+			send(subscriptions())
+		}
+	}()
+
+	heartbeat := time.NewTicker(PUBSUB_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case message := <-subscription.Messages():
+			payload := message.Payload
+			if !send(pubsubEvent{Type: "message", Channel: message.Channel, Pattern: message.Pattern, Message: &payload}) {
+				return
+			}
+		case <-subscription.Done():
+			switch err := subscription.Err(); {
+			case errors.Is(err, pubsub.ErrSlowConsumer):
+				conn.WriteClose(websocket.CLOSE_POLICY_VIOLATION, "slow consumer")
+			case errors.Is(err, pubsub.ErrClosed):
+				conn.WriteClose(websocket.CLOSE_GOING_AWAY, "server shutting down")
+			}
+			return
+		case <-readErr:
+			return
+		case <-heartbeat.C:
+			conn.SetWriteDeadline(time.Now().Add(PUBSUB_WRITE_TIMEOUT))
+			if conn.WriteMessage(websocket.PING_MESSAGE, nil) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/pubsub"
+	"github.com/dmarro89/dare-db/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type allowAllAuthorizer struct{}
+
+func (allowAllAuthorizer) HasPermission(userID, action, asset string) bool {
+	return true
+}
+
+// newPubSubServer starts srv behind the authentication middleware and
+// returns its URL with a valid token.
+func newPubSubServer(t *testing.T, srv *DareServer) (string, string) {
+	userStore := auth.NewUserStore()
+	userStore.AddUser("user", "password")
+	srv.userStore = userStore
+	server := httptest.NewServer(srv.CreateMux(allowAllAuthorizer{}, auth.NewJWTAutenticatorWithUsers(userStore)))
+	t.Cleanup(server.Close)
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/login", nil)
+	request.SetBasicAuth("user", "password")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	var tokenResponse map[string]string
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&tokenResponse))
+	return server.URL, tokenResponse["token"]
+}
+
+func publish(t *testing.T, url string, token string, channel string, message string) int {
+	request, _ := http.NewRequest(http.MethodPost, url+"/publish/"+channel, strings.NewReader(message))
+	request.Header.Set("Authorization", token)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var result map[string]int
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+	return result["receivers"]
+}
+
+// readEvent reads the next Server-Sent Event, skipping comments.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestHandlerSubscribe_ServerSentEvents(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	url, token := newPubSubServer(t, srv)
+
+	response, err := http.Get(url + "/subscribe?channel=orders")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request, _ := http.NewRequest(http.MethodGet, url+"/subscribe?channel=orders&pattern=users.*", nil)
+	request.Header.Set("Authorization", token)
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(response.Body)
+	event, data := readEvent(t, reader)
+	assert.Equal(t, "subscribe", event)
+	assert.JSONEq(t, `{"channels":["orders"],"patterns":["users.*"]}`, data)
+
+	assert.Equal(t, 1, publish(t, url, token, "orders", "created"))
+	event, data = readEvent(t, reader)
+	assert.Equal(t, "message", event)
+	assert.JSONEq(t, `{"channel":"orders","message":"created"}`, data)
+
+	assert.Equal(t, 1, publish(t, url, token, "users.42", `{"name":"Ada"}`))
+	_, data = readEvent(t, reader)
+	assert.JSONEq(t, `{"channel":"users.42","pattern":"users.*","message":"{\"name\":\"Ada\"}"}`, data)
+	assert.Equal(t, 0, publish(t, url, token, "other", "ignored"))
+}
+
+// blockingRecorder blocks the writes following the first one until release is closed.
+type blockingRecorder struct {
+	*httptest.ResponseRecorder
+	written chan struct{}
+	release chan struct{}
+	writes  int
+}
+
+func (w *blockingRecorder) Write(data []byte) (int, error) {
+	if w.writes > 0 {
+		<-w.release
+	}
+	w.writes++
+	n, err := w.ResponseRecorder.Write(data)
+	if w.writes == 1 {
+		close(w.written)
+	}
+	return n, err
+}
+
+func (w *blockingRecorder) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+func TestHandlerSubscribe_SlowConsumer(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.broker = pubsub.NewBroker(1)
+
+	response := &blockingRecorder{ResponseRecorder: httptest.NewRecorder(), written: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		srv.HandlerSubscribe(response, httptest.NewRequest(http.MethodGet, "/subscribe?channel=ticks", nil))
+		close(done)
+	}()
+
+	// The handler holds at most one message while blocked, the buffer another one
+	<-response.written
+	for i := 0; i < 3; i++ {
+		srv.broker.Publish("ticks", fmt.Sprint(i))
+	}
+	close(response.release)
+	<-done
+	assert.Contains(t, response.Body.String(), fmt.Sprintf("event: error\ndata: {\"error\":\"%s\"}", pubsub.ErrSlowConsumer.Error()))
+
+	srv.CloseSubscriptions()
+	recorder := httptest.NewRecorder()
+	srv.HandlerSubscribe(recorder, httptest.NewRequest(http.MethodGet, "/subscribe?channel=ticks", nil))
+	assert.Contains(t, recorder.Body.String(), "event: error")
+
+	recorder = httptest.NewRecorder()
+	srv.HandlerSubscribe(recorder, httptest.NewRequest(http.MethodGet, "/subscribe", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestHandlerSubscribeWebSocket(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	url, token := newPubSubServer(t, srv)
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/subscribe/ws?channel=orders"
+
+	_, response, err := websocket.Dial(wsURL, nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	conn, _, err := websocket.Dial(wsURL, http.Header{"Authorization": {token}})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	readEvent := func() pubsubEvent {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var event pubsubEvent
+		require.NoError(t, json.Unmarshal(data, &event))
+		return event
+	}
+
+	event := readEvent()
+	assert.Equal(t, pubsubEvent{Type: "subscriptions", Channels: []string{"orders"}}, event)
+
+	require.NoError(t, conn.WriteMessage(websocket.TEXT_MESSAGE, []byte(`{"action":"psubscribe","patterns":["users.*"]}`)))
+	event = readEvent()
+	assert.Equal(t, []string{"users.*"}, event.Patterns)
+
+	assert.Equal(t, 1, publish(t, url, token, "users.1", "hello"))
+	event = readEvent()
+	assert.Equal(t, "message", event.Type)
+	assert.Equal(t, "users.1", event.Channel)
+	assert.Equal(t, "users.*", event.Pattern)
+	assert.Equal(t, "hello", *event.Message)
+
+	require.NoError(t, conn.WriteMessage(websocket.TEXT_MESSAGE, []byte(`{"action":"jump"}`)))
+	assert.Equal(t, "error", readEvent().Type)
+}
+
+func TestHandlerSubscribeWebSocket_SlowConsumer(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.broker = pubsub.NewBroker(4)
+	url, token := newPubSubServer(t, srv)
+
+	conn, _, err := websocket.Dial("ws"+strings.TrimPrefix(url, "http")+"/subscribe/ws?channel=ticks", http.Header{"Authorization": {token}})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	// The client does not read, its buffer and then the socket fill up
+	message := strings.Repeat("x", 64<<10)
+	for i := 0; i < 1000; i++ {
+		srv.broker.Publish("ticks", fmt.Sprint(i, message))
+	}
+
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.CLOSE_POLICY_VIOLATION, closeErr.Code)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const REPLICATION_ID_PARAM = "id"
+const OFFSET_PARAM = "offset"
+
+// REPLICATION_HEARTBEAT_INTERVAL is the interval between two heartbeats
+// sent by a leader to its followers, carrying the offset of the leader.
+const REPLICATION_HEARTBEAT_INTERVAL = time.Second
+
+// REPLICATION_WRITE_TIMEOUT is how long a follower may take to accept a
+// message, including the copy of every collection, before it is disconnected.
+const REPLICATION_WRITE_TIMEOUT = time.Minute
+
+// Types of the messages of the replication stream
+const (
+	REPLICATION_FULL_SYNC = "full_sync"
+	REPLICATION_CONTINUE  = "continue"
+	REPLICATION_OPERATION = "operation"
+	REPLICATION_PING      = "ping"
+)
+
+// replicationMessage is a line of the stream sent by a leader to a
+// follower. The stream starts with a full_sync message holding a copy of
+// every collection, or a continue message if the follower can resume after
+// the operations it applied. Operation messages follow, one per operation,
+// and ping messages when the leader is idle. Offset is the offset of the
+// leader, or of the snapshot for a full_sync.
+type replicationMessage struct {
+	Type      string              `json:"type"`
+	ID        string              `json:"id,omitempty"`
+	Offset    uint64              `json:"offset,omitempty"`
+	Snapshot  *database.Snapshot  `json:"snapshot,omitempty"`
+	Operation *database.Operation `json:"operation,omitempty"`
+}
+
+// replicationStatus is the replication state of a node: the followers
+// connected to it, and the link to its leader for a follower.
+type replicationStatus struct {
+	Role      string                   `json:"role"`
+	ID        string                   `json:"id"`
+	Offset    uint64                   `json:"offset"`
+	Followers []database.ReplicaStatus `json:"followers"`
+	Leader    *FollowerStatus          `json:"leader,omitempty"`
+}
+
+// Follow makes the server a read-only follower of the leader at leaderURL,
+// such as http://127.0.0.1:2605, logging in as username. It must be called
+// before the server handles requests.
+func (srv *DareServer) Follow(leaderURL string, username string, password string) {
+	srv.follower = NewFollower(srv.collectionManager, leaderURL, username, password)
+	srv.follower.Start()
+}
+
+// HandlerReplicationStream streams the collections and their operations to
+// a follower as JSON lines. The id and offset query parameters are the log
+// and the offset the follower reached, if any.
+func (srv *DareServer) HandlerReplicationStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if srv.follower != nil {
+		http.Error(w, fmt.Sprintf("This node is a follower, follow the leader at %s", srv.follower.LeaderURL()), http.StatusConflict)
+		return
+	}
+	if srv.cluster != nil {
+		http.Error(w, "This node is a member of a cluster, which replicates the collections through raft", http.StatusConflict)
+		return
+	}
+
+	query := r.URL.Query()
+	var offset uint64
+	if value := query.Get(OFFSET_PARAM); value != "" {
+		var err error
+		if offset, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf(`query param "%s" must be a replication offset`, OFFSET_PARAM), http.StatusBadRequest)
+			return
+		}
+	}
+
+	replica, snapshot, backlog, offset := srv.replication.Follow(srv.collectionManager, r.RemoteAddr, query.Get(REPLICATION_ID_PARAM), offset)
+	defer replica.Close()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	send := func(message replicationMessage) bool {
+		controller.SetWriteDeadline(time.Now().Add(REPLICATION_WRITE_TIMEOUT))
+		if err := encoder.Encode(message); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+
+	id := srv.replication.ID()
+	if snapshot != nil {
+		if !send(replicationMessage{Type: REPLICATION_FULL_SYNC, ID: id, Offset: offset, Snapshot: snapshot}) {
+			return
+		}
+	} else if !send(replicationMessage{Type: REPLICATION_CONTINUE, ID: id, Offset: srv.replication.Offset()}) {
+		return
+	}
+	for i := range backlog {
+		if !send(replicationMessage{Type: REPLICATION_OPERATION, Operation: &backlog[i]}) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(REPLICATION_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case op := <-replica.Operations():
+			if !send(replicationMessage{Type: REPLICATION_OPERATION, Operation: &op}) {
+				return
+			}
+		case <-replica.Done():
+			// The follower notices the end of the stream and reconnects
+			return
+		case <-heartbeat.C:
+			if !send(replicationMessage{Type: REPLICATION_PING, Offset: srv.replication.Offset()}) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// HandlerReplicationStatus returns the role of the node, its offset and the
+// followers connected to it, and for a follower the state of the link to its
+// leader and the replication lag.
+func (srv *DareServer) HandlerReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := replicationStatus{
+		Role:      "leader",
+		ID:        srv.replication.ID(),
+		Offset:    srv.replication.Offset(),
+		Followers: srv.replication.Replicas(),
+	}
+	if srv.follower != nil {
+		leader := srv.follower.Status()
+		status.Role = "follower"
+		status.Leader = &leader
+	}
+	writeJSON(w, status)
+}
+
+// rejectWritesOnFollower answers the requests changing the collections sent
+// to a follower with a redirection to the same request on the leader.
+func (srv *DareServer) rejectWritesOnFollower(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if srv.follower == nil || !isWriteRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		leaderURL := srv.follower.LeaderURL()
+		w.Header().Set("Location", leaderURL+r.URL.RequestURI())
+		http.Error(w, fmt.Sprintf("This node is a read-only follower, send writes to the leader at %s", leaderURL), http.StatusTemporaryRedirect)
+	})
+}
+
+// isWriteRequest reports whether r may change the collections. Logging in
+// and out, refreshing tokens, publishing messages and the administration
+// endpoints only affect the node receiving them.
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return r.URL.Path != "/login" && r.URL.Path != "/logout" && r.URL.Path != "/refresh" && !strings.HasPrefix(r.URL.Path, "/publish/") && !strings.HasPrefix(r.URL.Path, "/admin/")
+}
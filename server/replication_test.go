@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFollowerServer starts a server following the leader at leaderURL, and
+// returns it with its URL and a valid token.
+func newFollowerServer(t *testing.T, leaderURL string) (*DareServer, string, string) {
+	follower := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	follower.Follow(leaderURL, "user", "password")
+	t.Cleanup(func() { follower.Close() })
+	url, token := newPubSubServer(t, follower)
+	return follower, url, token
+}
+
+func replicationStatusOf(t *testing.T, url string, token string) replicationStatus {
+	request, _ := http.NewRequest(http.MethodGet, url+"/admin/replication", nil)
+	request.Header.Set("Authorization", token)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var status replicationStatus
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&status))
+	return status
+}
+
+func TestReplication_LeaderAndFollowers(t *testing.T) {
+	leader := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	leaderURL, leaderToken := newPubSubServer(t, leader)
+	require.NoError(t, leader.collectionManager.GetDefaultCollection().Set("key1", "value1"))
+
+	first, firstURL, firstToken := newFollowerServer(t, leaderURL)
+	second, _, _ := newFollowerServer(t, leaderURL)
+	for _, follower := range []*DareServer{first, second} {
+		require.Eventually(t, func() bool {
+			return follower.collectionManager.GetDefaultCollection().Get("key1") == "value1"
+		}, 5*time.Second, 10*time.Millisecond, "Expected the follower to copy the collections of the leader")
+	}
+
+	// The followers apply the operations streamed by the leader
+	leader.collectionManager.AddCollection("users")
+	users, _ := leader.collectionManager.GetCollection("users")
+	require.NoError(t, users.Set("alice", "1"))
+	_, err := leader.collectionManager.GetDefaultCollection().HSet("user:1", map[string]string{"name": "Ada"})
+	require.NoError(t, err)
+	require.NoError(t, leader.collectionManager.GetDefaultCollection().Delete("key1"))
+	for _, follower := range []*DareServer{first, second} {
+		require.Eventually(t, func() bool {
+			return follower.follower.Status().Offset == leader.replication.Offset()
+		}, 5*time.Second, 10*time.Millisecond)
+		followerUsers, exists := follower.collectionManager.GetCollection("users")
+		require.True(t, exists)
+		assert.Equal(t, "1", followerUsers.Get("alice"))
+		name, _, err := follower.collectionManager.GetDefaultCollection().HGet("user:1", "name")
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", name)
+		assert.False(t, follower.collectionManager.GetDefaultCollection().Exists("key1"))
+	}
+
+	status := replicationStatusOf(t, leaderURL, leaderToken)
+	assert.Equal(t, "leader", status.Role)
+	assert.Equal(t, leader.replication.ID(), status.ID)
+	assert.Len(t, status.Followers, 2)
+	status = replicationStatusOf(t, firstURL, firstToken)
+	assert.Equal(t, "follower", status.Role)
+	require.NotNil(t, status.Leader)
+	assert.Equal(t, leaderURL, status.Leader.LeaderURL)
+	assert.Equal(t, FOLLOWER_STREAMING, status.Leader.State)
+	assert.Equal(t, leader.replication.ID(), status.Leader.LeaderID)
+	assert.Equal(t, leader.replication.Offset(), status.Leader.Offset)
+	assert.Zero(t, status.Leader.Lag)
+	assert.Equal(t, 1, status.Leader.FullSyncs)
+
+	// The follower resumes the stream after an interruption
+	first.follower.Stop()
+	require.NoError(t, leader.collectionManager.GetDefaultCollection().Set("key2", "value2"))
+	first.follower.Start()
+	require.Eventually(t, func() bool {
+		return first.collectionManager.GetDefaultCollection().Get("key2") == "value2"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, first.follower.Status().FullSyncs, "Expected the follower to resume without copying the collections again")
+}
+
+func TestReplication_FollowerRejectsWrites(t *testing.T) {
+	leader := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	leaderURL, leaderToken := newPubSubServer(t, leader)
+	follower, url, token := newFollowerServer(t, leaderURL)
+	require.NoError(t, leader.collectionManager.GetDefaultCollection().Set("key", "value"))
+	require.Eventually(t, func() bool {
+		return follower.collectionManager.GetDefaultCollection().Get("key") == "value"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	request, _ := http.NewRequest(http.MethodPost, url+"/set", strings.NewReader(`{"key":"value"}`))
+	request.Header.Set("Authorization", token)
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+	assert.Equal(t, leaderURL+"/set", response.Header.Get("Location"))
+
+	request, _ = http.NewRequest(http.MethodGet, url+"/get/key", nil)
+	request.Header.Set("Authorization", token)
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Expected followers to serve reads")
+
+	request, _ = http.NewRequest(http.MethodGet, url+"/replication/stream", nil)
+	request.Header.Set("Authorization", token)
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusConflict, response.StatusCode, "Expected followers not to be followed")
+
+	// Writes sent to the leader reach the follower
+	request, _ = http.NewRequest(http.MethodPost, leaderURL+"/set", strings.NewReader(`{"written":"on the leader"}`))
+	request.Header.Set("Authorization", leaderToken)
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	require.Eventually(t, func() bool {
+		return follower.collectionManager.GetDefaultCollection().Get("written") == "on the leader"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplication_StreamBadOffset(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	recorder := httptest.NewRecorder()
+	srv.HandlerReplicationStream(recorder, httptest.NewRequest(http.MethodGet, "/replication/stream?offset=abc", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRespServer_FollowerReadOnly(t *testing.T) {
+	t.Setenv("DARE_RESP_PORT", "0")
+	userStore := auth.NewUserStore()
+	userStore.AddUser("admin", "secret")
+	dareServer := NewDareServer(database.NewDatabase(), userStore)
+	dareServer.follower = NewFollower(dareServer.collectionManager, "http://127.0.0.1:2605", "admin", "secret")
+
+	server := NewRespServer(dareServer, NewConfiguration(""), logger.NewDareLogger())
+	server.authorizer = allowAllAuthorizer{}
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	assert.Equal(t, resp.Error("READONLY You can't write against a read only replica."), client.do("SET", "key", "value"))
+	assert.Nil(t, client.do("GET", "key"))
+}
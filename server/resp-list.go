@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/resp"
+)
+
+const RESP_WRONGTYPE_ERROR = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// push implements LPUSH key element [element ...] and RPUSH key element [element ...].
+func (server *RespServer) push(session *respSession, command string, args []string) {
+	writer := session.writer
+	if len(args) < 2 {
+		wrongArgs(writer, strings.ToLower(command))
+		return
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	collection := server.collectionForWrite(session)
+	var length int
+	var err error
+	if command == "LPUSH" {
+		length, err = collection.LPush(args[0], args[1:]...)
+	} else {
+		length, err = collection.RPush(args[0], args[1:]...)
+	}
+	if err != nil {
+		writeListCommandError(writer, err)
+		return
+	}
+	writer.WriteInteger(int64(length))
+}
+
+// pop implements LPOP key [count] and RPOP key [count].
+func (server *RespServer) pop(session *respSession, command string, args []string) {
+	writer := session.writer
+	if len(args) != 1 && len(args) != 2 {
+		wrongArgs(writer, strings.ToLower(command))
+		return
+	}
+	count := 1
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 0 {
+			writer.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil || count == 0 {
+		writer.WriteNull()
+		return
+	}
+	var elements []string
+	var err error
+	if command == "LPOP" {
+		elements, err = collection.LPop(args[0], count)
+	} else {
+		elements, err = collection.RPop(args[0], count)
+	}
+	if errors.Is(err, database.ErrKeyNotFound) {
+		writer.WriteNull()
+		return
+	}
+	if err != nil {
+		writeListCommandError(writer, err)
+		return
+	}
+	if len(args) == 2 {
+		writer.WriteStringArray(elements)
+	} else {
+		writer.WriteBulkString(elements[0])
+	}
+}
+
+// blockingPop implements BLPOP key [key ...] timeout and BRPOP key [key ...]
+// timeout. The connection is held until an element is popped, the timeout
+// in seconds expires or the server stops.
+func (server *RespServer) blockingPop(session *respSession, command string, args []string) {
+	writer := session.writer
+	if len(args) < 2 {
+		wrongArgs(writer, strings.ToLower(command))
+		return
+	}
+	seconds, err := strconv.ParseFloat(args[len(args)-1], 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		writer.WriteError("ERR timeout is not a float or out of range")
+		return
+	}
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		if !server.authorize(session, auth.ACTION_WRITE, key) {
+			return
+		}
+	}
+
+	// Replies to previous commands are sent before blocking
+	if err := writer.Flush(); err != nil {
+		return
+	}
+
+	collection := server.collectionForWrite(session)
+	timeout := time.Duration(seconds * float64(time.Second))
+	var key, element string
+	if cluster := server.dareServer.cluster; cluster != nil {
+		key, element, err = cluster.blockingPop(session.ctx, collection.Name(), keys, timeout, command == "BLPOP")
+	} else if command == "BLPOP" {
+		key, element, err = collection.BLPop(session.ctx, keys, timeout)
+	} else {
+		key, element, err = collection.BRPop(session.ctx, keys, timeout)
+	}
+	if errors.Is(err, database.ErrTimeout) || errors.Is(err, context.Canceled) {
+		writer.WriteNull()
+		return
+	}
+	if err != nil {
+		writeListCommandError(writer, err)
+		return
+	}
+	writer.WriteStringArray([]string{key, element})
+}
+
+func (server *RespServer) lrange(session *respSession, args []string) {
+	writer := session.writer
+	if len(args) != 3 {
+		wrongArgs(writer, "lrange")
+		return
+	}
+	start, startErr := strconv.Atoi(args[1])
+	stop, stopErr := strconv.Atoi(args[2])
+	if startErr != nil || stopErr != nil {
+		writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_READ, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil {
+		writer.WriteStringArray(nil)
+		return
+	}
+	elements, err := collection.LRange(args[0], start, stop)
+	if err != nil {
+		writeListCommandError(writer, err)
+		return
+	}
+	writer.WriteStringArray(elements)
+}
+
+func (server *RespServer) llen(session *respSession, args []string) {
+	writer := session.writer
+	if len(args) != 1 {
+		wrongArgs(writer, "llen")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_READ, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil {
+		writer.WriteInteger(0)
+		return
+	}
+	length, err := collection.LLen(args[0])
+	if err != nil {
+		writeListCommandError(writer, err)
+		return
+	}
+	writer.WriteInteger(int64(length))
+}
+
+func (server *RespServer) ltrim(session *respSession, args []string) {
+	writer := session.writer
+	if len(args) != 3 {
+		wrongArgs(writer, "ltrim")
+		return
+	}
+	start, startErr := strconv.Atoi(args[1])
+	stop, stopErr := strconv.Atoi(args[2])
+	if startErr != nil || stopErr != nil {
+		writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection != nil {
+		if err := collection.LTrim(args[0], start, stop); err != nil {
+			writeListCommandError(writer, err)
+			return
+		}
+	}
+	writer.WriteOK()
+}
+
+// valueType implements TYPE key.
+func (server *RespServer) valueType(session *respSession, args []string) {
+	if len(args) != 1 {
+		wrongArgs(session.writer, "type")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_READ, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil {
+		session.writer.WriteSimpleString(string(database.TYPE_NONE))
+		return
+	}
+	session.writer.WriteSimpleString(string(collection.Type(args[0])))
+}
+
+func writeListCommandError(writer *resp.Writer, err error) {
+	switch {
+	case errors.Is(err, database.ErrWrongType):
+		writer.WriteError(RESP_WRONGTYPE_ERROR)
+	case errors.Is(err, database.ErrOutOfMemory):
+		writer.WriteError("OOM command not allowed when used memory > 'maxmemory'")
+	default:
+		writer.WriteError("ERR error saving data")
+	}
+}
@@ -0,0 +1,836 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/resp"
+	"github.com/dmarro89/dare-db/utils"
+)
+
+// RespServer serves the collections over the Redis serialization protocol,
+// so that Redis clients can be used against DareDB. Every connection must
+// authenticate with AUTH or HELLO before issuing commands, and commands are
+// authorized like the equivalent HTTP requests.
+type RespServer struct {
+	dareServer    *DareServer
+	authorizer    auth.Authorizer
+	configuration Config
+	logger        logger.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	// ctx is canceled by Stop, releasing the blocked commands
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRespServer(dareServer *DareServer, configuration Config, logger logger.Logger) *RespServer {
+	return &RespServer{
+		dareServer:    dareServer,
+		configuration: configuration,
+		logger:        logger,
+		conns:         make(map[net.Conn]struct{}),
+	}
+}
+
+// Start listens on resp.host:resp.port and serves connections in the background.
+func (server *RespServer) Start() error {
+	if server.authorizer == nil {
+		server.authorizer = server.dareServer.defaultAuthorizer()
+	}
+
+	host := server.configuration.GetString("resp.host")
+	if host == "" {
+		host = server.configuration.GetString("server.host")
+	}
+	port := server.configuration.GetString("resp.port")
+	if port == "" {
+		port = DEFAULT_RESP_PORT
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("failed to start RESP listener: %w", err)
+	}
+
+	server.mu.Lock()
+	server.listener = listener
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	server.mu.Unlock()
+
+	server.logger.Info("Serving RESP connections on: ", listener.Addr().String())
+	server.wg.Add(1)
+	go server.accept(listener)
+	return nil
+}
+
+// Addr returns the address the server listens on, nil if it is not started.
+func (server *RespServer) Addr() net.Addr {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// Stop closes the listener and every open connection.
+func (server *RespServer) Stop() {
+	server.mu.Lock()
+	if server.listener != nil {
+		server.listener.Close()
+		server.listener = nil
+	}
+	if server.cancel != nil {
+		server.cancel()
+	}
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.mu.Unlock()
+
+	server.wg.Wait()
+	server.logger.Info("Stopped serving RESP connections.")
+}
+
+func (server *RespServer) accept(listener net.Listener) {
+	defer server.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				server.logger.Error("Error accepting RESP connection: ", err)
+			}
+			return
+		}
+
+		server.mu.Lock()
+		if server.listener == nil {
+			server.mu.Unlock()
+			conn.Close()
+			return
+		}
+		server.conns[conn] = struct{}{}
+		server.wg.Add(1)
+		server.mu.Unlock()
+
+		go server.serve(conn)
+	}
+}
+
+type respSession struct {
+	ctx        context.Context
+	writer     *resp.Writer
+	user       string
+	collection string
+	quit       bool
+}
+
+func (server *RespServer) serve(conn net.Conn) {
+	defer server.wg.Done()
+	defer func() {
+		server.mu.Lock()
+		delete(server.conns, conn)
+		server.mu.Unlock()
+		conn.Close()
+	}()
+
+	// The commands are limited until the client authenticates
+	reader := resp.NewReader(conn)
+	reader.SetLimits(resp.UNAUTHENTICATED_MAX_ARRAY_LENGTH, resp.UNAUTHENTICATED_MAX_BULK_LENGTH)
+	authenticated := false
+	server.mu.Lock()
+	ctx := server.ctx
+	server.mu.Unlock()
+
+	session := &respSession{
+		ctx:        ctx,
+		writer:     resp.NewWriter(conn),
+		collection: database.DEFAULT_COLLECTION,
+	}
+
+	for !session.quit {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if errors.Is(err, resp.ErrProtocol) {
+				session.writer.WriteError("ERR " + err.Error())
+				session.writer.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				server.logger.Debug("Error reading RESP command: ", err)
+			}
+			return
+		}
+
+		server.execute(session, args)
+		if !authenticated && session.user != "" {
+			reader.SetLimits(resp.MAX_ARRAY_LENGTH, resp.MAX_BULK_LENGTH)
+			authenticated = true
+		}
+
+		// Replies to pipelined commands are sent together
+		if reader.Buffered() == 0 || session.quit {
+			if err := session.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// respWriteCommands are the commands changing the collections, rejected by followers.
+var respWriteCommands = map[string]bool{
+	"SET": true, "DEL": true, "INCR": true, "DECR": true, "INCRBY": true, "DECRBY": true, "INCRBYFLOAT": true,
+	"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true, "BLPOP": true, "BRPOP": true, "LTRIM": true,
+	"EXPIRE": true, "PERSIST": true,
+}
+
+func (server *RespServer) execute(session *respSession, args []string) {
+	command := strings.ToUpper(args[0])
+	args = args[1:]
+	writer := session.writer
+
+	switch command {
+	case "PING":
+		if len(args) > 0 {
+			writer.WriteBulkString(args[0])
+		} else {
+			writer.WriteSimpleString("PONG")
+		}
+		return
+	case "QUIT":
+		writer.WriteOK()
+		session.quit = true
+		return
+	case "HELLO":
+		server.hello(session, args)
+		return
+	case "AUTH":
+		server.auth(session, args)
+		return
+	}
+
+	if session.user == "" {
+		writer.WriteError("NOAUTH Authentication required.")
+		return
+	}
+	if server.dareServer.follower != nil && respWriteCommands[command] {
+		writer.WriteError("READONLY You can't write against a read only replica.")
+		return
+	}
+	if cluster := server.dareServer.cluster; cluster != nil && !respLocalCommands[command] {
+		if server.confirmLeader(session) {
+			cluster.Serve(func() {
+				server.dispatch(session, command, args)
+			})
+		}
+		return
+	}
+	if server.dareServer.shards != nil && !respLocalCommands[command] {
+		release, ok := server.enterShard(session, command, args)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	server.dispatch(session, command, args)
+}
+
+// respLocalCommands are the commands which do not use the collections,
+// served by every node of a cluster.
+var respLocalCommands = map[string]bool{
+	"SELECT": true, "COMMAND": true, "CLIENT": true,
+}
+
+// confirmLeader answers a command sent to a node which is not the leader of
+// its cluster with an error. On the leader, it confirms the leadership and
+// waits until the committed entries are applied. It returns true if the
+// command can run.
+func (server *RespServer) confirmLeader(session *respSession) bool {
+	node := server.dareServer.cluster.Node()
+	if !node.IsLeader() {
+		if leader, known := node.Leader(); known {
+			session.writer.WriteError(fmt.Sprintf("NOTLEADER This node is not the leader of the cluster, the leader is %s at %s", leader.ID, leader.Address))
+		} else {
+			session.writer.WriteError("CLUSTERDOWN No leader is elected in the cluster")
+		}
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(session.ctx, CLUSTER_REQUEST_TIMEOUT)
+	defer cancel()
+	if err := node.ReadIndex(ctx); err != nil {
+		session.writer.WriteError(fmt.Sprintf("CLUSTERDOWN The leadership of the node could not be confirmed: %v", err))
+		return false
+	}
+	return true
+}
+
+// respCommandKeys returns the keys used by a command, which must be sent to
+// the node owning them in a sharded cluster. KEYS and SCAN only list the keys
+// of the node.
+func respCommandKeys(command string, args []string) []string {
+	switch command {
+	case "KEYS", "SCAN":
+		return nil
+	case "DEL", "EXISTS":
+		return args
+	case "BLPOP", "BRPOP":
+		// The last argument is the timeout
+		if len(args) < 2 {
+			return nil
+		}
+		return args[:len(args)-1]
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args[:1]
+}
+
+// enterShard answers a command on keys of another node of the sharded
+// cluster with a MOVED error giving the address of the node. The writes on a
+// migrating slot wait until the end of the migration. It returns true, with
+// the function to call once the command is served, if the command can run.
+func (server *RespServer) enterShard(session *respSession, command string, args []string) (func(), bool) {
+	keys := respCommandKeys(command, args)
+	if len(keys) == 0 {
+		return func() {}, true
+	}
+
+	shards := server.dareServer.shards
+	slots := slotsOf(keys)
+	for {
+		release, migrated, owner, err := shards.enter(slots, respWriteCommands[command])
+		switch {
+		case errors.Is(err, ErrCrossNodeKeys):
+			session.writer.WriteError("CROSSSLOT Keys in request don't hash to the same node")
+			return nil, false
+		case release != nil:
+			return release, true
+		case migrated != nil:
+			select {
+			case <-migrated:
+			case <-session.ctx.Done():
+				session.writer.WriteError("TRYAGAIN The slot is migrating")
+				return nil, false
+			}
+		default:
+			session.writer.WriteError(fmt.Sprintf("MOVED %d %s", slots[0], shards.nodes[owner]))
+			return nil, false
+		}
+	}
+}
+
+func (server *RespServer) dispatch(session *respSession, command string, args []string) {
+	writer := session.writer
+	switch command {
+	case "SELECT":
+		server.selectCollection(session, args)
+	case "GET":
+		server.get(session, args)
+	case "SET":
+		server.set(session, args)
+	case "DEL":
+		server.del(session, args)
+	case "EXISTS":
+		server.exists(session, args)
+	case "KEYS":
+		server.keys(session, args)
+	case "SCAN":
+		server.scan(session, args)
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		server.incrBy(session, command, args)
+	case "INCRBYFLOAT":
+		server.incrByFloat(session, args)
+	case "LPUSH", "RPUSH":
+		server.push(session, command, args)
+	case "LPOP", "RPOP":
+		server.pop(session, command, args)
+	case "BLPOP", "BRPOP":
+		server.blockingPop(session, command, args)
+	case "LRANGE":
+		server.lrange(session, args)
+	case "LLEN":
+		server.llen(session, args)
+	case "LTRIM":
+		server.ltrim(session, args)
+	case "TYPE":
+		server.valueType(session, args)
+	case "EXPIRE":
+		server.expire(session, args)
+	case "PERSIST":
+		server.persist(session, args)
+	case "TTL":
+		server.ttl(session, args)
+	case "COMMAND":
+		writer.WriteArrayHeader(0)
+	case "CLIENT":
+		writer.WriteOK()
+	default:
+		writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(command)))
+	}
+}
+
+func wrongArgs(writer *resp.Writer, command string) {
+	writer.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", command))
+}
+
+// hello implements HELLO [protover [AUTH username password]].
+func (server *RespServer) hello(session *respSession, args []string) {
+	writer := session.writer
+	protocol := writer.Protocol
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil || (version != resp.PROTOCOL_RESP2 && version != resp.PROTOCOL_RESP3) {
+			writer.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		protocol = version
+		args = args[1:]
+	}
+
+	if len(args) > 0 {
+		if len(args) != 3 || strings.ToUpper(args[0]) != "AUTH" {
+			writer.WriteError("ERR syntax error in HELLO option")
+			return
+		}
+		if !server.dareServer.userStore.ValidateCredentials(args[1], args[2]) {
+			writer.WriteError("WRONGPASS invalid username-password pair")
+			return
+		}
+		session.user = args[1]
+	}
+	if session.user == "" {
+		writer.WriteError("NOAUTH HELLO must be called with the client credentials")
+		return
+	}
+
+	writer.Protocol = protocol
+	writer.WriteMapHeader(3)
+	writer.WriteBulkString("server")
+	writer.WriteBulkString("dare-db")
+	writer.WriteBulkString("proto")
+	writer.WriteInteger(int64(protocol))
+	writer.WriteBulkString("mode")
+	writer.WriteBulkString("standalone")
+}
+
+// auth implements AUTH [username] password. Without username the admin user
+// of the server is assumed.
+func (server *RespServer) auth(session *respSession, args []string) {
+	var username, password string
+	switch len(args) {
+	case 1:
+		username, password = server.dareServer.adminUser, args[0]
+	case 2:
+		username, password = args[0], args[1]
+	default:
+		wrongArgs(session.writer, "auth")
+		return
+	}
+
+	if !server.dareServer.userStore.ValidateCredentials(username, password) {
+		session.writer.WriteError("WRONGPASS invalid username-password pair")
+		return
+	}
+	session.user = username
+	session.writer.WriteOK()
+}
+
+// authorize checks the permission of the session user to run the action on
+// the key of the selected collection, or on the collection itself if key is
+// empty, writing an error if it is denied.
+func (server *RespServer) authorize(session *respSession, action string, key string) bool {
+	if server.authorizer.HasPermission(session.user, action, auth.KeyAsset(session.collection, key)) {
+		return true
+	}
+	session.writer.WriteError("NOPERM you do not have permission to access this resource")
+	return false
+}
+
+// selectCollection implements SELECT collection. Index 0 is the default collection.
+func (server *RespServer) selectCollection(session *respSession, args []string) {
+	if len(args) != 1 {
+		wrongArgs(session.writer, "select")
+		return
+	}
+
+	name := args[0]
+	if name == "0" {
+		name = database.DEFAULT_COLLECTION
+	}
+	session.collection = name
+	session.writer.WriteOK()
+}
+
+// collection returns the selected collection, nil if it does not exist.
+func (server *RespServer) collection(session *respSession) *database.Database {
+	collection, _ := server.dareServer.collectionManager.GetCollection(session.collection)
+	return collection
+}
+
+// collectionForWrite returns the selected collection, creating it if needed.
+func (server *RespServer) collectionForWrite(session *respSession) *database.Database {
+	collectionManager := server.dareServer.collectionManager
+	collection, exists := collectionManager.GetCollection(session.collection)
+	if !exists {
+		collectionManager.AddCollection(session.collection)
+		collection, _ = collectionManager.GetCollection(session.collection)
+	}
+	return collection
+}
+
+func (server *RespServer) get(session *respSession, args []string) {
+	if len(args) != 1 {
+		wrongArgs(session.writer, "get")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_READ, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil {
+		session.writer.WriteNull()
+		return
+	}
+	value := collection.Get(args[0])
+	if value == "" {
+		session.writer.WriteNull()
+		return
+	}
+	session.writer.WriteBulkString(value)
+}
+
+// set implements SET key value [EX seconds | PX milliseconds].
+func (server *RespServer) set(session *respSession, args []string) {
+	writer := session.writer
+	if len(args) < 2 {
+		wrongArgs(writer, "set")
+		return
+	}
+
+	var ttl time.Duration
+	options := args[2:]
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(options[i])
+		if (option != "EX" && option != "PX") || i+1 == len(options) || ttl != 0 {
+			writer.WriteError("ERR syntax error")
+			return
+		}
+		unit := time.Second
+		if option == "PX" {
+			unit = time.Millisecond
+		}
+		amount, err := strconv.ParseInt(options[i+1], 10, 64)
+		var ok bool
+		if ttl, ok = durationOf(amount, unit); err != nil || amount <= 0 || !ok {
+			writer.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+		i++
+	}
+
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	err := server.collectionForWrite(session).SetWithTTL(args[0], args[1], ttl)
+	if errors.Is(err, database.ErrOutOfMemory) {
+		writer.WriteError("OOM command not allowed when used memory > 'maxmemory'")
+		return
+	}
+	if err != nil {
+		writer.WriteError("ERR error saving data")
+		return
+	}
+	writer.WriteOK()
+}
+
+func (server *RespServer) del(session *respSession, args []string) {
+	if len(args) == 0 {
+		wrongArgs(session.writer, "del")
+		return
+	}
+	for _, key := range args {
+		if !server.authorize(session, auth.ACTION_WRITE, key) {
+			return
+		}
+	}
+
+	deleted := 0
+	if collection := server.collection(session); collection != nil {
+		for _, key := range args {
+			if collection.Exists(key) && collection.Delete(key) == nil {
+				deleted++
+			}
+		}
+	}
+	session.writer.WriteInteger(int64(deleted))
+}
+
+func (server *RespServer) exists(session *respSession, args []string) {
+	if len(args) == 0 {
+		wrongArgs(session.writer, "exists")
+		return
+	}
+	for _, key := range args {
+		if !server.authorize(session, auth.ACTION_READ, key) {
+			return
+		}
+	}
+
+	found := 0
+	if collection := server.collection(session); collection != nil {
+		for _, key := range args {
+			if collection.Exists(key) {
+				found++
+			}
+		}
+	}
+	session.writer.WriteInteger(int64(found))
+}
+
+func (server *RespServer) keys(session *respSession, args []string) {
+	if len(args) != 1 {
+		wrongArgs(session.writer, "keys")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_READ, "") {
+		return
+	}
+
+	session.writer.WriteStringArray(server.matchingKeys(session, args[0]))
+}
+
+// matchingKeys returns the sorted keys of the selected collection matching pattern.
+func (server *RespServer) matchingKeys(session *respSession, pattern string) []string {
+	keys := []string{}
+	collection := server.collection(session)
+	if collection == nil {
+		return keys
+	}
+	for key := range collection.GetAllItems() {
+		if utils.GlobMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count].
+func (server *RespServer) scan(session *respSession, args []string) {
+	writer := session.writer
+	if len(args) == 0 {
+		wrongArgs(writer, "scan")
+		return
+	}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		writer.WriteError("ERR invalid cursor")
+		return
+	}
+
+	options := database.ScanOptions{}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			writer.WriteError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			options.Match = args[i+1]
+		case "COUNT":
+			options.Count, err = strconv.Atoi(args[i+1])
+			if err != nil || options.Count <= 0 {
+				writer.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			writer.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	if !server.authorize(session, auth.ACTION_READ, "") {
+		return
+	}
+
+	keys := []string{}
+	var next uint64
+	if collection := server.collection(session); collection != nil {
+		var items map[string]string
+		items, next = collection.Scan(cursor, options)
+		for key := range items {
+			keys = append(keys, key)
+		}
+	}
+
+	writer.WriteArrayHeader(2)
+	writer.WriteBulkString(strconv.FormatUint(next, 10))
+	writer.WriteStringArray(keys)
+}
+
+// incrBy implements INCR key, DECR key, INCRBY key increment and DECRBY key decrement.
+func (server *RespServer) incrBy(session *respSession, command string, args []string) {
+	writer := session.writer
+	withAmount := command == "INCRBY" || command == "DECRBY"
+	if (withAmount && len(args) != 2) || (!withAmount && len(args) != 1) {
+		wrongArgs(writer, strings.ToLower(command))
+		return
+	}
+
+	delta := int64(1)
+	if withAmount {
+		var err error
+		if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			writer.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	collection := server.collectionForWrite(session)
+	var value int64
+	var err error
+	if command == "DECR" || command == "DECRBY" {
+		value, err = collection.DecrBy(args[0], delta)
+	} else {
+		value, err = collection.IncrBy(args[0], delta)
+	}
+	if err != nil {
+		writeCounterError(writer, err)
+		return
+	}
+	writer.WriteInteger(value)
+}
+
+func (server *RespServer) incrByFloat(session *respSession, args []string) {
+	writer := session.writer
+	if len(args) != 2 {
+		wrongArgs(writer, "incrbyfloat")
+		return
+	}
+	delta, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		writer.WriteError("ERR value is not a valid float")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	value, err := server.collectionForWrite(session).IncrByFloat(args[0], delta)
+	if err != nil {
+		writeCounterError(writer, err)
+		return
+	}
+	writer.WriteBulkString(strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func writeCounterError(writer *resp.Writer, err error) {
+	switch {
+	case errors.Is(err, database.ErrWrongType):
+		writer.WriteError(RESP_WRONGTYPE_ERROR)
+	case errors.Is(err, database.ErrNotInteger):
+		writer.WriteError("ERR value is not an integer or out of range")
+	case errors.Is(err, database.ErrNotNumber):
+		writer.WriteError("ERR value is not a valid float")
+	case errors.Is(err, database.ErrOverflow):
+		writer.WriteError("ERR increment or decrement would overflow")
+	case errors.Is(err, database.ErrOutOfMemory):
+		writer.WriteError("OOM command not allowed when used memory > 'maxmemory'")
+	default:
+		writer.WriteError("ERR error saving data")
+	}
+}
+
+func (server *RespServer) expire(session *respSession, args []string) {
+	if len(args) != 2 {
+		wrongArgs(session.writer, "expire")
+		return
+	}
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	ttl, ok := durationOf(seconds, time.Second)
+	if err != nil {
+		session.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	if !ok {
+		session.writer.WriteError("ERR invalid expire time in 'expire' command")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil || collection.Expire(args[0], ttl) != nil {
+		session.writer.WriteInteger(0)
+		return
+	}
+	session.writer.WriteInteger(1)
+}
+
+func (server *RespServer) persist(session *respSession, args []string) {
+	if len(args) != 1 {
+		wrongArgs(session.writer, "persist")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_WRITE, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil {
+		session.writer.WriteInteger(0)
+		return
+	}
+	ttl, err := collection.TTL(args[0])
+	if err != nil || ttl == database.NO_EXPIRATION || collection.Persist(args[0]) != nil {
+		session.writer.WriteInteger(0)
+		return
+	}
+	session.writer.WriteInteger(1)
+}
+
+// ttl implements TTL key, returning -2 if the key does not exist and -1 if it
+// has no expiration.
+func (server *RespServer) ttl(session *respSession, args []string) {
+	if len(args) != 1 {
+		wrongArgs(session.writer, "ttl")
+		return
+	}
+	if !server.authorize(session, auth.ACTION_READ, args[0]) {
+		return
+	}
+
+	collection := server.collection(session)
+	if collection == nil {
+		session.writer.WriteInteger(-2)
+		return
+	}
+	ttl, err := collection.TTL(args[0])
+	if err != nil {
+		session.writer.WriteInteger(-2)
+		return
+	}
+	session.writer.WriteInteger(ttlSeconds(ttl))
+}
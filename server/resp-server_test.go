@@ -0,0 +1,253 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type respTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *resp.Reader
+	writer *resp.Writer
+}
+
+func (client *respTestClient) do(args ...string) interface{} {
+	client.writer.WriteStringArray(args)
+	require.NoError(client.t, client.writer.Flush())
+	reply, err := client.reader.ReadReply()
+	require.NoError(client.t, err)
+	return reply
+}
+
+func startTestRespServer(t *testing.T) (*RespServer, *DareServer) {
+	t.Setenv("DARE_RESP_PORT", "0")
+
+	userStore := auth.NewUserStore()
+	userStore.AddUser("admin", "secret")
+	userStore.AddUser("reader", "secret")
+
+	dareServer := NewDareServer(database.NewDatabase(), userStore)
+	server := NewRespServer(dareServer, NewConfiguration(""), logger.NewDareLogger())
+	server.authorizer = auth.NewCasbinAuth("../auth/rbac_model.conf", "../auth/rbac_policy.csv", auth.Users{
+		"admin":  {Roles: []string{auth.DEFAULT_ROLE}},
+		"reader": {Roles: []string{auth.GUEST_ROLE}},
+	})
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+	return server, dareServer
+}
+
+func dialTestRespServer(t *testing.T, server *RespServer) *respTestClient {
+	conn, err := net.DialTimeout("tcp", server.Addr().String(), time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &respTestClient{t: t, conn: conn, reader: resp.NewReader(conn), writer: resp.NewWriter(conn)}
+}
+
+func TestRespServer_Auth(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+
+	assert.Equal(t, "PONG", client.do("PING"))
+	assert.Equal(t, resp.Error("NOAUTH Authentication required."), client.do("GET", "key"))
+	assert.Equal(t, resp.Error("WRONGPASS invalid username-password pair"), client.do("AUTH", "admin", "wrong"))
+	assert.Equal(t, "OK", client.do("AUTH", "secret"))
+	assert.Nil(t, client.do("GET", "key"))
+
+	reader := dialTestRespServer(t, server)
+	assert.Equal(t, "OK", reader.do("AUTH", "reader", "secret"))
+	assert.Equal(t, resp.Error("NOPERM you do not have permission to access this resource"), reader.do("GET", "key"))
+}
+
+func TestRespServer_UnauthenticatedLimits(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	value := strings.Repeat("a", resp.UNAUTHENTICATED_MAX_BULK_LENGTH+1)
+
+	// The commands are limited until the client authenticates
+	client := dialTestRespServer(t, server)
+	reply := client.do("SET", "key", value)
+	require.IsType(t, resp.Error(""), reply)
+	assert.Contains(t, string(reply.(resp.Error)), "protocol error")
+
+	client = dialTestRespServer(t, server)
+	assert.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+	assert.Equal(t, "OK", client.do("SET", "key", value))
+	assert.Equal(t, value, client.do("GET", "key"))
+}
+
+func TestRespServer_Commands(t *testing.T) {
+	server, dareServer := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	assert.Equal(t, "OK", client.do("SET", "key1", "value1"))
+	assert.Equal(t, "OK", client.do("SET", "key2", "value2", "EX", "100"))
+	assert.Equal(t, resp.Error("ERR syntax error"), client.do("SET", "key3", "value3", "NX"))
+	assert.Equal(t, resp.Error("ERR invalid expire time in 'set' command"), client.do("SET", "key3", "value3", "EX", "9300000000"))
+	assert.Equal(t, "value1", client.do("GET", "key1"))
+	assert.Equal(t, "value1", dareServer.collectionManager.GetDefaultCollection().Get("key1"))
+
+	assert.Equal(t, int64(2), client.do("EXISTS", "key1", "key2", "missing"))
+	assert.Equal(t, int64(-1), client.do("TTL", "key1"))
+	assert.Equal(t, int64(100), client.do("TTL", "key2"))
+	assert.Equal(t, int64(1), client.do("PERSIST", "key2"))
+	assert.Equal(t, int64(-1), client.do("TTL", "key2"))
+	assert.Equal(t, int64(-2), client.do("TTL", "missing"))
+	assert.Equal(t, []interface{}{"key1", "key2"}, client.do("KEYS", "key*"))
+
+	assert.Equal(t, int64(1), client.do("DEL", "key1", "missing"))
+	assert.Nil(t, client.do("GET", "key1"))
+
+	assert.Equal(t, resp.Error("ERR unknown command 'foo'"), client.do("FOO"))
+	assert.Equal(t, resp.Error("ERR wrong number of arguments for 'get' command"), client.do("GET"))
+}
+
+func TestRespServer_Counters(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	assert.Equal(t, int64(1), client.do("INCR", "counter"))
+	assert.Equal(t, int64(11), client.do("INCRBY", "counter", "10"))
+	assert.Equal(t, int64(10), client.do("DECR", "counter"))
+	assert.Equal(t, int64(5), client.do("DECRBY", "counter", "5"))
+	assert.Equal(t, "5.5", client.do("INCRBYFLOAT", "counter", "0.5"))
+	assert.Equal(t, resp.Error("ERR value is not an integer or out of range"), client.do("INCR", "counter"))
+
+	assert.Equal(t, "OK", client.do("SET", "text", "abc"))
+	assert.Equal(t, resp.Error("ERR value is not a valid float"), client.do("INCRBYFLOAT", "text", "1"))
+}
+
+func TestRespServer_Lists(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	assert.Equal(t, int64(2), client.do("RPUSH", "queue", "a", "b"))
+	assert.Equal(t, int64(3), client.do("LPUSH", "queue", "z"))
+	assert.Equal(t, "list", client.do("TYPE", "queue"))
+	assert.Equal(t, []interface{}{"z", "a", "b"}, client.do("LRANGE", "queue", "0", "-1"))
+	assert.Equal(t, "z", client.do("LPOP", "queue"))
+	assert.Equal(t, []interface{}{"b"}, client.do("RPOP", "queue", "1"))
+	assert.Equal(t, "OK", client.do("LTRIM", "queue", "0", "0"))
+	assert.Equal(t, int64(1), client.do("LLEN", "queue"))
+	assert.Equal(t, resp.Error(RESP_WRONGTYPE_ERROR), client.do("INCR", "queue"))
+	assert.Equal(t, []interface{}{"queue", "a"}, client.do("BLPOP", "empty", "queue", "1"))
+	assert.Nil(t, client.do("BRPOP", "queue", "0.05"))
+
+	consumer := dialTestRespServer(t, server)
+	require.Equal(t, "OK", consumer.do("AUTH", "admin", "secret"))
+	popped := make(chan interface{})
+	go func() {
+		popped <- consumer.do("BRPOP", "jobs", "0")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(1), client.do("RPUSH", "jobs", "job"))
+	assert.Equal(t, []interface{}{"jobs", "job"}, <-popped)
+}
+
+func TestRespServer_StopReleasesBlockedPop(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	client.writer.WriteStringArray([]string{"BLPOP", "queue", "0"})
+	require.NoError(t, client.writer.Flush())
+	time.Sleep(20 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not release the blocked BLPOP")
+	}
+}
+
+func TestRespServer_Select(t *testing.T) {
+	server, dareServer := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	assert.Equal(t, "OK", client.do("SELECT", "books"))
+	assert.Nil(t, client.do("GET", "key"))
+	assert.Equal(t, "OK", client.do("SET", "key", "value"))
+
+	books, exists := dareServer.collectionManager.GetCollection("books")
+	require.True(t, exists)
+	assert.Equal(t, "value", books.Get("key"))
+
+	assert.Equal(t, "OK", client.do("SELECT", "0"))
+	assert.Nil(t, client.do("GET", "key"))
+}
+
+func TestRespServer_Scan(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	for _, key := range []string{"a1", "a2", "a3", "b1", "b2"} {
+		require.Equal(t, "OK", client.do("SET", key, "value"))
+	}
+
+	var keys []interface{}
+	cursor := "0"
+	for {
+		reply := client.do("SCAN", cursor, "MATCH", "a*", "COUNT", "2").([]interface{})
+		cursor = reply[0].(string)
+		keys = append(keys, reply[1].([]interface{})...)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.ElementsMatch(t, []interface{}{"a1", "a2", "a3"}, keys)
+}
+
+func TestRespServer_HelloAndPipeline(t *testing.T) {
+	server, _ := startTestRespServer(t)
+	client := dialTestRespServer(t, server)
+
+	assert.Equal(t, resp.Error("NOAUTH HELLO must be called with the client credentials"), client.do("HELLO", "3"))
+
+	hello := client.do("HELLO", "3", "AUTH", "admin", "secret")
+	assert.Equal(t, map[string]interface{}{"server": "dare-db", "proto": int64(3), "mode": "standalone"}, hello)
+	assert.Nil(t, client.do("GET", "missing"))
+
+	_, err := client.conn.Write([]byte("SET pipelined value\r\nGET pipelined\r\n"))
+	require.NoError(t, err)
+	reply, err := client.reader.ReadReply()
+	require.NoError(t, err)
+	assert.Equal(t, "OK", reply)
+	reply, err = client.reader.ReadReply()
+	require.NoError(t, err)
+	assert.Equal(t, "value", reply)
+}
+
+func TestFactory_GetRespServer(t *testing.T) {
+	dareServer := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	t.Setenv("DARE_RESP_ENABLED", "false")
+	assert.Nil(t, NewFactory(NewConfiguration(""), logger.NewDareLogger()).GetRespServer(dareServer))
+
+	t.Setenv("DARE_RESP_ENABLED", "true")
+	factory := NewFactory(NewConfiguration(""), logger.NewDareLogger())
+	assert.NotNil(t, factory.GetRespServer(dareServer))
+	assert.Nil(t, factory.GetRespServer(&MockDareServer{}))
+
+	server, isHttpServer := factory.GetWebServer(dareServer).(*HttpServer)
+	require.True(t, isHttpServer)
+	assert.NotNil(t, server.respServer)
+}
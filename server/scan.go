@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const CURSOR_PARAM = "cursor"
+
+func (srv *DareServer) HandlerScan(w http.ResponseWriter, r *http.Request) {
+	srv.scan(w, r, srv.collectionManager.GetDefaultCollection())
+}
+
+func (srv *DareServer) HandlerCollectionScan(w http.ResponseWriter, r *http.Request) {
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+	srv.scan(w, r, collection)
+}
+
+// scan returns a batch of items from the cursor query parameter, "0" to
+// start, along with the cursor of the next batch, "0" once the scan is
+// complete. count is a hint of the batch size, match a glob pattern and
+// prefix a prefix the keys must satisfy.
+func (srv *DareServer) scan(w http.ResponseWriter, r *http.Request, collection *database.Database) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var cursor uint64
+	if value := query.Get(CURSOR_PARAM); value != "" {
+		var err error
+		cursor, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, `query param "cursor" is not a valid cursor`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	items, next := collection.Scan(cursor, database.ScanOptions{
+		Count:  parseQueryParam(r, "count", database.DEFAULT_SCAN_COUNT),
+		Match:  query.Get("match"),
+		Prefix: query.Get("prefix"),
+	})
+	if srv.shards != nil {
+		srv.shards.filterItems(items)
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"cursor": strconv.FormatUint(next, 10),
+		"items":  items,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
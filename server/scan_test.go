@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCollectionScan(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.AddCollection("books")
+	books, _ := srv.collectionManager.GetCollection("books")
+	for i := 0; i < 50; i++ {
+		books.Set(fmt.Sprintf("book:%d", i), fmt.Sprintf("title%d", i))
+		books.Set(fmt.Sprintf("author:%d", i), "name")
+	}
+
+	items := make(map[string]string)
+	cursor := "0"
+	for calls := 0; calls < 100; calls++ {
+		request, _ := http.NewRequest("GET", "/collections/books/scan?count=5&prefix=book:&cursor="+cursor, nil)
+		request.SetPathValue(COLLECTION_NAME_PARAM, "books")
+		response := httptest.NewRecorder()
+		srv.HandlerCollectionScan(response, request)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var result struct {
+			Cursor string            `json:"cursor"`
+			Items  map[string]string `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &result))
+		for key, value := range result.Items {
+			items[key] = value
+		}
+		cursor = result.Cursor
+		if cursor == "0" {
+			break
+		}
+	}
+
+	assert.Equal(t, "0", cursor)
+	assert.Len(t, items, 50)
+	assert.Equal(t, "title7", items["book:7"])
+}
+
+func TestHandlerScan_Errors(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	request, _ := http.NewRequest("GET", "/scan?cursor=abc", nil)
+	response := httptest.NewRecorder()
+	srv.HandlerScan(response, request)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	request, _ = http.NewRequest("POST", "/scan", nil)
+	response = httptest.NewRecorder()
+	srv.HandlerScan(response, request)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+
+	request, _ = http.NewRequest("GET", "/collections/missing/scan", nil)
+	request.SetPathValue(COLLECTION_NAME_PARAM, "missing")
+	response = httptest.NewRecorder()
+	srv.HandlerCollectionScan(response, request)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
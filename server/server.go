@@ -20,6 +20,7 @@ type Server interface {
 
 type HttpServer struct {
 	dareServer    IDare
+	respServer    *RespServer
 	httpServer    *http.Server
 	configuration Config
 	sigChan       chan os.Signal
@@ -53,14 +54,28 @@ func (server *HttpServer) Start() {
 		server.logger.Info("Stopped serving new connections.")
 	}()
 
+	if server.respServer != nil {
+		if err := server.respServer.Start(); err != nil {
+			server.logger.Fatal("RESP server error: ", err)
+		}
+	}
+
 	signal.Notify(server.sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-server.sigChan
 }
 
 func (server *HttpServer) Stop() {
+	if server.respServer != nil {
+		server.respServer.Stop()
+	}
+
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownRelease()
 
+	// Shutdown waits for the handlers to return, end the pub/sub streams first
+	if dare, ok := server.dareServer.(*DareServer); ok {
+		dare.CloseSubscriptions()
+	}
 	if err := server.httpServer.Shutdown(shutdownCtx); err != nil {
 		server.logger.Fatal("HTTP shutdown error:", err)
 	}
@@ -73,6 +88,7 @@ func (server *HttpServer) Stop() {
 
 type HttpsServer struct {
 	dareServer    IDare
+	respServer    *RespServer
 	httpsServer   *http.Server
 	configuration Config
 	sigChan       chan os.Signal
@@ -105,14 +121,28 @@ func (server *HttpsServer) Start() {
 		server.logger.Close()
 	}()
 
+	if server.respServer != nil {
+		if err := server.respServer.Start(); err != nil {
+			server.logger.Fatal("RESP server error: ", err)
+		}
+	}
+
 	signal.Notify(server.sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-server.sigChan
 }
 
 func (server *HttpsServer) Stop() {
+	if server.respServer != nil {
+		server.respServer.Stop()
+	}
+
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownRelease()
 
+	// Shutdown waits for the handlers to return, end the pub/sub streams first
+	if dare, ok := server.dareServer.(*DareServer); ok {
+		dare.CloseSubscriptions()
+	}
 	if err := server.httpsServer.Shutdown(shutdownCtx); err != nil {
 		server.logger.Fatal("HTTP shutdown error:", err)
 	}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const SET_COMMAND_PARAM = "command"
+const MEMBER_PARAM = "member"
+
+func (srv *DareServer) HandlerCollectionSetMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	members, err := collection.SMembers(key)
+	if err != nil {
+		writeSetError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string][]string{"members": members})
+}
+
+func (srv *DareServer) HandlerCollectionSetContains(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	isMember, err := collection.SIsMember(key, r.URL.Query().Get(MEMBER_PARAM))
+	if err != nil {
+		writeSetError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string]bool{"member": isMember})
+}
+
+// HandlerCollectionSetCombine combines the sets named by the key query
+// parameters with the operation given by op: inter, union or diff.
+func (srv *DareServer) HandlerCollectionSetCombine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		http.Error(w, `At least one "key" query param is required`, http.StatusBadRequest)
+		return
+	}
+
+	var members []string
+	var err error
+	switch op := r.URL.Query().Get("op"); op {
+	case "inter":
+		members, err = collection.SInter(keys...)
+	case "union":
+		members, err = collection.SUnion(keys...)
+	case "diff":
+		members, err = collection.SDiff(keys...)
+	default:
+		http.Error(w, `query param "op" must be "inter", "union" or "diff"`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeSetError(w, strings.Join(keys, ", "), err)
+		return
+	}
+	writeJSON(w, map[string][]string{"members": members})
+}
+
+// HandlerCollectionSetCommand executes the set command named in the path,
+// add or remove, with a JSON array of members as body.
+func (srv *DareServer) HandlerCollectionSetCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	command := r.PathValue(SET_COMMAND_PARAM)
+	if command != "add" && command != "remove" {
+		http.Error(w, fmt.Sprintf(`Unknown set command "%s"`, command), http.StatusNotFound)
+		return
+	}
+	var members []string
+	if err := json.NewDecoder(r.Body).Decode(&members); err != nil || len(members) == 0 {
+		http.Error(w, `Invalid JSON format, the body must be a non empty array of strings such as ["member"]`, http.StatusBadRequest)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	collection := srv.collectionForWrite(r)
+	if command == "add" {
+		added, err := collection.SAdd(key, members...)
+		if err != nil {
+			writeSetError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string]int{"added": added})
+		return
+	}
+
+	removed, err := collection.SRem(key, members...)
+	if err != nil {
+		writeSetError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string]int{"removed": removed})
+}
+
+// HandlerCollectionSortedSetRange returns the members of the sorted set with
+// their scores, by score when the min or max query parameters are given,
+// "-inf", "+inf" or a number prefixed with "(" to exclude it, with offset and
+// limit, and by rank from start to stop otherwise. The order is asc or desc.
+func (srv *DareServer) HandlerCollectionSortedSetRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	reverse, err := parseOrder(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	var members []database.ScoredMember
+	if query.Has("min") || query.Has("max") {
+		bounds := make([]database.ScoreBound, 2)
+		for i, name := range []string{"min", "max"} {
+			queryValue := query.Get(name)
+			if queryValue == "" {
+				queryValue = []string{"-inf", "+inf"}[i]
+			}
+			if bounds[i], err = database.ParseScoreBound(queryValue); err != nil {
+				http.Error(w, fmt.Sprintf(`query param "%s": %s`, name, err.Error()), http.StatusBadRequest)
+				return
+			}
+		}
+		members, err = collection.ZRangeByScore(key, bounds[0], bounds[1], reverse, parseQueryParam(r, "offset", 0), parseQueryParam(r, "limit", 0))
+	} else {
+		start, stop, rangeErr := parseListRange(r)
+		if rangeErr != nil {
+			http.Error(w, rangeErr.Error(), http.StatusBadRequest)
+			return
+		}
+		members, err = collection.ZRange(key, start, stop, reverse)
+	}
+	if err != nil {
+		writeSetError(w, key, err)
+		return
+	}
+	writeJSON(w, map[string][]database.ScoredMember{"members": members})
+}
+
+// HandlerCollectionSortedSetRank returns the zero based rank of the member
+// query parameter, from the lowest score or from the highest when the order
+// is desc.
+func (srv *DareServer) HandlerCollectionSortedSetRank(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	member := r.URL.Query().Get(MEMBER_PARAM)
+	reverse, err := parseOrder(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rank int
+	var found bool
+	if reverse {
+		rank, found, err = collection.ZRevRank(key, member)
+	} else {
+		rank, found, err = collection.ZRank(key, member)
+	}
+	if err != nil {
+		writeSetError(w, key, err)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf(`Member "%v" not found in key "%v"`, member, key), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]int{"rank": rank})
+}
+
+func (srv *DareServer) HandlerCollectionSortedSetScore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	collection, ok := srv.getCollectionOrNotFound(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	member := r.URL.Query().Get(MEMBER_PARAM)
+	score, found, err := collection.ZScore(key, member)
+	if err != nil {
+		writeSetError(w, key, err)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf(`Member "%v" not found in key "%v"`, member, key), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]float64{"score": score})
+}
+
+// HandlerCollectionSortedSetCommand executes the sorted set command named in
+// the path: add takes a JSON array of {"member","score"} objects as body,
+// remove a JSON array of members and incr the member and by query
+// parameters.
+func (srv *DareServer) HandlerCollectionSortedSetCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue(KEY_PARAM)
+	switch command := r.PathValue(SET_COMMAND_PARAM); command {
+	case "add":
+		var members []database.ScoredMember
+		if err := json.NewDecoder(r.Body).Decode(&members); err != nil || len(members) == 0 {
+			http.Error(w, `Invalid JSON format, the body must be a non empty array such as [{"member":"alice","score":10}]`, http.StatusBadRequest)
+			return
+		}
+		added, err := srv.collectionForWrite(r).ZAdd(key, members...)
+		if err != nil {
+			writeSetError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string]int{"added": added})
+
+	case "remove":
+		var members []string
+		if err := json.NewDecoder(r.Body).Decode(&members); err != nil || len(members) == 0 {
+			http.Error(w, `Invalid JSON format, the body must be a non empty array of strings such as ["member"]`, http.StatusBadRequest)
+			return
+		}
+		removed, err := srv.collectionForWrite(r).ZRem(key, members...)
+		if err != nil {
+			writeSetError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string]int{"removed": removed})
+
+	case "incr":
+		query := r.URL.Query()
+		if !query.Has(MEMBER_PARAM) {
+			http.Error(w, `query param "member" is required`, http.StatusBadRequest)
+			return
+		}
+		delta := 1.0
+		if queryValue := query.Get(BY_PARAM); queryValue != "" {
+			var err error
+			if delta, err = strconv.ParseFloat(queryValue, 64); err != nil {
+				http.Error(w, fmt.Sprintf(`query param "%s" must be a number`, BY_PARAM), http.StatusBadRequest)
+				return
+			}
+		}
+		score, err := srv.collectionForWrite(r).ZIncrBy(key, query.Get(MEMBER_PARAM), delta)
+		if err != nil {
+			writeSetError(w, key, err)
+			return
+		}
+		writeJSON(w, map[string]float64{"score": score})
+
+	default:
+		http.Error(w, fmt.Sprintf(`Unknown sorted set command "%s"`, command), http.StatusNotFound)
+	}
+}
+
+// parseOrder reports whether the order query parameter is desc, it must be asc or desc.
+func parseOrder(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("order") {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, errors.New(`query param "order" must be "asc" or "desc"`)
+}
+
+func writeSetError(w http.ResponseWriter, key string, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotNumber), errors.Is(err, database.ErrInvalidScoreBound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeListError(w, key, err)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setRequest(method string, url string, key string, command string, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	request.SetPathValue(COLLECTION_NAME_PARAM, "social")
+	request.SetPathValue(KEY_PARAM, key)
+	request.SetPathValue(SET_COMMAND_PARAM, command)
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func TestHandlerCollectionSetMembers(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := setRequest("GET", "/collections/social/sets/tags:1", "tags:1", "", "", srv.HandlerCollectionSetMembers)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = setRequest("POST", "/collections/social/sets/tags:1/add", "tags:1", "add", `["go","db","go"]`, srv.HandlerCollectionSetCommand)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"added":2}`, response.Body.String())
+	setRequest("POST", "/collections/social/sets/tags:2/add", "tags:2", "add", `["go","cache"]`, srv.HandlerCollectionSetCommand)
+
+	response = setRequest("GET", "/collections/social/sets/tags:1", "tags:1", "", "", srv.HandlerCollectionSetMembers)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"members":["db","go"]}`, response.Body.String())
+
+	response = setRequest("GET", "/collections/social/sets/tags:1/contains?member=go", "tags:1", "", "", srv.HandlerCollectionSetContains)
+	assert.JSONEq(t, `{"member":true}`, response.Body.String())
+
+	response = setRequest("GET", "/collections/social/sets?op=inter&key=tags:1&key=tags:2", "", "", "", srv.HandlerCollectionSetCombine)
+	assert.JSONEq(t, `{"members":["go"]}`, response.Body.String())
+	response = setRequest("GET", "/collections/social/sets?op=union&key=tags:1&key=tags:2", "", "", "", srv.HandlerCollectionSetCombine)
+	assert.JSONEq(t, `{"members":["cache","db","go"]}`, response.Body.String())
+	response = setRequest("GET", "/collections/social/sets?op=diff&key=tags:1&key=tags:2", "", "", "", srv.HandlerCollectionSetCombine)
+	assert.JSONEq(t, `{"members":["db"]}`, response.Body.String())
+	response = setRequest("GET", "/collections/social/sets?op=xor&key=tags:1", "", "", "", srv.HandlerCollectionSetCombine)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = setRequest("POST", "/collections/social/sets/tags:1/remove", "tags:1", "remove", `["db","missing"]`, srv.HandlerCollectionSetCommand)
+	assert.JSONEq(t, `{"removed":1}`, response.Body.String())
+	response = setRequest("POST", "/collections/social/sets/tags:1/add", "tags:1", "add", `"go"`, srv.HandlerCollectionSetCommand)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = setRequest("POST", "/collections/social/sets/tags:1/pop", "tags:1", "pop", `["go"]`, srv.HandlerCollectionSetCommand)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	social, _ := srv.collectionManager.GetCollection("social")
+	require.NoError(t, social.Set("name", "string"))
+	response = setRequest("POST", "/collections/social/sets/name/add", "name", "add", `["go"]`, srv.HandlerCollectionSetCommand)
+	assert.Equal(t, http.StatusConflict, response.Code)
+}
+
+func TestHandlerCollectionSortedSet(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := setRequest("POST", "/collections/social/zsets/board/add", "board", "add", `[{"member":"alice","score":30},{"member":"bob","score":10},{"member":"carol","score":20}]`, srv.HandlerCollectionSortedSetCommand)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"added":3}`, response.Body.String())
+
+	response = setRequest("POST", "/collections/social/zsets/board/incr?member=bob&by=25", "board", "incr", "", srv.HandlerCollectionSortedSetCommand)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"score":35}`, response.Body.String())
+
+	response = setRequest("GET", "/collections/social/zsets/board?order=desc&stop=1", "board", "", "", srv.HandlerCollectionSortedSetRange)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"members":[{"member":"bob","score":35},{"member":"alice","score":30}]}`, response.Body.String())
+
+	response = setRequest("GET", "/collections/social/zsets/board?min=(20&max=40&limit=1", "board", "", "", srv.HandlerCollectionSortedSetRange)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"members":[{"member":"alice","score":30}]}`, response.Body.String())
+	response = setRequest("GET", "/collections/social/zsets/board?min=abc", "board", "", "", srv.HandlerCollectionSortedSetRange)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = setRequest("GET", "/collections/social/zsets/board/rank?member=bob&order=desc", "board", "", "", srv.HandlerCollectionSortedSetRank)
+	assert.JSONEq(t, `{"rank":0}`, response.Body.String())
+	response = setRequest("GET", "/collections/social/zsets/board/rank?member=dave", "board", "", "", srv.HandlerCollectionSortedSetRank)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = setRequest("GET", "/collections/social/zsets/board/score?member=carol", "board", "", "", srv.HandlerCollectionSortedSetScore)
+	assert.JSONEq(t, `{"score":20}`, response.Body.String())
+
+	response = setRequest("POST", "/collections/social/zsets/board/remove", "board", "remove", `["carol"]`, srv.HandlerCollectionSortedSetCommand)
+	assert.JSONEq(t, `{"removed":1}`, response.Body.String())
+	response = setRequest("POST", "/collections/social/zsets/board/incr?member=bob&by=x", "board", "incr", "", srv.HandlerCollectionSortedSetCommand)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = setRequest("POST", "/collections/social/zsets/board/add", "board", "add", `[]`, srv.HandlerCollectionSortedSetCommand)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
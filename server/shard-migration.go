@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+// SHARD_MIGRATION_BATCH is the number of slots moved at once by a migration:
+// the writes on the slots of a batch wait until the batch is moved.
+const SHARD_MIGRATION_BATCH = 1024
+
+// SHARD_MIGRATION_TIMEOUT bounds the wait of a migration for the requests in
+// progress on the slots it moves.
+const SHARD_MIGRATION_TIMEOUT = 30 * time.Second
+
+// SHARD_COMMIT_ATTEMPTS is how many times a migration sends the new owner of
+// the slots their assignment before giving up.
+const SHARD_COMMIT_ATTEMPTS = 3
+
+var ErrSlotsNotOwned = errors.New("the slots are not owned by this node")
+
+// shardMigrateRequest is the body of POST /admin/shards/migrate.
+type shardMigrateRequest struct {
+	Slots string `json:"slots"`
+	Node  string `json:"node"`
+}
+
+// shardImportRequest is the body of POST /shard/import, sent by a node
+// migrating the slots and their keys.
+type shardImportRequest struct {
+	Slots    []int              `json:"slots"`
+	Snapshot *database.Snapshot `json:"snapshot"`
+}
+
+// parseSlots parses a list of slots and ranges of slots such as "0-999,1200".
+func parseSlots(value string) ([]int, error) {
+	var slots []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+				return nil, fmt.Errorf("invalid slot range %q", part)
+			}
+		}
+		if start < 0 || end >= database.HASH_SLOTS || start > end {
+			return nil, fmt.Errorf("invalid slot range %q, slots go from 0 to %d", part, database.HASH_SLOTS-1)
+		}
+		for slot := start; slot <= end; slot++ {
+			if !seen[slot] {
+				seen[slot] = true
+				slots = append(slots, slot)
+			}
+		}
+	}
+	if len(slots) == 0 {
+		return nil, errors.New("no slot to migrate")
+	}
+	return slots, nil
+}
+
+func inSlots(slots []int) func(int) bool {
+	set := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		set[slot] = true
+	}
+	return func(slot int) bool {
+		return set[slot]
+	}
+}
+
+// waitIdleLocked waits until counts holds no request for the slots, or until
+// the timeout expires. The caller must hold s.mu.
+func (s *Sharding) waitIdleLocked(slots []int, counts map[int]int, timeout time.Duration) error {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		expired = true
+		s.idle.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	s.waiting++
+	defer func() { s.waiting-- }()
+	for {
+		busy := false
+		for _, slot := range slots {
+			if counts[slot] > 0 {
+				busy = true
+				break
+			}
+		}
+		if !busy {
+			return nil
+		}
+		if expired {
+			return fmt.Errorf("requests on the slots still in progress after %v", timeout)
+		}
+		s.idle.Wait()
+	}
+}
+
+// endMigrationLocked releases the writes waiting for the migration of the
+// slots. The caller must hold s.mu.
+func (s *Sharding) endMigrationLocked(slots []int) {
+	for _, slot := range slots {
+		if done, migrating := s.migrating[slot]; migrating {
+			delete(s.migrating, slot)
+			select {
+			case <-done:
+			default:
+				close(done)
+			}
+		}
+	}
+}
+
+// migrateSlots moves the slots, owned by this node, and their keys to the
+// node target, by batches of SHARD_MIGRATION_BATCH slots. The other nodes
+// are told of the new owner of the slots at the end.
+func (srv *DareServer) migrateSlots(slots []int, target string) (int, error) {
+	s := srv.shards
+	s.migration.Lock()
+	defer s.migration.Unlock()
+
+	migrated := 0
+	var err error
+	for start := 0; start < len(slots) && err == nil; start += SHARD_MIGRATION_BATCH {
+		batch := slots[start:min(start+SHARD_MIGRATION_BATCH, len(slots))]
+		if err = srv.migrateBatch(batch, target); err == nil {
+			migrated += len(batch)
+		}
+	}
+
+	if migrated > 0 {
+		ranges := s.table.ranges(slots[:migrated]...)
+		for _, node := range s.order {
+			if node == s.id || node == target {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), SHARD_REQUEST_TIMEOUT)
+			if publishErr := s.exchange(ctx, node, http.MethodPost, "/shard/slots", ranges, nil); publishErr != nil {
+				// The node learns the new owner on its next request to this node
+				s.logger.Info("Could not publish the migrated slots to node ", node, ": ", publishErr)
+			}
+			cancel()
+		}
+	}
+	return migrated, err
+}
+
+// migrateBatch moves the slots to target: the writes on the slots are held
+// while their keys are copied to target, which is then given the slots. The
+// keys are deleted from this node once the requests in progress end.
+func (srv *DareServer) migrateBatch(slots []int, target string) error {
+	s := srv.shards
+
+	s.mu.Lock()
+	for _, slot := range slots {
+		if owner, _ := s.table.owner(slot); owner != s.id {
+			s.mu.Unlock()
+			return fmt.Errorf("slot %d: %w", slot, ErrSlotsNotOwned)
+		}
+	}
+	done := make(chan struct{})
+	for _, slot := range slots {
+		s.migrating[slot] = done
+	}
+	err := s.waitIdleLocked(slots, s.writes, SHARD_MIGRATION_TIMEOUT)
+	s.mu.Unlock()
+
+	abort := func(err error) error {
+		s.mu.Lock()
+		s.endMigrationLocked(slots)
+		s.mu.Unlock()
+		return err
+	}
+	if err != nil {
+		return abort(err)
+	}
+
+	selected := inSlots(slots)
+	ctx, cancel := context.WithTimeout(context.Background(), SHARD_MIGRATION_TIMEOUT)
+	defer cancel()
+	request := shardImportRequest{Slots: slots, Snapshot: srv.collectionManager.ExportSlots(selected)}
+	if err := s.exchange(ctx, target, http.MethodPost, "/shard/import", request, nil); err != nil {
+		return abort(fmt.Errorf("import of the keys failed: %w", err))
+	}
+
+	ranges := s.table.reassigned(slots, target)
+	for attempt := 1; ; attempt++ {
+		err = s.exchange(ctx, target, http.MethodPost, "/shard/slots", ranges, nil)
+		if err == nil {
+			break
+		}
+		if attempt == SHARD_COMMIT_ATTEMPTS {
+			return abort(fmt.Errorf("assignment of the slots failed: %w", err))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := s.table.update(ranges); err != nil {
+		s.logger.Error("Failed to save the slots: ", err)
+	}
+
+	s.mu.Lock()
+	s.endMigrationLocked(slots)
+	// The reads started before the table changed still use the keys
+	err = s.waitIdleLocked(slots, s.requests, SHARD_MIGRATION_TIMEOUT)
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Deleting the keys of the migrated slots despite the requests in progress: ", err)
+	}
+	srv.collectionManager.DeleteSlots(selected)
+	return nil
+}
+
+// HandlerShardMigrate moves slots owned by this node, with their keys, to
+// another node of the sharded cluster.
+func (srv *DareServer) HandlerShardMigrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.shardingEnabled(w) {
+		return
+	}
+
+	var request shardMigrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if _, exists := srv.shards.nodes[request.Node]; !exists {
+		http.Error(w, fmt.Sprintf(`Node "%s" is not a member of the cluster`, request.Node), http.StatusBadRequest)
+		return
+	}
+	if request.Node == srv.shards.id {
+		http.Error(w, "The slots cannot be migrated to the node owning them", http.StatusBadRequest)
+		return
+	}
+	slots, err := parseSlots(request.Slots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, slot := range slots {
+		if owner, _ := srv.shards.table.owner(slot); owner != srv.shards.id {
+			http.Error(w, fmt.Sprintf("Slot %d is owned by node %s, migrate it from there", slot, owner), http.StatusConflict)
+			return
+		}
+	}
+
+	migrated, err := srv.migrateSlots(slots, request.Node)
+	if errors.Is(err, ErrSlotsNotOwned) {
+		http.Error(w, fmt.Sprintf("Migrated %d slots: %v", migrated, err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Migrated %d slots: %v", migrated, err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"migrated": migrated, "node": request.Node})
+}
+
+// HandlerShardImport stores the keys of slots migrated to this node by
+// another node. The keys left by an earlier, interrupted, migration of the
+// slots are deleted first.
+func (srv *DareServer) HandlerShardImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.shardingEnabled(w) {
+		return
+	}
+
+	var request shardImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Snapshot == nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	for _, slot := range request.Slots {
+		if slot < 0 || slot >= database.HASH_SLOTS {
+			http.Error(w, fmt.Sprintf("Invalid slot %d", slot), http.StatusBadRequest)
+			return
+		}
+		if owner, _ := srv.shards.table.owner(slot); owner == srv.shards.id {
+			http.Error(w, fmt.Sprintf("Slot %d is already owned by node %s", slot, owner), http.StatusConflict)
+			return
+		}
+	}
+	selected := inSlots(request.Slots)
+	for _, entries := range request.Snapshot.Collections {
+		for _, entry := range entries {
+			if !selected(database.KeySlot(entry.Key)) {
+				http.Error(w, fmt.Sprintf(`Key "%s" does not belong to the migrated slots`, entry.Key), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	srv.collectionManager.DeleteSlots(selected)
+	if err := srv.collectionManager.Import(request.Snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"slots": len(request.Slots)})
+}
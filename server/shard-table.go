@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+// SHARD_TABLE_FILE is the file of settings.data_dir holding the slots owned
+// by every node of a sharded cluster.
+const SHARD_TABLE_FILE = "shards.json"
+
+// shardRange assigns the hash slots from Start to End, inclusive, to the
+// node Owner. The epoch of a slot is increased whenever it changes of owner:
+// the nodes keep the assignment with the highest epoch.
+type shardRange struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Owner string `json:"owner"`
+	Epoch uint64 `json:"epoch"`
+}
+
+type shardSlot struct {
+	owner string
+	epoch uint64
+}
+
+// shardTable assigns every hash slot to a node of a sharded cluster. The
+// table is saved into path, unless empty, whenever it changes.
+type shardTable struct {
+	mu    sync.RWMutex
+	slots []shardSlot
+	path  string
+}
+
+// newShardTable shares the slots evenly between the nodes, in order, at epoch zero.
+func newShardTable(nodes []string, path string) *shardTable {
+	table := &shardTable{slots: make([]shardSlot, database.HASH_SLOTS), path: path}
+	for slot := range table.slots {
+		table.slots[slot].owner = nodes[slot*len(nodes)/database.HASH_SLOTS]
+	}
+	return table
+}
+
+// loadShardTable reads the table saved into path. It returns nil if there
+// is no saved table.
+func loadShardTable(path string) (*shardTable, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []shardRange
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, fmt.Errorf("invalid shard table %s: %w", path, err)
+	}
+	if err := validateShardRanges(ranges); err != nil {
+		return nil, fmt.Errorf("invalid shard table %s: %w", path, err)
+	}
+	table := &shardTable{slots: make([]shardSlot, database.HASH_SLOTS), path: path}
+	for _, r := range ranges {
+		for slot := r.Start; slot <= r.End; slot++ {
+			table.slots[slot] = shardSlot{owner: r.Owner, epoch: r.Epoch}
+		}
+	}
+	for slot, assignment := range table.slots {
+		if assignment.owner == "" {
+			return nil, fmt.Errorf("invalid shard table %s: slot %d has no owner", path, slot)
+		}
+	}
+	return table, nil
+}
+
+// owner returns the node owning the slot and the epoch of the assignment.
+func (t *shardTable) owner(slot int) (string, uint64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slots[slot].owner, t.slots[slot].epoch
+}
+
+// ranges returns the assignments of the table, merging the consecutive slots
+// with the same owner and epoch. With slots, only the ranges of these slots
+// are returned.
+func (t *shardTable) ranges(slots ...int) []shardRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.rangesLocked(slots...)
+}
+
+// rangesLocked returns the assignments like ranges. The caller must hold t.mu.
+func (t *shardTable) rangesLocked(slots ...int) []shardRange {
+	var ranges []shardRange
+	add := func(slot int) {
+		assignment := t.slots[slot]
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End == slot-1 && ranges[last].Owner == assignment.owner && ranges[last].Epoch == assignment.epoch {
+			ranges[last].End = slot
+			return
+		}
+		ranges = append(ranges, shardRange{Start: slot, End: slot, Owner: assignment.owner, Epoch: assignment.epoch})
+	}
+	if len(slots) > 0 {
+		for _, slot := range slots {
+			add(slot)
+		}
+		return ranges
+	}
+	for slot := range t.slots {
+		add(slot)
+	}
+	return ranges
+}
+
+// update applies the assignments of ranges with an epoch higher than the
+// one of their slots, and saves the table if it changed. It returns whether
+// the table changed.
+func (t *shardTable) update(ranges []shardRange) (bool, error) {
+	if err := validateShardRanges(ranges); err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := false
+	for _, r := range ranges {
+		for slot := r.Start; slot <= r.End; slot++ {
+			if r.Epoch > t.slots[slot].epoch {
+				t.slots[slot] = shardSlot{owner: r.Owner, epoch: r.Epoch}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, t.saveLocked()
+}
+
+// reassigned returns the ranges giving the slots to owner, at the epoch
+// following their current one. The table is not changed.
+func (t *shardTable) reassigned(slots []int, owner string) []shardRange {
+	ranges := t.ranges(slots...)
+	for i := range ranges {
+		ranges[i].Owner = owner
+		ranges[i].Epoch++
+	}
+	return ranges
+}
+
+func validateShardRanges(ranges []shardRange) error {
+	for _, r := range ranges {
+		if r.Start < 0 || r.End >= database.HASH_SLOTS || r.Start > r.End || r.Owner == "" {
+			return fmt.Errorf("invalid slot range %d-%d", r.Start, r.End)
+		}
+	}
+	return nil
+}
+
+// save writes the table into its file.
+func (t *shardTable) save() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.saveLocked()
+}
+
+// saveLocked writes the table atomically into its file: the data is written
+// to a temporary file, synced and then renamed into place. The caller must
+// hold t.mu.
+func (t *shardTable) saveLocked() error {
+	if t.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(t.rangesLocked())
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(t.path), SHARD_TABLE_FILE+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), t.path)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardTable_UpdateAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SHARD_TABLE_FILE)
+	table := newShardTable([]string{"n1", "n2"}, path)
+	assert.Equal(t, []shardRange{
+		{Start: 0, End: database.HASH_SLOTS/2 - 1, Owner: "n1"},
+		{Start: database.HASH_SLOTS / 2, End: database.HASH_SLOTS - 1, Owner: "n2"},
+	}, table.ranges())
+
+	ranges := table.reassigned([]int{10, 11, 12}, "n2")
+	assert.Equal(t, []shardRange{{Start: 10, End: 12, Owner: "n2", Epoch: 1}}, ranges)
+	owner, _ := table.owner(10)
+	assert.Equal(t, "n1", owner, "Expected reassigned to leave the table unchanged")
+
+	changed, err := table.update(ranges)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	owner, epoch := table.owner(11)
+	assert.Equal(t, "n2", owner)
+	assert.Equal(t, uint64(1), epoch)
+
+	// Older assignments are ignored
+	changed, err = table.update([]shardRange{{Start: 11, End: 11, Owner: "n1", Epoch: 1}})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, err = table.update([]shardRange{{Start: 5, End: database.HASH_SLOTS, Owner: "n1", Epoch: 2}})
+	assert.Error(t, err)
+
+	loaded, err := loadShardTable(path)
+	require.NoError(t, err)
+	assert.Equal(t, table.ranges(), loaded.ranges())
+	assert.Equal(t, []shardRange{{Start: 11, End: 12, Owner: "n2", Epoch: 1}, {Start: 13, End: 13, Owner: "n1"}}, loaded.ranges(11, 12, 13))
+
+	missing, err := loadShardTable(filepath.Join(t.TempDir(), SHARD_TABLE_FILE))
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
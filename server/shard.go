@@ -0,0 +1,862 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/raft"
+)
+
+// SHARD_FORWARDED_HEADER carries the id of the node which forwarded a request
+// to another node of a sharded cluster.
+const SHARD_FORWARDED_HEADER = "X-Dare-Forwarded-By"
+
+// SHARD_REQUEST_TIMEOUT bounds the requests a node of a sharded cluster
+// sends on its own to the other nodes. Requests forwarded for a client last
+// as long as the request of the client.
+const SHARD_REQUEST_TIMEOUT = 10 * time.Second
+
+// SHARD_MAX_REDIRECTS is how many times a request is forwarded again when
+// the node it was forwarded to does not own its keys anymore.
+const SHARD_MAX_REDIRECTS = 3
+
+// SHARD_CURSOR_SHIFT splits the scan cursors of a sharded cluster: the bits
+// above it hold the index of the node being scanned, the bits below the
+// cursor of the scan of this node.
+const SHARD_CURSOR_SHIFT = 48
+
+var ErrCrossNodeKeys = errors.New("the keys are held by different nodes of the cluster, give them a common hash tag such as {user1}.name and {user1}.email")
+
+// Sharding partitions the keys of the collections into hash slots, each
+// owned by a node of a sharded cluster. It tracks the requests served on the
+// slots of the node, so that a slot migrates once its writes are done.
+type Sharding struct {
+	id     string
+	nodes  map[string]string
+	order  []string
+	table  *shardTable
+	tokens *nodeTokens
+	logger logger.Logger
+
+	mu sync.Mutex
+	// idle is signaled when requests end while a migration waits for them
+	idle    *sync.Cond
+	waiting int
+	// requests counts the requests in progress on each slot, and writes the writes among them
+	requests map[int]int
+	writes   map[int]int
+	// migrating holds, by slot, a channel closed once the migration of the slot ends
+	migrating map[int]chan struct{}
+
+	// migration serializes the migrations of the slots of the node
+	migration sync.Mutex
+}
+
+// NewSharding makes the node id a member of the sharded cluster of nodes,
+// authenticating to the other nodes as username. The slots are read from
+// tablePath, unless empty. A node without a saved table asks the other nodes
+// for theirs, and shares the slots evenly between the nodes if none answers,
+// as when the cluster starts.
+func NewSharding(id string, nodes []raft.Server, tablePath string, username string, password string) (*Sharding, error) {
+	s := &Sharding{
+		id:        id,
+		nodes:     make(map[string]string, len(nodes)),
+		tokens:    newNodeTokens(username, password, &http.Client{}),
+		logger:    logger.NewDareLogger(),
+		requests:  make(map[int]int),
+		writes:    make(map[int]int),
+		migrating: make(map[int]chan struct{}),
+	}
+	s.idle = sync.NewCond(&s.mu)
+	for _, node := range nodes {
+		if _, exists := s.nodes[node.ID]; exists {
+			return nil, fmt.Errorf("node %s is listed twice in sharding.nodes", node.ID)
+		}
+		s.nodes[node.ID] = node.Address
+		s.order = append(s.order, node.ID)
+	}
+	if _, exists := s.nodes[id]; !exists {
+		return nil, fmt.Errorf("node %s is not listed in sharding.nodes", id)
+	}
+	sort.Strings(s.order)
+
+	var err error
+	if s.table, err = loadShardTable(tablePath); err != nil {
+		return nil, err
+	}
+	if s.table == nil {
+		s.table = newShardTable(s.order, tablePath)
+		s.fetchTable()
+		if err := s.table.save(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// fetchTable updates the table with the assignments known by the other nodes.
+func (s *Sharding) fetchTable() {
+	for _, node := range s.order {
+		if node == s.id {
+			continue
+		}
+		var ranges []shardRange
+		ctx, cancel := context.WithTimeout(context.Background(), SHARD_REQUEST_TIMEOUT)
+		err := s.exchange(ctx, node, http.MethodGet, "/shard/slots", nil, &ranges)
+		cancel()
+		if err != nil {
+			s.logger.Info("Could not fetch the slots of node ", node, ": ", err)
+			continue
+		}
+		if _, err := s.table.update(ranges); err != nil {
+			s.logger.Error("Invalid slots of node ", node, ": ", err)
+		}
+	}
+}
+
+// owns reports whether the key belongs to a slot of this node.
+func (s *Sharding) owns(key string) bool {
+	owner, _ := s.table.owner(database.KeySlot(key))
+	return owner == s.id
+}
+
+// filterItems removes from items the keys of the slots of other nodes, left
+// by an interrupted migration.
+func (s *Sharding) filterItems(items map[string]string) {
+	for key := range items {
+		if !s.owns(key) {
+			delete(items, key)
+		}
+	}
+}
+
+// filterRange removes from items the keys of the slots of other nodes.
+func (s *Sharding) filterRange(items []database.Item) []database.Item {
+	owned := items[:0]
+	for _, item := range items {
+		if s.owns(item.Key) {
+			owned = append(owned, item)
+		}
+	}
+	return owned
+}
+
+func slotsOf(keys []string) []int {
+	slots := make([]int, len(keys))
+	for i, key := range keys {
+		slots[i] = database.KeySlot(key)
+	}
+	return slots
+}
+
+// enter registers a request on the slots, which are all owned by this
+// node, and returns the function to call once the request is served. For a
+// write on a migrating slot it returns instead a channel closed at the end
+// of the migration, and for slots of another node the owner of the slots.
+func (s *Sharding) enter(slots []int, write bool) (func(), <-chan struct{}, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner, _ := s.table.owner(slots[0])
+	for _, slot := range slots[1:] {
+		if other, _ := s.table.owner(slot); other != owner {
+			return nil, nil, "", ErrCrossNodeKeys
+		}
+	}
+	if owner != s.id {
+		return nil, nil, owner, nil
+	}
+	if write {
+		for _, slot := range slots {
+			if done, migrating := s.migrating[slot]; migrating {
+				return nil, done, owner, nil
+			}
+		}
+	}
+
+	for _, slot := range slots {
+		s.requests[slot]++
+		if write {
+			s.writes[slot]++
+		}
+	}
+	return func() { s.leave(slots, write) }, nil, owner, nil
+}
+
+func (s *Sharding) leave(slots []int, write bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, slot := range slots {
+		if s.requests[slot]--; s.requests[slot] == 0 {
+			delete(s.requests, slot)
+		}
+		if !write {
+			continue
+		}
+		if s.writes[slot]--; s.writes[slot] == 0 {
+			delete(s.writes, slot)
+		}
+	}
+	if s.waiting > 0 {
+		s.idle.Broadcast()
+	}
+}
+
+// call sends a request to another node, with the headers of header, on
+// behalf of this node.
+func (s *Sharding) call(ctx context.Context, node string, method string, uri string, header http.Header, body []byte) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.nodes[node], "/")+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		request.Header = header.Clone()
+	}
+	request.Header.Set(SHARD_FORWARDED_HEADER, s.id)
+	return s.tokens.do(ctx, s.nodes[node], request, body)
+}
+
+// exchange sends request, unless nil, as JSON to another node and decodes
+// its JSON answer into response, unless nil.
+func (s *Sharding) exchange(ctx context.Context, node string, method string, path string, request interface{}, response interface{}) error {
+	var body []byte
+	header := http.Header{}
+	if request != nil {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+	httpResponse, err := s.call(ctx, node, method, path, header, body)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 1024))
+		return fmt.Errorf("node %s answered %s: %s", node, httpResponse.Status, strings.TrimSpace(string(message)))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+// shardNodeStatus describes a node of a sharded cluster and the number of slots it owns.
+type shardNodeStatus struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Slots   int    `json:"slots"`
+}
+
+// shardStatus describes a sharded cluster as known by one of its nodes.
+type shardStatus struct {
+	Node      string            `json:"node"`
+	Nodes     []shardNodeStatus `json:"nodes"`
+	Ranges    []shardRange      `json:"ranges"`
+	Migrating []int             `json:"migrating,omitempty"`
+}
+
+func (s *Sharding) status() shardStatus {
+	status := shardStatus{Node: s.id, Ranges: s.table.ranges()}
+	slots := make(map[string]int, len(s.order))
+	for _, r := range status.Ranges {
+		slots[r.Owner] += r.End - r.Start + 1
+	}
+	for _, node := range s.order {
+		status.Nodes = append(status.Nodes, shardNodeStatus{ID: node, Address: s.nodes[node], Slots: slots[node]})
+	}
+
+	s.mu.Lock()
+	for slot := range s.migrating {
+		status.Migrating = append(status.Migrating, slot)
+	}
+	s.mu.Unlock()
+	sort.Ints(status.Migrating)
+	return status
+}
+
+// startSharding makes the server a member of the sharded cluster described
+// by the sharding.* configuration keys.
+func (srv *DareServer) startSharding(configuration Config) error {
+	nodes, err := parseClusterPeers(configuration.GetString("sharding.nodes"))
+	if err != nil {
+		return err
+	}
+	username, password := configuration.GetString("sharding.user"), configuration.GetString("sharding.password")
+	if username == "" {
+		username, password = configuration.GetString("server.admin_user"), configuration.GetString("server.admin_password")
+	}
+	tablePath := filepath.Join(configuration.GetString("settings.data_dir"), SHARD_TABLE_FILE)
+	srv.shards, err = NewSharding(configuration.GetString("sharding.node_id"), nodes, tablePath, username, password)
+	return err
+}
+
+// shardKeys returns the keys used by a request, which a sharded cluster
+// serves on the node owning them.
+type shardKeys func(r *http.Request) ([]string, error)
+
+func pathKey(r *http.Request) ([]string, error) {
+	return []string{r.PathValue(KEY_PARAM)}, nil
+}
+
+func queryKeys(r *http.Request) ([]string, error) {
+	return r.URL.Query()["key"], nil
+}
+
+func setBodyKeys(r *http.Request) ([]string, error) {
+	data, err := decodeSetBody(r)
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	return keys, err
+}
+
+func transactionKeys(r *http.Request) ([]string, error) {
+	var request transactionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	var keys []string
+	for _, precondition := range request.Preconditions {
+		keys = append(keys, precondition.Key)
+	}
+	for _, operation := range request.Operations {
+		keys = append(keys, operation.Key)
+	}
+	return keys, err
+}
+
+// shardRoute serves a route in a sharded cluster: the requests of the
+// clients are authorized, then served by route, and the requests forwarded
+// by the other nodes are authenticated, then served by forwarded. Outside of
+// a sharded cluster, handler serves the authorized requests.
+func (srv *DareServer) shardRoute(authorize func(http.HandlerFunc) http.HandlerFunc, authorizer auth.Authorizer, handler http.HandlerFunc, route http.HandlerFunc, forwarded http.HandlerFunc) http.HandlerFunc {
+	local := authorize(handler)
+	routed := authorize(route)
+	fromNode := srv.authenticateNode(authorizer, forwarded)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case srv.shards == nil:
+			local(w, r)
+		case r.Header.Get(SHARD_FORWARDED_HEADER) != "":
+			fromNode(w, r)
+		default:
+			routed(w, r)
+		}
+	}
+}
+
+// routeToOwner serves the requests on the keys returned by keysOf on the
+// node owning them.
+func (srv *DareServer) routeToOwner(authorize func(http.HandlerFunc) http.HandlerFunc, authorizer auth.Authorizer, keysOf shardKeys, handler http.HandlerFunc) http.HandlerFunc {
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		srv.serveKeys(w, r, keysOf, handler)
+	}
+	return srv.shardRoute(authorize, authorizer, handler, serve, serve)
+}
+
+// routeToAll serves the requests on whole collections with route, which
+// combines the results of every node. The other nodes serve the forwarded
+// requests with handler.
+func (srv *DareServer) routeToAll(authorize func(http.HandlerFunc) http.HandlerFunc, authorizer auth.Authorizer, handler http.HandlerFunc, route func(http.ResponseWriter, *http.Request, http.HandlerFunc)) http.HandlerFunc {
+	return srv.shardRoute(authorize, authorizer, handler, func(w http.ResponseWriter, r *http.Request) {
+		route(w, r, handler)
+	}, handler)
+}
+
+// serveKeys serves a request on keys with handler if this node owns them.
+// A request of a client is otherwise forwarded to the owner of the keys,
+// while a request forwarded by another node is answered with 421 Misdirected
+// Request and the assignment of the slots, which the other node learns. The
+// writes on a migrating slot wait until the end of the migration.
+func (srv *DareServer) serveKeys(w http.ResponseWriter, r *http.Request, keysOf shardKeys, handler http.HandlerFunc) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading the request body", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	keys, err := keysOf(r)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(keys) == 0 {
+		// The handler answers the invalid requests
+		handler(w, r)
+		return
+	}
+
+	slots := slotsOf(keys)
+	forwarded := r.Header.Get(SHARD_FORWARDED_HEADER) != ""
+	for attempt := 0; ; attempt++ {
+		release, migrated, owner, err := srv.shards.enter(slots, isWriteRequest(r))
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case release != nil:
+			defer release()
+			handler(w, r)
+			return
+		case migrated != nil:
+			select {
+			case <-migrated:
+				continue
+			case <-r.Context().Done():
+				http.Error(w, "Request canceled while the slot was migrating", http.StatusServiceUnavailable)
+				return
+			}
+		case forwarded:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMisdirectedRequest)
+			json.NewEncoder(w).Encode(srv.shards.table.ranges(slots...))
+			return
+		case attempt > SHARD_MAX_REDIRECTS:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("The owner of slot %d could not be found, retry later", slots[0]), http.StatusServiceUnavailable)
+			return
+		}
+
+		moved, err := srv.forward(w, r, body, owner)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Node %s owning slot %d did not answer: %v", owner, slots[0], err), http.StatusBadGateway)
+			return
+		}
+		if !moved {
+			return
+		}
+	}
+}
+
+// forward sends the request of a client to the node owning its keys, and
+// copies the answer. It returns true, without answering, if the node does not
+// own the keys anymore: the slot table is then updated from its answer.
+func (srv *DareServer) forward(w http.ResponseWriter, r *http.Request, body []byte, node string) (bool, error) {
+	response, err := srv.shards.call(r.Context(), node, r.Method, r.URL.RequestURI(), r.Header, body)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusMisdirectedRequest {
+		var ranges []shardRange
+		if err := json.NewDecoder(response.Body).Decode(&ranges); err != nil {
+			return false, err
+		}
+		if _, err := srv.shards.table.update(ranges); err != nil {
+			srv.shards.logger.Error("Failed to update the slots: ", err)
+		}
+		return true, nil
+	}
+
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+	return false, nil
+}
+
+// shardResponse is the answer of a node to a request sent to every node.
+type shardResponse struct {
+	node   string
+	status int
+	header http.Header
+	body   []byte
+}
+
+// shardRecorder records the answer of this node to a request sent to every node.
+type shardRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *shardRecorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *shardRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.body.Write(data)
+}
+
+func (recorder *shardRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+}
+
+// requestNode sends the request r, with uri instead of its own, to node. This
+// node serves it with handler.
+func (srv *DareServer) requestNode(r *http.Request, body []byte, node string, uri string, handler http.HandlerFunc) (shardResponse, error) {
+	if node != srv.shards.id {
+		ctx, cancel := context.WithTimeout(r.Context(), SHARD_REQUEST_TIMEOUT)
+		defer cancel()
+		response, err := srv.shards.call(ctx, node, r.Method, uri, r.Header, body)
+		if err != nil {
+			return shardResponse{node: node}, err
+		}
+		defer response.Body.Close()
+		content, err := io.ReadAll(response.Body)
+		return shardResponse{node: node, status: response.StatusCode, header: response.Header, body: content}, err
+	}
+
+	local := r.Clone(r.Context())
+	parsed, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return shardResponse{node: node}, err
+	}
+	local.URL, local.RequestURI = parsed, uri
+	local.Body = io.NopCloser(bytes.NewReader(body))
+	recorder := &shardRecorder{header: http.Header{}}
+	handler(recorder, local)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return shardResponse{node: node, status: recorder.status, header: recorder.header, body: recorder.body.Bytes()}, nil
+}
+
+// gather sends r, with uri instead of its own, to every node, this node
+// serving it with handler. It answers with 502 Bad Gateway and returns false
+// if a node did not answer.
+func (srv *DareServer) gather(w http.ResponseWriter, r *http.Request, uri string, handler http.HandlerFunc) ([]shardResponse, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading the request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	responses := make([]shardResponse, len(srv.shards.order))
+	errs := make([]error, len(srv.shards.order))
+	var wg sync.WaitGroup
+	for i, node := range srv.shards.order {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = srv.requestNode(r, body, node, uri, handler)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Node %s did not answer: %v", srv.shards.order[i], err), http.StatusBadGateway)
+			return nil, false
+		}
+	}
+	return responses, true
+}
+
+func writeShardResponse(w http.ResponseWriter, response shardResponse) {
+	for name, values := range response.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body)
+}
+
+// writeShardError answers with the error of a node, prefixed with its id.
+func writeShardError(w http.ResponseWriter, response shardResponse) {
+	http.Error(w, fmt.Sprintf("Node %s answered: %s", response.node, strings.TrimSpace(string(response.body))), response.status)
+}
+
+// broadcast serves a request changing the collections of every node, such
+// as the creation of a collection, on this node with handler, then on the
+// other nodes. Errors of the other nodes are ignored, except server errors:
+// they may already hold the collection created on the first write of a key.
+func (srv *DareServer) broadcast(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading the request body", http.StatusBadRequest)
+		return
+	}
+	local, err := srv.requestNode(r, body, srv.shards.id, r.URL.RequestURI(), handler)
+	if err != nil || local.status >= http.StatusMultipleChoices {
+		writeShardResponse(w, local)
+		return
+	}
+
+	var failed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range srv.shards.order {
+		if node == srv.shards.id {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := srv.requestNode(r, body, node, r.URL.RequestURI(), handler)
+			if err == nil && response.status < http.StatusInternalServerError {
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("%d %s", response.status, strings.TrimSpace(string(response.body)))
+			}
+			mu.Lock()
+			failed = append(failed, fmt.Sprintf("%s (%v)", node, err))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		http.Error(w, fmt.Sprintf("Applied on node %s, but not on nodes %s", srv.shards.id, strings.Join(failed, ", ")), http.StatusBadGateway)
+		return
+	}
+	writeShardResponse(w, local)
+}
+
+// gatherCollectionNames lists the collections of every node.
+func (srv *DareServer) gatherCollectionNames(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	responses, ok := srv.gather(w, r, r.URL.RequestURI(), handler)
+	if !ok {
+		return
+	}
+
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, response := range responses {
+		if response.status != http.StatusOK {
+			writeShardError(w, response)
+			return
+		}
+		var nodeNames []string
+		if err := json.Unmarshal(response.body, &nodeNames); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid answer of node %s: %v", response.node, err), http.StatusBadGateway)
+			return
+		}
+		for _, name := range nodeNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	writeJSON(w, names)
+}
+
+// gatherItems returns a page of the items of a collection held by every node.
+func (srv *DareServer) gatherItems(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	page := parseQueryParam(r, "page", 1)
+	pageSize := parseQueryParam(r, "pageSize", 10)
+	// Every node returns as many items as the pages up to the one requested
+	query := r.URL.Query()
+	query.Set("page", "1")
+	query.Set("pageSize", strconv.Itoa(page*pageSize))
+	responses, ok := srv.gather(w, r, r.URL.Path+"?"+query.Encode(), handler)
+	if !ok {
+		return
+	}
+
+	items := make(map[string]string)
+	found := false
+	for _, response := range responses {
+		if response.status == http.StatusNotFound {
+			continue
+		}
+		if response.status != http.StatusOK {
+			writeShardError(w, response)
+			return
+		}
+		var nodeItems struct {
+			Items []Item `json:"items"`
+		}
+		if err := json.Unmarshal(response.body, &nodeItems); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid answer of node %s: %v", response.node, err), http.StatusBadGateway)
+			return
+		}
+		found = true
+		for _, item := range nodeItems.Items {
+			items[item.Key] = item.Value
+		}
+	}
+	if !found {
+		collectionName := r.PathValue(COLLECTION_NAME_PARAM)
+		http.Error(w, fmt.Sprintf(`Collection "%s" not found`, collectionName), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"items":    paginateItems(items, page, pageSize),
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// scanNodes scans the nodes one after the other. The cursor holds the index
+// of the node being scanned and the cursor of the scan of this node.
+func (srv *DareServer) scanNodes(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	query := r.URL.Query()
+	var cursor uint64
+	if value := query.Get(CURSOR_PARAM); value != "" {
+		var err error
+		cursor, err = strconv.ParseUint(value, 10, 64)
+		if err != nil || cursor>>SHARD_CURSOR_SHIFT >= uint64(len(srv.shards.order)) {
+			http.Error(w, `query param "cursor" is not a valid cursor`, http.StatusBadRequest)
+			return
+		}
+	}
+	nodeIndex := cursor >> SHARD_CURSOR_SHIFT
+	query.Set(CURSOR_PARAM, strconv.FormatUint(cursor&(1<<SHARD_CURSOR_SHIFT-1), 10))
+
+	response, err := srv.requestNode(r, nil, srv.shards.order[nodeIndex], r.URL.Path+"?"+query.Encode(), handler)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Node %s did not answer: %v", srv.shards.order[nodeIndex], err), http.StatusBadGateway)
+		return
+	}
+	result := struct {
+		Cursor string            `json:"cursor"`
+		Items  map[string]string `json:"items"`
+	}{Items: map[string]string{}}
+	switch response.status {
+	case http.StatusOK:
+		if err := json.Unmarshal(response.body, &result); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid answer of node %s: %v", response.node, err), http.StatusBadGateway)
+			return
+		}
+	case http.StatusNotFound:
+		// The collection was not created on this node
+		result.Cursor = "0"
+	default:
+		writeShardError(w, response)
+		return
+	}
+
+	next, err := strconv.ParseUint(result.Cursor, 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid cursor of node %s: %v", response.node, err), http.StatusBadGateway)
+		return
+	}
+	if next == 0 {
+		// The node is scanned, continue with the next one
+		nodeIndex++
+		if nodeIndex == uint64(len(srv.shards.order)) {
+			nodeIndex = 0
+		}
+	}
+	result.Cursor = strconv.FormatUint(nodeIndex<<SHARD_CURSOR_SHIFT|next, 10)
+	writeJSON(w, result)
+}
+
+// gatherRange merges the ranges of keys returned by every node.
+func (srv *DareServer) gatherRange(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	responses, ok := srv.gather(w, r, r.URL.RequestURI(), handler)
+	if !ok {
+		return
+	}
+
+	var items []database.Item
+	more := false
+	var conflict *shardResponse
+	for i, response := range responses {
+		if response.status == http.StatusConflict {
+			// The node has no ordered index
+			conflict = &responses[i]
+			continue
+		}
+		if response.status != http.StatusOK {
+			writeShardError(w, response)
+			return
+		}
+		var nodeRange struct {
+			Items []database.Item `json:"items"`
+			More  bool            `json:"more"`
+		}
+		if err := json.Unmarshal(response.body, &nodeRange); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid answer of node %s: %v", response.node, err), http.StatusBadGateway)
+			return
+		}
+		items = append(items, nodeRange.Items...)
+		more = more || nodeRange.More
+	}
+	if conflict != nil && items == nil && !more {
+		writeShardResponse(w, *conflict)
+		return
+	}
+
+	descending := r.URL.Query().Get("order") == "desc"
+	sort.Slice(items, func(i, j int) bool {
+		if descending {
+			return items[i].Key > items[j].Key
+		}
+		return items[i].Key < items[j].Key
+	})
+	if limit := parseQueryParam(r, "limit", DEFAULT_RANGE_LIMIT); limit > 0 && len(items) > limit {
+		items, more = items[:limit], true
+	}
+	if items == nil {
+		items = []database.Item{}
+	}
+	writeJSON(w, map[string]interface{}{
+		"items": items,
+		"more":  more,
+	})
+}
+
+// shardingEnabled answers with an error if the server is not a member of a
+// sharded cluster. It returns true if it is.
+func (srv *DareServer) shardingEnabled(w http.ResponseWriter) bool {
+	if srv.shards == nil {
+		http.Error(w, "Sharding is not enabled, set sharding.node_id", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// HandlerShardStatus returns the nodes of the sharded cluster and the slots they own.
+func (srv *DareServer) HandlerShardStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.shardingEnabled(w) {
+		return
+	}
+	writeJSON(w, srv.shards.status())
+}
+
+// HandlerShardSlots returns the slot table of the node to another node, or
+// updates it with the newer assignments sent by another node.
+func (srv *DareServer) HandlerShardSlots(w http.ResponseWriter, r *http.Request) {
+	if !srv.shardingEnabled(w) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, srv.shards.table.ranges())
+	case http.MethodPost:
+		var ranges []shardRange
+		if err := json.NewDecoder(r.Body).Decode(&ranges); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		changed, err := srv.shards.table.update(ranges)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]bool{"changed": changed})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/dmarro89/dare-db/logger"
+	"github.com/dmarro89/dare-db/raft"
+	"github.com/dmarro89/dare-db/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startShardNodes starts a node of a sharded cluster for each of ids, the
+// slots being shared evenly between them.
+func startShardNodes(t *testing.T, ids []string) []*clusterTestNode {
+	var nodes []*clusterTestNode
+	var handlers []*clusterTestHandler
+	var peers []raft.Server
+	for _, id := range ids {
+		handler := &clusterTestHandler{}
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		nodes = append(nodes, &clusterTestNode{id: id, server: server, url: server.URL})
+		handlers = append(handlers, handler)
+		peers = append(peers, raft.Server{ID: id, Address: server.URL})
+	}
+
+	for i, node := range nodes {
+		userStore := auth.NewUserStore()
+		userStore.AddUser("user", "password")
+		node.srv = NewDareServer(database.NewDatabase(), userStore)
+		shards, err := NewSharding(node.id, peers, "", "user", "password")
+		require.NoError(t, err)
+		node.srv.shards = shards
+
+		handlers[i].mu.Lock()
+		handlers[i].handler = node.srv.CreateMux(allowAllAuthorizer{}, auth.NewJWTAutenticatorWithUsers(userStore))
+		handlers[i].mu.Unlock()
+		node.token = loginTestNode(t, node.url)
+	}
+	return nodes
+}
+
+// shardOwner returns the node owning key, as known by node.
+func shardOwner(nodes []*clusterTestNode, node *clusterTestNode, key string) *clusterTestNode {
+	owner, _ := node.srv.shards.table.owner(database.KeySlot(key))
+	for _, candidate := range nodes {
+		if candidate.id == owner {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func TestSharding_RoutesKeysToOwners(t *testing.T) {
+	nodes := startShardNodes(t, []string{"n1", "n2", "n3"})
+
+	owners := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		response, _ := doClusterRequest(t, nodes[i%3], http.MethodPost, "/set", fmt.Sprintf(`{"%s":"value%d"}`, key, i))
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+
+		owner := shardOwner(nodes, nodes[0], key)
+		owners[owner.id] = true
+		for _, node := range nodes {
+			expected := ""
+			if node == owner {
+				expected = fmt.Sprintf("value%d", i)
+			}
+			assert.Equal(t, expected, node.srv.collectionManager.GetDefaultCollection().Get(key), "Expected %s to be held by its owner only", key)
+
+			response, body := doClusterRequest(t, node, http.MethodGet, "/get/"+key, "")
+			require.Equal(t, http.StatusOK, response.StatusCode)
+			assert.JSONEq(t, fmt.Sprintf(`{"%s":"value%d"}`, key, i), body)
+		}
+	}
+	assert.Len(t, owners, 3, "Expected the keys to be spread over every node")
+
+	response, _ := doClusterRequest(t, nodes[0], http.MethodPost, "/incr/key0?by=1", "")
+	assert.Equal(t, http.StatusConflict, response.StatusCode, "Expected the error of the owner to be forwarded")
+	response, _ = doClusterRequest(t, nodes[0], http.MethodDelete, "/delete/key0", "")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = doClusterRequest(t, nodes[1], http.MethodGet, "/get/key0", "")
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	// Keys held by different nodes cannot be set together, unless they share a hash tag
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		key := fmt.Sprintf("other%d", i)
+		if shardOwner(nodes, nodes[0], key) == nodes[0] {
+			local = key
+		} else {
+			remote = key
+		}
+	}
+	response, body := doClusterRequest(t, nodes[0], http.MethodPost, "/set", fmt.Sprintf(`{"%s":"a","%s":"b"}`, local, remote))
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Contains(t, body, "hash tag")
+	response, _ = doClusterRequest(t, nodes[0], http.MethodPost, "/set", `{"{user1}.name":"alice","{user1}.email":"alice@example.com"}`)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	response, _ = doClusterRequest(t, nodes[2], http.MethodPost, "/transaction", `{"operations":[{"op":"set","key":"{user1}.name","value":"bob"}]}`)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	owner := shardOwner(nodes, nodes[0], "user1")
+	assert.Equal(t, "bob", owner.srv.collectionManager.GetDefaultCollection().Get("{user1}.name"))
+}
+
+func TestSharding_FansOutCollectionRequests(t *testing.T) {
+	nodes := startShardNodes(t, []string{"n1", "n2", "n3"})
+
+	response, _ := doClusterRequest(t, nodes[1], http.MethodPost, "/collections/users", "")
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	for _, node := range nodes {
+		_, exists := node.srv.collectionManager.GetCollection("users")
+		assert.True(t, exists, "Expected the collection to be created on %s", node.id)
+	}
+	response, body := doClusterRequest(t, nodes[2], http.MethodGet, "/collections", "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, body, `"users"`)
+
+	expected := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		key, value := fmt.Sprintf("user%02d", i), fmt.Sprintf("name%d", i)
+		expected[key] = value
+		response, _ := doClusterRequest(t, nodes[i%3], http.MethodPost, "/collections/users/set", fmt.Sprintf(`{"%s":"%s"}`, key, value))
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+	}
+
+	scanned := make(map[string]string)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		require.Less(t, calls, 100, "Expected the scan to end")
+		response, body := doClusterRequest(t, nodes[0], http.MethodGet, "/collections/users/scan?count=4&cursor="+cursor, "")
+		require.Equal(t, http.StatusOK, response.StatusCode, body)
+		var result struct {
+			Cursor string            `json:"cursor"`
+			Items  map[string]string `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &result))
+		for key, value := range result.Items {
+			scanned[key] = value
+		}
+		if cursor = result.Cursor; cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, expected, scanned)
+
+	response, body = doClusterRequest(t, nodes[1], http.MethodGet, "/collections/users/items?page=2&pageSize=10", "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var page struct {
+		Items []Item `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	require.Len(t, page.Items, 10)
+	for _, item := range page.Items {
+		assert.Equal(t, expected[item.Key], item.Value)
+	}
+
+	response, _ = doClusterRequest(t, nodes[0], http.MethodPost, "/collections/users/index", "")
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	response, body = doClusterRequest(t, nodes[2], http.MethodGet, "/collections/users/range?start=user05&limit=3&order=asc", "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.JSONEq(t, `{"items":[{"key":"user05","value":"name5"},{"key":"user06","value":"name6"},{"key":"user07","value":"name7"}],"more":true}`, body)
+}
+
+func TestSharding_MigratesSlots(t *testing.T) {
+	nodes := startShardNodes(t, []string{"n1", "n2", "n3"})
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if candidate := fmt.Sprintf("key%d", i); shardOwner(nodes, nodes[0], candidate) == nodes[0] {
+			key = candidate
+		}
+	}
+	response, _ := doClusterRequest(t, nodes[0], http.MethodPost, "/set", fmt.Sprintf(`{"%s":"value"}`, key))
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+
+	slot := database.KeySlot(key)
+	response, body := doClusterRequest(t, nodes[1], http.MethodPost, "/admin/shards/migrate", fmt.Sprintf(`{"slots":"%d","node":"n3"}`, slot))
+	assert.Equal(t, http.StatusConflict, response.StatusCode, "Expected only the owner to migrate the slot")
+	response, body = doClusterRequest(t, nodes[0], http.MethodPost, "/admin/shards/migrate", fmt.Sprintf(`{"slots":"%d","node":"n2"}`, slot))
+	require.Equal(t, http.StatusOK, response.StatusCode, body)
+
+	assert.Empty(t, nodes[0].srv.collectionManager.GetDefaultCollection().Get(key), "Expected the key to be deleted from the former owner")
+	assert.Equal(t, "value", nodes[1].srv.collectionManager.GetDefaultCollection().Get(key))
+	assert.Equal(t, nodes[1], shardOwner(nodes, nodes[2], key), "Expected the other nodes to learn the new owner")
+
+	// A node with an outdated table learns the new owner from the former one
+	nodes[2].srv.shards.table = newShardTable([]string{"n1", "n2", "n3"}, "")
+	for _, node := range nodes {
+		response, body := doClusterRequest(t, node, http.MethodGet, "/get/"+key, "")
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		assert.JSONEq(t, fmt.Sprintf(`{"%s":"value"}`, key), body)
+	}
+	assert.Equal(t, nodes[1], shardOwner(nodes, nodes[2], key))
+
+	response, body = doClusterRequest(t, nodes[0], http.MethodGet, "/admin/shards", "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var status shardStatus
+	require.NoError(t, json.Unmarshal([]byte(body), &status))
+	assert.Equal(t, "n1", status.Node)
+	require.Len(t, status.Nodes, 3)
+	assert.Equal(t, database.HASH_SLOTS, status.Nodes[0].Slots+status.Nodes[1].Slots+status.Nodes[2].Slots)
+	assert.Empty(t, status.Migrating)
+}
+
+func TestSharding_RejectsRequestsOfUnknownNodes(t *testing.T) {
+	nodes := startShardNodes(t, []string{"n1", "n2"})
+
+	request, _ := http.NewRequest(http.MethodGet, nodes[0].url+"/shard/slots", nil)
+	request.SetBasicAuth("user", "wrong")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request, _ = http.NewRequest(http.MethodGet, nodes[0].url+"/get/"+url.PathEscape("key"), nil)
+	request.Header.Set(SHARD_FORWARDED_HEADER, "n2")
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "Expected forwarded requests to be authenticated")
+}
+
+func TestSharding_RespServer(t *testing.T) {
+	t.Setenv("DARE_RESP_PORT", "0")
+	nodes := startShardNodes(t, []string{"n1", "n2"})
+	nodes[0].srv.userStore.AddUser("admin", "secret")
+	server := NewRespServer(nodes[0].srv, NewConfiguration(""), logger.NewDareLogger())
+	server.authorizer = allowAllAuthorizer{}
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+	client := dialTestRespServer(t, server)
+	require.Equal(t, "OK", client.do("AUTH", "admin", "secret"))
+
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if shardOwner(nodes, nodes[0], key) == nodes[0] {
+			local = key
+		} else {
+			remote = key
+		}
+	}
+	assert.Equal(t, "OK", client.do("SET", local, "value"))
+	assert.Equal(t, "value", client.do("GET", local))
+	reply := client.do("GET", remote)
+	require.IsType(t, resp.Error(""), reply)
+	assert.Equal(t, fmt.Sprintf("MOVED %d %s", database.KeySlot(remote), nodes[1].url), string(reply.(resp.Error)))
+	reply = client.do("DEL", local, remote)
+	require.IsType(t, resp.Error(""), reply)
+	assert.Contains(t, string(reply.(resp.Error)), "CROSSSLOT")
+}
+
+func TestParseSlots(t *testing.T) {
+	slots, err := parseSlots("0-2, 5,1")
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 5}, slots)
+
+	for _, value := range []string{"", "a", "3-1", "0-16384", "-1"} {
+		_, err := parseSlots(value)
+		assert.Error(t, err, "Expected %q to be rejected", value)
+	}
+}
+
+func TestNewDareServerWithConfig_Sharding(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("DARE_DATA_DIR", dataDir)
+	t.Setenv("DARE_SHARDING_NODE_ID", "n1")
+	t.Setenv("DARE_SHARDING_NODES", "n1=http://127.0.0.1:2607")
+
+	srv, err := NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), NewConfiguration(""))
+	require.NoError(t, err)
+	require.NotNil(t, srv.shards)
+	require.NoError(t, srv.Close())
+	table, err := loadShardTable(srv.shards.table.path)
+	require.NoError(t, err)
+	require.NotNil(t, table, "Expected the slot table to be saved")
+	assert.Equal(t, []shardRange{{Start: 0, End: database.HASH_SLOTS - 1, Owner: "n1"}}, table.ranges())
+
+	t.Setenv("DARE_CLUSTER_NODE_ID", "n1")
+	_, err = NewDareServerWithConfig(database.NewDatabase(), auth.NewUserStore(), NewConfiguration(""))
+	assert.Error(t, err, "Expected sharding and cluster mode to be exclusive")
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+// transactionRequest is the body of POST /transaction, for example:
+//
+//	{
+//	  "preconditions": [{"type": "equals", "collection": "accounts", "key": "alice", "value": "100"}],
+//	  "operations": [
+//	    {"op": "set", "collection": "accounts", "key": "alice", "value": "50"},
+//	    {"op": "set", "collection": "accounts", "key": "bob", "value": "50", "ttl": 60},
+//	    {"op": "delete", "key": "pending"}
+//	  ]
+//	}
+type transactionRequest struct {
+	Preconditions []struct {
+		Type       database.PreconditionType `json:"type"`
+		Collection string                    `json:"collection"`
+		Key        string                    `json:"key"`
+		Value      string                    `json:"value"`
+	} `json:"preconditions"`
+	Operations []struct {
+		Type       database.OperationType `json:"op"`
+		Collection string                 `json:"collection"`
+		Key        string                 `json:"key"`
+		Value      string                 `json:"value"`
+		TTL        *int64                 `json:"ttl"`
+	} `json:"operations"`
+}
+
+// validate returns an error if the ttl of an operation is not positive, or
+// longer than MAX_TTL_SECONDS.
+func (request transactionRequest) validate() error {
+	for i, operation := range request.Operations {
+		if operation.TTL != nil && (*operation.TTL <= 0 || *operation.TTL > MAX_TTL_SECONDS) {
+			return fmt.Errorf("ttl of operation %d must be a positive number of seconds up to %d", i, MAX_TTL_SECONDS)
+		}
+	}
+	return nil
+}
+
+func (request transactionRequest) transaction() database.Transaction {
+	var tx database.Transaction
+	for _, precondition := range request.Preconditions {
+		tx.Preconditions = append(tx.Preconditions, database.Precondition{
+			Type:       precondition.Type,
+			Collection: precondition.Collection,
+			Key:        precondition.Key,
+			Value:      precondition.Value,
+		})
+	}
+	for _, operation := range request.Operations {
+		var ttl time.Duration
+		if operation.TTL != nil {
+			ttl = time.Duration(*operation.TTL) * time.Second
+		}
+		tx.Operations = append(tx.Operations, database.TransactionOperation{
+			Type:       operation.Type,
+			Collection: operation.Collection,
+			Key:        operation.Key,
+			Value:      operation.Value,
+			TTL:        ttl,
+		})
+	}
+	return tx
+}
+
+// HandlerTransaction applies an ordered list of set and delete operations
+// across collections atomically. The whole batch is rejected with 409 if one
+// of its preconditions does not hold.
+func (srv *DareServer) HandlerTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request transactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `Invalid JSON format, the body must be in the form of {"preconditions": [...], "operations": [...]}`, http.StatusBadRequest)
+		return
+	}
+	if err := request.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := srv.collectionManager.Execute(request.transaction())
+	if errors.Is(err, database.ErrInvalidTransaction) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrPreconditionFailed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrOutOfMemory) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving data", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
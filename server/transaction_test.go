@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerTransaction(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.GetDefaultCollection().Set("pending", "1")
+
+	body := `{
+		"preconditions": [{"type": "exists", "key": "pending"}],
+		"operations": [
+			{"op": "set", "collection": "accounts", "key": "alice", "value": "50"},
+			{"op": "set", "collection": "accounts", "key": "bob", "value": "50", "ttl": 60},
+			{"op": "delete", "key": "pending"}
+		]
+	}`
+	request, _ := http.NewRequest("POST", "/transaction", bytes.NewBufferString(body))
+	response := httptest.NewRecorder()
+	srv.HandlerTransaction(response, request)
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+
+	accounts, exists := srv.collectionManager.GetCollection("accounts")
+	require.True(t, exists)
+	assert.Equal(t, "50", accounts.Get("alice"))
+	assert.Equal(t, "50", accounts.Get("bob"))
+	assert.False(t, srv.collectionManager.GetDefaultCollection().Exists("pending"))
+}
+
+func TestHandlerTransaction_Errors(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "plainText", http.StatusBadRequest},
+		{"POST", `{"operations": []}`, http.StatusBadRequest},
+		{"POST", `{"operations": [{"op": "expire", "key": "key"}]}`, http.StatusBadRequest},
+		// A ttl not positive would store the key without expiration, a longer one overflow
+		{"POST", `{"operations": [{"op": "set", "key": "other", "value": "value"}, {"op": "set", "key": "key", "value": "value", "ttl": -5}]}`, http.StatusBadRequest},
+		{"POST", `{"operations": [{"op": "set", "key": "other", "value": "value"}, {"op": "set", "key": "key", "value": "value", "ttl": 0}]}`, http.StatusBadRequest},
+		{"POST", `{"operations": [{"op": "set", "key": "other", "value": "value"}, {"op": "set", "key": "key", "value": "value", "ttl": 9300000000}]}`, http.StatusBadRequest},
+		{"POST", `{"preconditions": [{"type": "exists", "key": "missing"}], "operations": [{"op": "set", "key": "key", "value": "value"}]}`, http.StatusConflict},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.method, "/transaction", bytes.NewBufferString(test.body))
+		response := httptest.NewRecorder()
+		srv.HandlerTransaction(response, request)
+		assert.Equal(t, test.status, response.Code, test.body)
+	}
+
+	assert.False(t, srv.collectionManager.GetDefaultCollection().Exists("key"))
+	assert.False(t, srv.collectionManager.GetDefaultCollection().Exists("other"))
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmarro89/dare-db/auth"
+)
+
+const USERNAME_PARAM = "username"
+const SESSION_ID_PARAM = "sessionId"
+
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HandlerListUsers returns the usernames of the user store.
+func (srv *DareServer) HandlerListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"users": srv.userStore.ListUsers()})
+}
+
+// HandlerCreateUser adds a user with the username and password of the body.
+func (srv *DareServer) HandlerCreateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request userRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !writeUserError(w, request.Username, srv.userStore.AddUser(request.Username, request.Password)) {
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandlerDeleteUser removes the user named in the path, its sessions and the
+// roles assigned to it.
+func (srv *DareServer) HandlerDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	if !writeUserError(w, username, srv.userStore.DeleteUser(username)) {
+		return
+	}
+	if _, err := srv.defaultAuthorizer().DeleteRolesForUser(username); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save the roles: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// HandlerChangePassword replaces the password of the user named in the path
+// with the password of the body, and revokes its sessions.
+func (srv *DareServer) HandlerChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request userRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	username := r.PathValue(USERNAME_PARAM)
+	writeUserError(w, username, srv.userStore.UpdatePassword(username, request.Password))
+}
+
+// HandlerResetCredentials replaces the password of the user named in the
+// path with a random one, returned in the response, and revokes its sessions.
+func (srv *DareServer) HandlerResetCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	password, err := srv.userStore.ResetPassword(username)
+	if !writeUserError(w, username, err) {
+		return
+	}
+	writeJSON(w, userRequest{Username: username, Password: password})
+}
+
+// HandlerListSessions returns the sessions of the user named in the path.
+func (srv *DareServer) HandlerListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	if !srv.userStore.HasUser(username) {
+		http.Error(w, fmt.Sprintf(`User "%v" not found`, username), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"sessions": srv.userStore.ListSessions(username)})
+}
+
+// HandlerRevokeSessions revokes every session of the user named in the path,
+// returning their number.
+func (srv *DareServer) HandlerRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue(USERNAME_PARAM)
+	if !srv.userStore.HasUser(username) {
+		http.Error(w, fmt.Sprintf(`User "%v" not found`, username), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"revoked": srv.userStore.DeleteSessions(username)})
+}
+
+// HandlerRevokeSession revokes the session of the path of the user named in
+// the path.
+func (srv *DareServer) HandlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, id := r.PathValue(USERNAME_PARAM), r.PathValue(SESSION_ID_PARAM)
+	if errors.Is(srv.userStore.DeleteSession(username, id), auth.ErrSessionNotFound) {
+		http.Error(w, fmt.Sprintf(`Session "%v" of user "%v" not found`, id, username), http.StatusNotFound)
+	}
+}
+
+// writeUserError writes the response matching an error of the user store,
+// returning true if there is no error.
+func writeUserError(w http.ResponseWriter, username string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrUserNotFound):
+		http.Error(w, fmt.Sprintf(`User "%v" not found`, username), http.StatusNotFound)
+	case errors.Is(err, auth.ErrUserExists):
+		http.Error(w, fmt.Sprintf(`User "%v" already exists`, username), http.StatusConflict)
+	case errors.Is(err, auth.ErrInvalidUsername), errors.Is(err, auth.ErrInvalidPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to save the users: %v", err), http.StatusInternalServerError)
+	}
+	return false
+}
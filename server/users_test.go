@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), auth.USERS_FILE)
+	userStore, err := auth.NewUserStoreWithFile(path)
+	require.NoError(t, err)
+	require.NoError(t, userStore.AddUser("admin", "secret"))
+	srv := NewDareServer(database.NewDatabase(), userStore)
+	srv.authorizer, _ = newTestAuthorizer(t)
+
+	userRequest := func(method string, username string, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/users", bytes.NewBufferString(body))
+		req.SetPathValue(USERNAME_PARAM, username)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := userRequest(http.MethodPost, "", `{"username": "reader", "password": "password"}`, srv.HandlerCreateUser)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = userRequest(http.MethodPost, "", `{"username": "reader", "password": "password"}`, srv.HandlerCreateUser)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = userRequest(http.MethodPost, "", `{"username": "writer"}`, srv.HandlerCreateUser)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = userRequest(http.MethodGet, "", "", srv.HandlerListUsers)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list map[string][]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, []string{"admin", "reader"}, list["users"])
+
+	w = userRequest(http.MethodPut, "reader", `{"password": "changed"}`, srv.HandlerChangePassword)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, userStore.ValidateCredentials("reader", "changed"))
+	w = userRequest(http.MethodPut, "missing", `{"password": "changed"}`, srv.HandlerChangePassword)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = userRequest(http.MethodPost, "reader", "", srv.HandlerResetCredentials)
+	require.Equal(t, http.StatusOK, w.Code)
+	var reset map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&reset))
+	assert.True(t, userStore.ValidateCredentials("reader", reset["password"]))
+	assert.False(t, userStore.ValidateCredentials("reader", "changed"))
+
+	_, err = srv.authorizer.AddRoleForUser("reader", "readers")
+	require.NoError(t, err)
+	w = userRequest(http.MethodDelete, "reader", "", srv.HandlerDeleteUser)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, srv.authorizer.GetRolesForUser("reader"))
+	w = userRequest(http.MethodDelete, "reader", "", srv.HandlerDeleteUser)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	loaded, err := auth.NewUserStoreWithFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, loaded.ListUsers())
+}
+
+func TestHandlerSessions(t *testing.T) {
+	userStore := auth.NewUserStore()
+	require.NoError(t, userStore.AddUser("admin", "secret"))
+	srv := NewDareServer(database.NewDatabase(), userStore)
+	authorizer, _ := newTestAuthorizer(t)
+	mux := srv.CreateMux(authorizer, auth.NewJWTAutenticatorWithUsers(userStore))
+
+	login := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]string
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response["token"]
+	}
+	request := func(method string, target string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	first, second, third := login(), login(), login()
+	w := request(http.MethodGet, "/admin/users/admin/sessions", first)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list map[string][]auth.Session
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Len(t, list["sessions"], 3)
+
+	// Logging out ends the session of the token only
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/logout", first).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/logout", first).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/users", first).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/users", second).Code)
+
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/admin/users/admin/sessions/unknown", second).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/admin/users/missing/sessions", second).Code)
+
+	// Revoking the sessions of a user invalidates all of its tokens
+	w = request(http.MethodDelete, "/admin/users/admin/sessions", second)
+	require.Equal(t, http.StatusOK, w.Code)
+	var revoked map[string]int
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&revoked))
+	assert.Equal(t, 2, revoked["revoked"])
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/users", second).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/users", third).Code)
+
+	token := login()
+	sessions := userStore.ListSessions("admin")
+	require.Len(t, sessions, 1)
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/admin/users/admin/sessions/"+sessions[0].ID, token).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/users", token).Code)
+}
+
+func TestHandlerRefresh(t *testing.T) {
+	userStore := auth.NewUserStore()
+	require.NoError(t, userStore.AddUser("admin", "secret"))
+	srv := NewDareServer(database.NewDatabase(), userStore)
+	authorizer, _ := newTestAuthorizer(t)
+	mux := srv.CreateMux(authorizer, nil)
+
+	request := func(method string, target string, token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		} else {
+			req.SetBasicAuth("admin", "secret")
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) auth.TokenPair {
+		require.Equal(t, http.StatusOK, w.Code)
+		var tokens auth.TokenPair
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		require.NotEmpty(t, tokens.AccessToken)
+		require.NotEmpty(t, tokens.RefreshToken)
+		return tokens
+	}
+
+	tokens := decode(request(http.MethodPost, "/login", "", ""))
+	refreshed := decode(request(http.MethodPost, "/refresh", "", `{"refresh_token": "`+tokens.RefreshToken+`"}`))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/users", tokens.AccessToken, "").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/users", refreshed.AccessToken, "").Code)
+
+	// Reusing the first refresh token revokes the refreshed tokens
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/refresh", "", `{"refresh_token": "`+tokens.RefreshToken+`"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/users", refreshed.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/refresh", "", `{"refresh_token": "`+refreshed.RefreshToken+`"}`).Code)
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/refresh", "", `{}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/refresh", "", `{"refresh_token": "`+tokens.AccessToken+`"}`).Code)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag formats the version of a key as a strong entity tag.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// versionCondition holds the If-Match and If-None-Match headers of a request.
+type versionCondition struct {
+	ifMatch     []string
+	ifNoneMatch []string
+}
+
+// parseVersionCondition returns the conditions of the request, nil if it has none.
+func parseVersionCondition(r *http.Request) *versionCondition {
+	ifMatch := parseETags(r.Header.Get("If-Match"))
+	ifNoneMatch := parseETags(r.Header.Get("If-None-Match"))
+	if ifMatch == nil && ifNoneMatch == nil {
+		return nil
+	}
+	return &versionCondition{ifMatch: ifMatch, ifNoneMatch: ifNoneMatch}
+}
+
+func parseETags(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// matchesETags reports whether the version, zero for a missing key, matches
+// one of the tags. "*" matches any existing key.
+func matchesETags(tags []string, version uint64) bool {
+	if version == 0 {
+		return false
+	}
+	current := etag(version)
+	for _, tag := range tags {
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether a write is allowed on a key with the given version.
+func (condition *versionCondition) holds(version uint64) bool {
+	if condition.ifMatch != nil && !matchesETags(condition.ifMatch, version) {
+		return false
+	}
+	if condition.ifNoneMatch != nil && matchesETags(condition.ifNoneMatch, version) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doVersionedRequest(handler http.HandlerFunc, method string, url string, body string, headers map[string]string, pathValues map[string]string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	for name, value := range pathValues {
+		request.SetPathValue(name, value)
+	}
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func TestHandlerGetById_ETag(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := doVersionedRequest(srv.HandlerSet, "POST", "/set", `{"key":"value"}`, nil, nil)
+	require.Equal(t, http.StatusCreated, response.Code)
+	tag := response.Header().Get("ETag")
+	assert.Equal(t, `"1"`, tag)
+
+	response = doVersionedRequest(srv.HandlerGetById, "GET", "/get/key", "", nil, map[string]string{KEY_PARAM: "key"})
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, tag, response.Header().Get("ETag"))
+
+	response = doVersionedRequest(srv.HandlerGetById, "GET", "/get/key", "", map[string]string{"If-None-Match": tag}, map[string]string{KEY_PARAM: "key"})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+
+	srv.collectionManager.AddCollection("books")
+	books, _ := srv.collectionManager.GetCollection("books")
+	books.Set("key", "value")
+	response = doVersionedRequest(srv.HandlerCollectionGetById, "GET", "/collections/books/get/key", "", nil, map[string]string{COLLECTION_NAME_PARAM: "books", KEY_PARAM: "key"})
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+}
+
+func TestHandlerSet_IfMatch(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+
+	response := doVersionedRequest(srv.HandlerSet, "POST", "/set", `{"key":"value1"}`, map[string]string{"If-None-Match": "*"}, nil)
+	require.Equal(t, http.StatusCreated, response.Code)
+	tag := response.Header().Get("ETag")
+
+	response = doVersionedRequest(srv.HandlerSet, "POST", "/set", `{"key":"value2"}`, map[string]string{"If-None-Match": "*"}, nil)
+	assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+
+	response = doVersionedRequest(srv.HandlerSet, "POST", "/set", `{"key":"value2"}`, map[string]string{"If-Match": tag}, nil)
+	require.Equal(t, http.StatusCreated, response.Code)
+	assert.NotEqual(t, tag, response.Header().Get("ETag"))
+
+	// The version moved on, the stale tag is rejected
+	response = doVersionedRequest(srv.HandlerSet, "POST", "/set", `{"key":"value3"}`, map[string]string{"If-Match": tag}, nil)
+	assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+	assert.Equal(t, "value2", srv.collectionManager.GetDefaultCollection().Get("key"))
+
+	response = doVersionedRequest(srv.HandlerSet, "POST", "/set", `{"key":"value3","other":"value"}`, map[string]string{"If-Match": "*"}, nil)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = doVersionedRequest(srv.HandlerCollectionSet, "POST", "/collections/books/set", `{"key":"value"}`, map[string]string{"If-Match": "*"}, map[string]string{COLLECTION_NAME_PARAM: "books"})
+	assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+}
+
+func TestHandlerDelete_IfMatch(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	srv.collectionManager.GetDefaultCollection().Set("key", "value")
+	tag := etag(srv.collectionManager.GetDefaultCollection().Version("key"))
+
+	response := doVersionedRequest(srv.HandlerDelete, "DELETE", "/delete/key", "", map[string]string{"If-Match": `"999"`}, map[string]string{KEY_PARAM: "key"})
+	assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+
+	response = doVersionedRequest(srv.HandlerDelete, "DELETE", "/delete/key", "", map[string]string{"If-None-Match": tag}, map[string]string{KEY_PARAM: "key"})
+	assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+
+	response = doVersionedRequest(srv.HandlerDelete, "DELETE", "/delete/key", "", map[string]string{"If-Match": `"999", ` + tag}, map[string]string{KEY_PARAM: "key"})
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "", srv.collectionManager.GetDefaultCollection().Get("key"))
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dmarro89/dare-db/database"
+)
+
+const SINCE_PARAM = "since"
+
+// HandlerWatch streams the changes of the collection query parameter, every
+// collection if empty, on the keys starting with the prefix query parameter.
+func (srv *DareServer) HandlerWatch(w http.ResponseWriter, r *http.Request) {
+	srv.watch(w, r, r.URL.Query().Get("collection"))
+}
+
+func (srv *DareServer) HandlerCollectionWatch(w http.ResponseWriter, r *http.Request) {
+	if _, ok := srv.getCollectionOrNotFound(w, r); !ok {
+		return
+	}
+	srv.watch(w, r, r.PathValue(COLLECTION_NAME_PARAM))
+}
+
+// watch streams keyspace events as Server-Sent Events: a watch event holding
+// the current sequence number, then an event per change, named after its
+// type, whose id is its sequence number. The since query parameter, or the
+// Last-Event-ID header sent by reconnecting clients, resumes the stream after
+// that sequence number; the server answers 410 Gone if the events are no
+// longer available. A slow consumer receives an error event before the
+// stream ends, and may resume from the last event it received.
+func (srv *DareServer) watch(w http.ResponseWriter, r *http.Request, collection string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since uint64
+	value := r.URL.Query().Get(SINCE_PARAM)
+	if value == "" {
+		value = r.Header.Get("Last-Event-ID")
+	}
+	if value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf(`query param "%s" must be a sequence number`, SINCE_PARAM), http.StatusBadRequest)
+			return
+		}
+	}
+
+	watcher, backlog, seq, err := srv.collectionManager.Watch(collection, r.URL.Query().Get("prefix"), since)
+	if errors.Is(err, database.ErrEventsUnavailable) {
+		http.Error(w, fmt.Sprintf("The events following %d are no longer available, watch again without %s", since, SINCE_PARAM), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer watcher.Close()
+
+	stream := newEventStream(w)
+	last := seq
+	if since > 0 {
+		last = since
+	}
+	if !stream.send(strconv.FormatUint(last, 10), "watch", map[string]uint64{"seq": seq}) {
+		return
+	}
+	send := func(event database.Event) bool {
+		last = event.Seq
+		return stream.send(strconv.FormatUint(event.Seq, 10), string(event.Type), event)
+	}
+	for _, event := range backlog {
+		if !send(event) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(PUBSUB_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-watcher.Events():
+			if !send(event) {
+				return
+			}
+		case <-watcher.Done():
+			// Deliver the events buffered before the watcher was closed
+			for len(watcher.Events()) > 0 {
+				if !send(<-watcher.Events()) {
+					return
+				}
+			}
+			if err := watcher.Err(); err != nil {
+				stream.send("", "error", map[string]interface{}{"error": err.Error(), "seq": last})
+			}
+			return
+		case <-heartbeat.C:
+			if !stream.ping() {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
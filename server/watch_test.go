@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dmarro89/dare-db/auth"
+	"github.com/dmarro89/dare-db/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func watchRequest(t *testing.T, url string, token string, lastEventID string) *http.Response {
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	request.Header.Set("Authorization", token)
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	return response
+}
+
+func TestHandlerWatch(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	url, token := newPubSubServer(t, srv)
+	db := srv.collectionManager.GetDefaultCollection()
+
+	response := watchRequest(t, url+"/watch?prefix=user:", token, "")
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(response.Body)
+	event, data := readEvent(t, reader)
+	assert.Equal(t, "watch", event)
+	assert.JSONEq(t, `{"seq":1}`, data)
+
+	require.NoError(t, db.Set("order:1", "book"))
+	require.NoError(t, db.Set("user:1", "Ada"))
+	require.NoError(t, db.Delete("user:1"))
+
+	event, data = readEvent(t, reader)
+	assert.Equal(t, "set", event)
+	var change database.Event
+	require.NoError(t, json.Unmarshal([]byte(data), &change))
+	assert.Equal(t, uint64(3), change.Seq)
+	assert.Equal(t, database.DEFAULT_COLLECTION, change.Collection)
+	assert.Equal(t, "user:1", change.Key)
+	assert.NotZero(t, change.Version)
+	assert.NotZero(t, change.Timestamp)
+
+	event, data = readEvent(t, reader)
+	assert.Equal(t, "delete", event)
+	require.NoError(t, json.Unmarshal([]byte(data), &change))
+	assert.Equal(t, uint64(4), change.Seq)
+
+	// A reconnecting client resumes after the last event it received
+	resumed := watchRequest(t, url+"/watch?prefix=user:", token, "2")
+	defer resumed.Body.Close()
+	require.Equal(t, http.StatusOK, resumed.StatusCode)
+	reader = bufio.NewReader(resumed.Body)
+	event, data = readEvent(t, reader)
+	assert.Equal(t, "watch", event)
+	assert.JSONEq(t, `{"seq":4}`, data)
+	event, _ = readEvent(t, reader)
+	assert.Equal(t, "set", event)
+	event, _ = readEvent(t, reader)
+	assert.Equal(t, "delete", event)
+
+	gone := watchRequest(t, url+"/watch?since=100", token, "")
+	gone.Body.Close()
+	assert.Equal(t, http.StatusGone, gone.StatusCode)
+
+	invalid := watchRequest(t, url+"/watch?since=abc", token, "")
+	invalid.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, invalid.StatusCode)
+}
+
+func TestHandlerCollectionWatch(t *testing.T) {
+	srv := NewDareServer(database.NewDatabase(), auth.NewUserStore())
+	url, token := newPubSubServer(t, srv)
+	srv.collectionManager.AddCollection("users")
+
+	response := watchRequest(t, url+"/collections/users/watch", token, "")
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	reader := bufio.NewReader(response.Body)
+	readEvent(t, reader)
+
+	require.NoError(t, srv.collectionManager.GetDefaultCollection().Set("key", "value"))
+	users, _ := srv.collectionManager.GetCollection("users")
+	require.NoError(t, users.Set("alice", "1"))
+	srv.collectionManager.RemoveCollection("users")
+
+	event, data := readEvent(t, reader)
+	assert.Equal(t, "set", event)
+	assert.Contains(t, data, `"key":"alice"`)
+	event, data = readEvent(t, reader)
+	assert.Equal(t, "remove_collection", event)
+	assert.Contains(t, data, `"collection":"users"`)
+
+	srv.CloseSubscriptions()
+	event, data = readEvent(t, reader)
+	assert.Equal(t, "error", event)
+	assert.JSONEq(t, `{"error":"watchers closed","seq":5}`, data)
+
+	request := httptest.NewRequest(http.MethodGet, "/collections/missing/watch", nil)
+	request.SetPathValue(COLLECTION_NAME_PARAM, "missing")
+	recorder := httptest.NewRecorder()
+	srv.HandlerCollectionWatch(recorder, request)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,98 @@
+package utils
+
+// GlobMatch reports whether value matches a glob pattern, as used by Redis:
+// '*' matches any sequence of characters, '?' any single character,
+// '[abc]', '[^abc]' and '[a-z]' a character class and '\' escapes the next
+// character. Unlike path.Match, '*' also matches '/'.
+func GlobMatch(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(value); i++ {
+				if GlobMatch(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(value) == 0 {
+				return false
+			}
+			value = value[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(value) == 0 {
+				return false
+			}
+			matched, rest, ok := matchClass(pattern[1:], value[0])
+			if !ok {
+				// Unterminated class, match '[' literally
+				if value[0] != '[' {
+					return false
+				}
+				rest = pattern[1:]
+				matched = true
+			}
+			if !matched {
+				return false
+			}
+			value = value[1:]
+			pattern = rest
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(value) == 0 || pattern[0] != value[0] {
+				return false
+			}
+			value = value[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(value) == 0
+}
+
+// matchClass matches c against the class starting after '[' and returns the
+// pattern following the closing ']'.
+func matchClass(pattern string, c byte) (bool, string, bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == ']' && i > 0:
+			return matched != negate, pattern[i+1:], true
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			if pattern[i] == c {
+				matched = true
+			}
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			low, high := pattern[i], pattern[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			if c >= low && c <= high {
+				matched = true
+			}
+			i += 2
+		default:
+			if pattern[i] == c {
+				matched = true
+			}
+		}
+	}
+	return false, "", false
+}
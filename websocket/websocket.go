@@ -0,0 +1,372 @@
+// Package websocket implements the subset of the WebSocket protocol (RFC
+// 6455) used by dare-db: the server side handshake, a minimal client for Go
+// callers and tests, and reading and writing of messages. Extensions and
+// subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types, the opcodes of the frames.
+const (
+	CONTINUATION_FRAME = 0
+	TEXT_MESSAGE       = 1
+	BINARY_MESSAGE     = 2
+	CLOSE_MESSAGE      = 8
+	PING_MESSAGE       = 9
+	PONG_MESSAGE       = 10
+)
+
+// Close codes.
+const (
+	CLOSE_NORMAL_CLOSURE     = 1000
+	CLOSE_GOING_AWAY         = 1001
+	CLOSE_PROTOCOL_ERROR     = 1002
+	CLOSE_NO_STATUS_RECEIVED = 1005
+	CLOSE_POLICY_VIOLATION   = 1008
+	CLOSE_MESSAGE_TOO_BIG    = 1009
+)
+
+// DEFAULT_MAX_MESSAGE_SIZE is the largest message accepted by ReadMessage, in bytes.
+const DEFAULT_MAX_MESSAGE_SIZE int64 = 1 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+var ErrProtocol = errors.New("websocket: protocol error")
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// CloseError is returned by ReadMessage once the peer closed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. A single goroutine may read while others
+// write, writes being serialized.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isClient bool
+
+	writeMu    sync.Mutex
+	closeSent  bool
+	MaxMessage int64
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isClient bool) *Conn {
+	return &Conn{conn: conn, reader: reader, isClient: isClient, MaxMessage: DEFAULT_MAX_MESSAGE_SIZE}
+}
+
+// acceptKey computes the Sec-WebSocket-Accept header answering key.
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// headerContains reports whether the comma separated header name contains token, ignoring case.
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the handshake of a WebSocket request and takes over its
+// connection. On failure, it replies with 400 Bad Request and returns
+// ErrBadHandshake.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "Bad Request, a WebSocket handshake is expected", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	conn, buffer, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, buffer.Reader, false), nil
+}
+
+// Dial opens a WebSocket connection to a ws:// or wss:// URL, sending header
+// with the handshake. On a failed handshake, the response is returned with
+// ErrBadHandshake.
+func Dial(rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.Dial("tcp", hostPort(u, "80"))
+	case "wss":
+		conn, err = tls.Dial("tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: http.Header{}}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, response, ErrBadHandshake
+	}
+	return newConn(conn, reader, true), response, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// close frames. Once the peer closed the connection it returns a *CloseError.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType := 0
+	var data []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				c.WriteClose(CLOSE_PROTOCOL_ERROR, "")
+			} else if errors.Is(err, ErrMessageTooBig) {
+				c.WriteClose(CLOSE_MESSAGE_TOO_BIG, "")
+			}
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PING_MESSAGE:
+			if err := c.WriteMessage(PONG_MESSAGE, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PONG_MESSAGE:
+			continue
+		case CLOSE_MESSAGE:
+			closeErr := &CloseError{Code: CLOSE_NO_STATUS_RECEIVED}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Text = string(payload[2:])
+			}
+			c.WriteClose(closeErr.Code, "")
+			return 0, nil, closeErr
+		case TEXT_MESSAGE, BINARY_MESSAGE:
+			if messageType != 0 {
+				c.WriteClose(CLOSE_PROTOCOL_ERROR, "")
+				return 0, nil, ErrProtocol
+			}
+			messageType = opcode
+			data = payload
+		case CONTINUATION_FRAME:
+			if messageType == 0 {
+				c.WriteClose(CLOSE_PROTOCOL_ERROR, "")
+				return 0, nil, ErrProtocol
+			}
+			data = append(data, payload...)
+		default:
+			c.WriteClose(CLOSE_PROTOCOL_ERROR, "")
+			return 0, nil, ErrProtocol
+		}
+
+		if int64(len(data)) > c.MaxMessage {
+			c.WriteClose(CLOSE_MESSAGE_TOO_BIG, "")
+			return 0, nil, ErrMessageTooBig
+		}
+		if fin {
+			return messageType, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	// Frames sent by clients are masked, frames sent by servers are not
+	if header[0]&0x70 != 0 || masked == c.isClient {
+		return false, 0, nil, ErrProtocol
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= CLOSE_MESSAGE && (!fin || length > 125) {
+		return false, 0, nil, ErrProtocol
+	}
+	if length > uint64(c.MaxMessage) {
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends data in a single frame of the given type.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteClose sends a close frame with the code and text, once. Nothing may be
+// written after it.
+func (c *Conn) WriteClose(code int, text string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrame(CLOSE_MESSAGE, append(payload, text...))
+}
+
+// writeFrame writes a final frame, the caller must hold c.writeMu.
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+
+	maskBit := byte(0)
+	if c.isClient {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if !c.isClient {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// SetReadDeadline sets the deadline of the reads of the underlying connection.
+func (c *Conn) SetReadDeadline(deadline time.Time) error {
+	return c.conn.SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline sets the deadline of the writes of the underlying connection.
+func (c *Conn) SetWriteDeadline(deadline time.Time) error {
+	return c.conn.SetWriteDeadline(deadline)
+}
+
+// Close closes the underlying connection without sending a close frame.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEchoServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.MaxMessage = 1024
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Example of RFC 6455, section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestConn_Echo(t *testing.T) {
+	conn, response, err := Dial(newEchoServer(t), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+
+	for _, message := range []string{"hello", strings.Repeat("a", 126), strings.Repeat("b", 1000), ""} {
+		require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, []byte(message)))
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TEXT_MESSAGE, messageType)
+		assert.Equal(t, message, string(data))
+	}
+
+	require.NoError(t, conn.WriteMessage(PING_MESSAGE, []byte("ping")))
+	require.NoError(t, conn.WriteMessage(BINARY_MESSAGE, []byte{0, 1}))
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BINARY_MESSAGE, messageType)
+	assert.Equal(t, []byte{0, 1}, data)
+
+	require.NoError(t, conn.WriteClose(CLOSE_NORMAL_CLOSURE, "bye"))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CLOSE_NORMAL_CLOSURE, closeErr.Code)
+}
+
+func TestConn_MessageTooBig(t *testing.T) {
+	conn, _, err := Dial(newEchoServer(t), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, []byte(strings.Repeat("a", 2048))))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CLOSE_MESSAGE_TOO_BIG, closeErr.Code)
+}
+
+func TestConn_UnmaskedClientFrame(t *testing.T) {
+	url := newEchoServer(t)
+	conn, _, err := Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// A client frame without mask is a protocol error
+	_, err = conn.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+	require.NoError(t, err)
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CLOSE_PROTOCOL_ERROR, closeErr.Code)
+}
+
+func TestUpgrade_BadHandshake(t *testing.T) {
+	url := newEchoServer(t)
+	response, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	_, _, err = Dial("http://127.0.0.1:1", nil)
+	assert.Error(t, err)
+}
+
+func TestConn_FragmentedMessage(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	conn := newConn(client, bufio.NewReader(client), true)
+
+	go func() {
+		// Text frame "hel" without FIN, then a continuation "lo" with FIN
+		server.Write([]byte{0x01, 0x03, 'h', 'e', 'l'})
+		server.Write([]byte{0x80, 0x02, 'l', 'o'})
+	}()
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TEXT_MESSAGE, messageType)
+	assert.Equal(t, "hello", string(data))
+}